@@ -2,14 +2,18 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
 )
 
 func main() {
@@ -18,7 +22,17 @@ func main() {
 		fmt.Println("Commands:")
 		fmt.Println("  ask        Create a new conversation with assistant or continue an existing one")
 		fmt.Println("  list       List existing conversations")
-		fmt.Println("  show       Show conversation by ID")
+		fmt.Println("  search     Search conversations by words in their title or messages")
+		fmt.Println("  recall     Find messages of past conversations by meaning")
+		fmt.Println("  show       Show conversation by ID, add --tree to include all branches with message IDs")
+		fmt.Println("  retry      Regenerate the last reply of a conversation, or the reply with the given message ID")
+		fmt.Println("  edit       Replace a user message of a conversation and get a new reply")
+		fmt.Println("  attach     Add a document to a conversation, or list its documents")
+		fmt.Println("  fork       Copy a conversation, up to the given message ID if any, into a new one")
+		fmt.Println("  tools      List tools the assistant can use")
+		fmt.Println("  memories   List what the assistant remembers about you")
+		fmt.Println("  forget     Make the assistant forget a memory by ID")
+		fmt.Println("  usage      Show token usage and estimated cost, of all conversations or by model for one")
 	}
 
 	if len(os.Args) < 2 {
@@ -36,6 +50,18 @@ func main() {
 	cli := pb.NewChatServiceJSONClient(url, http.DefaultClient)
 	ctx := context.Background()
 
+	// The server keeps memories and quotas per API key, callers without one are identified by IP address
+	if key := os.Getenv("API_KEY"); key != "" {
+		header := http.Header{}
+		header.Set("X-API-Key", key)
+
+		var err error
+		if ctx, err = twirp.WithHTTPRequestHeaders(ctx, header); err != nil {
+			fmt.Printf("Error: invalid API_KEY: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	switch os.Args[1] {
 	case "ask":
 		fmt.Println("Press CMD+C to exit.")
@@ -56,7 +82,7 @@ func main() {
 			fmt.Println("Timestamp:", resp.GetConversation().GetTimestamp().AsTime().Format(time.RFC1123))
 			fmt.Println("")
 			for _, msg := range resp.GetConversation().GetMessages() {
-				fmt.Printf("%s, %s:\n%s\n\n", msg.GetRole(), msg.GetTimestamp().AsTime().Format(time.TimeOnly), msg.GetContent())
+				printMessage(msg)
 			}
 		} else {
 			fmt.Println("Starting a new conversation, type your message below.")
@@ -64,6 +90,7 @@ func main() {
 		}
 
 		reader := bufio.NewReader(os.Stdin)
+		var images []*pb.Part
 
 		for {
 			fmt.Printf("USER:\n")
@@ -75,9 +102,25 @@ func main() {
 
 			fmt.Println()
 
+			// Images are sent with the next message
+			if ref, ok := strings.CutPrefix(string(line), "/image "); ok {
+				image, err := imagePart(strings.TrimSpace(ref))
+				if err != nil {
+					fmt.Printf("Error reading image: %v\n\n", err)
+					continue
+				}
+
+				images = append(images, image)
+				continue
+			}
+
+			parts := images
+			images = nil
+
 			if cid == "" {
 				out, err := cli.StartConversation(ctx, &pb.StartConversationRequest{
 					Message: string(line),
+					Parts:   parts,
 				})
 
 				if err != nil {
@@ -91,13 +134,14 @@ func main() {
 				fmt.Println()
 
 				cid = out.GetConversationId()
-				fmt.Printf("ASSISTANT:\n%s\n\n", out.GetReply())
+				fmt.Printf("ASSISTANT:\n%s\n\n", confirm(ctx, cli, reader, cid, out.GetReply(), out.GetPendingToolCalls()))
 				continue
 			}
 
 			out, err := cli.ContinueConversation(ctx, &pb.ContinueConversationRequest{
 				ConversationId: cid,
 				Message:        string(line),
+				Parts:          parts,
 			})
 
 			if err != nil {
@@ -105,9 +149,75 @@ func main() {
 				os.Exit(1)
 			}
 
-			fmt.Printf("ASSISTANT:\n%s\n\n", out.GetReply())
+			fmt.Printf("ASSISTANT:\n%s\n\n", confirm(ctx, cli, reader, cid, out.GetReply(), out.GetPendingToolCalls()))
+		}
+
+	case "attach":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Conversation ID is required")
+			os.Exit(1)
+		}
+
+		for _, path := range os.Args[3:] {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Printf("Error reading file: %v\n", err)
+				os.Exit(1)
+			}
+
+			out, err := cli.UploadAttachment(ctx, &pb.UploadAttachmentRequest{
+				ConversationId: os.Args[2],
+				Filename:       filepath.Base(path),
+				Content:        content,
+			})
+
+			if err != nil {
+				fmt.Printf("Error uploading attachment: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Attached %s (%s, %d parts)\n", out.GetAttachment().GetFilename(), out.GetAttachment().GetContentType(), out.GetAttachment().GetChunks())
+		}
+
+		if len(os.Args) > 3 {
+			return
+		}
+
+		resp, err := cli.ListAttachments(ctx, &pb.ListAttachmentsRequest{ConversationId: os.Args[2]})
+		if err != nil {
+			fmt.Printf("Error listing attachments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(resp.GetAttachments()) == 0 {
+			fmt.Println("No attachments found.")
+			return
+		}
+
+		fmt.Printf("%-26s %-10s %-6s %s\n", "ID", "SIZE", "PARTS", "FILENAME")
+		for _, a := range resp.GetAttachments() {
+			fmt.Printf("%-26s %-10d %-6d %s\n", a.GetId(), a.GetSize(), a.GetChunks(), a.GetFilename())
+		}
+	case "fork":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Conversation ID is required")
+			os.Exit(1)
+		}
+
+		req := &pb.ForkConversationRequest{ConversationId: os.Args[2]}
+		if len(os.Args) >= 4 {
+			req.MessageId = os.Args[3]
 		}
 
+		out, err := cli.ForkConversation(ctx, req)
+		if err != nil {
+			fmt.Printf("Error forking conversation: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("New conversation forked:")
+		fmt.Println("ID:", out.GetConversationId())
+		fmt.Println("Title:", out.GetTitle())
 	case "list":
 		resp, err := cli.ListConversations(ctx, &pb.ListConversationsRequest{})
 		if err != nil {
@@ -124,14 +234,61 @@ func main() {
 		for _, conv := range resp.Conversations {
 			fmt.Printf("%s   %s\n", conv.GetId(), conv.GetTitle())
 		}
+	case "search":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Search query is required")
+			os.Exit(1)
+		}
+
+		resp, err := cli.SearchConversations(ctx, &pb.SearchConversationsRequest{Query: strings.Join(os.Args[2:], " ")})
+		if err != nil {
+			fmt.Printf("Error searching conversations: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(resp.GetResults()) == 0 {
+			fmt.Println("No conversations found.")
+			return
+		}
+
+		for _, r := range resp.GetResults() {
+			fmt.Printf("%s   %s\n", r.GetConversation().GetId(), cmp.Or(r.GetTitleSnippet(), r.GetConversation().GetTitle()))
+			for _, m := range r.GetMatches() {
+				fmt.Printf("  %s %s: %s\n", m.GetRole(), m.GetMessageId(), m.GetSnippet())
+			}
+			fmt.Println()
+		}
+	case "recall":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Search query is required")
+			os.Exit(1)
+		}
+
+		resp, err := cli.SemanticSearch(ctx, &pb.SemanticSearchRequest{Query: strings.Join(os.Args[2:], " ")})
+		if err != nil {
+			fmt.Printf("Error searching messages: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(resp.GetResults()) == 0 {
+			fmt.Println("No messages found.")
+			return
+		}
+
+		for _, r := range resp.GetResults() {
+			fmt.Printf("%s   %s   %.2f\n", r.GetConversationId(), r.GetTitle(), r.GetScore())
+			fmt.Printf("  %s %s: %s\n\n", r.GetRole(), r.GetMessageId(), r.GetContent())
+		}
 	case "show":
 		if len(os.Args) < 3 {
 			fmt.Println("Error: Conversation ID is required")
 			os.Exit(1)
 		}
 
+		tree := len(os.Args) >= 4 && os.Args[3] == "--tree"
 		resp, err := cli.DescribeConversation(ctx, &pb.DescribeConversationRequest{
 			ConversationId: os.Args[2],
+			FullTree:       tree,
 		})
 
 		if err != nil {
@@ -144,7 +301,225 @@ func main() {
 		fmt.Println("Timestamp:", resp.GetConversation().GetTimestamp().AsTime().Format(time.RFC1123))
 		fmt.Println("")
 		for _, msg := range resp.GetConversation().GetMessages() {
-			fmt.Printf("%s, %s:\n%s\n\n", msg.GetRole(), msg.GetTimestamp().AsTime().Format(time.TimeOnly), msg.GetContent())
+			if tree {
+				fmt.Printf("[%s, follows %s]\n", msg.GetId(), cmp.Or(msg.GetParentId(), "nothing"))
+			}
+			printMessage(msg)
 		}
+		if tree {
+			fmt.Println("Active message:", resp.GetConversation().GetActiveMessageId())
+		}
+	case "retry":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Conversation ID is required")
+			os.Exit(1)
+		}
+
+		req := &pb.RegenerateReplyRequest{ConversationId: os.Args[2]}
+		if len(os.Args) >= 4 {
+			req.MessageId = os.Args[3]
+		}
+
+		out, err := cli.RegenerateReply(ctx, req)
+		if err != nil {
+			fmt.Printf("Error regenerating reply: %v\n", err)
+			os.Exit(1)
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Printf("ASSISTANT:\n%s\n\n", confirm(ctx, cli, reader, req.ConversationId, out.GetReply(), out.GetPendingToolCalls()))
+	case "edit":
+		if len(os.Args) < 5 {
+			fmt.Println("Error: Conversation ID, message ID and the new message are required")
+			os.Exit(1)
+		}
+
+		out, err := cli.EditMessage(ctx, &pb.EditMessageRequest{
+			ConversationId: os.Args[2],
+			MessageId:      os.Args[3],
+			Message:        strings.Join(os.Args[4:], " "),
+		})
+		if err != nil {
+			fmt.Printf("Error editing message: %v\n", err)
+			os.Exit(1)
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Printf("ASSISTANT:\n%s\n\n", confirm(ctx, cli, reader, os.Args[2], out.GetReply(), out.GetPendingToolCalls()))
+	case "tools":
+		resp, err := cli.ListTools(ctx, &pb.ListToolsRequest{})
+		if err != nil {
+			fmt.Printf("Error listing tools: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, tool := range resp.GetTools() {
+			status := ""
+			if !tool.GetEnabled() {
+				status = " (disabled)"
+			}
+			if tool.GetRisk() != pb.Tool_LOW {
+				status += fmt.Sprintf(" (%s risk, needs approval)", strings.ToLower(tool.GetRisk().String()))
+			}
+			fmt.Printf("%s%s\n  %s\n\n", tool.GetName(), status, tool.GetDescription())
+		}
+	case "memories":
+		resp, err := cli.ListMemories(ctx, &pb.ListMemoriesRequest{})
+		if err != nil {
+			fmt.Printf("Error listing memories: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(resp.GetMemories()) == 0 {
+			fmt.Println("Nothing remembered yet.")
+			return
+		}
+
+		fmt.Printf("%-26s %-12s %s\n", "ID", "DATE", "FACT")
+		for _, m := range resp.GetMemories() {
+			fmt.Printf("%-26s %-12s %s\n", m.GetId(), m.GetTimestamp().AsTime().Format(time.DateOnly), m.GetContent())
+		}
+	case "forget":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Memory ID is required")
+			os.Exit(1)
+		}
+
+		if _, err := cli.DeleteMemory(ctx, &pb.DeleteMemoryRequest{MemoryId: os.Args[2]}); err != nil {
+			fmt.Printf("Error deleting memory: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Forgotten.")
+	case "usage":
+		if len(os.Args) >= 3 {
+			resp, err := cli.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: os.Args[2]})
+			if err != nil {
+				fmt.Printf("Error describing conversation: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Title generation is only part of the total, so it shows up as the difference
+			byModel := map[string]*pb.Usage{}
+			var models []string
+			for _, msg := range resp.GetConversation().GetMessages() {
+				u := msg.GetUsage()
+				if u == nil {
+					continue
+				}
+				if byModel[u.GetModel()] == nil {
+					byModel[u.GetModel()] = &pb.Usage{Model: u.GetModel()}
+					models = append(models, u.GetModel())
+				}
+				addUsage(byModel[u.GetModel()], u)
+			}
+
+			fmt.Println("MODEL                        PROMPT     CACHED    COMPLETION   COST (USD)")
+			for _, m := range models {
+				printUsage(m, byModel[m])
+			}
+			printUsage("total", resp.GetConversation().GetUsage())
+			return
+		}
+
+		resp, err := cli.ListConversations(ctx, &pb.ListConversationsRequest{})
+		if err != nil {
+			fmt.Printf("Error listing conversations: %v\n", err)
+			os.Exit(1)
+		}
+
+		total := &pb.Usage{}
+		fmt.Println("ID                           PROMPT     CACHED    COMPLETION   COST (USD)")
+		for _, conv := range resp.GetConversations() {
+			printUsage(conv.GetId(), conv.GetUsage())
+			addUsage(total, conv.GetUsage())
+		}
+		printUsage("total", total)
+	}
+}
+
+func addUsage(total, u *pb.Usage) {
+	total.PromptTokens += u.GetPromptTokens()
+	total.CompletionTokens += u.GetCompletionTokens()
+	total.CachedTokens += u.GetCachedTokens()
+	total.CostUsd += u.GetCostUsd()
+}
+
+func printUsage(label string, u *pb.Usage) {
+	fmt.Printf("%-26s %8d   %8d   %11d   %10.4f\n", label, u.GetPromptTokens(), u.GetCachedTokens(), u.GetCompletionTokens(), u.GetCostUsd())
+}
+
+func printMessage(msg *pb.Conversation_Message) {
+	fmt.Printf("%s, %s:\n", msg.GetRole(), msg.GetTimestamp().AsTime().Format(time.TimeOnly))
+	if msg.GetContent() != "" {
+		fmt.Println(msg.GetContent())
+	}
+	for _, call := range msg.GetToolCalls() {
+		fmt.Printf("-> %s(%s) %s\n", call.GetName(), call.GetArguments(), call.GetStatus())
+	}
+	for _, p := range msg.GetParts() {
+		if image := p.GetImage(); image != nil {
+			fmt.Printf("[image %s]\n", cmp.Or(image.GetUrl(), image.GetId()))
+		}
+	}
+	for i, c := range msg.GetCitations() {
+		fmt.Printf("[%d] %s, part %d: %s\n", i+1, c.GetFilename(), c.GetChunk()+1, c.GetExcerpt())
+	}
+	fmt.Println()
+}
+
+// imagePart references an image by URL, or reads it from a file to upload it
+func imagePart(ref string) (*pb.Part, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return &pb.Part{Part: &pb.Part_Image{Image: &pb.Image{Url: ref}}}, nil
+	}
+
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return nil, err
 	}
+
+	return &pb.Part{Part: &pb.Part_Image{Image: &pb.Image{Data: data}}}, nil
+}
+
+// confirm asks the user to approve or reject each pending tool call and returns the final reply
+func confirm(ctx context.Context, cli pb.ChatService, reader *bufio.Reader, cid, reply string, pending []*pb.Conversation_ToolCall) string {
+	for len(pending) > 0 {
+		call := pending[0]
+		fmt.Printf("The assistant wants to run %s(%s)\nAllow? [y]es, [n]o, [a]lways: ", call.GetName(), call.GetArguments())
+
+		line, _, err := reader.ReadLine()
+		if err != nil {
+			fmt.Printf("Error reading input: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println()
+
+		switch answer := strings.ToLower(strings.TrimSpace(string(line))); answer {
+		case "y", "yes", "a", "always":
+			out, err := cli.ApproveToolCall(ctx, &pb.ApproveToolCallRequest{
+				ConversationId: cid,
+				ToolCallId:     call.GetId(),
+				AlwaysAllow:    answer == "a" || answer == "always",
+			})
+			if err != nil {
+				fmt.Printf("Error approving tool call: %v\n", err)
+				os.Exit(1)
+			}
+			reply, pending = out.GetReply(), out.GetPendingToolCalls()
+		default:
+			out, err := cli.RejectToolCall(ctx, &pb.RejectToolCallRequest{
+				ConversationId: cid,
+				ToolCallId:     call.GetId(),
+			})
+			if err != nil {
+				fmt.Printf("Error rejecting tool call: %v\n", err)
+				os.Exit(1)
+			}
+			reply, pending = out.GetReply(), out.GetPendingToolCalls()
+		}
+	}
+
+	return reply
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/acai-travel/tech-challenge/internal/chat"
+	"github.com/acai-travel/tech-challenge/internal/chat/assistant"
+	"github.com/acai-travel/tech-challenge/internal/chat/attachment"
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/config"
+	"github.com/acai-travel/tech-challenge/internal/mongox"
+)
+
+func main() {
+	addr := flag.String("http", "", "serve the streamable HTTP transport on this address instead of stdio (e.g. :8081)")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	// stdout carries the protocol on stdio, logs must go to stderr
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	mongo := mongox.MustConnect(cfg.Mongo.URI, cfg.Mongo.Database)
+
+	repo := model.New(mongo)
+	if err := repo.EnsureSearchIndexes(ctx); err != nil {
+		slog.Error("Failed to create search indexes", "error", err)
+	}
+	if err := repo.EnsureMemoryIndexes(ctx); err != nil {
+		slog.Error("Failed to create memory indexes", "error", err)
+	}
+	if err := repo.EnsureAttachmentIndexes(ctx); err != nil {
+		slog.Error("Failed to create attachment indexes", "error", err)
+	}
+	embedder := assistant.NewEmbedder(cfg.OpenAI.RequestOptions()...)
+	documents := attachment.NewStore(repo, embedder)
+	assist := assistant.New(
+		assistant.WithRequestOptions(cfg.OpenAI.RequestOptions()...),
+		assistant.WithSettings(cfg.AssistantSettings()),
+		assistant.WithMemory(repo),
+		assistant.WithDocuments(documents),
+		assistant.WithImages(repo),
+	)
+	defer func() {
+		if err := assist.Close(); err != nil {
+			slog.Error("Failed to close assistant", "error", err)
+		}
+	}()
+
+	index := semantic.NewIndex(repo, embedder, semantic.WithVectorIndex(cfg.Mongo.VectorIndex))
+	assist.Tools().Register(tools.NewRecallTool(index))
+
+	srv := chat.NewMCPServer(chat.NewServer(repo, assist, chat.WithSemanticIndex(index), chat.WithAttachments(documents)), assist.Tools())
+
+	if *addr == "" {
+		slog.Info("Serving MCP over stdio")
+		if err := srv.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil {
+			slog.Error("MCP server error", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	httpServer := &http.Server{
+		Addr:        *addr,
+		Handler:     srv,
+		ReadTimeout: cfg.HTTP.ReadTimeout,
+		IdleTimeout: cfg.HTTP.IdleTimeout,
+	}
+
+	go func() {
+		slog.Info("Serving MCP over HTTP", "addr", *addr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer shutdownCancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown error", "error", err)
+	}
+}
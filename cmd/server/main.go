@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,18 +13,51 @@ import (
 
 	"github.com/acai-travel/tech-challenge/internal/chat"
 	"github.com/acai-travel/tech-challenge/internal/chat/assistant"
+	"github.com/acai-travel/tech-challenge/internal/chat/attachment"
 	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
+	"github.com/acai-travel/tech-challenge/internal/chat/titles"
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/config"
+	"github.com/acai-travel/tech-challenge/internal/grpcx"
+	"github.com/acai-travel/tech-challenge/internal/health"
 	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
 	"github.com/acai-travel/tech-challenge/internal/mongox"
 	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/acai-travel/tech-challenge/internal/rest"
+	"github.com/acai-travel/tech-challenge/internal/webhook"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"go.opentelemetry.io/otel"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	loader, err := config.NewLoader(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	cfg, err := loader.Load()
+	if *printConfig && cfg != nil {
+		if err := cfg.Print(os.Stdout); err != nil {
+			slog.Error("Failed to print the configuration", "error", err)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		return
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
@@ -44,12 +79,91 @@ func main() {
 	slog.Info("OpenTelemetry initialized", "metrics_endpoint", "/metrics")
 
 	// Initialize MongoDB
-	mongo := mongox.MustConnect()
+	mongo := mongox.MustConnect(cfg.Mongo.URI, cfg.Mongo.Database)
 
 	// Initialize components
 	repo := model.New(mongo)
-	assist := assistant.New()
-	server := chat.NewServer(repo, assist)
+	if err := repo.EnsureSearchIndexes(ctx); err != nil {
+		slog.Error("Failed to create search indexes", "error", err)
+	}
+	if err := repo.EnsureMemoryIndexes(ctx); err != nil {
+		slog.Error("Failed to create memory indexes", "error", err)
+	}
+	if err := repo.EnsureAttachmentIndexes(ctx); err != nil {
+		slog.Error("Failed to create attachment indexes", "error", err)
+	}
+	embedder := assistant.NewEmbedder(cfg.OpenAI.RequestOptions()...)
+	documents := attachment.NewStore(repo, embedder)
+	assist := assistant.New(
+		assistant.WithRequestOptions(cfg.OpenAI.RequestOptions()...),
+		assistant.WithSettings(cfg.AssistantSettings()),
+		assistant.WithMemory(repo),
+		assistant.WithDocuments(documents),
+		assistant.WithImages(repo),
+	)
+	defer func() {
+		if err := assist.Close(); err != nil {
+			slog.Error("Failed to close assistant", "error", err)
+		}
+	}()
+
+	// Prompts, models and tools are reloaded on SIGHUP or when the configuration files change, replies
+	// in flight finish with the settings they started with
+	go loader.Watch(ctx, cfg, func(ctx context.Context, cfg *config.Config) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		return assist.Configure(ctx, cfg.AssistantSettings())
+	})
+
+	// Messages are embedded as they are added, for SemanticSearch and the recall_past_conversations tool
+	index := semantic.NewIndex(repo, embedder, semantic.WithVectorIndex(cfg.Mongo.VectorIndex))
+	assist.Tools().Register(tools.NewRecallTool(index))
+
+	// Work off the request path runs as jobs stored in MongoDB
+	queue := jobs.New(mongo)
+	if err := queue.EnsureIndexes(ctx); err != nil {
+		slog.Error("Failed to create job indexes", "error", err)
+	}
+
+	// Titles are generated in the background, retried when they fail and reviewed as conversations grow
+	var titleOpts []titles.Option
+	if cfg.Limits.DailyTokenQuota > 0 {
+		titleOpts = append(titleOpts, titles.WithQuota(repo))
+	}
+	titleWorker := titles.NewWorker(repo, assist, queue, titleOpts...)
+	queue.Handle(titles.Kind, titleWorker.Handle)
+	go titleWorker.Run(ctx)
+
+	// Async replies notify clients through signed webhooks, which are only sent with a secret to sign them
+	var webhooks *webhook.Sender
+	if cfg.Webhooks.Secret != "" {
+		webhooks = webhook.NewSender(cfg.Webhooks.Secret, queue)
+		queue.Handle(webhook.Kind, webhooks.Handle)
+	}
+
+	opts := []chat.Option{
+		chat.WithSemanticIndex(index),
+		chat.WithAttachments(documents),
+		chat.WithTitleQueue(titleWorker),
+		chat.WithAsyncReplies(queue, webhooks),
+	}
+	if cfg.Limits.DailyTokenQuota > 0 {
+		if err := repo.EnsureQuotaIndexes(ctx); err != nil {
+			slog.Error("Failed to create quota indexes", "error", err)
+		}
+		opts = append(opts, chat.WithDailyTokenQuota(cfg.Limits.DailyTokenQuota))
+	}
+
+	server := chat.NewServer(repo, assist, opts...)
+	queue.Handle(chat.ReplyKind, server.HandleReply)
+
+	// The queue stops claiming jobs on shutdown and lets running ones finish
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(ctx)
+	}()
 
 	// Initialize telemetry
 	telemetry, err := httpx.NewTelemetry()
@@ -59,11 +173,15 @@ func main() {
 	}
 
 	// Configure handler
+	// Callers are identified by API key when they send one of the configured keys, by IP address otherwise
+	keys := httpx.NewKeys(cfg.Limits.APIKeys...)
+
 	handler := mux.NewRouter()
 	handler.Use(
 		telemetry.Middleware,
 		httpx.Logger(),
 		httpx.Recovery(),
+		httpx.Client(keys),
 	)
 
 	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -73,20 +191,73 @@ func main() {
 	// Use standard Prometheus HTTP handler
 	handler.Handle("/metrics", promhttp.Handler())
 
-	handler.PathPrefix("/twirp/").Handler(
-		pb.NewChatServiceServer(server, twirp.WithServerJSONSkipDefaults(true)),
+	// Only MongoDB and OpenAI are required to serve traffic, the tools' upstreams are reported on /status
+	checker := health.New(
+		health.Check{Name: "mongo", Required: true, TTL: 5 * time.Second, Run: func(ctx context.Context) error {
+			return mongo.Client().Ping(ctx, readpref.Primary())
+		}},
+		health.Check{Name: "openai", Required: true, TTL: 30 * time.Second, Run: assist.Ping},
+		health.Check{Name: "weather", TTL: time.Minute, Run: func(ctx context.Context) error {
+			return assist.Tools().Ping(ctx, "get_weather")
+		}},
+		health.Check{Name: "calendar", TTL: time.Minute, Run: func(ctx context.Context) error {
+			return assist.Tools().Ping(ctx, "get_holidays")
+		}},
 	)
+	handler.Handle("/healthz", checker.Liveness())
+	handler.Handle("/readyz", checker.Readiness())
+	handler.Handle("/status", checker.Status())
+
+	// Web clients served from other origins must be listed to open WebSockets
+	var wsOpts []chat.WebSocketOption
+	if len(cfg.WebSocket.Origins) > 0 {
+		wsOpts = append(wsOpts, chat.WithOrigins(cfg.WebSocket.Origins...))
+	}
+
+	// Clients get the same request budget over Twirp, REST, gRPC and WebSockets
+	var twirpHandler http.Handler = pb.NewChatServiceServer(server, twirp.WithServerJSONSkipDefaults(true))
+	restHandler := rest.NewHandler(server)
+	grpcOpts := []grpcx.Option{grpcx.WithKeys(keys)}
+	if cfg.Limits.RatePerMinute > 0 {
+		limiter := httpx.NewLimiter(float64(cfg.Limits.RatePerMinute), cfg.Limits.RateBurst)
+		twirpHandler = limiter.Middleware(twirpHandler)
+		restHandler = limiter.Middleware(restHandler)
+		grpcOpts = append(grpcOpts, grpcx.WithRateLimit(limiter))
+		wsOpts = append(wsOpts, chat.WithMessageLimit(limiter))
+	}
+
+	websockets := chat.NewWebSocketHandler(server, wsOpts...)
+
+	handler.PathPrefix("/twirp/").Handler(twirpHandler)
+	handler.PathPrefix("/v1/").Handler(restHandler)
+	handler.Handle("/ws", websockets)
+
+	// gRPC is served on its own port, by the same server
+	grpcServer := grpcx.NewServer(server, grpcOpts...)
+	grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPC.Port))
+	if err != nil {
+		slog.Error("Failed to listen for gRPC", "error", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		slog.Info("Starting the gRPC server", "port", cfg.GRPC.Port)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			slog.Error("gRPC server error", "error", err)
+			os.Exit(1)
+		}
+	}()
 
 	httpServer := &http.Server{
-		Addr:         ":8080",
+		Addr:         fmt.Sprintf(":%d", cfg.HTTP.Port),
 		Handler:      handler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
 	}
 
 	go func() {
-		slog.Info("Starting the server", "port", 8080)
+		slog.Info("Starting the server", "port", cfg.HTTP.Port)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			slog.Error("HTTP server error", "error", err)
 			os.Exit(1)
@@ -96,7 +267,12 @@ func main() {
 	<-ctx.Done()
 	slog.Info("Shutting down gracefully")
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Load balancers see /readyz fail and stop sending traffic before the listeners close
+	checker.Drain()
+	slog.Info("Draining", "delay", cfg.HTTP.DrainDelay)
+	time.Sleep(cfg.HTTP.DrainDelay)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer shutdownCancel()
 
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
@@ -104,5 +280,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Calls in flight get to finish, until the shutdown times out
+	grpcStopped := make(chan struct{})
+	go func() {
+		defer close(grpcStopped)
+		grpcServer.GracefulStop()
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+
+	// Hijacked WebSocket connections are not tracked by the HTTP server
+	if err := websockets.Shutdown(shutdownCtx); err != nil {
+		slog.Error("WebSocket shutdown error", "error", err)
+	}
+
+	<-queueDone
+
 	slog.Info("Server stopped")
 }
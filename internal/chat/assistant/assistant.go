@@ -2,68 +2,250 @@ package assistant
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"log/slog"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/acai-travel/tech-challenge/internal/chat/model"
 	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
 	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxMemories bounds the facts about the user added to the system prompt, the newest are kept
+	maxMemories = 50
+
+	// maxExcerpts bounds the chunks of attachments added to the prompt
+	maxExcerpts = 4
+
+	// retitleMessages bounds the latest user messages a title is reviewed against
+	retitleMessages = 5
 )
 
 type Assistant struct {
 	cli      openai.Client
 	registry *tools.Registry
+	metrics  *usageMetrics
+
+	// memories keeps facts about users across conversations, nil when they are not remembered
+	memories tools.MemoryStore
+
+	// documents retrieves chunks of the attachments of a conversation, nil when attachments are ignored
+	documents Documents
+
+	// images loads the images uploaded with messages, nil when only images referenced by URL are shown to the model
+	images Images
+
+	// clientOptions configure the OpenAI client, which otherwise reads OPENAI_API_KEY and OPENAI_BASE_URL
+	clientOptions []option.RequestOption
+
+	// settings can be changed while the assistant runs, see Configure
+	settings atomic.Pointer[Settings]
+	initial  Settings
+}
+
+// Images loads the data of uploaded images, implemented by model.Repository
+type Images interface {
+	LoadImage(ctx context.Context, id primitive.ObjectID) ([]byte, string, error)
+}
+
+// Documents finds the chunks of a conversation's attachments relevant to a question, implemented by attachment.Store
+type Documents interface {
+	Search(ctx context.Context, conversationID primitive.ObjectID, query string, limit int) ([]*model.ChunkMatch, *model.Usage, error)
+}
+
+// Option configures optional behaviour of the assistant
+type Option func(*Assistant)
+
+// WithMemory lets the assistant remember facts about users with the save_memory, recall_memories and
+// forget_memory tools, and reminds it of them at the start of every reply
+func WithMemory(store tools.MemoryStore) Option {
+	return func(a *Assistant) {
+		a.memories = store
+		a.registry.Register(tools.NewSaveMemoryTool(store))
+		a.registry.Register(tools.NewRecallMemoriesTool(store))
+		a.registry.Register(tools.NewForgetMemoryTool(store))
+	}
+}
+
+// WithDocuments adds the chunks of the conversation's attachments most relevant to the last user message
+// to the prompt, and records those the answer cites
+func WithDocuments(documents Documents) Option {
+	return func(a *Assistant) {
+		a.documents = documents
+	}
 }
 
-func New() *Assistant {
+// WithImages shows the images uploaded with user messages to the model
+func WithImages(images Images) Option {
+	return func(a *Assistant) {
+		a.images = images
+	}
+}
+
+// WithRequestOptions configures the OpenAI client, like its API key
+func WithRequestOptions(opts ...option.RequestOption) Option {
+	return func(a *Assistant) {
+		a.clientOptions = append(a.clientOptions, opts...)
+	}
+}
+
+// WithSettings sets the prompts, models and tool settings the assistant starts with
+func WithSettings(settings Settings) Option {
+	return func(a *Assistant) {
+		a.initial = settings
+	}
+}
+
+func New(opts ...Option) *Assistant {
 	// Initialize tool registry
 	registry := tools.NewRegistry()
-	registry.Register(tools.NewWeatherTool())
 	registry.Register(tools.NewDateTool())
-	registry.Register(tools.NewHolidayTool())
 	registry.Register(tools.NewCalculatorTool()) // Bonus tool
 
-	return &Assistant{
-		cli:      openai.NewClient(),
+	a := &Assistant{
 		registry: registry,
+		metrics:  newUsageMetrics(),
 	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.cli = openai.NewClient(a.clientOptions...)
+	initial := a.initial.withDefaults()
+	for _, tool := range builtins(initial) {
+		registry.Register(tool)
+	}
+	a.settings.Store(&initial)
+
+	// Bound the time spent connecting to MCP servers during startup
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := a.Configure(ctx, a.initial); err != nil {
+		slog.Error("Failed to configure the assistant", "error", err)
+	}
+
+	return a
+}
+
+// Tools returns the registry of tools available to the assistant
+func (a *Assistant) Tools() *tools.Registry {
+	return a.registry
 }
 
-func (a *Assistant) Title(ctx context.Context, conv *model.Conversation) (string, error) {
+// Ping checks the OpenAI API is reachable with the configured key
+func (a *Assistant) Ping(ctx context.Context) error {
+	_, err := a.cli.Models.List(ctx)
+	return err
+}
+
+// Close releases resources held by the assistant's tools, like MCP server sessions
+func (a *Assistant) Close() error {
+	return a.registry.Close()
+}
+
+// Title generates a title for the conversation and returns it with the usage of the call
+func (a *Assistant) Title(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error) {
 	if len(conv.Messages) == 0 {
-		return "An empty conversation", nil
+		return "An empty conversation", nil, nil
 	}
 
 	slog.InfoContext(ctx, "Generating title for conversation", "conversation_id", conv.ID)
 
+	settings := a.settings.Load()
+
 	// Create system message with instruction, then add user messages
 	msgs := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage("Generate a concise, descriptive title for the conversation based on the user's first message. The title should be a single line, no more than 80 characters, and should not include any special characters or emojis. Only return the title, nothing else."),
+		openai.SystemMessage(settings.TitlePrompt),
 	}
 
 	// Add only the first user message for title generation
-	for _, m := range conv.Messages {
+	for _, m := range conv.Path() {
 		if m.Role == model.RoleUser {
-			msgs = append(msgs, openai.UserMessage(m.Content))
+			content := m.Content
+			if strings.TrimSpace(content) == "" && len(m.Images()) > 0 {
+				content = "(an image without text)"
+			}
+			msgs = append(msgs, openai.UserMessage(content))
 			break // Only need the first user message
 		}
 	}
 
 	resp, err := a.cli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model:    openai.ChatModelGPT4oMini, // Use faster, cheaper model for title generation
+		Model:    settings.TitleModel,
 		Messages: msgs,
 	})
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
+	usage := a.usage(ctx, resp)
+
 	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
-		return "", errors.New("empty response from OpenAI for title generation")
+		return "", usage, errors.New("empty response from OpenAI for title generation")
+	}
+
+	return cleanTitle(resp.Choices[0].Message.Content), usage, nil
+}
+
+// Retitle reviews the title of a conversation against its recent user messages, returning the current
+// title when it still fits and a new one when the conversation moved on to another topic
+func (a *Assistant) Retitle(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error) {
+	var recent []string
+	for _, m := range conv.Path() {
+		if m.Role == model.RoleUser && strings.TrimSpace(m.Content) != "" {
+			recent = append(recent, m.Content)
+		}
+	}
+
+	if len(recent) == 0 || conv.Title == model.UntitledConversation {
+		return a.Title(ctx, conv)
+	}
+
+	if len(recent) > retitleMessages {
+		recent = recent[len(recent)-retitleMessages:]
+	}
+
+	slog.InfoContext(ctx, "Reviewing title of conversation", "conversation_id", conv.ID)
+
+	msgs := []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage("You review the title of a conversation. The current title is: " + conv.Title + "\n\nThe user's latest messages follow. If the title still describes what the conversation is about, return it unchanged. If the conversation moved on to another topic, return a new concise, descriptive title for it, a single line of no more than 80 characters, without special characters or emojis. Only return the title, nothing else."),
 	}
 
-	title := resp.Choices[0].Message.Content
+	for _, content := range recent {
+		msgs = append(msgs, openai.UserMessage(content))
+	}
+
+	resp, err := a.cli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model:    a.settings.Load().TitleModel,
+		Messages: msgs,
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	usage := a.usage(ctx, resp)
+
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return "", usage, errors.New("empty response from OpenAI for title review")
+	}
+
+	return cleanTitle(resp.Choices[0].Message.Content), usage, nil
+}
+
+// cleanTitle keeps a generated title on a single line of at most 80 characters
+func cleanTitle(title string) string {
 	title = strings.ReplaceAll(title, "\n", " ")
 	title = strings.Trim(title, " \t\r\n-\"'")
 
@@ -71,71 +253,309 @@ func (a *Assistant) Title(ctx context.Context, conv *model.Conversation) (string
 		title = title[:80]
 	}
 
-	return title, nil
+	return title
 }
 
-func (a *Assistant) Reply(ctx context.Context, conv *model.Conversation) (string, error) {
+// Reply generates the next messages of the conversation: the assistant's tool calls, their results
+// and finally its answer. Calls to tools that require approval are left pending and the reply stops
+// there, once they are approved or rejected Reply resumes from the open calls. When the context is
+// cancelled the messages generated so far are returned with the error.
+func (a *Assistant) Reply(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
 	if len(conv.Messages) == 0 {
-		return "", errors.New("conversation has no messages")
+		return nil, errors.New("conversation has no messages")
+	}
+
+	if len(conv.PendingToolCalls()) > 0 {
+		return nil, errors.New("conversation has tool calls waiting for approval")
 	}
 
 	slog.InfoContext(ctx, "Generating reply for conversation", "conversation_id", conv.ID)
 
+	// Tools like recall_past_conversations need to know which conversation they are called from
+	ctx = tools.WithConversation(ctx, conv.ID)
+
+	// The reply keeps the settings it started with when they are reloaded meanwhile
+	settings := a.settings.Load()
+
 	msgs := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage("You are a helpful, concise AI assistant. Provide accurate, safe, and clear responses."),
+		openai.SystemMessage(settings.SystemPrompt + a.remembered(ctx)),
 	}
 
-	for _, m := range conv.Messages {
-		switch m.Role {
-		case model.RoleUser:
-			msgs = append(msgs, openai.UserMessage(m.Content))
-		case model.RoleAssistant:
-			msgs = append(msgs, openai.AssistantMessage(m.Content))
+	// The query embedding of the excerpts is charged with the first completion
+	excerpts, searched := a.excerpts(ctx, conv)
+	if len(excerpts) > 0 {
+		msgs = append(msgs, openai.SystemMessage(excerptsPrompt(excerpts)))
+	}
+
+	path := conv.Path()
+	images := a.imageURLs(ctx, path)
+	for _, m := range path {
+		// Replies cancelled before the model said anything leave nothing to show it
+		if m.Cancelled && m.Content == "" {
+			continue
 		}
+		msgs = append(msgs, toParam(m, images))
+	}
+
+	format, err := responseFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*model.Message
+	add := func(m *model.Message) {
+		out = append(out, m)
+		msgs = append(msgs, toParam(m, nil))
+	}
+
+	// Resume a reply that was paused, running the calls approved since
+	for _, call := range conv.OpenToolCalls() {
+		result := a.runTool(ctx, call)
+		emit(ctx, Event{Type: EventToolResult, ToolCall: call, Result: result})
+		add(result)
 	}
 
+	// Usage of answers rejected for not matching the response schema, charged to the final one
+	var rejected *model.Usage
+
 	for i := 0; i < 15; i++ {
-		resp, err := a.cli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-			Model:    openai.ChatModelGPT4_1,
+		params := openai.ChatCompletionNewParams{
+			Model:    settings.ReplyModel,
 			Messages: msgs,
 			Tools:    a.registry.Definitions(), // Use registry for tool definitions
-		})
+		}
 
+		if format != nil {
+			params.ResponseFormat = format.param
+		}
+
+		// Structured answers are only sent once they are validated against the schema
+		resp, err := a.complete(ctx, params, format != nil)
 		if err != nil {
-			return "", err
+			if ctx.Err() != nil {
+				return partial(out, resp), err
+			}
+			return nil, err
 		}
 
 		if len(resp.Choices) == 0 {
-			return "", errors.New("no choices returned by OpenAI")
+			return nil, errors.New("no choices returned by OpenAI")
 		}
 
-		if message := resp.Choices[0].Message; len(message.ToolCalls) > 0 {
-			msgs = append(msgs, message.ToParam())
+		usage := a.usage(ctx, resp)
+		usage.Add(searched)
+		searched = nil
 
-			for _, call := range message.ToolCalls {
-				slog.InfoContext(ctx, "Tool call received", "name", call.Function.Name, "args", call.Function.Arguments)
+		message := resp.Choices[0].Message
+		if len(message.ToolCalls) == 0 {
+			if format != nil {
+				if err := format.schema.Validate([]byte(message.Content)); err != nil {
+					if rejected != nil {
+						return nil, fmt.Errorf("reply does not match the response schema: %w", err)
+					}
 
-				// Look up and execute tool
-				tool, exists := a.registry.Get(call.Function.Name)
-				if !exists {
-					msgs = append(msgs, openai.ToolMessage("unknown tool: "+call.Function.Name, call.ID))
+					// Point the model at its mistakes and let it try once more
+					slog.WarnContext(ctx, "Reply does not match the response schema, retrying", "error", err)
+					rejected = usage
+					msgs = append(msgs,
+						openai.AssistantMessage(message.Content),
+						openai.UserMessage("Your reply does not match the response schema: "+err.Error()+". Reply again with only the JSON document, matching the schema."),
+					)
 					continue
 				}
+			}
 
-				result, err := tool.Execute(ctx, call.Function.Arguments)
-				if err != nil {
-					msgs = append(msgs, openai.ToolMessage("tool execution failed: "+err.Error(), call.ID))
-					continue
-				}
+			usage.Add(rejected)
+			answer, citations := cite(message.Content, excerpts)
+			add(&model.Message{Role: model.RoleAssistant, Content: answer, Usage: usage, Citations: citations, Structured: format != nil})
+			return out, nil
+		}
 
-				msgs = append(msgs, openai.ToolMessage(result, call.ID))
+		request := &model.Message{Role: model.RoleAssistant, Content: message.Content, Usage: usage}
+		for _, c := range message.ToolCalls {
+			call := &model.ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments}
+			if a.registry.RequiresApproval(call.Name) && !conv.ToolApproved(call.Name) {
+				call.Status = model.ToolCallPending
 			}
+			request.ToolCalls = append(request.ToolCalls, call)
+		}
+		add(request)
 
-			continue
+		paused := false
+		for _, call := range request.ToolCalls {
+			emit(ctx, Event{Type: EventToolCall, ToolCall: call})
+
+			if call.Status == model.ToolCallPending {
+				slog.InfoContext(ctx, "Tool call waiting for approval", "name", call.Name, "args", call.Arguments)
+				paused = true
+				continue
+			}
+
+			result := a.runTool(ctx, call)
+			emit(ctx, Event{Type: EventToolResult, ToolCall: call, Result: result})
+			add(result)
+		}
+
+		if paused {
+			return out, nil
 		}
+	}
 
-		return resp.Choices[0].Message.Content, nil
+	return nil, errors.New("too many tool calls, unable to generate reply")
+}
+
+// partial returns the messages of a cancelled reply, ending with the text the model streamed before it
+// was cancelled, if any
+func partial(out []*model.Message, resp *openai.ChatCompletion) []*model.Message {
+	if resp == nil || len(resp.Choices) == 0 {
+		return out
 	}
 
-	return "", errors.New("too many tool calls, unable to generate reply")
+	message := resp.Choices[0].Message
+	if message.Content == "" || len(message.ToolCalls) > 0 {
+		return out
+	}
+
+	return append(out, &model.Message{Role: model.RoleAssistant, Content: message.Content, Cancelled: true})
+}
+
+// remembered returns the part of the system prompt listing the facts remembered about the user, if any.
+// Replies do not depend on them, so failing to load them is logged rather than returned. Only users
+// identified by an API key have memories.
+func (a *Assistant) remembered(ctx context.Context) string {
+	user, ok := httpx.UserFromContext(ctx)
+	if a.memories == nil || !ok {
+		return ""
+	}
+
+	memories, err := a.memories.ListMemories(ctx, user, maxMemories)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load memories", "error", err)
+		return ""
+	}
+
+	if len(memories) == 0 {
+		return ""
+	}
+
+	return "\n\nFacts remembered about the user from earlier conversations, one per line as 'ID: fact'. " +
+		"Take them into account, and forget those the user asks you to or that are no longer true:\n" + tools.FormatMemories(memories)
+}
+
+// imageURLs loads the images uploaded with the messages and returns their data URLs by ID.
+// Images that cannot be loaded are logged and left out, the model is told they are unavailable.
+func (a *Assistant) imageURLs(ctx context.Context, messages []*model.Message) map[primitive.ObjectID]string {
+	if a.images == nil {
+		return nil
+	}
+
+	urls := map[primitive.ObjectID]string{}
+	for _, m := range messages {
+		for _, image := range m.Images() {
+			if image.ID.IsZero() {
+				continue
+			}
+
+			data, contentType, err := a.images.LoadImage(ctx, image.ID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load image", "image_id", image.ID.Hex(), "error", err)
+				continue
+			}
+
+			urls[image.ID] = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+
+	return urls
+}
+
+// usage records the usage of a completion in the metrics and returns it
+func (a *Assistant) usage(ctx context.Context, resp *openai.ChatCompletion) *model.Usage {
+	usage := newUsage(resp.Model, resp.Usage)
+	a.metrics.record(ctx, usage)
+	return usage
+}
+
+// runTool executes the call and returns the message holding its result for the model
+func (a *Assistant) runTool(ctx context.Context, call *model.ToolCall) *model.Message {
+	slog.InfoContext(ctx, "Tool call received", "name", call.Name, "args", call.Arguments)
+
+	result := &model.Message{Role: model.RoleTool, ToolCallID: call.ID}
+
+	// Look up and execute tool
+	if _, exists := a.registry.Get(call.Name); !exists {
+		result.Content = "unknown tool: " + call.Name
+		return result
+	}
+
+	// Models called by the tool, like the embeddings of recall_past_conversations, count as usage of the result
+	usage := &model.Usage{}
+	out, err := a.registry.Execute(tools.WithUsage(ctx, usage), call.Name, call.Arguments)
+	if usage.Tokens() > 0 {
+		result.Usage = usage
+	}
+
+	var argErr *tools.ArgumentsError
+	switch {
+	case errors.As(err, &argErr):
+		slog.InfoContext(ctx, "Tool call rejected", "name", call.Name, "error", argErr)
+		result.Content = argErr.Message()
+	case err != nil:
+		result.Content = "tool execution failed: " + err.Error()
+	default:
+		result.Content = out
+	}
+
+	return result
+}
+
+// toParam converts a message for the model, uploaded images are sent as the data URLs of their IDs
+func toParam(m *model.Message, images map[primitive.ObjectID]string) openai.ChatCompletionMessageParamUnion {
+	switch m.Role {
+	case model.RoleAssistant:
+		if len(m.ToolCalls) == 0 {
+			return openai.AssistantMessage(m.Content)
+		}
+
+		msg := openai.ChatCompletionAssistantMessageParam{}
+		if m.Content != "" {
+			msg.Content.OfString = openai.String(m.Content)
+		}
+
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, openai.ChatCompletionMessageToolCallUnionParam{
+				OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+					ID: call.ID,
+					Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+						Name:      call.Name,
+						Arguments: call.Arguments,
+					},
+				},
+			})
+		}
+
+		return openai.ChatCompletionMessageParamUnion{OfAssistant: &msg}
+	case model.RoleTool:
+		return openai.ToolMessage(m.Content, m.ToolCallID)
+	default:
+		if len(m.Parts) == 0 {
+			return openai.UserMessage(m.Content)
+		}
+
+		var parts []openai.ChatCompletionContentPartUnionParam
+		for _, p := range m.Parts {
+			switch {
+			case p.Image == nil:
+				parts = append(parts, openai.TextContentPart(p.Text))
+			case p.Image.URL != "":
+				parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: p.Image.URL}))
+			case images[p.Image.ID] != "":
+				parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: images[p.Image.ID]}))
+			default:
+				parts = append(parts, openai.TextContentPart("[image unavailable]"))
+			}
+		}
+
+		return openai.UserMessage(parts)
+	}
 }
@@ -3,10 +3,12 @@ package assistant
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -37,7 +39,7 @@ func TestTitle(t *testing.T) {
 			}},
 		}
 
-		title, err := assist.Title(ctx, conv)
+		title, _, err := assist.Title(ctx, conv)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -61,7 +63,7 @@ func TestTitle(t *testing.T) {
 			Messages:  []*model.Message{},
 		}
 
-		title, err := assist.Title(ctx, conv)
+		title, _, err := assist.Title(ctx, conv)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -85,7 +87,7 @@ func TestTitle(t *testing.T) {
 			}},
 		}
 
-		title, err := assist.Title(ctx, conv)
+		title, _, err := assist.Title(ctx, conv)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -99,3 +101,112 @@ func TestTitle(t *testing.T) {
 		t.Logf("Generated title: %s", title)
 	})
 }
+
+// memories returns the same memories to every user
+type memories []*model.Memory
+
+func (m memories) SaveMemory(ctx context.Context, memory *model.Memory) error { return nil }
+
+func (m memories) ListMemories(ctx context.Context, user string, limit int64) ([]*model.Memory, error) {
+	return m, nil
+}
+
+func (m memories) DeleteMemory(ctx context.Context, user, id string) error { return nil }
+
+func TestRemembered(t *testing.T) {
+	ctx := httpx.WithClient(context.Background(), "key:alice")
+
+	if got := New().remembered(ctx); got != "" {
+		t.Errorf("expected nothing without memory, got %q", got)
+	}
+
+	if got := New(WithMemory(memories{})).remembered(ctx); got != "" {
+		t.Errorf("expected nothing without memories, got %q", got)
+	}
+
+	fact := &model.Memory{ID: primitive.NewObjectID(), Content: "Is vegetarian"}
+	assist := New(WithMemory(memories{fact}))
+
+	if got := assist.remembered(ctx); !strings.HasSuffix(got, "\n"+fact.ID.Hex()+": Is vegetarian") {
+		t.Errorf("expected the fact in the prompt, got %q", got)
+	}
+
+	if got := assist.remembered(httpx.WithClient(context.Background(), "ip:10.0.0.1")); got != "" {
+		t.Errorf("expected nothing for callers without an API key, got %q", got)
+	}
+
+	if _, ok := assist.Tools().Get("forget_memory"); !ok {
+		t.Error("expected memory tools to be registered")
+	}
+}
+
+func TestToParam_Images(t *testing.T) {
+	uploaded, missing := primitive.NewObjectID(), primitive.NewObjectID()
+	m := &model.Message{Role: model.RoleUser, Parts: []*model.Part{
+		{Text: "What does this sign say?"},
+		{Image: &model.Image{URL: "https://example.com/sign.jpg"}},
+		{Image: &model.Image{ID: uploaded, ContentType: "image/png"}},
+		{Image: &model.Image{ID: missing, ContentType: "image/png"}},
+	}}
+
+	parts := toParam(m, map[primitive.ObjectID]string{uploaded: "data:image/png;base64,AAAA"}).OfUser.Content.OfArrayOfContentParts
+	if len(parts) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(parts))
+	}
+
+	if parts[0].OfText == nil || parts[0].OfText.Text != "What does this sign say?" {
+		t.Errorf("expected the text first, got %+v", parts[0])
+	}
+
+	if parts[1].OfImageURL == nil || parts[1].OfImageURL.ImageURL.URL != "https://example.com/sign.jpg" {
+		t.Errorf("expected the image URL, got %+v", parts[1])
+	}
+
+	if parts[2].OfImageURL == nil || parts[2].OfImageURL.ImageURL.URL != "data:image/png;base64,AAAA" {
+		t.Errorf("expected the data URL of the uploaded image, got %+v", parts[2])
+	}
+
+	if parts[3].OfText == nil || parts[3].OfText.Text != "[image unavailable]" {
+		t.Errorf("expected images that could not be loaded to be reported, got %+v", parts[3])
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	schema := `{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`
+
+	t.Run("uses the schema of the last user message", func(t *testing.T) {
+		format, err := responseFormat([]*model.Message{
+			{Role: model.RoleUser, Content: "Where is the Sagrada Familia?", ResponseSchema: schema},
+			{Role: model.RoleAssistant, Content: `{"city":"Barcelona"}`, Structured: true},
+			{Role: model.RoleUser, Content: "And the Eiffel Tower?", ResponseSchema: schema},
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if format == nil || format.param.OfJSONSchema == nil {
+			t.Fatal("expected a JSON schema response format")
+		}
+
+		if err := format.schema.Validate([]byte(`{"city":"Paris"}`)); err != nil {
+			t.Errorf("expected a matching reply to validate, got %v", err)
+		}
+
+		if err := format.schema.Validate([]byte(`{"town":"Paris"}`)); err == nil {
+			t.Error("expected a reply without city to be rejected")
+		}
+	})
+
+	t.Run("replies in free text without a schema", func(t *testing.T) {
+		format, err := responseFormat([]*model.Message{
+			{Role: model.RoleUser, Content: "Where is the Sagrada Familia?", ResponseSchema: schema},
+			{Role: model.RoleAssistant, Content: `{"city":"Barcelona"}`, Structured: true},
+			{Role: model.RoleUser, Content: "Tell me more about it"},
+		})
+
+		if err != nil || format != nil {
+			t.Fatalf("expected no format, got %v, %v", format, err)
+		}
+	})
+}
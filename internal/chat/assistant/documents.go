@@ -0,0 +1,104 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+)
+
+// maxCitationExcerpt bounds the bytes of a chunk kept on a citation
+const maxCitationExcerpt = 300
+
+var citationMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+// excerpts returns the chunks of the conversation's attachments relevant to its last user message, and
+// the usage of looking for them. Replies do not depend on them, so failing to retrieve them is logged
+// rather than returned.
+func (a *Assistant) excerpts(ctx context.Context, conv *model.Conversation) ([]*model.ChunkMatch, *model.Usage) {
+	if a.documents == nil {
+		return nil, nil
+	}
+
+	path := conv.Path()
+	i := len(path) - 1
+	for i >= 0 && path[i].Role != model.RoleUser {
+		i--
+	}
+
+	if i < 0 {
+		return nil, nil
+	}
+
+	excerpts, usage, err := a.documents.Search(ctx, conv.ID, path[i].Content, maxExcerpts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve attachment excerpts", "conversation_id", conv.ID, "error", err)
+		return nil, usage
+	}
+
+	return excerpts, usage
+}
+
+// excerptsPrompt lists the excerpts numbered from 1, the number the model cites them with
+func excerptsPrompt(excerpts []*model.ChunkMatch) string {
+	var b strings.Builder
+	b.WriteString("Excerpts of documents the user attached to this conversation. Base your answer on those relevant to the question, " +
+		"and cite each one you use with its number in brackets, e.g. [1]. Ignore the others.\n")
+
+	for i, e := range excerpts {
+		fmt.Fprintf(&b, "\n[%d] %s, part %d:\n%s\n", i+1, e.Filename, e.Index+1, e.Content)
+	}
+
+	return b.String()
+}
+
+// cite returns the citations of the excerpts the answer refers to, in the order they are first cited,
+// with the answer's markers renumbered to match: [n] refers to the nth citation
+func cite(answer string, excerpts []*model.ChunkMatch) (string, []*model.Citation) {
+	var citations []*model.Citation
+	numbers := map[int]int{} // Excerpt number to citation number
+
+	answer = citationMarker.ReplaceAllStringFunc(answer, func(marker string) string {
+		n, err := strconv.Atoi(marker[1 : len(marker)-1])
+		if err != nil || n < 1 || n > len(excerpts) {
+			return marker
+		}
+
+		if _, ok := numbers[n]; !ok {
+			e := excerpts[n-1]
+			citations = append(citations, &model.Citation{
+				AttachmentID: e.AttachmentID,
+				Filename:     e.Filename,
+				Chunk:        e.Index,
+				Excerpt:      excerpt(e.Content, maxCitationExcerpt),
+			})
+			numbers[n] = len(citations)
+		}
+
+		return "[" + strconv.Itoa(numbers[n]) + "]"
+	})
+
+	return answer, citations
+}
+
+// excerpt cuts s to at most n bytes at a word boundary
+func excerpt(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	cut := strings.LastIndexByte(s[:n], ' ')
+	if cut <= 0 {
+		cut = n
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+	}
+
+	return s[:cut] + "…"
+}
@@ -0,0 +1,73 @@
+package assistant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCite(t *testing.T) {
+	excerpts := []*model.ChunkMatch{
+		{AttachmentChunk: &model.AttachmentChunk{AttachmentID: primitive.NewObjectID(), Filename: "booking.pdf", Index: 0, Content: "Check-in from 3pm."}},
+		{AttachmentChunk: &model.AttachmentChunk{AttachmentID: primitive.NewObjectID(), Filename: "policy.md", Index: 3, Content: strings.Repeat("Economy class only. ", 30)}},
+	}
+
+	answer, got := cite("Flights are economy [2], check-in is at 3pm [1][2]. See also [3].", excerpts)
+
+	if want := "Flights are economy [1], check-in is at 3pm [2][1]. See also [3]."; answer != want {
+		t.Errorf("expected %q, got %q", want, answer)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 citations, got %d", len(got))
+	}
+
+	if got[0].Chunk != 3 || len(got[0].Excerpt) > maxCitationExcerpt+len("…") || !strings.HasSuffix(got[0].Excerpt, "only.…") {
+		t.Errorf("unexpected first citation %+v", got[0])
+	}
+
+	if got[1].Filename != "booking.pdf" || got[1].Excerpt != "Check-in from 3pm." {
+		t.Errorf("unexpected second citation %+v", got[1])
+	}
+
+	if _, citations := cite("No sources.", excerpts); citations != nil {
+		t.Error("expected no citations without markers")
+	}
+}
+
+// documents returns the same chunk for every search, charging tokens for the query
+type documents struct{}
+
+func (documents) Search(ctx context.Context, conversationID primitive.ObjectID, query string, limit int) ([]*model.ChunkMatch, *model.Usage, error) {
+	return []*model.ChunkMatch{{AttachmentChunk: &model.AttachmentChunk{AttachmentID: primitive.NewObjectID(), Filename: "booking.md", Content: "Hotel Alfama, two nights."}}}, &model.Usage{PromptTokens: 5}, nil
+}
+
+func TestReply_ExcerptsUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"1","model":"gpt-4.1","choices":[{"index":0,"message":{"role":"assistant","content":"Alfama [1]."},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":3,"total_tokens":103}}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	conv := &model.Conversation{
+		ID:       primitive.NewObjectID(),
+		Messages: []*model.Message{{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "Which hotel?"}},
+	}
+
+	out, err := New(WithDocuments(documents{})).Reply(context.Background(), conv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if usage := out[len(out)-1].Usage; usage == nil || usage.PromptTokens != 105 {
+		t.Errorf("expected the query embedding to be part of the reply usage, got %+v", usage)
+	}
+}
@@ -0,0 +1,59 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+)
+
+// Embedder computes embeddings of texts with OpenAI, for semantic search and document retrieval
+type Embedder struct {
+	cli     openai.Client
+	metrics *usageMetrics
+}
+
+// NewEmbedder creates an embedder, the options configure its OpenAI client like WithRequestOptions does
+func NewEmbedder(opts ...option.RequestOption) *Embedder {
+	return &Embedder{
+		cli:     openai.NewClient(opts...),
+		metrics: newUsageMetrics(),
+	}
+}
+
+// Embed computes the embeddings of the texts, in the same order
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, *model.Usage, error) {
+	resp, err := e.cli.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: openai.EmbeddingModelTextEmbedding3Small,
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, nil, fmt.Errorf("expected %d embeddings from OpenAI, got %d", len(texts), len(resp.Data))
+	}
+
+	usage := &model.Usage{Model: resp.Model, PromptTokens: resp.Usage.PromptTokens}
+	estimateCost(usage)
+	e.metrics.record(ctx, usage)
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || int(d.Index) >= len(texts) {
+			return nil, nil, fmt.Errorf("unexpected embedding index %d from OpenAI", d.Index)
+		}
+
+		vector := make([]float32, len(d.Embedding))
+		for i, v := range d.Embedding {
+			vector[i] = float32(v)
+		}
+		vectors[d.Index] = vector
+	}
+
+	return vectors, usage, nil
+}
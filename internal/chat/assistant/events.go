@@ -0,0 +1,80 @@
+package assistant
+
+import (
+	"context"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/openai/openai-go/v2"
+)
+
+// EventType tells what happened while generating a reply
+type EventType string
+
+const (
+	// EventDelta carries the next piece of text of an assistant message
+	EventDelta EventType = "delta"
+
+	// EventToolCall carries a call the assistant made, pending when it waits for approval
+	EventToolCall EventType = "tool_call"
+
+	// EventToolResult carries the tool message with the result of a call
+	EventToolResult EventType = "tool_result"
+)
+
+// Event is something that happened while generating a reply, see WithEvents
+type Event struct {
+	Type     EventType
+	Delta    string
+	ToolCall *model.ToolCall
+	Result   *model.Message
+}
+
+type eventsKey struct{}
+
+// WithEvents returns a context under which Reply streams the answer from the model and reports its
+// progress to fn as it happens. fn is called from the goroutine running Reply.
+func WithEvents(ctx context.Context, fn func(Event)) context.Context {
+	return context.WithValue(ctx, eventsKey{}, fn)
+}
+
+// emit reports the event to the listener of the context, if any
+func emit(ctx context.Context, e Event) {
+	if fn, ok := ctx.Value(eventsKey{}).(func(Event)); ok {
+		fn(e)
+	}
+}
+
+// complete runs a chat completion. When the context has an event listener the completion is streamed
+// and its text reported as deltas, unless quiet is set because the text may yet be rejected.
+func (a *Assistant) complete(ctx context.Context, params openai.ChatCompletionNewParams, quiet bool) (*openai.ChatCompletion, error) {
+	if _, ok := ctx.Value(eventsKey{}).(func(Event)); !ok {
+		return a.cli.Chat.Completions.New(ctx, params)
+	}
+
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+
+	stream := a.cli.Chat.Completions.NewStreaming(ctx, params)
+	defer func() { _ = stream.Close() }()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+
+		// The accumulator sums token counts but drops their details, the last chunk has the totals
+		if chunk.Usage.TotalTokens > 0 {
+			acc.Usage = chunk.Usage
+		}
+
+		if !quiet && len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			emit(ctx, Event{Type: EventDelta, Delta: chunk.Choices[0].Delta.Content})
+		}
+	}
+
+	// What was streamed before a failure is returned with the error
+	if err := stream.Err(); err != nil {
+		return &acc.ChatCompletion, err
+	}
+
+	return &acc.ChatCompletion, nil
+}
@@ -0,0 +1,132 @@
+package assistant
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// streamingOpenAI fakes the chat completions API, streaming each response as server-sent events
+func streamingOpenAI(t *testing.T, responses ...[]string) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls >= len(responses) {
+			t.Errorf("unexpected call to the model")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range responses[calls] {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+		calls++
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+}
+
+func TestReply_Events(t *testing.T) {
+	streamingOpenAI(t,
+		[]string{
+			`{"id":"1","model":"gpt-4.1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_today_date","arguments":""}}]}}]}`,
+			`{"id":"1","model":"gpt-4.1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}`,
+			`{"id":"1","model":"gpt-4.1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"id":"1","model":"gpt-4.1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+		},
+		[]string{
+			`{"id":"2","model":"gpt-4.1","choices":[{"index":0,"delta":{"role":"assistant","content":"Today is "}}]}`,
+			`{"id":"2","model":"gpt-4.1","choices":[{"index":0,"delta":{"content":"a good day."}}]}`,
+			`{"id":"2","model":"gpt-4.1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			`{"id":"2","model":"gpt-4.1","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":4,"total_tokens":24,"prompt_tokens_details":{"cached_tokens":8}}}`,
+		},
+	)
+
+	conv := &model.Conversation{
+		ID:       primitive.NewObjectID(),
+		Messages: []*model.Message{{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "What day is it?"}},
+	}
+
+	var events []string
+	ctx := WithEvents(context.Background(), func(e Event) {
+		switch e.Type {
+		case EventDelta:
+			events = append(events, "delta:"+e.Delta)
+		case EventToolCall:
+			events = append(events, "call:"+e.ToolCall.Name)
+		case EventToolResult:
+			events = append(events, "result:"+e.ToolCall.ID)
+		}
+	})
+
+	messages, err := New().Reply(ctx, conv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "call:get_today_date result:call_1 delta:Today is  delta:a good day."
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("expected events %q, got %q", want, got)
+	}
+
+	if len(messages) != 3 {
+		t.Fatalf("expected a tool call, its result and an answer, got %d messages", len(messages))
+	}
+
+	if got := messages[0].ToolCalls[0].Arguments; got != "{}" {
+		t.Errorf("expected the streamed arguments to be accumulated, got %q", got)
+	}
+
+	answer := messages[2]
+	if answer.Content != "Today is a good day." {
+		t.Errorf("unexpected answer %q", answer.Content)
+	}
+
+	if answer.Usage.PromptTokens != 20 || answer.Usage.CompletionTokens != 4 || answer.Usage.CachedTokens != 8 {
+		t.Errorf("unexpected usage %+v", answer.Usage)
+	}
+}
+
+func TestReply_Cancelled(t *testing.T) {
+	// The model streams the start of an answer and then hangs until the request is cancelled
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, `data: {"id":"1","model":"gpt-4.1","choices":[{"index":0,"delta":{"role":"assistant","content":"Lisbon is"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	conv := &model.Conversation{
+		ID:       primitive.NewObjectID(),
+		Messages: []*model.Message{{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "Tell me about Lisbon"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = WithEvents(ctx, func(e Event) {
+		if e.Type == EventDelta {
+			cancel()
+		}
+	})
+
+	messages, err := New().Reply(ctx, conv)
+	if err == nil {
+		t.Fatal("expected the reply to fail once cancelled")
+	}
+
+	if len(messages) != 1 || messages[0].Content != "Lisbon is" || !messages[0].Cancelled {
+		t.Errorf("expected the streamed text as a cancelled answer, got %v", messages)
+	}
+}
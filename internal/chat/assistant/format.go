@@ -0,0 +1,50 @@
+package assistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/jsonschema"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+)
+
+// format constrains a reply to a JSON Schema, the model is asked to follow it and the answer is validated
+type format struct {
+	param  openai.ChatCompletionNewParamsResponseFormatUnion
+	schema *jsonschema.Schema
+}
+
+// responseFormat returns the format of the answer to the last user message of the path, nil for free text
+func responseFormat(path []*model.Message) (*format, error) {
+	i := len(path) - 1
+	for i >= 0 && path[i].Role != model.RoleUser {
+		i--
+	}
+
+	if i < 0 || path[i].ResponseSchema == "" {
+		return nil, nil
+	}
+
+	raw := json.RawMessage(path[i].ResponseSchema)
+
+	schema, err := jsonschema.Compile(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+
+	return &format{
+		param: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name: "reply",
+					// Strict mode only accepts a subset of JSON Schema, answers are validated instead
+					Strict: openai.Bool(false),
+					Schema: raw,
+				},
+			},
+		},
+		schema: schema,
+	}, nil
+}
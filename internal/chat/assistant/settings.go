@@ -0,0 +1,102 @@
+package assistant
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/chat/weatherclient"
+	"github.com/openai/openai-go/v2"
+)
+
+const (
+	// DefaultSystemPrompt sets the persona of the assistant
+	DefaultSystemPrompt = "You are a helpful, concise AI assistant. Provide accurate, safe, and clear responses."
+
+	// DefaultTitlePrompt asks for the title of a conversation from its first message
+	DefaultTitlePrompt = "Generate a concise, descriptive title for the conversation based on the user's first message. The title should be a single line, no more than 80 characters, and should not include any special characters or emojis. Only return the title, nothing else."
+)
+
+// Settings are the parts of the assistant that can change while it runs, see Configure.
+// Empty fields use their defaults.
+type Settings struct {
+	// SystemPrompt starts every reply, it sets the persona of the assistant
+	SystemPrompt string
+
+	// TitlePrompt asks for the title of a conversation
+	TitlePrompt string
+
+	// ReplyModel answers users, TitleModel names conversations
+	ReplyModel string
+	TitleModel string
+
+	// WeatherAPIKey lets the get_weather tool call WeatherAPI, it fails without one
+	WeatherAPIKey string
+
+	// HolidayCalendarLink is the iCalendar feed the get_holidays tool reads
+	HolidayCalendarLink string
+
+	// ToolsConfig is the file declaring external tools and MCP servers, see tools.LoadConfig
+	ToolsConfig string
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.SystemPrompt == "" {
+		s.SystemPrompt = DefaultSystemPrompt
+	}
+	if s.TitlePrompt == "" {
+		s.TitlePrompt = DefaultTitlePrompt
+	}
+	if s.ReplyModel == "" {
+		s.ReplyModel = openai.ChatModelGPT4_1
+	}
+	if s.TitleModel == "" {
+		s.TitleModel = openai.ChatModelGPT4oMini // Use faster, cheaper model for title generation
+	}
+	if s.HolidayCalendarLink == "" {
+		s.HolidayCalendarLink = tools.DefaultHolidayCalendarLink
+	}
+	return s
+}
+
+// Configure swaps the settings of the assistant. Requests being served keep the settings they started
+// with, the next ones use the new settings. When the tools configuration cannot be read nothing changes;
+// MCP servers that fail to connect are left out and reported in the error, the rest still applies.
+//
+// The tools are connected first and swapped in with the settings at once, so no request sees the new
+// tools with the old settings.
+func (a *Assistant) Configure(ctx context.Context, settings Settings) error {
+	settings = settings.withDefaults()
+
+	cfg := &tools.Config{}
+	if settings.ToolsConfig != "" {
+		loaded, err := tools.LoadConfig(settings.ToolsConfig)
+		if err != nil {
+			return err
+		}
+		cfg = loaded
+	}
+
+	loaded, err := a.registry.Prepare(ctx, cfg)
+	if loaded == nil {
+		return err
+	}
+
+	a.registry.Apply(loaded, builtins(settings), func() {
+		a.settings.Store(&settings)
+	})
+
+	if settings.ToolsConfig != "" {
+		slog.InfoContext(ctx, "Configured tools loaded", "path", settings.ToolsConfig)
+	}
+
+	return err
+}
+
+// builtins are the built-in tools that depend on the settings
+func builtins(settings Settings) []tools.Tool {
+	return []tools.Tool{
+		tools.NewWeatherTool(weatherclient.New(settings.WeatherAPIKey)),
+		tools.NewHolidayTool(settings.HolidayCalendarLink),
+	}
+}
@@ -0,0 +1,78 @@
+package assistant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAssistant_Configure(t *testing.T) {
+	// The model answers every request, recording the model and system prompt it was asked with
+	var asked struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&asked)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Ahoy!"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	conv := &model.Conversation{
+		ID:       primitive.NewObjectID(),
+		Messages: []*model.Message{{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "Hello"}},
+	}
+
+	a := New()
+	if _, err := a.Reply(context.Background(), conv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if asked.Model != "gpt-4.1" || asked.Messages[0].Content != DefaultSystemPrompt {
+		t.Errorf("expected the defaults, got %q with %q", asked.Model, asked.Messages[0].Content)
+	}
+
+	toolsConfig := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(toolsConfig, []byte(`{"external": [{"name": "lookup", "url": "https://example.com/lookup"}], "disabled": ["calculate"]}`), 0o600); err != nil {
+		t.Fatalf("failed to write tools config: %v", err)
+	}
+
+	err := a.Configure(context.Background(), Settings{SystemPrompt: "You are a pirate.", ReplyModel: "gpt-4o", ToolsConfig: toolsConfig})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := a.Reply(context.Background(), conv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if asked.Model != "gpt-4o" || asked.Messages[0].Content != "You are a pirate." {
+		t.Errorf("expected the new settings, got %q with %q", asked.Model, asked.Messages[0].Content)
+	}
+
+	if !a.Tools().Enabled("lookup") || a.Tools().Enabled("calculate") {
+		t.Errorf("expected the configured tools to be loaded, got %v", a.Tools().List())
+	}
+
+	// A tools configuration that cannot be read changes nothing
+	if err := a.Configure(context.Background(), Settings{SystemPrompt: "You are a poet.", ToolsConfig: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Fatal("expected an error for the missing tools config")
+	}
+
+	if got := a.settings.Load().SystemPrompt; got != "You are a pirate." {
+		t.Errorf("expected the running settings to stay, got %q", got)
+	}
+}
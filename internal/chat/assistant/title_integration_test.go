@@ -62,7 +62,7 @@ func TestTitleDoesNotAnswerQuestion(t *testing.T) {
 				}},
 			}
 
-			title, err := assist.Title(ctx, conv)
+			title, _, err := assist.Title(ctx, conv)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -0,0 +1,110 @@
+package assistant
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/openai/openai-go/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// price is the cost in US dollars per million tokens
+type price struct {
+	input, cachedInput, output float64
+}
+
+// prices of the models used by the assistant, see https://openai.com/api/pricing
+var prices = map[string]price{
+	openai.ChatModelGPT4_1:     {input: 2.00, cachedInput: 0.50, output: 8.00},
+	openai.ChatModelGPT4_1Mini: {input: 0.40, cachedInput: 0.10, output: 1.60},
+	openai.ChatModelGPT4o:      {input: 2.50, cachedInput: 1.25, output: 10.00},
+	openai.ChatModelGPT4oMini:  {input: 0.15, cachedInput: 0.075, output: 0.60},
+
+	openai.EmbeddingModelTextEmbedding3Small: {input: 0.02},
+}
+
+// priceOf looks up the price of a model, responses name dated snapshots like "gpt-4.1-2025-04-14"
+// so the longest model name the snapshot starts with wins
+func priceOf(name string) (price, bool) {
+	var best string
+	for m := range prices {
+		if strings.HasPrefix(name, m) && len(m) > len(best) {
+			best = m
+		}
+	}
+
+	p, ok := prices[best]
+	return p, ok
+}
+
+// newUsage converts the usage reported by OpenAI and estimates its cost
+func newUsage(name string, u openai.CompletionUsage) *model.Usage {
+	usage := &model.Usage{
+		Model:            name,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     u.PromptTokensDetails.CachedTokens,
+	}
+
+	estimateCost(usage)
+	return usage
+}
+
+func estimateCost(usage *model.Usage) {
+	p, ok := priceOf(usage.Model)
+	if !ok {
+		slog.Warn("No price known for model, cost not estimated", "model", usage.Model)
+		return
+	}
+
+	uncached := usage.PromptTokens - usage.CachedTokens
+	usage.CostUSD = (float64(uncached)*p.input + float64(usage.CachedTokens)*p.cachedInput + float64(usage.CompletionTokens)*p.output) / 1e6
+}
+
+// usageMetrics exports token usage and cost by model
+type usageMetrics struct {
+	tokens metric.Int64Counter
+	cost   metric.Float64Counter
+}
+
+func newUsageMetrics() *usageMetrics {
+	meter := otel.Meter("acai-travel-chat-service")
+
+	tokens, err := meter.Int64Counter(
+		"llm.tokens",
+		metric.WithDescription("Tokens consumed by model calls, by model and token type (prompt, completion, cached)"),
+		metric.WithUnit("{token}"),
+	)
+	if err != nil {
+		slog.Error("Failed to create token counter", "error", err)
+		return nil
+	}
+
+	cost, err := meter.Float64Counter(
+		"llm.cost",
+		metric.WithDescription("Estimated cost of model calls in US dollars, by model"),
+		metric.WithUnit("USD"),
+	)
+	if err != nil {
+		slog.Error("Failed to create cost counter", "error", err)
+		return nil
+	}
+
+	return &usageMetrics{tokens: tokens, cost: cost}
+}
+
+func (m *usageMetrics) record(ctx context.Context, usage *model.Usage) {
+	if m == nil {
+		return
+	}
+
+	byModel := attribute.String("model", usage.Model)
+	m.tokens.Add(ctx, usage.PromptTokens, metric.WithAttributes(byModel, attribute.String("type", "prompt")))
+	m.tokens.Add(ctx, usage.CompletionTokens, metric.WithAttributes(byModel, attribute.String("type", "completion")))
+	m.tokens.Add(ctx, usage.CachedTokens, metric.WithAttributes(byModel, attribute.String("type", "cached")))
+	m.cost.Add(ctx, usage.CostUSD, metric.WithAttributes(byModel))
+}
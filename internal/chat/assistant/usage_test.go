@@ -0,0 +1,32 @@
+package assistant
+
+import (
+	"math"
+	"testing"
+
+	"github.com/openai/openai-go/v2"
+)
+
+func TestNewUsage(t *testing.T) {
+	u := openai.CompletionUsage{PromptTokens: 1_000_000, CompletionTokens: 500_000}
+	u.PromptTokensDetails.CachedTokens = 400_000
+
+	t.Run("estimates cost of dated snapshots", func(t *testing.T) {
+		usage := newUsage("gpt-4o-mini-2024-07-18", u)
+
+		// 600k uncached at 0.15, 400k cached at 0.075 and 500k completion at 0.60 per million
+		if want := 0.09 + 0.03 + 0.30; math.Abs(usage.CostUSD-want) > 1e-9 {
+			t.Errorf("expected cost %v, got %v", want, usage.CostUSD)
+		}
+
+		if usage.CachedTokens != 400_000 || usage.Model != "gpt-4o-mini-2024-07-18" {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+	})
+
+	t.Run("does not estimate cost of unknown models", func(t *testing.T) {
+		if usage := newUsage("unknown-model", u); usage.CostUSD != 0 || usage.PromptTokens != 1_000_000 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/acai-travel/tech-challenge/internal/webhook"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReplyKind identifies async reply jobs in the job queue
+const ReplyKind = "reply"
+
+// maxReplyAttempts bounds the times an async reply is generated before it is reported as failed
+const maxReplyAttempts = 3
+
+var errReplying = twirp.NewError(twirp.FailedPrecondition, "a reply is being generated in the background, wait for it with GetMessage")
+
+type replyJob struct {
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	MessageID      primitive.ObjectID `bson:"message_id"`
+	Client         string             `bson:"client"`
+	WebhookURL     string             `bson:"webhook_url,omitempty"`
+}
+
+// replyEvent is the body of webhook deliveries for async replies
+type replyEvent struct {
+	// Type is reply.completed, reply.cancelled or reply.failed
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Reply          string `json:"reply,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// checkAsync validates the async options of a request
+func (s *Server) checkAsync(async bool, webhookURL string) error {
+	if !async {
+		if webhookURL != "" {
+			return twirp.InvalidArgumentError("webhook_url", "is only used with async")
+		}
+		return nil
+	}
+
+	if s.replies == nil {
+		return twirp.NewError(twirp.Unimplemented, "async replies are disabled")
+	}
+
+	if webhookURL == "" {
+		return nil
+	}
+
+	if s.webhooks == nil {
+		return twirp.InvalidArgumentError("webhook_url", "webhooks are disabled on this server")
+	}
+
+	if err := webhook.CheckURL(webhookURL); err != nil {
+		return twirp.InvalidArgumentError("webhook_url", err.Error())
+	}
+
+	return nil
+}
+
+// replyAsync saves the new message and queues the reply to it, returning the ID the reply will have
+func (s *Server) replyAsync(ctx context.Context, conversation *model.Conversation, message *model.Message, webhookURL string) (primitive.ObjectID, error) {
+	if err := s.checkQuota(ctx); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id := primitive.NewObjectID()
+	conversation.PendingReply = &model.PendingReply{MessageID: id, Status: model.ReplyPending, CreatedAt: time.Now()}
+	conversation.UpdatedAt = time.Now()
+
+	if err := s.save(ctx, conversation, message); err == model.ErrConflict {
+		return primitive.NilObjectID, err
+	} else if err != nil {
+		return primitive.NilObjectID, twirp.InternalErrorWith(err)
+	}
+
+	job := replyJob{ConversationID: conversation.ID, MessageID: id, Client: httpx.ClientFromContext(ctx), WebhookURL: webhookURL}
+	if _, err := s.replies.Enqueue(ctx, ReplyKind, job, jobs.WithMaxAttempts(maxReplyAttempts)); err != nil {
+		// Without a job the reply would stay pending forever
+		conversation.PendingReply.Status = model.ReplyFailed
+		conversation.PendingReply.Error = "the reply could not be queued"
+		if err := s.repo.UpdateConversation(ctx, conversation); err != nil {
+			slog.ErrorContext(ctx, "Failed to save failed reply", "conversation_id", conversation.ID.Hex(), "error", err)
+		}
+
+		return primitive.NilObjectID, twirp.InternalErrorWith(err)
+	}
+
+	return id, nil
+}
+
+// HandleReply generates an async reply, register it with the job queue for ReplyKind. The last
+// message of the reply gets the pending message ID, and the webhook of the request is notified.
+func (s *Server) HandleReply(ctx context.Context, job *jobs.Job) error {
+	var p replyJob
+	if err := job.Decode(&p); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	// The reply is charged to the client that asked for it
+	ctx = httpx.WithClient(ctx, p.Client)
+
+	conversation, err := s.repo.DescribeConversation(ctx, p.ConversationID.Hex())
+	if te, ok := err.(twirp.Error); ok && te.Code() == twirp.NotFound {
+		return jobs.Permanent(err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Nothing to do if an earlier run of the job completed the reply before losing its lease
+	if !conversation.Replying() || conversation.PendingReply.MessageID != p.MessageID {
+		return nil
+	}
+
+	// A reply cancelled with CancelReply is stored up to where it stopped, one cancelled because the
+	// queue is shutting down is generated again
+	messages, err := s.reply(ctx, conversation)
+	cancelled := err == errReplyCancelled && ctx.Err() == nil
+	if err != nil && !cancelled {
+		if job.Attempts < job.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		s.failReply(ctx, conversation, p, err)
+		return jobs.Permanent(err)
+	}
+
+	messages[len(messages)-1].ID = p.MessageID
+	reply := appendReply(conversation, messages)
+	conversation.PendingReply = nil
+	conversation.UpdatedAt = time.Now()
+
+	if err := s.save(ctx, conversation, messages...); err != nil {
+		return err
+	}
+
+	event := replyEvent{Type: "reply.completed", Reply: reply}
+	if cancelled {
+		event.Type = "reply.cancelled"
+	}
+
+	s.notify(ctx, p, event)
+	return nil
+}
+
+// failReply records that the reply could not be generated, so clients stop waiting for it
+func (s *Server) failReply(ctx context.Context, conversation *model.Conversation, p replyJob, cause error) {
+	slog.ErrorContext(ctx, "Failed to generate async reply", "conversation_id", p.ConversationID.Hex(), "message_id", p.MessageID.Hex(), "error", cause)
+
+	conversation.PendingReply.Status = model.ReplyFailed
+	conversation.PendingReply.Error = "the assistant could not generate a reply"
+
+	if err := s.repo.UpdateConversation(ctx, conversation); err != nil {
+		slog.ErrorContext(ctx, "Failed to save failed reply", "conversation_id", p.ConversationID.Hex(), "error", err)
+	}
+
+	s.notify(ctx, p, replyEvent{Type: "reply.failed", Error: conversation.PendingReply.Error})
+}
+
+func (s *Server) notify(ctx context.Context, p replyJob, event replyEvent) {
+	if p.WebhookURL == "" || s.webhooks == nil {
+		return
+	}
+
+	event.ConversationID = p.ConversationID.Hex()
+	event.MessageID = p.MessageID.Hex()
+
+	if err := s.webhooks.Send(ctx, p.WebhookURL, event); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue webhook", "conversation_id", event.ConversationID, "error", err)
+	}
+}
+
+func (s *Server) GetMessage(ctx context.Context, req *pb.GetMessageRequest) (*pb.GetMessageResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	if req.GetMessageId() == "" {
+		return nil, twirp.RequiredArgumentError("message_id")
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := primitive.ObjectIDFromHex(req.GetMessageId())
+	if err != nil {
+		return nil, twirp.NotFoundError("message not found")
+	}
+
+	if m := conversation.Message(id); m != nil {
+		out := &pb.GetMessageResponse{Status: pb.GetMessageResponse_COMPLETE, Message: m.Proto()}
+		if path := conversation.Path(); path[len(path)-1].ID == id {
+			out.PendingToolCalls = toolCallsProto(conversation.PendingToolCalls())
+		}
+		return out, nil
+	}
+
+	if p := conversation.PendingReply; p != nil && p.MessageID == id {
+		if p.Status == model.ReplyFailed {
+			return &pb.GetMessageResponse{Status: pb.GetMessageResponse_FAILED, Error: p.Error}, nil
+		}
+		return &pb.GetMessageResponse{Status: pb.GetMessageResponse_PENDING}, nil
+	}
+
+	return nil, twirp.NotFoundError("message not found")
+}
@@ -0,0 +1,104 @@
+package attachment
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// paragraph separates paragraphs in the words passed to Chunk
+const paragraph = "\n\n"
+
+// Chunk splits text into parts of at most size bytes, made of whole words when possible. Each part repeats
+// up to overlap bytes from the end of the previous one, so a sentence cut in two is found in full in one of them.
+func Chunk(text string, size, overlap int) []string {
+	var words []string
+	for i, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		fields := strings.Fields(p)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if i > 0 && len(words) > 0 {
+			words = append(words, paragraph)
+		}
+
+		for _, f := range fields {
+			// Words longer than a chunk, like encoded data, are cut between characters
+			for len(f) > size {
+				cut := size
+				for cut > 0 && !utf8.RuneStart(f[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					_, cut = utf8.DecodeRuneInString(f)
+				}
+
+				words = append(words, f[:cut])
+				f = f[cut:]
+			}
+			words = append(words, f)
+		}
+	}
+
+	var chunks []string
+	var current []string
+	length := 0
+
+	for _, w := range words {
+		if length+len(w)+1 > size && length > 0 {
+			chunks = append(chunks, join(current))
+
+			// Start the next chunk with the last words of this one
+			keep := 0
+			for n := 0; keep < len(current); keep++ {
+				n += len(current[len(current)-1-keep]) + 1
+				if n > overlap {
+					break
+				}
+			}
+			current = append([]string(nil), current[len(current)-keep:]...)
+
+			length = 0
+			for _, c := range current {
+				length += len(c) + 1
+			}
+
+			// The overlap gives way to words that would not fit with it
+			for len(current) > 0 && length+len(w)+1 > size {
+				length -= len(current[0]) + 1
+				current = current[1:]
+			}
+		}
+
+		if w == paragraph && len(current) == 0 {
+			continue
+		}
+
+		current = append(current, w)
+		length += len(w) + 1
+	}
+
+	if length > 0 {
+		chunks = append(chunks, join(current))
+	}
+
+	return chunks
+}
+
+// join renders words separated by spaces, and by blank lines between paragraphs
+func join(words []string) string {
+	var b strings.Builder
+	for i, w := range words {
+		switch {
+		case w == paragraph:
+			b.WriteString(paragraph)
+		case i > 0 && words[i-1] != paragraph:
+			b.WriteString(" ")
+			b.WriteString(w)
+		default:
+			b.WriteString(w)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
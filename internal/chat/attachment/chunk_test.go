@@ -0,0 +1,52 @@
+package attachment
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunk(t *testing.T) {
+	t.Run("short text is a single chunk", func(t *testing.T) {
+		got := Chunk("  Hotel   Alfama,\nLisbon.\r\n\r\n\n\nCheck-in from 3pm. ", 100, 20)
+		if len(got) != 1 || got[0] != "Hotel Alfama, Lisbon.\n\nCheck-in from 3pm." {
+			t.Errorf("unexpected chunks %q", got)
+		}
+	})
+
+	t.Run("long text overlaps", func(t *testing.T) {
+		got := Chunk("one two three four five six seven eight nine ten", 20, 10)
+		want := []string{"one two three four", "four five six seven", "six seven eight", "eight nine ten"}
+
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("long words are cut", func(t *testing.T) {
+		got := Chunk(strings.Repeat("x", 25), 10, 0)
+		if len(got) != 3 || got[0] != strings.Repeat("x", 10) || got[2] != strings.Repeat("x", 5) {
+			t.Errorf("unexpected chunks %q", got)
+		}
+	})
+
+	t.Run("long words are cut between characters", func(t *testing.T) {
+		word := strings.Repeat("é", 7)
+		got := Chunk(word, 5, 0)
+		for _, c := range got {
+			if !utf8.ValidString(c) {
+				t.Errorf("expected valid text, got %q", c)
+			}
+		}
+
+		if strings.Join(got, "") != word {
+			t.Errorf("expected the word to be kept whole across chunks, got %q", got)
+		}
+	})
+
+	t.Run("empty text", func(t *testing.T) {
+		if got := Chunk(" \n\n ", 10, 0); len(got) != 0 {
+			t.Errorf("expected no chunks, got %q", got)
+		}
+	})
+}
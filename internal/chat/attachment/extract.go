@@ -0,0 +1,60 @@
+package attachment
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnsupported is returned for documents of a type whose text cannot be extracted
+	ErrUnsupported = errors.New("unsupported document type, expected plain text, Markdown, HTML or PDF")
+
+	// ErrInvalid is returned for documents that do not match their type
+	ErrInvalid = errors.New("invalid document")
+)
+
+// extensions maps the file extensions of supported documents to their content type
+var extensions = map[string]string{
+	".txt":      "text/plain",
+	".text":     "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".htm":      "text/html",
+	".html":     "text/html",
+	".pdf":      "application/pdf",
+}
+
+// DetectContentType returns the content type of a document: the declared one when set,
+// the one of its file extension otherwise, or finally the one sniffed from its content
+func DetectContentType(filename, declared string, data []byte) string {
+	if declared != "" && declared != "application/octet-stream" {
+		if mediaType, _, err := mime.ParseMediaType(declared); err == nil {
+			return mediaType
+		}
+	}
+
+	if ct, ok := extensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return ct
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
+
+// ExtractText returns the text of a document of the given content type
+func ExtractText(contentType string, data []byte) (string, error) {
+	switch contentType {
+	case "text/plain", "text/markdown", "text/x-markdown":
+		return strings.ToValidUTF8(string(data), string(utf8.RuneError)), nil
+	case "text/html", "application/xhtml+xml":
+		return htmlText(strings.ToValidUTF8(string(data), string(utf8.RuneError))), nil
+	case "application/pdf":
+		return pdfText(data)
+	default:
+		return "", ErrUnsupported
+	}
+}
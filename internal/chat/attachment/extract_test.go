@@ -0,0 +1,156 @@
+package attachment
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name, filename, declared string
+		data                     []byte
+		want                     string
+	}{
+		{"declared", "notes", "text/markdown; charset=utf-8", nil, "text/markdown"},
+		{"extension", "policy.HTML", "", nil, "text/html"},
+		{"generic declared type", "booking.pdf", "application/octet-stream", nil, "application/pdf"},
+		{"sniffed", "booking", "", []byte("%PDF-1.4\n"), "application/pdf"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DetectContentType(tc.filename, tc.declared, tc.data); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	t.Run("html", func(t *testing.T) {
+		doc := `<html><head><title>Policy</title><style>p { color: red }</style></head>
+<body><h1>Travel&nbsp;policy</h1><p>Economy class for flights under <b>6 hours</b>.</p>
+<script>track()</script><ul><li>Hotels up to &euro;150</li><li>No minibar</li></ul></body></html>`
+
+		got, err := ExtractText("text/html", []byte(doc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := "Policy\n\nTravel policy\n\nEconomy class for flights under 6 hours.\n\nHotels up to €150\n\nNo minibar"
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("pdf", func(t *testing.T) {
+		page := "BT /F1 12 Tf 72 712 Td (Booking confirmed) Tj 0 -14 Td [(Hotel) -300 (Alfama, ) 20 (Lisbon)] TJ ET"
+		got, err := ExtractText("application/pdf", pdf(page, true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := "Booking confirmed\nHotel Alfama, Lisbon"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("pdf with escapes and hex strings", func(t *testing.T) {
+		page := `BT (Check-in \(from 3pm\)\234) Tj T* <FEFF004C00690073006200F80061> Tj ET`
+		got, err := ExtractText("application/pdf", pdf(page, false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := "Check-in (from 3pm)œ\nLisbøa"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("pdf decompression bombs", func(t *testing.T) {
+		// Each stream inflates to 8 MB, well under the limit of a stream but not of the document
+		filler := strings.Repeat(" ", 8<<20)
+		if _, err := ExtractText("application/pdf", streams(filler, 9)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for too much content, got %v", err)
+		}
+
+		text := "BT (" + strings.Repeat("a", 4<<20) + ") Tj ET"
+		if _, err := ExtractText("application/pdf", streams(text, 3)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for too much text, got %v", err)
+		}
+	})
+
+	t.Run("pdf with many streams", func(t *testing.T) {
+		// Thousands of streams after megabytes without an object must not make each stream scan the whole document
+		doc := "%PDF-1.4\n" + strings.Repeat(" ", 4<<20) + strings.Repeat("stream\nBT ET\nendstream\n", 20000)
+
+		start := time.Now()
+		if _, err := ExtractText("application/pdf", []byte(doc)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected the document to be read within 2s, took %v", elapsed)
+		}
+	})
+
+	t.Run("not a pdf", func(t *testing.T) {
+		if _, err := ExtractText("application/pdf", []byte("hello")); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := ExtractText("image/png", []byte{0x89, 'P', 'N', 'G'}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+	})
+}
+
+// pdf builds a single page document drawing the content stream
+func pdf(content string, compress bool) []byte {
+	stream, filter := []byte(content), ""
+	if compress {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		_, _ = w.Write(stream)
+		_ = w.Close()
+		stream, filter = b.Bytes(), " /Filter /FlateDecode"
+	}
+
+	var doc bytes.Buffer
+	doc.WriteString("%PDF-1.4\n")
+	doc.WriteString("1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n")
+	doc.WriteString("2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n")
+	doc.WriteString("3 0 obj << /Type /Page /Parent 2 0 R /Contents 4 0 R >> endobj\n")
+	fmt.Fprintf(&doc, "4 0 obj << /Length %d%s >>\nstream\n", len(stream), filter)
+	doc.Write(stream)
+	doc.WriteString("\nendstream\nendobj\n")
+	doc.WriteString("5 0 obj << /Subtype /Image /Length 2 >>\nstream\nBT\nendstream\nendobj\n")
+	doc.WriteString("trailer << /Root 1 0 R >>\n%%EOF\n")
+
+	return doc.Bytes()
+}
+
+// streams builds a document of copies of a compressed content stream
+func streams(content string, copies int) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	_, _ = w.Write([]byte(content))
+	_ = w.Close()
+
+	var doc bytes.Buffer
+	doc.WriteString("%PDF-1.4\n")
+	for i := 0; i < copies; i++ {
+		fmt.Fprintf(&doc, "%d 0 obj << /Length %d /Filter /FlateDecode >>\nstream\n", i+1, b.Len())
+		doc.Write(b.Bytes())
+		doc.WriteString("\nendstream\nendobj\n")
+	}
+	doc.WriteString("%%EOF\n")
+
+	return doc.Bytes()
+}
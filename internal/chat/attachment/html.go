@@ -0,0 +1,43 @@
+package attachment
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// htmlHidden matches elements whose content is not text of the page
+	htmlHidden = regexp.MustCompile(`(?is)<!--.*?-->|<(script|style|noscript|template|svg)\b.*?</(script|style|noscript|template|svg)\s*>`)
+
+	// htmlBreak matches tags that start a new line of text
+	htmlBreak = regexp.MustCompile(`(?i)<(br|hr|/?(p|div|section|article|header|footer|li|ul|ol|tr|table|h[1-6]|blockquote|pre|title))\b[^>]*>`)
+
+	// htmlInline matches formatting tags within words and sentences, other tags separate words
+	htmlInline = regexp.MustCompile(`(?i)</?(a|abbr|b|code|em|i|mark|small|span|strong|sub|sup|u)\b[^>]*>`)
+
+	htmlTag    = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaces     = regexp.MustCompile(`[ \t\f\r\v\x{a0}]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlText converts an HTML document to plain text, keeping line breaks between blocks
+func htmlText(doc string) string {
+	doc = htmlHidden.ReplaceAllString(doc, "")
+	doc = htmlBreak.ReplaceAllString(doc, "\n")
+	doc = htmlInline.ReplaceAllString(doc, "")
+	doc = htmlTag.ReplaceAllString(doc, " ")
+	doc = html.UnescapeString(doc)
+
+	return tidy(doc)
+}
+
+// tidy collapses runs of spaces and blank lines
+func tidy(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
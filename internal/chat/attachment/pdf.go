@@ -0,0 +1,455 @@
+package attachment
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// pdfSkipped matches dictionaries of streams that hold no page content: images, fonts, metadata and cross-reference data
+	pdfSkipped = regexp.MustCompile(`/Subtype\s*/Image|/Type\s*/(XRef|ObjStm|Metadata|EmbeddedFile)|/Length[123]\b|/FontFile|/Subtype\s*/(Type1C|CIDFontType0C|OpenType)`)
+
+	pdfFilter = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/\w+)`)
+)
+
+// The sizes of decompressed data are bounded to protect against decompression bombs: each stream, all of the
+// streams of a document together, and the text extracted from them, which is at most as long as a text document
+// can be
+const (
+	maxStream  = 32 << 20
+	maxDecoded = 64 << 20
+	maxText    = 10 << 20
+)
+
+// maxDict bounds how far before a stream its dictionary is looked for
+const maxDict = 4 << 10
+
+// pdfText extracts the text drawn on the pages of a PDF document. It reads uncompressed and Flate compressed
+// content streams and decodes strings as single byte or UTF-16 text, which covers documents generated by most
+// office tools and browsers. Scanned documents and fonts with custom encodings yield no text.
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return "", fmt.Errorf("%w: not a PDF", ErrInvalid)
+	}
+
+	var b strings.Builder
+	decoded := 0
+
+	for pos := 0; ; {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			break
+		}
+
+		start := pos + i
+		pos = start + len("stream")
+
+		// Skip the end marker of the previous stream
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+
+		// The dictionary is looked for right before the keyword only, so documents with many streams are not
+		// scanned again from the start for each of them
+		dict := data[max(0, start-maxDict):start]
+		if j := bytes.LastIndex(dict, []byte("obj")); j >= 0 {
+			dict = dict[j:]
+		}
+
+		// The stream data starts after the end of line following the keyword
+		if pos < len(data) && data[pos] == '\r' {
+			pos++
+		}
+		if pos < len(data) && data[pos] == '\n' {
+			pos++
+		}
+
+		end := bytes.Index(data[pos:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+
+		raw := data[pos : pos+end]
+		pos += end + len("endstream")
+
+		if pdfSkipped.Match(dict) {
+			continue
+		}
+
+		content, ok := decodeStream(dict, raw, min(maxStream, maxDecoded-decoded+1))
+		if decoded += len(content); decoded > maxDecoded {
+			return "", fmt.Errorf("%w: more than %d MB of compressed content", ErrInvalid, maxDecoded>>20)
+		}
+
+		if !ok || !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+
+		b.WriteString(contentText(content))
+		b.WriteString("\n")
+
+		if b.Len() > maxText {
+			return "", fmt.Errorf("%w: more than %d MB of text", ErrInvalid, maxText>>20)
+		}
+	}
+
+	return tidy(b.String()), nil
+}
+
+// decodeStream returns the data of a stream, up to limit bytes once decompressed. It reports false for filters
+// other than Flate.
+func decodeStream(dict, raw []byte, limit int) ([]byte, bool) {
+	m := pdfFilter.FindSubmatch(dict)
+	if m == nil {
+		return raw, true
+	}
+
+	filters := strings.Fields(strings.NewReplacer("[", " ", "]", " ", "/", " ").Replace(string(m[1])))
+	if len(filters) != 1 || filters[0] != "FlateDecode" {
+		return nil, false
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, false
+	}
+
+	// Streams are often truncated by a few bytes, what could be read is kept
+	out, _ := io.ReadAll(io.LimitReader(r, int64(limit)))
+	return out, len(out) > 0
+}
+
+// pdfOperand is a value pushed before an operator of a content stream, only strings, numbers and arrays are kept
+type pdfOperand struct {
+	str   *string
+	num   float64
+	array []pdfOperand
+	isNum bool
+}
+
+// contentText runs the text operators of a content stream and returns the text they show
+func contentText(content []byte) string {
+	var b strings.Builder
+	var operands []pdfOperand
+	var stack [][]pdfOperand // Operands of the enclosing arrays
+
+	lastY := 0.0
+
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	lex := &pdfLexer{data: content}
+	for {
+		kind, tok := lex.next()
+		switch kind {
+		case tokEOF:
+			return b.String()
+		case tokString:
+			s := tok
+			operands = append(operands, pdfOperand{str: &s})
+		case tokNumber:
+			n, _ := strconv.ParseFloat(tok, 64)
+			operands = append(operands, pdfOperand{num: n, isNum: true})
+		case tokArrayStart:
+			stack = append(stack, operands)
+			operands = nil
+		case tokArrayEnd:
+			if len(stack) == 0 {
+				continue
+			}
+			array := operands
+			operands = append(stack[len(stack)-1], pdfOperand{array: array})
+			stack = stack[:len(stack)-1]
+		case tokOther:
+			// Names and dictionaries are operands of no interest
+			operands = append(operands, pdfOperand{})
+		case tokOperator:
+			switch tok {
+			case "Tj":
+				writeOperand(&b, operands, 1)
+			case "'", "\"":
+				newline()
+				writeOperand(&b, operands, 1)
+			case "TJ":
+				if len(operands) > 0 {
+					for _, o := range operands[len(operands)-1].array {
+						switch {
+						case o.str != nil:
+							b.WriteString(*o.str)
+						case o.isNum && o.num < -200:
+							// A large negative adjustment moves the next glyph far enough to be a space
+							b.WriteString(" ")
+						}
+					}
+				}
+			case "Td", "TD":
+				if len(operands) >= 2 && operands[len(operands)-1].num != 0 {
+					newline()
+				} else {
+					b.WriteString(" ")
+				}
+			case "Tm":
+				if len(operands) >= 6 {
+					if y := operands[len(operands)-1].num; y != lastY {
+						newline()
+						lastY = y
+					} else {
+						b.WriteString(" ")
+					}
+				}
+			case "T*", "ET":
+				newline()
+			}
+
+			operands = operands[:0]
+			stack = stack[:0]
+		}
+	}
+}
+
+// writeOperand writes the string operand at position n from the end, if any
+func writeOperand(b *strings.Builder, operands []pdfOperand, n int) {
+	if len(operands) >= n && operands[len(operands)-n].str != nil {
+		b.WriteString(*operands[len(operands)-n].str)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokString
+	tokNumber
+	tokArrayStart
+	tokArrayEnd
+	tokOperator
+	tokOther
+)
+
+// pdfLexer splits a content stream into tokens, strings are returned decoded
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (tokenKind, string) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return tokString, decodePDFString(l.literal())
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.pos += 2
+			return tokOther, "<<"
+		case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return tokOther, ">>"
+		case c == '<':
+			return tokString, decodePDFString(l.hex())
+		case c == '[':
+			l.pos++
+			return tokArrayStart, "["
+		case c == ']':
+			l.pos++
+			return tokArrayEnd, "]"
+		case c == '/':
+			l.pos++
+			return tokOther, "/" + l.word()
+		case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			return tokNumber, l.word()
+		case c == '\'' || c == '"':
+			l.pos++
+			return tokOperator, string(c)
+		default:
+			w := l.word()
+			if w == "" {
+				// A delimiter that starts no token, like a stray ')'
+				l.pos++
+				continue
+			}
+			if w == "BI" {
+				l.skipInlineImage()
+				continue
+			}
+			return tokOperator, w
+		}
+	}
+
+	return tokEOF, ""
+}
+
+// word reads characters up to the next whitespace or delimiter
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !strings.ContainsRune("()<>[]{}/%", rune(l.data[l.pos])) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literal reads a (string) with balanced parentheses and escapes
+func (l *pdfLexer) literal() []byte {
+	var out []byte
+	depth := 0
+
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for k := 0; k < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; k++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// hex reads a <hexadecimal string>, a missing last digit counts as 0
+func (l *pdfLexer) hex() []byte {
+	l.pos++ // <
+
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // >
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(n)
+	}
+
+	return out
+}
+
+// skipInlineImage moves past the binary data of an inline image, up to its EI operator
+func (l *pdfLexer) skipInlineImage() {
+	for i := l.pos; ; {
+		j := bytes.Index(l.data[i:], []byte("EI"))
+		if j < 0 {
+			l.pos = len(l.data)
+			return
+		}
+
+		at := i + j
+		if isPDFSpace(l.data[at-1]) && (at+2 == len(l.data) || isPDFSpace(l.data[at+2])) {
+			l.pos = at + 2
+			return
+		}
+
+		i = at + 2
+	}
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+// winAnsi maps the bytes of the Windows-1252 encoding that differ from Latin-1
+var winAnsi = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰',
+	0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•',
+	0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›', 0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+}
+
+// decodePDFString decodes UTF-16 strings marked with a byte order mark, and others as Windows-1252,
+// the encoding of the standard fonts. Control characters are dropped.
+func decodePDFString(raw []byte) string {
+	var b strings.Builder
+
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		for _, r := range utf16.Decode(units) {
+			if r >= ' ' || r == '\n' {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+
+	for _, c := range raw {
+		switch r, ok := winAnsi[c]; {
+		case ok:
+			b.WriteRune(r)
+		case c >= ' ' && c != 0x7f && (c < 0x80 || c >= 0xa0):
+			b.WriteRune(rune(c))
+		case c == '\n':
+			b.WriteByte(' ')
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,119 @@
+// Package attachment extracts the text of documents added to conversations and retrieves the parts
+// relevant to a question, comparing embeddings of the parts with the embedding of the question.
+package attachment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// chunkSize and chunkOverlap are in bytes, about 400 and 50 tokens of English text
+	chunkSize    = 1600
+	chunkOverlap = 200
+
+	// embedBatch bounds the chunks embedded by a single request
+	embedBatch = 64
+)
+
+// ErrNoText is returned for documents without any text, like scanned PDFs
+var ErrNoText = errors.New("no text found in the document")
+
+// Embedder computes embeddings of texts, implemented by assistant.Embedder
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, *model.Usage, error)
+}
+
+// Store keeps the text of attachments in chunks with their embeddings
+type Store struct {
+	repo     *model.Repository
+	embedder Embedder
+}
+
+func NewStore(repo *model.Repository, embedder Embedder) *Store {
+	return &Store{repo: repo, embedder: embedder}
+}
+
+// Add extracts the text of a document, splits it in chunks and stores them with their embeddings. It returns
+// the usage of the embeddings, which is also returned with an error when some of them were computed.
+func (s *Store) Add(ctx context.Context, conversationID primitive.ObjectID, filename, contentType string, data []byte) (*model.Attachment, *model.Usage, error) {
+	contentType = DetectContentType(filename, contentType, data)
+	spent := &model.Usage{}
+
+	text, err := ExtractText(contentType, data)
+	if err != nil {
+		return nil, spent, err
+	}
+
+	parts := Chunk(text, chunkSize, chunkOverlap)
+	if len(parts) == 0 {
+		return nil, spent, ErrNoText
+	}
+
+	attachment := &model.Attachment{
+		ID:             primitive.NewObjectID(),
+		ConversationID: conversationID,
+		Filename:       filename,
+		ContentType:    contentType,
+		Size:           int64(len(data)),
+		Chunks:         len(parts),
+		CreatedAt:      time.Now(),
+	}
+
+	chunks := make([]*model.AttachmentChunk, 0, len(parts))
+	for start := 0; start < len(parts); start += embedBatch {
+		batch := parts[start:min(start+embedBatch, len(parts))]
+
+		vectors, usage, err := s.embedder.Embed(ctx, batch)
+		if err != nil {
+			return nil, spent, err
+		}
+		spent.Add(usage)
+
+		for i, content := range batch {
+			chunks = append(chunks, &model.AttachmentChunk{
+				ID:             primitive.NewObjectID(),
+				AttachmentID:   attachment.ID,
+				ConversationID: conversationID,
+				Filename:       filename,
+				Index:          start + i,
+				Content:        content,
+				Model:          usage.Model,
+				Vector:         vectors[i],
+			})
+		}
+	}
+
+	if err := s.repo.SaveAttachment(ctx, attachment, chunks); err != nil {
+		return nil, spent, err
+	}
+
+	return attachment, spent, nil
+}
+
+// Search returns up to limit chunks of the conversation's attachments most similar to the query, best first,
+// and the usage of the query embedding, also when the search fails
+func (s *Store) Search(ctx context.Context, conversationID primitive.ObjectID, query string, limit int) ([]*model.ChunkMatch, *model.Usage, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil, nil
+	}
+
+	// Most conversations have no attachments, they are not worth embedding the query for
+	attachments, err := s.repo.ListAttachments(ctx, conversationID)
+	if err != nil || len(attachments) == 0 {
+		return nil, nil, err
+	}
+
+	vectors, usage, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, usage, err
+	}
+
+	matches, err := s.repo.NearestChunks(ctx, conversationID, vectors[0], limit)
+	return matches, usage, err
+}
@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errReplyCancelled is returned when the reply was cancelled, by CancelReply or by the client going away.
+// The turn is stored up to where it stopped.
+var errReplyCancelled = twirp.NewError(twirp.Canceled, "the reply was cancelled")
+
+// generations tracks the replies being generated by this server, by conversation, so CancelReply can
+// cancel them. Replies generated by other instances are not known.
+type generations struct {
+	mu      sync.Mutex
+	running map[primitive.ObjectID]map[*generation]struct{}
+}
+
+type generation struct {
+	cancel context.CancelFunc
+}
+
+// start tracks a reply to the conversation and returns its context, cancelled by cancel until done is called
+func (g *generations) start(ctx context.Context, id primitive.ObjectID) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	gen := &generation{cancel: cancel}
+
+	g.mu.Lock()
+	if g.running == nil {
+		g.running = map[primitive.ObjectID]map[*generation]struct{}{}
+	}
+	if g.running[id] == nil {
+		g.running[id] = map[*generation]struct{}{}
+	}
+	g.running[id][gen] = struct{}{}
+	g.mu.Unlock()
+
+	return ctx, func() {
+		g.mu.Lock()
+		delete(g.running[id], gen)
+		if len(g.running[id]) == 0 {
+			delete(g.running, id)
+		}
+		g.mu.Unlock()
+
+		cancel()
+	}
+}
+
+// cancel cancels the replies to the conversation and returns how many there were
+func (g *generations) cancel(id primitive.ObjectID) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for gen := range g.running[id] {
+		gen.cancel()
+	}
+
+	return len(g.running[id])
+}
+
+// cancelledReply ends the messages generated before a reply was cancelled with a cancelled assistant
+// message, the partial answer when the model had started it
+func cancelledReply(messages []*model.Message) []*model.Message {
+	if n := len(messages); n > 0 && messages[n-1].Cancelled {
+		return messages
+	}
+
+	return append(messages, &model.Message{Role: model.RoleAssistant, Cancelled: true})
+}
+
+func (s *Server) CancelReply(ctx context.Context, req *pb.CancelReplyRequest) (*pb.CancelReplyResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	id, err := primitive.ObjectIDFromHex(req.GetConversationId())
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("conversation_id", "must be a valid conversation ID")
+	}
+
+	n := s.generations.cancel(id)
+	if n > 0 {
+		slog.InfoContext(ctx, "Reply cancelled", "conversation_id", id.Hex(), "replies", n)
+	}
+
+	return &pb.CancelReplyResponse{Cancelled: int32(n)}, nil
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/mcp"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewMCPServer exposes the registered tools and the conversation operations of the server over MCP.
+// Tools that need the user's approval are left out, they only run through conversations, where
+// approve_tool_call and reject_tool_call decide on them.
+func NewMCPServer(s *Server, registry *tools.Registry) *mcp.Server {
+	srv := mcp.NewServer("acai-chat", "1.0.0")
+
+	for _, name := range registry.List() {
+		tool, ok := registry.Get(name)
+		if !ok || tools.RiskOf(tool).RequiresApproval() {
+			continue
+		}
+
+		d := tools.Describe(tool)
+
+		srv.AddTool(mcp.Tool{Name: d.Name, Description: d.Description, InputSchema: d.Parameters},
+			func(ctx context.Context, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+				out, err := registry.Execute(ctx, name, string(arguments))
+				if err != nil {
+					return nil, err
+				}
+				return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent(out)}}, nil
+			})
+	}
+
+	conversationID := map[string]any{
+		"type":        "string",
+		"description": "ID of the conversation",
+	}
+
+	message := map[string]any{
+		"type":        "string",
+		"description": "Message from the user",
+	}
+
+	parts := map[string]any{
+		"type":        "array",
+		"description": "Text and images sent after the message",
+		"items": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"text": map[string]any{"type": "string"},
+				"image": map[string]any{
+					"type":        "object",
+					"description": "An image by url, or uploaded as base64 data with its content_type",
+					"properties": map[string]any{
+						"url":          map[string]any{"type": "string"},
+						"data":         map[string]any{"type": "string", "contentEncoding": "base64"},
+						"content_type": map[string]any{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+
+	responseSchema := map[string]any{
+		"type":        "object",
+		"description": "JSON Schema of an object the reply must match, the reply is then also returned as structured_reply",
+	}
+
+	addRPC(srv, mcp.Tool{
+		Name:        "start_conversation",
+		Description: "Start a new conversation with the travel assistant. Returns the conversation ID, its title and the assistant's reply.",
+		InputSchema: objectSchema(map[string]any{"message": message, "parts": parts, "response_schema": responseSchema}, "message"),
+	}, s.StartConversation)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "continue_conversation",
+		Description: "Send a new message to an existing conversation and get the assistant's reply.",
+		InputSchema: objectSchema(map[string]any{"conversation_id": conversationID, "message": message, "parts": parts, "response_schema": responseSchema}, "conversation_id", "message"),
+	}, s.ContinueConversation)
+
+	toolCallID := map[string]any{
+		"type":        "string",
+		"description": "ID of a pending tool call returned in pending_tool_calls",
+	}
+
+	addRPC(srv, mcp.Tool{
+		Name:        "approve_tool_call",
+		Description: "Approve a tool call the assistant is waiting on, the assistant runs the tool and resumes its reply. Set always_allow to run later calls to the same tool without asking.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"tool_call_id":    toolCallID,
+			"always_allow":    map[string]any{"type": "boolean"},
+		}, "conversation_id", "tool_call_id"),
+	}, s.ApproveToolCall)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "reject_tool_call",
+		Description: "Reject a tool call the assistant is waiting on, optionally explaining why. The assistant resumes its reply without running the tool.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"tool_call_id":    toolCallID,
+			"reason":          map[string]any{"type": "string"},
+		}, "conversation_id", "tool_call_id"),
+	}, s.RejectToolCall)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "regenerate_reply",
+		Description: "Generate a new reply to the last message of a conversation, or to the reply with the given message ID. The previous reply is kept in another branch.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"message_id":      map[string]any{"type": "string", "description": "ID of a message of the reply to regenerate"},
+		}, "conversation_id"),
+	}, s.RegenerateReply)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "edit_message",
+		Description: "Replace a user message of a conversation and get a reply to the new message. The original message and what followed are kept in another branch.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"message_id":      map[string]any{"type": "string", "description": "ID of the user message to replace"},
+			"message":         message,
+		}, "conversation_id", "message_id", "message"),
+	}, s.EditMessage)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "fork_conversation",
+		Description: "Copy a conversation up to a message into a new conversation, to explore an alternative without changing the original. Returns the new conversation ID.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"message_id":      map[string]any{"type": "string", "description": "ID of the last message to copy, defaults to the last message"},
+		}, "conversation_id"),
+	}, s.ForkConversation)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "list_conversations",
+		Description: "List the most recent conversations with their IDs and titles.",
+		InputSchema: objectSchema(map[string]any{}),
+	}, s.ListConversations)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "search_conversations",
+		Description: "Find conversations by words in their title or messages, most relevant first, with snippets of the matching messages.",
+		InputSchema: objectSchema(map[string]any{
+			"query": map[string]any{"type": "string", "description": "Words to look for, \"quoted phrases\" must match exactly and -words must not appear"},
+			"limit": map[string]any{"type": "integer", "minimum": 1, "maximum": 100},
+		}, "query"),
+	}, s.SearchConversations)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "semantic_search",
+		Description: "Find messages of past conversations by meaning rather than exact words, most similar first.",
+		InputSchema: objectSchema(map[string]any{
+			"query":                   map[string]any{"type": "string", "description": "Text to compare the messages with, e.g. a question"},
+			"limit":                   map[string]any{"type": "integer", "minimum": 1, "maximum": 100},
+			"exclude_conversation_id": conversationID,
+		}, "query"),
+	}, s.SemanticSearch)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "list_memories",
+		Description: "List the facts the assistant remembers about the caller across conversations, newest first.",
+		InputSchema: objectSchema(map[string]any{}),
+	}, s.ListMemories)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "delete_memory",
+		Description: "Make the assistant forget a fact it remembers about the caller.",
+		InputSchema: objectSchema(map[string]any{
+			"memory_id": map[string]any{"type": "string"},
+		}, "memory_id"),
+	}, s.DeleteMemory)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "upload_attachment",
+		Description: "Add a plain text, Markdown, HTML or PDF document to a conversation, so the assistant answers with the relevant parts and cites them.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"filename":        map[string]any{"type": "string"},
+			"content_type":    map[string]any{"type": "string", "description": "Detected from the filename and content when omitted"},
+			"content":         map[string]any{"type": "string", "contentEncoding": "base64", "description": "Document, base64 encoded"},
+		}, "conversation_id", "filename", "content"),
+	}, s.UploadAttachment)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "list_attachments",
+		Description: "List the documents added to a conversation.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+		}, "conversation_id"),
+	}, s.ListAttachments)
+
+	addRPC(srv, mcp.Tool{
+		Name:        "describe_conversation",
+		Description: "Get a conversation with the messages of its active branch, or of all branches with full_tree.",
+		InputSchema: objectSchema(map[string]any{
+			"conversation_id": conversationID,
+			"full_tree":       map[string]any{"type": "boolean"},
+		}, "conversation_id"),
+	}, s.DescribeConversation)
+
+	return srv
+}
+
+// addRPC exposes a chat service method as an MCP tool, arguments and results use the protobuf JSON mapping
+func addRPC[Req any, Resp proto.Message, PReq interface {
+	*Req
+	proto.Message
+}](srv *mcp.Server, tool mcp.Tool, call func(context.Context, PReq) (Resp, error)) {
+	srv.AddTool(tool, func(ctx context.Context, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+		req := PReq(new(Req))
+		if len(arguments) > 0 {
+			if err := protojson.Unmarshal(arguments, req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := call(ctx, req)
+		if err != nil {
+			var te twirp.Error
+			if errors.As(err, &te) {
+				return nil, errors.New(te.Msg())
+			}
+			return nil, err
+		}
+
+		out, err := protojson.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent(string(out))}}, nil
+	})
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
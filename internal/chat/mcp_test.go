@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/mcp"
+	"github.com/openai/openai-go/v2"
+)
+
+// bookingTool is a tool with side effects, which the user must approve
+type bookingTool struct{}
+
+func (bookingTool) Name() string { return "book_flight" }
+
+func (bookingTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{Name: "book_flight"})
+}
+
+func (bookingTool) Execute(ctx context.Context, arguments string) (string, error) {
+	return "booked", nil
+}
+
+func (bookingTool) Risk() tools.Risk { return tools.RiskHigh }
+
+func TestNewMCPServer(t *testing.T) {
+	registry := tools.NewRegistry()
+	registry.Register(tools.NewDateTool())
+	registry.Register(bookingTool{})
+
+	srv := NewMCPServer(NewServer(nil, &MockAssistant{}), registry)
+	resp := srv.Handle(context.Background(), &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "tools/list"})
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+
+	var result struct {
+		Tools []mcp.Tool `json:"tools"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		t.Fatalf("failed to decode tools: %v", err)
+	}
+
+	listed := map[string]bool{}
+	for _, tool := range result.Tools {
+		listed[tool.Name] = true
+	}
+
+	if !listed["get_today_date"] || !listed["approve_tool_call"] {
+		t.Errorf("expected the tools and conversation operations, got %v", listed)
+	}
+
+	if listed["book_flight"] {
+		t.Error("expected tools needing approval to be left out")
+	}
+}
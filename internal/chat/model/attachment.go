@@ -0,0 +1,154 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Attachment is a document uploaded to a conversation, its text is stored in chunks for retrieval
+type Attachment struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	Filename       string             `bson:"filename"`
+	ContentType    string             `bson:"content_type"`
+	Size           int64              `bson:"size"`
+	Chunks         int                `bson:"chunks"`
+	CreatedAt      time.Time          `bson:"created_at"`
+}
+
+func (a *Attachment) Proto() *pb.Attachment {
+	return &pb.Attachment{
+		Id:          a.ID.Hex(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Chunks:      int32(a.Chunks),
+		Timestamp:   timestamppb.New(a.CreatedAt),
+	}
+}
+
+// AttachmentChunk is a part of the text of an attachment with its embedding
+type AttachmentChunk struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	AttachmentID   primitive.ObjectID `bson:"attachment_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	Filename       string             `bson:"filename"`
+	Index          int                `bson:"index"`
+	Content        string             `bson:"content"`
+	Model          string             `bson:"model"`
+	Vector         []float32          `bson:"vector"`
+}
+
+// ChunkMatch is a chunk similar to a query, Score is the cosine similarity
+type ChunkMatch struct {
+	*AttachmentChunk
+	Score float64
+}
+
+// Citation points to the chunk of an attachment a reply is based on
+type Citation struct {
+	AttachmentID primitive.ObjectID `bson:"attachment_id"`
+	Filename     string             `bson:"filename"`
+	Chunk        int                `bson:"chunk"`
+	Excerpt      string             `bson:"excerpt"`
+}
+
+func (c *Citation) Proto() *pb.Citation {
+	return &pb.Citation{
+		AttachmentId: c.AttachmentID.Hex(),
+		Filename:     c.Filename,
+		Chunk:        int32(c.Chunk),
+		Excerpt:      c.Excerpt,
+	}
+}
+
+// SaveAttachment stores an attachment with its chunks
+func (r *Repository) SaveAttachment(ctx context.Context, a *Attachment, chunks []*AttachmentChunk) error {
+	if len(chunks) > 0 {
+		docs := make([]any, len(chunks))
+		for i, c := range chunks {
+			docs[i] = c
+		}
+
+		if _, err := r.conn.Collection(chunkCollection).InsertMany(ctx, docs); err != nil {
+			return err
+		}
+	}
+
+	// The attachment is stored last, so it is only listed once all of its chunks can be retrieved
+	_, err := r.conn.Collection(attachmentCollection).InsertOne(ctx, a)
+	return err
+}
+
+// ListAttachments returns the attachments of the conversation, oldest first
+func (r *Repository) ListAttachments(ctx context.Context, conversationID primitive.ObjectID) ([]*Attachment, error) {
+	cursor, err := r.conn.Collection(attachmentCollection).Find(ctx, bson.D{{Key: "conversation_id", Value: conversationID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*Attachment
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(items, func(a, b *Attachment) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return items, nil
+}
+
+// NearestChunks compares the vector with the chunks of the conversation's attachments and returns the most similar ones
+func (r *Repository) NearestChunks(ctx context.Context, conversationID primitive.ObjectID, vector []float32, limit int) ([]*ChunkMatch, error) {
+	cursor, err := r.conn.Collection(chunkCollection).Find(ctx, bson.D{{Key: "conversation_id", Value: conversationID}})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var matches []*ChunkMatch
+
+	for cursor.Next(ctx) {
+		var c AttachmentChunk
+
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, &ChunkMatch{AttachmentChunk: &c, Score: Cosine(vector, c.Vector)})
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(matches, func(a, b *ChunkMatch) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return matches[:min(limit, len(matches))], nil
+}
+
+// EnsureAttachmentIndexes creates the indexes listing the attachments and chunks of a conversation
+func (r *Repository) EnsureAttachmentIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{Keys: bson.D{{Key: "conversation_id", Value: 1}}}
+
+	if _, err := r.conn.Collection(attachmentCollection).Indexes().CreateOne(ctx, index); err != nil {
+		return err
+	}
+
+	_, err := r.conn.Collection(chunkCollection).Indexes().CreateOne(ctx, index)
+	return err
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"slices"
 	"time"
 
 	"github.com/acai-travel/tech-challenge/internal/pb"
@@ -8,24 +9,268 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// UntitledConversation is the title of conversations until one is generated
+const UntitledConversation = "Untitled conversation"
+
 type Conversation struct {
 	ID        primitive.ObjectID `bson:"_id"`
 	Title     string             `bson:"subject"`
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
-	Messages  []*Message         `bson:"messages"`
+
+	// Messages holds the messages of all branches in the order they were created,
+	// use Path for the history of the active branch
+	Messages []*Message `bson:"messages"`
+
+	// ActiveID is the last message of the active branch. It is not set on conversations created before
+	// branching existed, their messages form a single branch in list order.
+	ActiveID primitive.ObjectID `bson:"active_id,omitempty"`
+
+	// ApprovedTools are tools the user allowed to run without confirmation in this conversation
+	ApprovedTools []string `bson:"approved_tools,omitempty"`
+
+	// Usage aggregates the usage of all messages, and of titles generated when the conversation was created
+	Usage Usage `bson:"usage"`
+
+	// TitleUsage aggregates the usage of titles generated in the background, see Repository.SetTitle
+	TitleUsage Usage `bson:"title_usage"`
+
+	// TitledMessages is the number of user messages on the active branch when the title was generated
+	TitledMessages int `bson:"titled_messages,omitempty"`
+
+	// ForkedFrom links back to the source of conversations created by forking another one
+	ForkedFrom *Fork `bson:"forked_from,omitempty"`
+
+	// PendingReply is the reply being generated in the background, or the last one that failed
+	PendingReply *PendingReply `bson:"pending_reply,omitempty"`
+
+	// Version counts the saves of the conversation, see Repository.UpdateConversation
+	Version int64 `bson:"version"`
 }
 
+type ReplyStatus string
+
+const (
+	ReplyPending ReplyStatus = "pending"
+	ReplyFailed  ReplyStatus = "failed"
+)
+
+// PendingReply tracks a reply generated in the background, the last message of the reply gets its ID
+type PendingReply struct {
+	MessageID primitive.ObjectID `bson:"message_id"`
+	Status    ReplyStatus        `bson:"status"`
+	Error     string             `bson:"error,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Replying reports whether a reply is being generated in the background
+func (c *Conversation) Replying() bool {
+	return c.PendingReply != nil && c.PendingReply.Status == ReplyPending
+}
+
+// Fork identifies the conversation and message a conversation was copied from
+type Fork struct {
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	MessageID      primitive.ObjectID `bson:"message_id"`
+}
+
+// Proto converts the conversation with the messages of its active branch
 func (c *Conversation) Proto() *pb.Conversation {
+	return c.proto(c.Path())
+}
+
+// TreeProto converts the conversation with the messages of all branches
+func (c *Conversation) TreeProto() *pb.Conversation {
+	return c.proto(c.Messages)
+}
+
+func (c *Conversation) proto(messages []*Message) *pb.Conversation {
 	proto := &pb.Conversation{
-		Id:        c.ID.Hex(),
-		Title:     c.Title,
-		Timestamp: timestamppb.New(c.UpdatedAt),
+		Id:            c.ID.Hex(),
+		Title:         c.Title,
+		Timestamp:     timestamppb.New(c.UpdatedAt),
+		ApprovedTools: c.ApprovedTools,
+		Usage:         c.TotalUsage().Proto(),
 	}
 
-	for _, m := range c.Messages {
+	if path := c.Path(); len(path) > 0 {
+		proto.ActiveMessageId = path[len(path)-1].ID.Hex()
+	}
+
+	if c.ForkedFrom != nil {
+		proto.ForkedFromConversationId = c.ForkedFrom.ConversationID.Hex()
+		proto.ForkedFromMessageId = c.ForkedFrom.MessageID.Hex()
+	}
+
+	for _, m := range messages {
 		proto.Messages = append(proto.Messages, m.Proto())
 	}
 
 	return proto
 }
+
+// TotalUsage is the usage of the messages and of all generated titles
+func (c *Conversation) TotalUsage() *Usage {
+	total := c.Usage
+	total.Add(&c.TitleUsage)
+	return &total
+}
+
+// UserMessages counts the user messages of the active branch
+func (c *Conversation) UserMessages() int {
+	n := 0
+	for _, m := range c.Path() {
+		if m.Role == RoleUser {
+			n++
+		}
+	}
+	return n
+}
+
+// Path returns the messages of the active branch, from the first one to the active one
+func (c *Conversation) Path() []*Message {
+	if c.ActiveID.IsZero() {
+		return c.Messages
+	}
+
+	return c.PathTo(c.ActiveID)
+}
+
+// PathTo returns the messages from the first one to the given one, or nil if there is no such message
+func (c *Conversation) PathTo(id primitive.ObjectID) []*Message {
+	c.link()
+
+	byID := make(map[primitive.ObjectID]*Message, len(c.Messages))
+	for _, m := range c.Messages {
+		byID[m.ID] = m
+	}
+
+	var path []*Message
+	for m := byID[id]; m != nil; m = byID[m.ParentID] {
+		path = append(path, m)
+	}
+
+	slices.Reverse(path)
+	return path
+}
+
+// Message returns the message with the given ID in any branch
+func (c *Conversation) Message(id primitive.ObjectID) *Message {
+	for _, m := range c.Messages {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Append adds a message to the end of the active branch and makes it the active message.
+// The message must already have its ID.
+func (c *Conversation) Append(m *Message) {
+	c.link()
+
+	if len(c.Messages) > 0 {
+		m.ParentID = c.ActiveID
+	}
+
+	c.Messages = append(c.Messages, m)
+	c.ActiveID = m.ID
+}
+
+// Branch adds a message next to an existing one, sharing its parent, and makes it the active message
+func (c *Conversation) Branch(sibling, m *Message) {
+	c.link()
+
+	m.ParentID = sibling.ParentID
+	c.Messages = append(c.Messages, m)
+	c.ActiveID = m.ID
+}
+
+// Fork copies the messages from the first one to the given one into a new conversation with new IDs.
+// Usage stays with the source conversation, it returns nil if there is no such message.
+func (c *Conversation) Fork(id primitive.ObjectID, now time.Time) *Conversation {
+	path := c.PathTo(id)
+	if path == nil {
+		return nil
+	}
+
+	fork := &Conversation{
+		ID:            primitive.NewObjectID(),
+		Title:         c.Title,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		ApprovedTools: slices.Clone(c.ApprovedTools),
+		ForkedFrom:    &Fork{ConversationID: c.ID, MessageID: id},
+	}
+
+	for _, m := range path {
+		copied := *m
+		copied.ID = primitive.NewObjectID()
+		copied.ParentID = primitive.NilObjectID
+		copied.Usage = nil
+		copied.ToolCalls = nil
+		for _, call := range m.ToolCalls {
+			callCopy := *call
+			copied.ToolCalls = append(copied.ToolCalls, &callCopy)
+		}
+		fork.Append(&copied)
+	}
+
+	return fork
+}
+
+// link gives conversations created before branching existed parent links following the list order
+func (c *Conversation) link() {
+	if !c.ActiveID.IsZero() || len(c.Messages) == 0 {
+		return
+	}
+
+	for i := 1; i < len(c.Messages); i++ {
+		c.Messages[i].ParentID = c.Messages[i-1].ID
+	}
+
+	c.ActiveID = c.Messages[len(c.Messages)-1].ID
+}
+
+// OpenToolCalls returns the calls of the latest assistant message that have no result yet
+func (c *Conversation) OpenToolCalls() []*ToolCall {
+	answered := map[string]bool{}
+
+	path := c.Path()
+	for i := len(path) - 1; i >= 0; i-- {
+		m := path[i]
+
+		switch m.Role {
+		case RoleTool:
+			answered[m.ToolCallID] = true
+		case RoleAssistant:
+			var open []*ToolCall
+			for _, call := range m.ToolCalls {
+				if !answered[call.ID] {
+					open = append(open, call)
+				}
+			}
+			return open
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// PendingToolCalls returns the open tool calls waiting for the user's approval
+func (c *Conversation) PendingToolCalls() []*ToolCall {
+	var pending []*ToolCall
+	for _, call := range c.OpenToolCalls() {
+		if call.Status == ToolCallPending {
+			pending = append(pending, call)
+		}
+	}
+	return pending
+}
+
+// ToolApproved reports whether the user allowed the tool for the whole conversation
+func (c *Conversation) ToolApproved(name string) bool {
+	return slices.Contains(c.ApprovedTools, name)
+}
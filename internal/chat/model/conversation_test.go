@@ -0,0 +1,110 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func contents(messages []*Message) string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Content)
+	}
+	return strings.Join(out, " | ")
+}
+
+func message(role Role, content string) *Message {
+	return &Message{ID: primitive.NewObjectID(), Role: role, Content: content}
+}
+
+func TestConversation_Branches(t *testing.T) {
+	// Conversations stored before branching existed have no parent links
+	question := message(RoleUser, "Weather in Faro?")
+	c := &Conversation{Messages: []*Message{question, message(RoleAssistant, "Sunny")}}
+
+	if got := len(c.Path()); got != 2 {
+		t.Fatalf("expected the list to be the active branch, got %d messages", got)
+	}
+
+	c.Append(message(RoleUser, "And in Porto?"))
+	c.Append(message(RoleAssistant, "Rainy"))
+
+	edited := message(RoleUser, "Weather in Lisbon?")
+	c.Branch(question, edited)
+	c.Append(message(RoleAssistant, "Cloudy"))
+
+	if got, want := contents(c.Path()), "Weather in Lisbon? | Cloudy"; got != want {
+		t.Errorf("expected active branch %q, got %q", want, got)
+	}
+
+	if len(c.Messages) != 6 {
+		t.Errorf("expected all branches to be kept, got %d messages", len(c.Messages))
+	}
+
+	c.ActiveID = c.Messages[3].ID
+	if got, want := contents(c.Path()), "Weather in Faro? | Sunny | And in Porto? | Rainy"; got != want {
+		t.Errorf("expected original branch %q, got %q", want, got)
+	}
+
+	if c.PathTo(primitive.NewObjectID()) != nil {
+		t.Error("expected no path to an unknown message")
+	}
+}
+
+func TestConversation_Fork(t *testing.T) {
+	c := &Conversation{ID: primitive.NewObjectID(), ApprovedTools: []string{"book_hotel"}}
+	c.Append(message(RoleUser, "Plan a weekend in Lisbon"))
+	c.Append(message(RoleAssistant, "Day 1: Alfama"))
+	c.Append(message(RoleUser, "Add a day trip"))
+	c.Messages[1].Usage = &Usage{PromptTokens: 10}
+
+	fork := c.Fork(c.Messages[1].ID, time.Now())
+	if fork == nil {
+		t.Fatal("expected a fork")
+	}
+
+	if got, want := contents(fork.Path()), "Plan a weekend in Lisbon | Day 1: Alfama"; got != want {
+		t.Errorf("expected messages %q, got %q", want, got)
+	}
+
+	for i, m := range fork.Path() {
+		if m.ID == c.Messages[i].ID {
+			t.Errorf("expected message %d to get a new ID", i)
+		}
+		if m.Usage != nil {
+			t.Errorf("expected usage to stay with the source, got %+v on message %d", m.Usage, i)
+		}
+	}
+
+	if fork.ID == c.ID || fork.ForkedFrom.ConversationID != c.ID || fork.ForkedFrom.MessageID != c.Messages[1].ID {
+		t.Errorf("expected a new conversation linking back to the source, got %+v", fork.ForkedFrom)
+	}
+
+	fork.ApprovedTools[0] = "send_email"
+	if c.ApprovedTools[0] != "book_hotel" {
+		t.Error("expected the fork not to share state with the source")
+	}
+
+	if c.Fork(primitive.NewObjectID(), time.Now()) != nil {
+		t.Error("expected no fork from an unknown message")
+	}
+}
+
+func TestConversation_TotalUsage(t *testing.T) {
+	c := &Conversation{
+		Usage:      Usage{PromptTokens: 100, CompletionTokens: 10, CostUSD: 0.5},
+		TitleUsage: Usage{PromptTokens: 20, CompletionTokens: 5, CostUSD: 0.01},
+	}
+
+	total := c.TotalUsage()
+	if total.PromptTokens != 120 || total.CompletionTokens != 15 || total.CostUSD != 0.51 {
+		t.Errorf("unexpected total %+v", total)
+	}
+
+	if c.Usage.PromptTokens != 100 {
+		t.Errorf("expected the message usage to be left alone, got %+v", c.Usage)
+	}
+}
@@ -0,0 +1,186 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MessageEmbedding is the vector representation of a message used for semantic search.
+// It keeps a copy of the content so matches can be shown without loading the conversation,
+// and the client that sent it, which is the only one who can find it.
+type MessageEmbedding struct {
+	MessageID      primitive.ObjectID `bson:"_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	Client         string             `bson:"client"`
+	Role           Role               `bson:"role"`
+	Content        string             `bson:"content"`
+	Model          string             `bson:"model"`
+	Vector         []float32          `bson:"vector"`
+	CreatedAt      time.Time          `bson:"created_at"`
+}
+
+// EmbeddingMatch is a message similar to a search query, Score is the cosine similarity
+type EmbeddingMatch struct {
+	*MessageEmbedding
+	Score float64
+}
+
+// SaveEmbeddings stores embeddings, replacing those of the same messages
+func (r *Repository) SaveEmbeddings(ctx context.Context, embeddings []*MessageEmbedding) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, len(embeddings))
+	for i, e := range embeddings {
+		writes[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: e.MessageID}}).
+			SetReplacement(e).
+			SetUpsert(true)
+	}
+
+	_, err := r.conn.Collection(embeddingCollection).BulkWrite(ctx, writes)
+	return err
+}
+
+// NearestEmbeddings compares the vector with every embedding stored for the client and returns the most similar ones.
+// Messages of the excluded conversation are skipped, pass primitive.NilObjectID to search all of them.
+func (r *Repository) NearestEmbeddings(ctx context.Context, client string, vector []float32, limit int, exclude primitive.ObjectID) ([]*EmbeddingMatch, error) {
+	filter := bson.D{{Key: "client", Value: client}}
+	if !exclude.IsZero() {
+		filter = append(filter, bson.E{Key: "conversation_id", Value: bson.D{{Key: "$ne", Value: exclude}}})
+	}
+
+	cursor, err := r.conn.Collection(embeddingCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var matches []*EmbeddingMatch
+
+	for cursor.Next(ctx) {
+		var e MessageEmbedding
+
+		if err := cursor.Decode(&e); err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, &EmbeddingMatch{MessageEmbedding: &e, Score: Cosine(vector, e.Vector)})
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(matches, func(a, b *EmbeddingMatch) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return matches[:min(limit, len(matches))], nil
+}
+
+// VectorSearchEmbeddings finds the most similar embeddings of the client using a MongoDB Atlas vector search index
+// on the vector field, which has the client field as a filter
+func (r *Repository) VectorSearchEmbeddings(ctx context.Context, index string, client string, vector []float32, limit int, exclude primitive.ObjectID) ([]*EmbeddingMatch, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$vectorSearch", Value: bson.D{
+			{Key: "index", Value: index},
+			{Key: "path", Value: "vector"},
+			{Key: "queryVector", Value: vector},
+			{Key: "filter", Value: bson.D{{Key: "client", Value: client}}},
+			{Key: "numCandidates", Value: limit * 20},
+			// Ask for more so the excluded conversation does not leave the results short
+			{Key: "limit", Value: limit * 2},
+		}}},
+		{{Key: "$addFields", Value: bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "vectorSearchScore"}}}}}},
+		{{Key: "$match", Value: bson.D{{Key: "conversation_id", Value: bson.D{{Key: "$ne", Value: exclude}}}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := r.conn.Collection(embeddingCollection).Aggregate(ctx, pipeline, options.Aggregate())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var matches []*EmbeddingMatch
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			MessageEmbedding `bson:",inline"`
+			Score            float64 `bson:"score"`
+		}
+
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, &EmbeddingMatch{MessageEmbedding: &doc.MessageEmbedding, Score: doc.Score})
+	}
+
+	return matches, cursor.Err()
+}
+
+// ConversationTitles returns the titles of the conversations by ID
+func (r *Repository) ConversationTitles(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	cursor, err := r.conn.Collection(conversationCollection).Find(ctx,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+		options.Find().SetProjection(bson.D{{Key: "subject", Value: 1}}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	titles := make(map[primitive.ObjectID]string, len(ids))
+
+	for cursor.Next(ctx) {
+		var c Conversation
+
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		titles[c.ID] = c.Title
+	}
+
+	return titles, cursor.Err()
+}
+
+// Cosine returns the cosine similarity of two vectors, 0 when their lengths differ or one of them is zero
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
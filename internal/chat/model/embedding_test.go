@@ -0,0 +1,28 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same direction", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 1}, []float32{-1, -1}, -1},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+		{"different lengths", []float32{1, 1}, []float32{1, 1, 1}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Cosine(tc.a, tc.b); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
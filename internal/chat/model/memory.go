@@ -0,0 +1,102 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Memory is a fact about a user the assistant remembers across conversations,
+// users are clients of the API identified by an API key, see httpx.UserFromContext
+type Memory struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	User      string             `bson:"user"`
+	Content   string             `bson:"content"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+func (m *Memory) Proto() *pb.Memory {
+	return &pb.Memory{
+		Id:        m.ID.Hex(),
+		Content:   m.Content,
+		Timestamp: timestamppb.New(m.CreatedAt),
+	}
+}
+
+// SaveMemory stores a fact about the user, a fact the user already has is not stored twice
+func (r *Repository) SaveMemory(ctx context.Context, m *Memory) error {
+	_, err := r.conn.Collection(memoryCollection).UpdateOne(ctx,
+		map[string]any{"user": m.User, "content": m.Content},
+		map[string]any{"$setOnInsert": m},
+		options.Update().SetUpsert(true))
+
+	return err
+}
+
+// ListMemories returns the facts remembered about the user, newest first. A limit of 0 returns all of them.
+func (r *Repository) ListMemories(ctx context.Context, user string, limit int64) ([]*Memory, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := r.conn.Collection(memoryCollection).Find(ctx, map[string]any{"user": user}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var items []*Memory
+
+	for cursor.Next(ctx) {
+		var m Memory
+
+		if err := cursor.Decode(&m); err != nil {
+			return nil, err
+		}
+
+		items = append(items, &m)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// DeleteMemory forgets a fact about the user, memories of other users are not found
+func (r *Repository) DeleteMemory(ctx context.Context, user, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return twirp.NotFoundError("invalid memory ID")
+	}
+
+	res, err := r.conn.Collection(memoryCollection).DeleteOne(ctx, map[string]any{"_id": oid, "user": user})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return twirp.NotFoundError("memory not found")
+	}
+
+	return nil
+}
+
+// EnsureMemoryIndexes creates the index listing the memories of a user
+func (r *Repository) EnsureMemoryIndexes(ctx context.Context) error {
+	_, err := r.conn.Collection(memoryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
@@ -14,13 +14,73 @@ type Message struct {
 	Content   string             `bson:"content"`
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
+
+	// ParentID is the message this one follows, it is not set on the first message of a branch
+	ParentID primitive.ObjectID `bson:"parent_id,omitempty"`
+
+	// ToolCalls are the tools the assistant asked to run, set on assistant messages only
+	ToolCalls []*ToolCall `bson:"tool_calls,omitempty"`
+
+	// ToolCallID links a tool result to the call it answers, set on tool messages only
+	ToolCallID string `bson:"tool_call_id,omitempty"`
+
+	// Usage of the completion that generated the message, set on assistant messages only
+	Usage *Usage `bson:"usage,omitempty"`
+
+	// Citations are the attachment chunks an assistant answer refers to
+	Citations []*Citation `bson:"citations,omitempty"`
+
+	// Parts are the text and images of user messages with images, Content holds their text
+	Parts []*Part `bson:"parts,omitempty"`
+
+	// ResponseSchema is the JSON Schema the answer to a user message must match, stored as JSON
+	ResponseSchema string `bson:"response_schema,omitempty"`
+
+	// Structured is set on assistant answers whose content is JSON matching the response schema
+	Structured bool `bson:"structured,omitempty"`
+
+	// Cancelled is set on the last assistant message of a reply that was cancelled before it was complete
+	Cancelled bool `bson:"cancelled,omitempty"`
+}
+
+// Images returns the images of the message
+func (m *Message) Images() []*Image {
+	var images []*Image
+	for _, p := range m.Parts {
+		if p.Image != nil {
+			images = append(images, p.Image)
+		}
+	}
+
+	return images
 }
 
 func (m *Message) Proto() *pb.Conversation_Message {
-	return &pb.Conversation_Message{
-		Id:        m.ID.Hex(),
-		Role:      m.Role.Proto(),
-		Content:   m.Content,
-		Timestamp: timestamppb.New(m.CreatedAt),
+	proto := &pb.Conversation_Message{
+		Id:         m.ID.Hex(),
+		Role:       m.Role.Proto(),
+		Content:    m.Content,
+		Timestamp:  timestamppb.New(m.CreatedAt),
+		ToolCallId: m.ToolCallID,
+		Usage:      m.Usage.Proto(),
+		Cancelled:  m.Cancelled,
+	}
+
+	if !m.ParentID.IsZero() {
+		proto.ParentId = m.ParentID.Hex()
+	}
+
+	for _, c := range m.ToolCalls {
+		proto.ToolCalls = append(proto.ToolCalls, c.Proto())
 	}
+
+	for _, c := range m.Citations {
+		proto.Citations = append(proto.Citations, c.Proto())
+	}
+
+	for _, p := range m.Parts {
+		proto.Parts = append(proto.Parts, p.Proto())
+	}
+
+	return proto
 }
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const imageBucket = "images"
+
+// Part is the text or an image of a message, exactly one of them is set
+type Part struct {
+	Text  string `bson:"text,omitempty"`
+	Image *Image `bson:"image,omitempty"`
+}
+
+// Image is stored in GridFS when uploaded, and referenced by URL otherwise
+type Image struct {
+	ID          primitive.ObjectID `bson:"id,omitempty"`
+	URL         string             `bson:"url,omitempty"`
+	ContentType string             `bson:"content_type,omitempty"`
+}
+
+func (p *Part) Proto() *pb.Part {
+	if p.Image != nil {
+		return &pb.Part{Part: &pb.Part_Image{Image: p.Image.Proto()}}
+	}
+
+	return &pb.Part{Part: &pb.Part_Text{Text: p.Text}}
+}
+
+// Proto references the image, its data is downloaded separately
+func (i *Image) Proto() *pb.Image {
+	proto := &pb.Image{Url: i.URL, ContentType: i.ContentType}
+	if !i.ID.IsZero() {
+		proto.Id = i.ID.Hex()
+	}
+	return proto
+}
+
+// PartsText joins the text of the parts, one part per line
+func PartsText(parts []*Part) string {
+	var texts []string
+	for _, p := range parts {
+		if p.Image == nil && strings.TrimSpace(p.Text) != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// SaveImage stores the data of an image in GridFS and returns its ID
+func (r *Repository) SaveImage(ctx context.Context, contentType string, data []byte) (primitive.ObjectID, error) {
+	bucket, err := gridfs.NewBucket(r.conn, options.GridFSBucket().SetName(imageBucket))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id := primitive.NewObjectID()
+	opts := options.GridFSUpload().SetMetadata(bson.D{{Key: "content_type", Value: contentType}})
+
+	// The driver only honours the context through the bucket's deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetWriteDeadline(deadline); err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
+
+	if err := bucket.UploadFromStreamWithID(id, id.Hex(), bytes.NewReader(data), opts); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return id, nil
+}
+
+// LoadImage returns the data and content type of an image stored with SaveImage
+func (r *Repository) LoadImage(ctx context.Context, id primitive.ObjectID) ([]byte, string, error) {
+	bucket, err := gridfs.NewBucket(r.conn, options.GridFSBucket().SetName(imageBucket))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetReadDeadline(deadline); err != nil {
+			return nil, "", err
+		}
+	}
+
+	stream, err := bucket.OpenDownloadStream(id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, "", twirp.NotFoundError("image not found")
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var metadata struct {
+		ContentType string `bson:"content_type"`
+	}
+
+	if raw := stream.GetFile().Metadata; raw != nil {
+		if err := bson.Unmarshal(raw, &metadata); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return data, metadata.ContentType, nil
+}
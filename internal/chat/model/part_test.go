@@ -0,0 +1,38 @@
+package model
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPartsText(t *testing.T) {
+	parts := []*Part{
+		{Text: "What does this sign say?"},
+		{Image: &Image{URL: "https://example.com/sign.jpg"}},
+		{Text: " "},
+		{Text: "It is in Catalan."},
+	}
+
+	if got, want := PartsText(parts), "What does this sign say?\nIt is in Catalan."; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMessage_ProtoParts(t *testing.T) {
+	id := primitive.NewObjectID()
+	m := &Message{Role: RoleUser, Content: "Sign?", Parts: []*Part{
+		{Text: "Sign?"},
+		{Image: &Image{ID: id, ContentType: "image/png"}},
+	}}
+
+	parts := m.Proto().GetParts()
+	if len(parts) != 2 || parts[0].GetText() != "Sign?" {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+
+	// Uploaded images are referenced by ID, without their data
+	if image := parts[1].GetImage(); image.GetId() != id.Hex() || image.GetContentType() != "image/png" || image.GetData() != nil {
+		t.Errorf("unexpected image %v", image)
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Quota is the usage of a client during a UTC day
+type Quota struct {
+	ID      string    `bson:"_id"`
+	Client  string    `bson:"client"`
+	Day     string    `bson:"day"`
+	Tokens  int64     `bson:"tokens"`
+	CostUSD float64   `bson:"cost_usd"`
+	Expires time.Time `bson:"expires_at"`
+}
+
+func quotaID(client string, day time.Time) (string, string) {
+	d := day.UTC().Format(time.DateOnly)
+	return client + "/" + d, d
+}
+
+// DailyUsage returns the tokens used by the client on the day of t
+func (r *Repository) DailyUsage(ctx context.Context, client string, t time.Time) (int64, error) {
+	id, _ := quotaID(client, t)
+
+	var q Quota
+	err := r.conn.Collection(quotaCollection).FindOne(ctx, map[string]any{"_id": id}).Decode(&q)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return q.Tokens, nil
+}
+
+// AddDailyUsage adds usage to the client's total for the day of t. Records expire a week after the day ends,
+// which requires a TTL index on expires_at, see EnsureQuotaIndexes.
+func (r *Repository) AddDailyUsage(ctx context.Context, client string, t time.Time, usage *Usage) error {
+	id, day := quotaID(client, t)
+	end, _ := time.Parse(time.DateOnly, day)
+
+	_, err := r.conn.Collection(quotaCollection).UpdateOne(ctx,
+		map[string]any{"_id": id},
+		map[string]any{
+			"$inc": map[string]any{"tokens": usage.Tokens(), "cost_usd": usage.CostUSD},
+			"$setOnInsert": map[string]any{
+				"client":     client,
+				"day":        day,
+				"expires_at": end.AddDate(0, 0, 8),
+			},
+		},
+		options.Update().SetUpsert(true))
+
+	return err
+}
+
+// EnsureQuotaIndexes creates the index expiring old quota records
+func (r *Repository) EnsureQuotaIndexes(ctx context.Context) error {
+	_, err := r.conn.Collection(quotaCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
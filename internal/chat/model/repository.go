@@ -13,6 +13,11 @@ import (
 
 const (
 	conversationCollection = "conversations"
+	quotaCollection        = "quotas"
+	embeddingCollection    = "message_embeddings"
+	memoryCollection       = "memories"
+	attachmentCollection   = "attachments"
+	chunkCollection        = "attachment_chunks"
 )
 
 type Repository struct {
@@ -84,18 +89,60 @@ func (r *Repository) ListConversations(ctx context.Context) ([]*Conversation, er
 	return items, nil
 }
 
+// UpdateConversation saves everything except the fields owned by SetTitle, so a concurrent title job's write
+// is not overwritten.
+// Only the version the conversation was loaded at is saved: when another request saved it in between,
+// ErrConflict is returned and nothing is written.
 func (r *Repository) UpdateConversation(ctx context.Context, c *Conversation) error {
-	_, err := r.conn.Collection(conversationCollection).UpdateOne(ctx,
-		map[string]any{"_id": c.ID},
-		map[string]any{"$set": c})
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return err
+	}
 
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return twirp.NotFoundError("conversation not found")
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return err
 	}
 
-	return err
+	for _, name := range []string{"subject", "title_usage", "titled_messages", "version"} {
+		delete(fields, name)
+	}
+
+	update := map[string]any{"$set": fields, "$inc": map[string]any{"version": 1}}
+	if c.PendingReply == nil {
+		update["$unset"] = map[string]any{"pending_reply": ""}
+	}
+
+	// Conversations saved before versions existed have none
+	version := any(c.Version)
+	if c.Version == 0 {
+		version = map[string]any{"$in": []any{0, nil}}
+	}
+
+	res, err := r.conn.Collection(conversationCollection).UpdateOne(ctx,
+		map[string]any{"_id": c.ID, "version": version}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		n, err := r.conn.Collection(conversationCollection).CountDocuments(ctx, map[string]any{"_id": c.ID})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return twirp.NotFoundError("conversation not found")
+		}
+		return ErrConflict
+	}
+
+	c.Version++
+	return nil
 }
 
+// ErrConflict is returned when a conversation is saved from a version another request already changed
+var ErrConflict = twirp.NewError(twirp.FailedPrecondition, "the conversation was changed by another request, reload it")
+
 func (r *Repository) DeleteConversation(ctx context.Context, id string) error {
 	_, err := r.conn.Collection(conversationCollection).DeleteOne(ctx, map[string]any{"_id": id})
 	if errors.Is(err, mongo.ErrNoDocuments) {
@@ -7,6 +7,7 @@ type Role string
 const (
 	RoleUser      Role = "user"
 	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
 )
 
 func (r Role) Proto() pb.Conversation_Role {
@@ -15,6 +16,8 @@ func (r Role) Proto() pb.Conversation_Role {
 		return pb.Conversation_USER
 	case RoleAssistant:
 		return pb.Conversation_ASSISTANT
+	case RoleTool:
+		return pb.Conversation_TOOL
 	default:
 		return 0
 	}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchResult is a conversation matching a search with its relevance
+type SearchResult struct {
+	Conversation *Conversation
+	Score        float64
+}
+
+// EnsureSearchIndexes creates the text index used by SearchConversations, titles weigh more than messages
+func (r *Repository) EnsureSearchIndexes(ctx context.Context) error {
+	_, err := r.conn.Collection(conversationCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "subject", Value: "text"}, {Key: "messages.content", Value: "text"}},
+		Options: options.Index().
+			SetName("conversations_text").
+			SetWeights(bson.D{{Key: "subject", Value: 5}, {Key: "messages.content", Value: 1}}),
+	})
+	return err
+}
+
+// SearchConversations finds conversations whose title or messages match the query, most relevant first.
+// The query uses the MongoDB text search syntax, e.g. quoted phrases and -excluded words.
+func (r *Repository) SearchConversations(ctx context.Context, query string, limit int64) ([]*SearchResult, error) {
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetSort(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetLimit(limit)
+
+	cursor, err := r.conn.Collection(conversationCollection).
+		Find(ctx, bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: query}}}}, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var items []*SearchResult
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			Conversation `bson:",inline"`
+			Score        float64 `bson:"score"`
+		}
+
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		items = append(items, &SearchResult{Conversation: &doc.Conversation, Score: doc.Score})
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// SearchTerms splits a text search query into the lowercase words to highlight, skipping excluded ones
+func SearchTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+
+		for _, word := range strings.FieldsFunc(field, isSeparator) {
+			terms = append(terms, strings.ToLower(word))
+		}
+	}
+	return terms
+}
+
+// Snippet returns the part of text around the first word matching one of the terms, with matching words
+// wrapped in ** and about width runes of context on each side. It reports false when no word matches.
+// Words match when they start with a term, ignoring a plural ending: "hotel" matches "Hotels" and "hotels" matches "hotel".
+func Snippet(text string, terms []string, width int) (string, bool) {
+	runes := []rune(text)
+
+	type span struct{ start, end int }
+	var matches []span
+
+	for i := 0; i < len(runes); {
+		if isSeparator(runes[i]) {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(runes) && !isSeparator(runes[j]) {
+			j++
+		}
+
+		if matchesTerm(strings.ToLower(string(runes[i:j])), terms) {
+			matches = append(matches, span{i, j})
+		}
+		i = j
+	}
+
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	// Widen the context to whole words
+	start := max(0, matches[0].start-width)
+	for start > 0 && !isSeparator(runes[start-1]) {
+		start--
+	}
+
+	end := min(len(runes), matches[0].end+width)
+	for end < len(runes) && !isSeparator(runes[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+
+	pos := start
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		b.WriteString(string(runes[pos:m.start]))
+		b.WriteString("**" + string(runes[m.start:m.end]) + "**")
+		pos = m.end
+	}
+	b.WriteString(string(runes[pos:end]))
+
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return strings.Join(strings.Fields(b.String()), " "), true
+}
+
+func matchesTerm(word string, terms []string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(word, stem(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// stem drops a plural ending so singular and plural forms match each other
+func stem(word string) string {
+	if len([]rune(word)) > 3 {
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
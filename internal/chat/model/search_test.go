@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestSnippet(t *testing.T) {
+	text := "For your stay in Lisbon I would pick one of the hotels in Alfama, close to the castle and the river."
+
+	tests := []struct {
+		name  string
+		query string
+		width int
+		want  string
+		found bool
+	}{
+		{"highlights every match", "lisbon hotel", 100, "For your stay in **Lisbon** I would pick one of the **hotels** in Alfama, close to the castle and the river.", true},
+		{"trims context", "castle", 10, "…close to the **castle** and the river…", true},
+		{"ignores case and plurals", "ALFAMAS", 6, "…hotels in **Alfama**, close…", true},
+		{"skips excluded words", "porto -lisbon", 10, "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, found := Snippet(text, SearchTerms(tc.query), tc.width)
+			if got != tc.want || found != tc.found {
+				t.Errorf("expected %q (%v), got %q (%v)", tc.want, tc.found, got, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetTitle saves a generated title, titled is the number of user messages it was generated from
+// and the usage of its generation is added to the title usage of the conversation
+func (r *Repository) SetTitle(ctx context.Context, id primitive.ObjectID, title string, titled int, usage *Usage) error {
+	update := bson.M{"$set": bson.M{"subject": title, "titled_messages": titled}}
+	if usage != nil {
+		update["$inc"] = bson.M{
+			"title_usage.prompt_tokens":     usage.PromptTokens,
+			"title_usage.completion_tokens": usage.CompletionTokens,
+			"title_usage.cached_tokens":     usage.CachedTokens,
+			"title_usage.cost_usd":          usage.CostUSD,
+		}
+	}
+
+	res, err := r.conn.Collection(conversationCollection).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return twirp.NotFoundError("conversation not found")
+	}
+
+	return nil
+}
+
+// UntitledConversations returns the IDs of conversations last updated before the given time that
+// still have no title, in ID order starting after the given ID, so callers can page through them
+func (r *Repository) UntitledConversations(ctx context.Context, before time.Time, after primitive.ObjectID, limit int64) ([]primitive.ObjectID, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"_id": 1})
+
+	cursor, err := r.conn.Collection(conversationCollection).Find(ctx, bson.M{
+		"_id":        bson.M{"$gt": after},
+		"subject":    UntitledConversation,
+		"updated_at": bson.M{"$lt": before},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import "github.com/acai-travel/tech-challenge/internal/pb"
+
+type ToolCallStatus string
+
+const (
+	// ToolCallAuto is used for calls executed without asking the user
+	ToolCallAuto     ToolCallStatus = ""
+	ToolCallPending  ToolCallStatus = "pending"
+	ToolCallApproved ToolCallStatus = "approved"
+	ToolCallRejected ToolCallStatus = "rejected"
+)
+
+func (s ToolCallStatus) Proto() pb.Conversation_ToolCall_Status {
+	switch s {
+	case ToolCallPending:
+		return pb.Conversation_ToolCall_PENDING
+	case ToolCallApproved:
+		return pb.Conversation_ToolCall_APPROVED
+	case ToolCallRejected:
+		return pb.Conversation_ToolCall_REJECTED
+	default:
+		return pb.Conversation_ToolCall_AUTO
+	}
+}
+
+// ToolCall is a request from the assistant to run a tool
+type ToolCall struct {
+	ID        string         `bson:"id"`
+	Name      string         `bson:"name"`
+	Arguments string         `bson:"arguments"`
+	Status    ToolCallStatus `bson:"status,omitempty"`
+}
+
+func (c *ToolCall) Proto() *pb.Conversation_ToolCall {
+	return &pb.Conversation_ToolCall{
+		Id:        c.ID,
+		Name:      c.Name,
+		Arguments: c.Arguments,
+		Status:    c.Status.Proto(),
+	}
+}
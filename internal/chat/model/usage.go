@@ -0,0 +1,44 @@
+package model
+
+import "github.com/acai-travel/tech-challenge/internal/pb"
+
+// Usage counts the tokens consumed by calls to the model and their estimated cost
+type Usage struct {
+	// Model is set on the usage of a single completion, it is empty on aggregates
+	Model            string  `bson:"model,omitempty"`
+	PromptTokens     int64   `bson:"prompt_tokens"`
+	CompletionTokens int64   `bson:"completion_tokens"`
+	CachedTokens     int64   `bson:"cached_tokens"`
+	CostUSD          float64 `bson:"cost_usd"`
+}
+
+// Add accumulates the tokens and cost of another usage
+func (u *Usage) Add(other *Usage) {
+	if other == nil {
+		return
+	}
+
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CachedTokens += other.CachedTokens
+	u.CostUSD += other.CostUSD
+}
+
+// Tokens is the number of prompt and completion tokens, cached tokens are part of the prompt
+func (u *Usage) Tokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u *Usage) Proto() *pb.Usage {
+	if u == nil {
+		return nil
+	}
+
+	return &pb.Usage{
+		Model:            u.Model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     u.CachedTokens,
+		CostUsd:          u.CostUSD,
+	}
+}
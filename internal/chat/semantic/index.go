@@ -0,0 +1,175 @@
+// Package semantic finds messages of past conversations by meaning rather than by words,
+// comparing embeddings of the messages with the embedding of a query.
+package semantic
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxInput bounds the characters of a message that are embedded, well below the input limit of embedding models
+const maxInput = 8000
+
+// Embedder computes embeddings of texts, implemented by assistant.Embedder
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, *model.Usage, error)
+}
+
+// Match is a message of a past conversation similar to a query
+type Match struct {
+	ConversationID primitive.ObjectID
+	Title          string
+	MessageID      primitive.ObjectID
+	Role           model.Role
+	Content        string
+	Score          float64
+	CreatedAt      time.Time
+}
+
+// Index stores embeddings of messages and searches them
+type Index struct {
+	repo     *model.Repository
+	embedder Embedder
+
+	// vectorIndex is the name of a MongoDB Atlas vector search index, similarity is computed in process without it
+	vectorIndex string
+}
+
+// Option configures optional behaviour of the index
+type Option func(*Index)
+
+// WithVectorIndex searches with the Atlas vector search index of that name on the message_embeddings collection
+func WithVectorIndex(name string) Option {
+	return func(i *Index) {
+		i.vectorIndex = name
+	}
+}
+
+// NewIndex creates an index storing embeddings in the repository
+func NewIndex(repo *model.Repository, embedder Embedder, opts ...Option) *Index {
+	i := &Index{repo: repo, embedder: embedder}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Add embeds and stores the messages of the conversation for the client of the context, tool results and
+// empty messages are skipped. It returns the usage of the embeddings, also when storing them fails.
+func (i *Index) Add(ctx context.Context, conv *model.Conversation, msgs ...*model.Message) (*model.Usage, error) {
+	var texts []string
+	var indexed []*model.Message
+
+	for _, m := range msgs {
+		if m.Role == model.RoleTool || strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+
+		texts = append(texts, truncate(m.Content, maxInput))
+		indexed = append(indexed, m)
+	}
+
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	vectors, usage, err := i.embedder.Embed(ctx, texts)
+	if err != nil {
+		return usage, err
+	}
+
+	embeddings := make([]*model.MessageEmbedding, len(indexed))
+	for j, m := range indexed {
+		embeddings[j] = &model.MessageEmbedding{
+			MessageID:      m.ID,
+			ConversationID: conv.ID,
+			Client:         httpx.ClientFromContext(ctx),
+			Role:           m.Role,
+			Content:        m.Content,
+			Model:          usage.Model,
+			Vector:         vectors[j],
+			CreatedAt:      m.CreatedAt,
+		}
+	}
+
+	return usage, i.repo.SaveEmbeddings(ctx, embeddings)
+}
+
+// Search returns up to limit messages of the client of the context most similar to the query, best first.
+// Messages of the excluded conversation are skipped, pass primitive.NilObjectID to search all of them.
+// It returns the usage of the query embedding, also when the search fails.
+func (i *Index) Search(ctx context.Context, query string, limit int, exclude primitive.ObjectID) ([]*Match, *model.Usage, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil, errors.New("query is empty")
+	}
+
+	vectors, usage, err := i.embedder.Embed(ctx, []string{truncate(query, maxInput)})
+	if err != nil {
+		return nil, usage, err
+	}
+
+	client := httpx.ClientFromContext(ctx)
+
+	var found []*model.EmbeddingMatch
+	if i.vectorIndex != "" {
+		found, err = i.repo.VectorSearchEmbeddings(ctx, i.vectorIndex, client, vectors[0], limit, exclude)
+	} else {
+		found, err = i.repo.NearestEmbeddings(ctx, client, vectors[0], limit, exclude)
+	}
+
+	if err != nil {
+		return nil, usage, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(found))
+	for _, f := range found {
+		ids = append(ids, f.ConversationID)
+	}
+
+	titles, err := i.repo.ConversationTitles(ctx, ids)
+	if err != nil {
+		return nil, usage, err
+	}
+
+	matches := make([]*Match, 0, len(found))
+	for _, f := range found {
+		title, ok := titles[f.ConversationID]
+		if !ok {
+			slog.WarnContext(ctx, "Embedding of a deleted conversation", "conversation_id", f.ConversationID.Hex())
+			continue
+		}
+
+		matches = append(matches, &Match{
+			ConversationID: f.ConversationID,
+			Title:          title,
+			MessageID:      f.MessageID,
+			Role:           f.Role,
+			Content:        f.Content,
+			Score:          f.Score,
+			CreatedAt:      f.CreatedAt,
+		})
+	}
+
+	return matches, usage, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
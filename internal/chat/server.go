@@ -2,102 +2,240 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/acai-travel/tech-challenge/internal/chat/attachment"
 	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+	"github.com/acai-travel/tech-challenge/internal/jsonschema"
 	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/acai-travel/tech-challenge/internal/webhook"
 	"github.com/twitchtv/twirp"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ pb.ChatService = (*Server)(nil)
+var _ pb.ChatServiceServer = (*Server)(nil)
 
 type Assistant interface {
-	Title(ctx context.Context, conv *model.Conversation) (string, error)
-	Reply(ctx context.Context, conv *model.Conversation) (string, error)
+	Title(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error)
+	Reply(ctx context.Context, conv *model.Conversation) ([]*model.Message, error)
+	Tools() *tools.Registry
 }
 
 type Server struct {
 	repo   *model.Repository
 	assist Assistant
+
+	// dailyTokenQuota limits the tokens a client can use per UTC day, 0 means unlimited
+	dailyTokenQuota int64
+	now             func() time.Time
+
+	// index holds embeddings of the messages for semantic search, nil when it is disabled
+	index *semantic.Index
+
+	// attachments holds documents added to conversations, nil when uploads are disabled
+	attachments *attachment.Store
+
+	// titles generates titles in the background, nil to generate them while starting conversations
+	titles TitleQueue
+
+	// replies queues replies generated in the background, nil when async replies are disabled
+	replies jobs.Enqueuer
+
+	// webhooks notifies clients of async replies, nil when webhooks are disabled
+	webhooks *webhook.Sender
+
+	// generations are the replies being generated, for CancelReply
+	generations generations
+}
+
+// TitleQueue generates conversation titles in the background
+type TitleQueue interface {
+	// Enqueue queues the title of the conversation to be generated, or reviewed if it has one
+	Enqueue(ctx context.Context, id primitive.ObjectID)
+
+	// Refresh queues the title of the conversation if it is missing or may no longer fit
+	Refresh(ctx context.Context, conv *model.Conversation)
+}
+
+const (
+	// maxAttachmentSize bounds the size of uploaded documents
+	maxAttachmentSize = 10 << 20
+
+	// maxImageSize bounds the size of images uploaded with messages
+	maxImageSize = 10 << 20
+)
+
+// imageTypes are the image formats vision models accept
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// Option configures optional behaviour of the server
+type Option func(*Server)
+
+// WithDailyTokenQuota limits the prompt and completion tokens each client can use per UTC day.
+// Clients are identified by httpx.ClientFromContext.
+func WithDailyTokenQuota(tokens int64) Option {
+	return func(s *Server) {
+		s.dailyTokenQuota = tokens
+	}
+}
+
+// WithSemanticIndex embeds the messages as they are added, so they can be found by SemanticSearch
+func WithSemanticIndex(index *semantic.Index) Option {
+	return func(s *Server) {
+		s.index = index
+	}
 }
 
-func NewServer(repo *model.Repository, assist Assistant) *Server {
-	return &Server{repo: repo, assist: assist}
+// WithAttachments lets users add documents to conversations with UploadAttachment
+func WithAttachments(store *attachment.Store) Option {
+	return func(s *Server) {
+		s.attachments = store
+	}
+}
+
+// WithTitleQueue generates titles in the background instead of while starting conversations,
+// and reviews them as conversations grow
+func WithTitleQueue(queue TitleQueue) Option {
+	return func(s *Server) {
+		s.titles = queue
+	}
+}
+
+// WithAsyncReplies lets ContinueConversation generate replies in the background as jobs of the queue,
+// the server's HandleReply must be registered for ReplyKind. Clients are notified through the webhooks
+// sender if it is not nil.
+func WithAsyncReplies(queue jobs.Enqueuer, webhooks *webhook.Sender) Option {
+	return func(s *Server) {
+		s.replies = queue
+		s.webhooks = webhooks
+	}
+}
+
+func NewServer(repo *model.Repository, assist Assistant, opts ...Option) *Server {
+	s := &Server{repo: repo, assist: assist, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Server) StartConversation(ctx context.Context, req *pb.StartConversationRequest) (*pb.StartConversationResponse, error) {
-	if strings.TrimSpace(req.GetMessage()) == "" {
+	if strings.TrimSpace(req.GetMessage()) == "" && len(req.GetParts()) == 0 {
 		return nil, twirp.RequiredArgumentError("message")
 	}
 
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	message, err := s.userMessage(ctx, req.GetMessage(), req.GetParts())
+	if err != nil {
+		return nil, err
+	}
+
+	if message.ResponseSchema, err = responseSchema(req.GetResponseSchema()); err != nil {
+		return nil, err
+	}
+
 	conversation := &model.Conversation{
 		ID:        primitive.NewObjectID(),
-		Title:     "Untitled conversation",
+		Title:     model.UntitledConversation,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-		Messages: []*model.Message{{
-			ID:        primitive.NewObjectID(),
-			Role:      model.RoleUser,
-			Content:   req.GetMessage(),
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}},
+		Messages:  []*model.Message{message},
 	}
 
 	// BONUS: Run title generation and reply generation in parallel
 	var wg sync.WaitGroup
-	var title, reply string
+	var title string
+	var titleUsage *model.Usage
+	var messages []*model.Message
 	var titleErr, replyErr error
 
-	wg.Add(2)
-
-	// Generate title concurrently
-	go func() {
-		defer wg.Done()
-		title, titleErr = s.assist.Title(ctx, conversation)
-	}()
+	// With a title queue the title is generated in the background once the conversation is saved
+	if s.titles == nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			title, titleUsage, titleErr = s.assist.Title(ctx, conversation)
+		}()
+	}
 
 	// Generate reply concurrently
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		reply, replyErr = s.assist.Reply(ctx, conversation)
+		messages, replyErr = s.reply(ctx, conversation)
 	}()
 
 	wg.Wait()
 
+	// A reply cancelled with CancelReply or by the client going away is stored up to where it stopped
+	cancelled := replyErr == errReplyCancelled
+	if cancelled {
+		replyErr = nil
+		ctx = context.WithoutCancel(ctx)
+	}
+
 	// Handle errors
 	if replyErr != nil {
 		return nil, replyErr
 	}
 
+	conversation.Usage.Add(titleUsage)
+
 	// If title generation fails, log but continue with default title
 	if titleErr != nil {
 		slog.ErrorContext(ctx, "Failed to generate conversation title", "error", titleErr)
-	} else {
+	} else if title != "" {
 		conversation.Title = title
 	}
 
 	// Add assistant's reply to conversation
-	conversation.Messages = append(conversation.Messages, &model.Message{
-		ID:        primitive.NewObjectID(),
-		Role:      model.RoleAssistant,
-		Content:   reply,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	reply := appendReply(conversation, messages)
+
+	// The reply was charged as it was generated
+	s.chargeQuota(ctx, titleUsage)
 
 	if err := s.repo.CreateConversation(ctx, conversation); err != nil {
 		return nil, err
 	}
 
+	s.indexMessages(ctx, conversation, conversation.Messages...)
+
+	if s.titles != nil {
+		s.titles.Enqueue(ctx, conversation.ID)
+	}
+
+	if cancelled {
+		return nil, errReplyCancelled.WithMeta("conversation_id", conversation.ID.Hex())
+	}
+
 	return &pb.StartConversationResponse{
-		ConversationId: conversation.ID.Hex(),
-		Title:          conversation.Title,
-		Reply:          reply,
+		ConversationId:   conversation.ID.Hex(),
+		Title:            conversation.Title,
+		Reply:            reply,
+		PendingToolCalls: toolCallsProto(conversation.PendingToolCalls()),
+		StructuredReply:  structuredReply(conversation),
 	}, nil
 }
 
@@ -106,6 +244,160 @@ func (s *Server) ContinueConversation(ctx context.Context, req *pb.ContinueConve
 		return nil, twirp.RequiredArgumentError("conversation_id")
 	}
 
+	if strings.TrimSpace(req.GetMessage()) == "" && len(req.GetParts()) == 0 {
+		return nil, twirp.RequiredArgumentError("message")
+	}
+
+	if err := s.checkAsync(req.GetAsync(), req.GetWebhookUrl()); err != nil {
+		return nil, err
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation.Replying() {
+		return nil, errReplying
+	}
+
+	message, err := s.userMessage(ctx, req.GetMessage(), req.GetParts())
+	if err != nil {
+		return nil, err
+	}
+
+	if message.ResponseSchema, err = responseSchema(req.GetResponseSchema()); err != nil {
+		return nil, err
+	}
+
+	// Sending a new message instead of answering pending tool calls declines them
+	for _, call := range conversation.OpenToolCalls() {
+		rejectToolCall(conversation, call, "the user sent a new message instead")
+	}
+
+	conversation.Append(message)
+
+	if req.GetAsync() {
+		id, err := s.replyAsync(ctx, conversation, message, req.GetWebhookUrl())
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.ContinueConversationResponse{PendingMessageId: id.Hex()}, nil
+	}
+
+	reply, pending, err := s.resume(ctx, conversation, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ContinueConversationResponse{Reply: reply, PendingToolCalls: pending, StructuredReply: structuredReply(conversation)}, nil
+}
+
+func (s *Server) ApproveToolCall(ctx context.Context, req *pb.ApproveToolCallRequest) (*pb.ApproveToolCallResponse, error) {
+	conversation, call, err := s.pendingToolCall(ctx, req.GetConversationId(), req.GetToolCallId())
+	if err != nil {
+		return nil, err
+	}
+
+	call.Status = model.ToolCallApproved
+
+	if req.GetAlwaysAllow() {
+		if !conversation.ToolApproved(call.Name) {
+			conversation.ApprovedTools = append(conversation.ApprovedTools, call.Name)
+		}
+
+		// Other calls to the same tool made in the same turn are covered too
+		for _, other := range conversation.PendingToolCalls() {
+			if other.Name == call.Name {
+				other.Status = model.ToolCallApproved
+			}
+		}
+	}
+
+	if err := s.claim(ctx, conversation); err != nil {
+		return nil, err
+	}
+
+	reply, pending, err := s.resume(ctx, conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ApproveToolCallResponse{Reply: reply, PendingToolCalls: pending}, nil
+}
+
+func (s *Server) RejectToolCall(ctx context.Context, req *pb.RejectToolCallRequest) (*pb.RejectToolCallResponse, error) {
+	conversation, call, err := s.pendingToolCall(ctx, req.GetConversationId(), req.GetToolCallId())
+	if err != nil {
+		return nil, err
+	}
+
+	rejectToolCall(conversation, call, req.GetReason())
+
+	if err := s.claim(ctx, conversation); err != nil {
+		return nil, err
+	}
+
+	reply, pending, err := s.resume(ctx, conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RejectToolCallResponse{Reply: reply, PendingToolCalls: pending}, nil
+}
+
+func (s *Server) RegenerateReply(ctx context.Context, req *pb.RegenerateReplyRequest) (*pb.RegenerateReplyResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	path := conversation.Path()
+	if req.GetMessageId() != "" {
+		id, err := primitive.ObjectIDFromHex(req.GetMessageId())
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("message_id", "must be a valid message ID")
+		}
+
+		if path = conversation.PathTo(id); path == nil {
+			return nil, twirp.NotFoundError("message not found")
+		}
+	}
+
+	// The reply starts after the user message it answers, that message becomes the end of the branch
+	i := len(path) - 1
+	for i >= 0 && path[i].Role != model.RoleUser {
+		i--
+	}
+
+	if i < 0 {
+		return nil, twirp.InvalidArgumentError("message_id", "must be part of a reply to a user message")
+	}
+
+	conversation.ActiveID = path[i].ID
+
+	reply, pending, err := s.resume(ctx, conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RegenerateReplyResponse{Reply: reply, PendingToolCalls: pending}, nil
+}
+
+func (s *Server) EditMessage(ctx context.Context, req *pb.EditMessageRequest) (*pb.EditMessageResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	if req.GetMessageId() == "" {
+		return nil, twirp.RequiredArgumentError("message_id")
+	}
+
 	if strings.TrimSpace(req.GetMessage()) == "" {
 		return nil, twirp.RequiredArgumentError("message")
 	}
@@ -115,33 +407,415 @@ func (s *Server) ContinueConversation(ctx context.Context, req *pb.ContinueConve
 		return nil, err
 	}
 
-	conversation.UpdatedAt = time.Now()
-	conversation.Messages = append(conversation.Messages, &model.Message{
+	id, err := primitive.ObjectIDFromHex(req.GetMessageId())
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("message_id", "must be a valid message ID")
+	}
+
+	original := conversation.Message(id)
+	if original == nil {
+		return nil, twirp.NotFoundError("message not found")
+	}
+
+	if original.Role != model.RoleUser {
+		return nil, twirp.InvalidArgumentError("message_id", "must be a user message")
+	}
+
+	edited := &model.Message{
 		ID:        primitive.NewObjectID(),
 		Role:      model.RoleUser,
 		Content:   req.GetMessage(),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-	})
+	}
+	conversation.Branch(original, edited)
 
-	reply, err := s.assist.Reply(ctx, conversation)
+	reply, pending, err := s.resume(ctx, conversation, edited)
 	if err != nil {
+		return nil, err
+	}
+
+	return &pb.EditMessageResponse{MessageId: edited.ID.Hex(), Reply: reply, PendingToolCalls: pending}, nil
+}
+
+func (s *Server) ForkConversation(ctx context.Context, req *pb.ForkConversationRequest) (*pb.ForkConversationResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	source, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	var id primitive.ObjectID
+	if req.GetMessageId() != "" {
+		if id, err = primitive.ObjectIDFromHex(req.GetMessageId()); err != nil {
+			return nil, twirp.InvalidArgumentError("message_id", "must be a valid message ID")
+		}
+	} else if path := source.Path(); len(path) > 0 {
+		id = path[len(path)-1].ID
+	}
+
+	fork := source.Fork(id, time.Now())
+	if fork == nil {
+		return nil, twirp.NotFoundError("message not found")
+	}
+
+	// The copy keeps the source title until the title queue reviews it
+	if s.titles != nil {
+		if err := s.repo.CreateConversation(ctx, fork); err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+
+		s.titles.Enqueue(ctx, fork.ID)
+		return &pb.ForkConversationResponse{ConversationId: fork.ID.Hex(), Title: fork.Title}, nil
+	}
+
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	// The copy keeps the source title if a fresh one cannot be generated
+	title, usage, err := s.assist.Title(ctx, fork)
+	fork.Usage.Add(usage)
+	s.chargeQuota(ctx, &fork.Usage)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to generate conversation title", "error", err)
+	} else {
+		fork.Title = title
+	}
+
+	if err := s.repo.CreateConversation(ctx, fork); err != nil {
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	conversation.Messages = append(conversation.Messages, &model.Message{
+	return &pb.ForkConversationResponse{ConversationId: fork.ID.Hex(), Title: fork.Title}, nil
+}
+
+// userMessage builds a message from the text and parts sent by the user, uploading the images
+func (s *Server) userMessage(ctx context.Context, text string, parts []*pb.Part) (*model.Message, error) {
+	message := &model.Message{
 		ID:        primitive.NewObjectID(),
-		Role:      model.RoleAssistant,
-		Content:   reply,
+		Role:      model.RoleUser,
+		Content:   text,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-	})
+	}
+
+	if len(parts) == 0 {
+		return message, nil
+	}
+
+	if strings.TrimSpace(text) != "" {
+		message.Parts = append(message.Parts, &model.Part{Text: text})
+	}
+
+	for _, p := range parts {
+		image := p.GetImage()
+		if image == nil {
+			message.Parts = append(message.Parts, &model.Part{Text: p.GetText()})
+			continue
+		}
+
+		switch {
+		case image.GetUrl() != "":
+			if u, err := url.Parse(image.GetUrl()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, twirp.InvalidArgumentError("parts", "image URLs must be absolute http or https URLs")
+			}
+
+			message.Parts = append(message.Parts, &model.Part{Image: &model.Image{URL: image.GetUrl(), ContentType: image.GetContentType()}})
+		case len(image.GetData()) > 0:
+			if len(image.GetData()) > maxImageSize {
+				return nil, twirp.InvalidArgumentError("parts", fmt.Sprintf("images must not exceed %d MB", maxImageSize>>20))
+			}
+
+			contentType := image.GetContentType()
+			if contentType == "" {
+				contentType = http.DetectContentType(image.GetData())
+			}
+
+			if !slices.Contains(imageTypes, contentType) {
+				return nil, twirp.InvalidArgumentError("parts", "images must be PNG, JPEG, GIF or WebP")
+			}
+
+			id, err := s.repo.SaveImage(ctx, contentType, image.GetData())
+			if err != nil {
+				return nil, twirp.InternalErrorWith(err)
+			}
+
+			message.Parts = append(message.Parts, &model.Part{Image: &model.Image{ID: id, ContentType: contentType}})
+		default:
+			return nil, twirp.InvalidArgumentError("parts", "images need a URL or data")
+		}
+	}
+
+	message.Content = model.PartsText(message.Parts)
+	return message, nil
+}
+
+// responseSchema checks the JSON Schema replies must match and returns it as JSON, empty without one
+func responseSchema(schema *structpb.Struct) (string, error) {
+	if len(schema.GetFields()) == 0 {
+		return "", nil
+	}
+
+	raw, err := protojson.Marshal(schema)
+	if err != nil {
+		return "", twirp.InvalidArgumentError("response_schema", err.Error())
+	}
+
+	if _, err := jsonschema.Compile(json.RawMessage(raw)); err != nil {
+		return "", twirp.InvalidArgumentError("response_schema", err.Error())
+	}
 
+	if t, ok := schema.GetFields()["type"]; ok && t.GetStringValue() != "object" {
+		return "", twirp.InvalidArgumentError("response_schema", "must describe an object")
+	}
+
+	return string(raw), nil
+}
+
+// structuredReply decodes the last reply of the conversation when it was constrained to a response schema
+func structuredReply(conversation *model.Conversation) *structpb.Value {
+	path := conversation.Path()
+	last := path[len(path)-1]
+	if !last.Structured {
+		return nil
+	}
+
+	var value structpb.Value
+	if err := protojson.Unmarshal([]byte(last.Content), &value); err != nil {
+		slog.Error("Failed to decode structured reply", "conversation_id", conversation.ID.Hex(), "error", err)
+		return nil
+	}
+
+	return &value
+}
+
+func (s *Server) pendingToolCall(ctx context.Context, conversationID, toolCallID string) (*model.Conversation, *model.ToolCall, error) {
+	if conversationID == "" {
+		return nil, nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	if toolCallID == "" {
+		return nil, nil, twirp.RequiredArgumentError("tool_call_id")
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, call := range conversation.PendingToolCalls() {
+		if call.ID == toolCallID {
+			return conversation, call, nil
+		}
+	}
+
+	return nil, nil, twirp.NotFoundError("pending tool call not found")
+}
+
+// claim saves the decisions taken on pending tool calls before approved calls run. Requests deciding on
+// the same calls at once loaded the same version of the conversation, so only the first one saves it and
+// the others fail with failed_precondition: a tool call is never run or decided twice.
+func (s *Server) claim(ctx context.Context, conversation *model.Conversation) error {
+	if conversation.Replying() {
+		return errReplying
+	}
+
+	err := s.repo.UpdateConversation(ctx, conversation)
+	if err == model.ErrConflict {
+		return twirp.NewError(twirp.FailedPrecondition, "the tool call was already decided by another request")
+	}
+	if _, ok := err.(twirp.Error); !ok && err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+	return err
+}
+
+// resume lets the assistant continue the conversation once no tool call is waiting for approval,
+// and stores the result. It returns the reply and the tool calls still waiting for approval.
+// Messages added by the caller are passed along to be indexed with the reply.
+func (s *Server) resume(ctx context.Context, conversation *model.Conversation, added ...*model.Message) (string, []*pb.Conversation_ToolCall, error) {
+	if conversation.Replying() {
+		return "", nil, errReplying
+	}
+
+	conversation.UpdatedAt = time.Now()
+
+	reply := ""
+	cancelled := false
+	if len(conversation.PendingToolCalls()) == 0 {
+		if err := s.checkQuota(ctx); err != nil {
+			return "", nil, err
+		}
+
+		messages, err := s.reply(ctx, conversation)
+		if cancelled = err == errReplyCancelled; cancelled {
+			// The cancelled turn is stored even though the request is over
+			ctx = context.WithoutCancel(ctx)
+		} else if err != nil {
+			return "", nil, twirp.InternalErrorWith(err)
+		}
+
+		reply = appendReply(conversation, messages)
+		added = append(added, messages...)
+	}
+
+	if err := s.save(ctx, conversation, added...); err == model.ErrConflict {
+		return "", nil, err
+	} else if err != nil {
+		return "", nil, twirp.InternalErrorWith(err)
+	}
+
+	if cancelled {
+		path := conversation.Path()
+		return "", nil, errReplyCancelled.WithMeta("message_id", path[len(path)-1].ID.Hex())
+	}
+
+	return reply, toolCallsProto(conversation.PendingToolCalls()), nil
+}
+
+// reply generates the next messages of the conversation and charges their usage to the client.
+// When the reply is cancelled, with CancelReply or because ctx is done, it returns the messages
+// generated so far, ending with a cancelled assistant message, and errReplyCancelled.
+func (s *Server) reply(ctx context.Context, conversation *model.Conversation) ([]*model.Message, error) {
+	replyCtx, done := s.generations.start(ctx, conversation.ID)
+	defer done()
+
+	messages, err := s.assist.Reply(replyCtx, conversation)
+
+	// Tokens spent before a cancellation are charged too
+	spent := &model.Usage{}
+	for _, m := range messages {
+		spent.Add(m.Usage)
+	}
+	s.chargeQuota(context.WithoutCancel(ctx), spent)
+
+	if err != nil && replyCtx.Err() != nil {
+		slog.InfoContext(ctx, "Reply stopped before it was complete", "conversation_id", conversation.ID.Hex(), "error", err)
+		return cancelledReply(messages), errReplyCancelled
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// save stores the conversation, indexes the added messages and queues a review of its title
+func (s *Server) save(ctx context.Context, conversation *model.Conversation, added ...*model.Message) error {
 	if err := s.repo.UpdateConversation(ctx, conversation); err != nil {
-		return nil, twirp.InternalErrorWith(err)
+		return err
 	}
 
-	return &pb.ContinueConversationResponse{Reply: reply}, nil
+	s.indexMessages(ctx, conversation, added...)
+
+	if s.titles != nil {
+		s.titles.Refresh(ctx, conversation)
+	}
+
+	return nil
+}
+
+// checkQuota fails with resource_exhausted once the client used its daily tokens,
+// the retry_after metadata holds the seconds until the quota resets at midnight UTC
+func (s *Server) checkQuota(ctx context.Context) error {
+	if s.dailyTokenQuota <= 0 {
+		return nil
+	}
+
+	now := s.now()
+	used, err := s.repo.DailyUsage(ctx, httpx.ClientFromContext(ctx), now)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	if used < s.dailyTokenQuota {
+		return nil
+	}
+
+	reset := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return twirp.NewError(twirp.ResourceExhausted, "daily token quota exceeded").
+		WithMeta("retry_after", strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+}
+
+// chargeQuota adds usage to the client's daily total. The reply was already generated,
+// so failing to record it is logged rather than returned.
+func (s *Server) chargeQuota(ctx context.Context, usage *model.Usage) {
+	if s.dailyTokenQuota <= 0 || usage == nil || usage.Tokens() == 0 {
+		return
+	}
+
+	if err := s.repo.AddDailyUsage(ctx, httpx.ClientFromContext(ctx), s.now(), usage); err != nil {
+		slog.ErrorContext(ctx, "Failed to record quota usage", "error", err)
+	}
+}
+
+// indexMessages adds the messages to the semantic index and charges their embeddings to the client.
+// The conversation is already stored, so failing to index is logged rather than returned.
+func (s *Server) indexMessages(ctx context.Context, conversation *model.Conversation, messages ...*model.Message) {
+	if s.index == nil {
+		return
+	}
+
+	usage, err := s.index.Add(ctx, conversation, messages...)
+	s.chargeQuota(context.WithoutCancel(ctx), usage)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to index messages", "conversation_id", conversation.ID.Hex(), "error", err)
+	}
+}
+
+// appendReply adds the messages generated by the assistant to the conversation and returns its answer,
+// which is empty when the assistant is waiting for tool calls to be approved
+func appendReply(conversation *model.Conversation, messages []*model.Message) string {
+	for _, m := range messages {
+		if m.ID.IsZero() {
+			m.ID = primitive.NewObjectID()
+		}
+		m.CreatedAt = time.Now()
+		m.UpdatedAt = time.Now()
+		conversation.Usage.Add(m.Usage)
+		conversation.Append(m)
+	}
+
+	path := conversation.Path()
+	last := path[len(path)-1]
+	if last.Role != model.RoleAssistant || len(last.ToolCalls) > 0 {
+		return ""
+	}
+
+	return last.Content
+}
+
+// rejectToolCall marks the call as rejected and records the rejection as its result for the assistant
+func rejectToolCall(conversation *model.Conversation, call *model.ToolCall, reason string) {
+	call.Status = model.ToolCallRejected
+
+	content := "The user rejected this tool call, it was not executed."
+	if reason = strings.TrimSpace(reason); reason != "" {
+		content += " Reason: " + reason
+	}
+
+	conversation.Append(&model.Message{
+		ID:         primitive.NewObjectID(),
+		Role:       model.RoleTool,
+		Content:    content,
+		ToolCallID: call.ID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	})
+}
+
+func toolCallsProto(calls []*model.ToolCall) []*pb.Conversation_ToolCall {
+	var out []*pb.Conversation_ToolCall
+	for _, c := range calls {
+		out = append(out, c.Proto())
+	}
+	return out
 }
 
 func (s *Server) ListConversations(ctx context.Context, req *pb.ListConversationsRequest) (*pb.ListConversationsResponse, error) {
@@ -173,5 +847,256 @@ func (s *Server) DescribeConversation(ctx context.Context, req *pb.DescribeConve
 		return nil, twirp.NotFoundError("conversation not found")
 	}
 
+	if req.GetFullTree() {
+		return &pb.DescribeConversationResponse{Conversation: conversation.TreeProto()}, nil
+	}
+
 	return &pb.DescribeConversationResponse{Conversation: conversation.Proto()}, nil
-}
\ No newline at end of file
+}
+
+func (s *Server) SearchConversations(ctx context.Context, req *pb.SearchConversationsRequest) (*pb.SearchConversationsResponse, error) {
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return nil, twirp.RequiredArgumentError("query")
+	}
+
+	limit := int64(req.GetLimit())
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	results, err := s.repo.SearchConversations(ctx, req.GetQuery(), limit)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	terms := model.SearchTerms(req.GetQuery())
+
+	resp := &pb.SearchConversationsResponse{}
+	for _, r := range results {
+		result := &pb.SearchConversationsResponse_Result{Score: r.Score}
+
+		if snippet, ok := model.Snippet(r.Conversation.Title, terms, len(r.Conversation.Title)); ok {
+			result.TitleSnippet = snippet
+		}
+
+		for _, m := range r.Conversation.Messages {
+			if m.Role == model.RoleTool {
+				continue // Tool results are indexed, but are not worth showing
+			}
+
+			if snippet, ok := model.Snippet(m.Content, terms, 60); ok {
+				result.Matches = append(result.Matches, &pb.SearchConversationsResponse_Match{
+					MessageId: m.ID.Hex(),
+					Role:      m.Role.Proto(),
+					Snippet:   snippet,
+				})
+			}
+		}
+
+		r.Conversation.Messages = nil // Matches carry what is needed from the messages
+		result.Conversation = r.Conversation.Proto()
+		resp.Results = append(resp.Results, result)
+	}
+
+	return resp, nil
+}
+
+func (s *Server) SemanticSearch(ctx context.Context, req *pb.SemanticSearchRequest) (*pb.SemanticSearchResponse, error) {
+	if s.index == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "semantic search is not enabled")
+	}
+
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return nil, twirp.RequiredArgumentError("query")
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+
+	var exclude primitive.ObjectID
+	if req.GetExcludeConversationId() != "" {
+		id, err := primitive.ObjectIDFromHex(req.GetExcludeConversationId())
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("exclude_conversation_id", "must be a valid conversation ID")
+		}
+		exclude = id
+	}
+
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	matches, usage, err := s.index.Search(ctx, req.GetQuery(), limit, exclude)
+	s.chargeQuota(context.WithoutCancel(ctx), usage)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &pb.SemanticSearchResponse{}
+	for _, m := range matches {
+		resp.Results = append(resp.Results, &pb.SemanticSearchResponse_Result{
+			ConversationId: m.ConversationID.Hex(),
+			Title:          m.Title,
+			MessageId:      m.MessageID.Hex(),
+			Role:           m.Role.Proto(),
+			Content:        m.Content,
+			Score:          m.Score,
+			Timestamp:      timestamppb.New(m.CreatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+// errNoUser rejects memory calls from callers without an API key, whose memories would be shared with
+// everyone behind the same address
+var errNoUser = twirp.NewError(twirp.Unauthenticated, "memories need an API key")
+
+func (s *Server) ListMemories(ctx context.Context, req *pb.ListMemoriesRequest) (*pb.ListMemoriesResponse, error) {
+	user, ok := httpx.UserFromContext(ctx)
+	if !ok {
+		return nil, errNoUser
+	}
+
+	memories, err := s.repo.ListMemories(ctx, user, 0)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &pb.ListMemoriesResponse{}
+	for _, m := range memories {
+		resp.Memories = append(resp.Memories, m.Proto())
+	}
+
+	return resp, nil
+}
+
+func (s *Server) DeleteMemory(ctx context.Context, req *pb.DeleteMemoryRequest) (*pb.DeleteMemoryResponse, error) {
+	if req.GetMemoryId() == "" {
+		return nil, twirp.RequiredArgumentError("memory_id")
+	}
+
+	user, ok := httpx.UserFromContext(ctx)
+	if !ok {
+		return nil, errNoUser
+	}
+
+	if err := s.repo.DeleteMemory(ctx, user, req.GetMemoryId()); err != nil {
+		return nil, err
+	}
+
+	return &pb.DeleteMemoryResponse{}, nil
+}
+
+func (s *Server) UploadAttachment(ctx context.Context, req *pb.UploadAttachmentRequest) (*pb.UploadAttachmentResponse, error) {
+	if s.attachments == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "attachments are not enabled")
+	}
+
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	if strings.TrimSpace(req.GetFilename()) == "" {
+		return nil, twirp.RequiredArgumentError("filename")
+	}
+
+	if len(req.GetContent()) == 0 {
+		return nil, twirp.RequiredArgumentError("content")
+	}
+
+	if len(req.GetContent()) > maxAttachmentSize {
+		return nil, twirp.InvalidArgumentError("content", fmt.Sprintf("must not exceed %d MB", maxAttachmentSize>>20))
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Embedding the document counts against the quota like replies do
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	a, spent, err := s.attachments.Add(ctx, conversation.ID, req.GetFilename(), req.GetContentType(), req.GetContent())
+	s.chargeQuota(context.WithoutCancel(ctx), spent)
+
+	switch {
+	case errors.Is(err, attachment.ErrUnsupported), errors.Is(err, attachment.ErrInvalid), errors.Is(err, attachment.ErrNoText):
+		return nil, twirp.InvalidArgumentError("content", err.Error())
+	case err != nil:
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &pb.UploadAttachmentResponse{Attachment: a.Proto()}, nil
+}
+
+func (s *Server) ListAttachments(ctx context.Context, req *pb.ListAttachmentsRequest) (*pb.ListAttachmentsResponse, error) {
+	if req.GetConversationId() == "" {
+		return nil, twirp.RequiredArgumentError("conversation_id")
+	}
+
+	conversation, err := s.repo.DescribeConversation(ctx, req.GetConversationId())
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := s.repo.ListAttachments(ctx, conversation.ID)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &pb.ListAttachmentsResponse{}
+	for _, a := range attachments {
+		resp.Attachments = append(resp.Attachments, a.Proto())
+	}
+
+	return resp, nil
+}
+
+func (s *Server) GetImage(ctx context.Context, req *pb.GetImageRequest) (*pb.GetImageResponse, error) {
+	if req.GetImageId() == "" {
+		return nil, twirp.RequiredArgumentError("image_id")
+	}
+
+	id, err := primitive.ObjectIDFromHex(req.GetImageId())
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("image_id", "must be a valid image ID")
+	}
+
+	data, contentType, err := s.repo.LoadImage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetImageResponse{Image: &pb.Image{Id: req.GetImageId(), ContentType: contentType, Data: data}}, nil
+}
+
+func (s *Server) ListTools(ctx context.Context, req *pb.ListToolsRequest) (*pb.ListToolsResponse, error) {
+	resp := &pb.ListToolsResponse{}
+	for _, d := range s.assist.Tools().Descriptors() {
+		// Schemas are built from arbitrary Go values, so they are normalized through JSON
+		raw, err := json.Marshal(d.Parameters)
+		if err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+
+		params := &structpb.Struct{}
+		if err := protojson.Unmarshal(raw, params); err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+
+		resp.Tools = append(resp.Tools, &pb.Tool{
+			Name:        d.Name,
+			Description: d.Description,
+			Parameters:  params,
+			Enabled:     d.Enabled,
+			Risk:        pb.Tool_Risk(d.Risk), // Both enums declare the levels in the same order
+		})
+	}
+
+	return resp, nil
+}
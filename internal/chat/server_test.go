@@ -2,34 +2,68 @@ package chat
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/acai-travel/tech-challenge/internal/chat/attachment"
 	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
 	. "github.com/acai-travel/tech-challenge/internal/chat/testing"
+	"github.com/acai-travel/tech-challenge/internal/chat/tools"
+	"github.com/acai-travel/tech-challenge/internal/chat/weatherclient"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
 	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/acai-travel/tech-challenge/internal/webhook"
 	"github.com/google/go-cmp/cmp"
 	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 // MockAssistant for testing without calling OpenAI
 type MockAssistant struct {
 	TitleFunc func(ctx context.Context, conv *model.Conversation) (string, error)
 	ReplyFunc func(ctx context.Context, conv *model.Conversation) (string, error)
+	Registry  *tools.Registry
+
+	// MessagesFunc replaces ReplyFunc when the reply involves tool calls
+	MessagesFunc func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error)
 }
 
-func (m *MockAssistant) Title(ctx context.Context, conv *model.Conversation) (string, error) {
+func (m *MockAssistant) Title(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error) {
 	if m.TitleFunc != nil {
-		return m.TitleFunc(ctx, conv)
+		title, err := m.TitleFunc(ctx, conv)
+		return title, nil, err
 	}
-	return "Test Title", nil
+	return "Test Title", nil, nil
 }
 
-func (m *MockAssistant) Reply(ctx context.Context, conv *model.Conversation) (string, error) {
+func (m *MockAssistant) Reply(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+	if m.MessagesFunc != nil {
+		return m.MessagesFunc(ctx, conv)
+	}
+
+	reply := "Test Reply"
 	if m.ReplyFunc != nil {
-		return m.ReplyFunc(ctx, conv)
+		var err error
+		if reply, err = m.ReplyFunc(ctx, conv); err != nil {
+			return nil, err
+		}
+	}
+
+	return []*model.Message{{Role: model.RoleAssistant, Content: reply}}, nil
+}
+
+func (m *MockAssistant) Tools() *tools.Registry {
+	if m.Registry != nil {
+		return m.Registry
 	}
-	return "Test Reply", nil
+	return tools.NewRegistry()
 }
 
 func TestServer_StartConversation(t *testing.T) {
@@ -301,3 +335,1181 @@ func TestServer_DescribeConversation(t *testing.T) {
 		}
 	}))
 }
+
+func TestServer_ListTools(t *testing.T) {
+	ctx := context.Background()
+
+	registry := tools.NewRegistry()
+	registry.Register(tools.NewWeatherTool(weatherclient.New("")))
+	registry.Register(tools.NewCalculatorTool())
+	registry.Register(tools.NewDateTool())
+	registry.SetEnabled("get_today_date", false)
+
+	srv := NewServer(nil, &MockAssistant{Registry: registry})
+
+	out, err := srv.ListTools(ctx, &pb.ListToolsRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, tool := range out.GetTools() {
+		names = append(names, tool.GetName())
+	}
+
+	if want := []string{"calculate", "get_today_date", "get_weather"}; !cmp.Equal(names, want) {
+		t.Fatalf("expected tools %v in name order, got %v", want, names)
+	}
+
+	if out.GetTools()[1].GetEnabled() {
+		t.Error("expected get_today_date to be reported as disabled")
+	}
+
+	weather := out.GetTools()[2]
+	if weather.GetDescription() == "" {
+		t.Error("expected tool description to be populated")
+	}
+
+	required := weather.GetParameters().GetFields()["required"].GetListValue().GetValues()
+	if len(required) != 1 || required[0].GetStringValue() != "location" {
+		t.Errorf("expected parameters schema to require location, got %v", weather.GetParameters())
+	}
+}
+
+func TestServer_ToolApproval(t *testing.T) {
+	ctx := context.Background()
+
+	// The mock asks to book a flight, then answers with the result of the call once it is resolved
+	mockAssist := &MockAssistant{
+		MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+			last := conv.Messages[len(conv.Messages)-1]
+
+			if open := conv.OpenToolCalls(); len(open) > 0 {
+				if open[0].Status != model.ToolCallApproved {
+					t.Errorf("expected open call to be approved, got %q", open[0].Status)
+				}
+				return []*model.Message{
+					{Role: model.RoleTool, ToolCallID: open[0].ID, Content: "booked"},
+					{Role: model.RoleAssistant, Content: "Your flight is booked."},
+				}, nil
+			}
+
+			if last.Role == model.RoleTool {
+				return []*model.Message{{Role: model.RoleAssistant, Content: "Understood: " + last.Content}}, nil
+			}
+
+			status := model.ToolCallPending
+			if conv.ToolApproved("book_flight") {
+				status = model.ToolCallApproved
+			}
+
+			call := &model.ToolCall{ID: primitive.NewObjectID().Hex(), Name: "book_flight", Arguments: `{"to":"LIS"}`, Status: status}
+			messages := []*model.Message{{Role: model.RoleAssistant, ToolCalls: []*model.ToolCall{call}}}
+			if status == model.ToolCallApproved {
+				messages = append(messages, &model.Message{Role: model.RoleTool, ToolCallID: call.ID, Content: "booked"})
+				messages = append(messages, &model.Message{Role: model.RoleAssistant, Content: "Booked again."})
+			}
+			return messages, nil
+		},
+	}
+
+	t.Run("pauses on pending calls and resumes once approved", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, mockAssist)
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Book me a flight to Lisbon"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, started.GetConversationId()) }()
+
+		if started.GetReply() != "" || len(started.GetPendingToolCalls()) != 1 {
+			t.Fatalf("expected an empty reply and one pending call, got %q and %v", started.GetReply(), started.GetPendingToolCalls())
+		}
+
+		approved, err := server.ApproveToolCall(ctx, &pb.ApproveToolCallRequest{
+			ConversationId: started.GetConversationId(),
+			ToolCallId:     started.GetPendingToolCalls()[0].GetId(),
+			AlwaysAllow:    true,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if approved.GetReply() != "Your flight is booked." || len(approved.GetPendingToolCalls()) != 0 {
+			t.Errorf("expected the reply to resume, got %q and %v", approved.GetReply(), approved.GetPendingToolCalls())
+		}
+
+		// The tool is now allowed for the rest of the conversation
+		continued, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{
+			ConversationId: started.GetConversationId(),
+			Message:        "Book it again",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if continued.GetReply() != "Booked again." || len(continued.GetPendingToolCalls()) != 0 {
+			t.Errorf("expected the call to run without approval, got %q and %v", continued.GetReply(), continued.GetPendingToolCalls())
+		}
+	}))
+
+	t.Run("tells the assistant about rejected calls", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, mockAssist)
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Book me a flight to Lisbon"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, started.GetConversationId()) }()
+
+		rejected, err := server.RejectToolCall(ctx, &pb.RejectToolCallRequest{
+			ConversationId: started.GetConversationId(),
+			ToolCallId:     started.GetPendingToolCalls()[0].GetId(),
+			Reason:         "too expensive",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(rejected.GetReply(), "too expensive") {
+			t.Errorf("expected the assistant to see the reason, got %q", rejected.GetReply())
+		}
+
+		_, err = server.RejectToolCall(ctx, &pb.RejectToolCallRequest{
+			ConversationId: started.GetConversationId(),
+			ToolCallId:     started.GetPendingToolCalls()[0].GetId(),
+		})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.NotFound {
+			t.Errorf("expected not found for a resolved call, got %v", err)
+		}
+	}))
+
+	t.Run("lets only one of the requests racing on a call decide it", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, mockAssist)
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Book me a flight to Lisbon"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, started.GetConversationId()) }()
+
+		// Both requests load the conversation while the call is pending
+		first, _, err := server.pendingToolCall(ctx, started.GetConversationId(), started.GetPendingToolCalls()[0].GetId())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		second, call, err := server.pendingToolCall(ctx, started.GetConversationId(), started.GetPendingToolCalls()[0].GetId())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		first.PendingToolCalls()[0].Status = model.ToolCallApproved
+		if err := server.claim(ctx, first); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		rejectToolCall(second, call, "")
+		err = server.claim(ctx, second)
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.FailedPrecondition {
+			t.Errorf("expected the second decision to fail, got %v", err)
+		}
+	}))
+}
+
+func TestServer_Usage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("aggregates usage of the messages in the conversation", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				return []*model.Message{{
+					Role:    model.RoleAssistant,
+					Content: "Sunny",
+					Usage:   &model.Usage{Model: "gpt-4.1", PromptTokens: 100, CompletionTokens: 10, CachedTokens: 50, CostUSD: 0.5},
+				}}, nil
+			},
+		})
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Weather in Faro?"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, started.GetConversationId()) }()
+
+		if _, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{
+			ConversationId: started.GetConversationId(),
+			Message:        "And tomorrow?",
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		out, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: started.GetConversationId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := &pb.Usage{PromptTokens: 200, CompletionTokens: 20, CachedTokens: 100, CostUsd: 1}
+		if diff := cmp.Diff(want, out.GetConversation().GetUsage(), protocmp.Transform()); diff != "" {
+			t.Errorf("unexpected conversation usage (-want +got):\n%s", diff)
+		}
+
+		if got := out.GetConversation().GetMessages()[1].GetUsage().GetModel(); got != "gpt-4.1" {
+			t.Errorf("expected usage on the assistant message, got model %q", got)
+		}
+	}))
+}
+
+func TestServer_DailyTokenQuota(t *testing.T) {
+	t.Run("rejects replies once the quota is used", WithFixture(func(t *testing.T, f *Fixture) {
+		ctx := httpx.WithClient(context.Background(), "test:"+primitive.NewObjectID().Hex())
+
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				return []*model.Message{{
+					Role:    model.RoleAssistant,
+					Content: "Sunny",
+					Usage:   &model.Usage{PromptTokens: 80, CompletionTokens: 20},
+				}}, nil
+			},
+		}, WithDailyTokenQuota(100))
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Weather in Faro?"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, started.GetConversationId()) }()
+
+		_, err = server.ContinueConversation(ctx, &pb.ContinueConversationRequest{
+			ConversationId: started.GetConversationId(),
+			Message:        "And tomorrow?",
+		})
+
+		te, ok := err.(twirp.Error)
+		if !ok || te.Code() != twirp.ResourceExhausted {
+			t.Fatalf("expected resource exhausted error, got %v", err)
+		}
+
+		if te.Meta("retry_after") == "" {
+			t.Error("expected retry_after metadata")
+		}
+
+		// Other clients have their own quota
+		other := httpx.WithClient(context.Background(), "test:"+primitive.NewObjectID().Hex())
+		if _, err := server.ContinueConversation(other, &pb.ContinueConversationRequest{
+			ConversationId: started.GetConversationId(),
+			Message:        "And tomorrow?",
+		}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}))
+}
+
+func TestServer_Branching(t *testing.T) {
+	ctx := context.Background()
+
+	replies := 0
+	mockAssist := &MockAssistant{
+		ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+			replies++
+			path := conv.Path()
+			return fmt.Sprintf("reply %d to %s", replies, path[len(path)-1].Content), nil
+		},
+	}
+
+	t.Run("regenerates and edits in new branches", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, mockAssist)
+
+		started, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Porto"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cid := started.GetConversationId()
+		defer func() { _ = f.Repository.DeleteConversation(ctx, cid) }()
+
+		regenerated, err := server.RegenerateReply(ctx, &pb.RegenerateReplyRequest{ConversationId: cid})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if regenerated.GetReply() == started.GetReply() {
+			t.Errorf("expected a new reply, got %q again", regenerated.GetReply())
+		}
+
+		described, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: cid})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		first := described.GetConversation().GetMessages()[0]
+		edited, err := server.EditMessage(ctx, &pb.EditMessageRequest{ConversationId: cid, MessageId: first.GetId(), Message: "Lisbon"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.HasSuffix(edited.GetReply(), "to Lisbon") {
+			t.Errorf("expected a reply to the edited message, got %q", edited.GetReply())
+		}
+
+		active, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: cid})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if messages := active.GetConversation().GetMessages(); len(messages) != 2 || messages[0].GetId() != edited.GetMessageId() {
+			t.Errorf("expected the active branch to start with the edited message, got %v", messages)
+		}
+
+		tree, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: cid, FullTree: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n := len(tree.GetConversation().GetMessages()); n != 5 {
+			t.Errorf("expected 5 messages across branches, got %d", n)
+		}
+	}))
+
+	t.Run("only edits user messages", WithFixture(func(t *testing.T, f *Fixture) {
+		c := f.CreateConversation(func(c *model.Conversation) {
+			c.Messages = append(c.Messages, &model.Message{ID: primitive.NewObjectID(), Role: model.RoleAssistant, Content: "Sunny"})
+		})
+
+		_, err := NewServer(f.Repository, mockAssist).EditMessage(ctx, &pb.EditMessageRequest{
+			ConversationId: c.ID.Hex(),
+			MessageId:      c.Messages[1].ID.Hex(),
+			Message:        "Rainy",
+		})
+
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+			t.Errorf("expected invalid argument, got %v", err)
+		}
+	}))
+}
+
+func TestServer_ForkConversation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("copies the conversation up to the message", WithFixture(func(t *testing.T, f *Fixture) {
+		c := f.CreateConversation(func(c *model.Conversation) {
+			c.Messages = append(c.Messages,
+				&model.Message{ID: primitive.NewObjectID(), Role: model.RoleAssistant, Content: "Sunny in Lisbon"},
+				&model.Message{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "Book a hotel there"},
+			)
+		})
+
+		server := NewServer(f.Repository, &MockAssistant{
+			TitleFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "Weather in Porto", nil
+			},
+		})
+
+		out, err := server.ForkConversation(ctx, &pb.ForkConversationRequest{ConversationId: c.ID.Hex(), MessageId: c.Messages[1].ID.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = f.Repository.DeleteConversation(ctx, out.GetConversationId()) }()
+
+		if out.GetTitle() != "Weather in Porto" {
+			t.Errorf("expected a fresh title, got %q", out.GetTitle())
+		}
+
+		fork, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: out.GetConversationId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if fork.GetConversation().GetForkedFromConversationId() != c.ID.Hex() || len(fork.GetConversation().GetMessages()) != 2 {
+			t.Errorf("expected two messages linked to the source, got %v", fork.GetConversation())
+		}
+
+		source, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: c.ID.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(source.GetConversation().GetMessages()) != 3 {
+			t.Errorf("expected the source to be unchanged, got %v", source.GetConversation().GetMessages())
+		}
+	}))
+
+	t.Run("requires an existing message", WithFixture(func(t *testing.T, f *Fixture) {
+		c := f.CreateConversation()
+
+		_, err := NewServer(f.Repository, &MockAssistant{}).ForkConversation(ctx, &pb.ForkConversationRequest{
+			ConversationId: c.ID.Hex(),
+			MessageId:      primitive.NewObjectID().Hex(),
+		})
+
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.NotFound {
+			t.Errorf("expected not found, got %v", err)
+		}
+	}))
+}
+
+func TestServer_SearchConversations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("finds conversations by message content", WithFixture(func(t *testing.T, f *Fixture) {
+		if err := f.EnsureSearchIndexes(ctx); err != nil {
+			t.Fatalf("failed to create search indexes: %v", err)
+		}
+
+		word := "zx" + primitive.NewObjectID().Hex() // Unique so other conversations do not match
+		c := f.CreateConversation(func(c *model.Conversation) {
+			c.Messages = append(c.Messages, &model.Message{
+				ID:      primitive.NewObjectID(),
+				Role:    model.RoleAssistant,
+				Content: "The hotel " + word + " is close to the river.",
+			})
+		})
+		f.CreateConversation()
+
+		out, err := NewServer(f.Repository, &MockAssistant{}).SearchConversations(ctx, &pb.SearchConversationsRequest{Query: word})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(out.GetResults()) != 1 || out.GetResults()[0].GetConversation().GetId() != c.ID.Hex() {
+			t.Fatalf("expected only the matching conversation, got %v", out.GetResults())
+		}
+
+		matches := out.GetResults()[0].GetMatches()
+		if len(matches) != 1 || matches[0].GetMessageId() != c.Messages[1].ID.Hex() || !strings.Contains(matches[0].GetSnippet(), "**"+word+"**") {
+			t.Errorf("expected a highlighted match in the assistant message, got %v", matches)
+		}
+	}))
+
+	t.Run("requires a query", WithFixture(func(t *testing.T, f *Fixture) {
+		_, err := NewServer(f.Repository, &MockAssistant{}).SearchConversations(ctx, &pb.SearchConversationsRequest{Query: " "})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+			t.Errorf("expected invalid argument, got %v", err)
+		}
+	}))
+}
+
+// keywordEmbedder embeds texts as vectors counting the keywords they contain
+type keywordEmbedder []string
+
+func (k keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, *model.Usage, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(k))
+		for j, word := range k {
+			vectors[i][j] = float32(strings.Count(strings.ToLower(text), word))
+		}
+	}
+
+	return vectors, &model.Usage{Model: "keywords"}, nil
+}
+
+func TestServer_SemanticSearch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("finds messages by meaning and skips the excluded conversation", WithFixture(func(t *testing.T, f *Fixture) {
+		// A unique keyword keeps messages indexed by other runs out of the closest matches
+		word := "zx" + primitive.NewObjectID().Hex()
+		index := semantic.NewIndex(f.Repository, keywordEmbedder{word, "weather"})
+		server := NewServer(f.Repository, &MockAssistant{
+			ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "Noted.", nil
+			},
+			TitleFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "Trip", nil
+			},
+		}, WithSemanticIndex(index))
+
+		first, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "I loved the " + word + " hotel"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		second, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "Book the " + word + " hotel again"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		out, err := server.SemanticSearch(ctx, &pb.SemanticSearchRequest{
+			Query:                 "Where was the " + word + " place?",
+			Limit:                 1,
+			ExcludeConversationId: second.GetConversationId(),
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(out.GetResults()) != 1 {
+			t.Fatalf("expected 1 result, got %v", out.GetResults())
+		}
+
+		r := out.GetResults()[0]
+		if r.GetConversationId() != first.GetConversationId() || r.GetTitle() != "Trip" || r.GetRole() != pb.Conversation_USER {
+			t.Errorf("expected the user message of the first conversation, got %v", r)
+		}
+	}))
+
+	t.Run("only finds the messages of the caller", WithFixture(func(t *testing.T, f *Fixture) {
+		word := "zx" + primitive.NewObjectID().Hex()
+		alice := httpx.WithClient(ctx, "key:alice-"+word)
+		bob := httpx.WithClient(ctx, "key:bob-"+word)
+
+		index := semantic.NewIndex(f.Repository, keywordEmbedder{word})
+		server := NewServer(f.Repository, &MockAssistant{}, WithSemanticIndex(index))
+
+		if _, err := server.StartConversation(alice, &pb.StartConversationRequest{Message: "My passport number is " + word}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		matches, _, err := index.Search(bob, "passport "+word, 5, primitive.NilObjectID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(matches) != 0 {
+			t.Errorf("expected another client not to recall the messages, got %v", matches)
+		}
+
+		matches, _, err = index.Search(alice, "passport "+word, 5, primitive.NilObjectID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(matches) == 0 || !strings.Contains(matches[0].Content, word) {
+			t.Errorf("expected the client to recall its own message, got %v", matches)
+		}
+	}))
+
+	t.Run("charges the embeddings to the quota", WithFixture(func(t *testing.T, f *Fixture) {
+		ctx := httpx.WithClient(context.Background(), "test:"+primitive.NewObjectID().Hex())
+		index := semantic.NewIndex(f.Repository, meteredEmbedder{keywordEmbedder{"hotel"}})
+		server := NewServer(f.Repository, &MockAssistant{}, WithSemanticIndex(index), WithDailyTokenQuota(100))
+
+		for i := 0; i < 2; i++ {
+			if _, err := server.SemanticSearch(ctx, &pb.SemanticSearchRequest{Query: "hotels"}); err != nil {
+				t.Fatalf("search %d: unexpected error: %v", i+1, err)
+			}
+		}
+
+		_, err := server.SemanticSearch(ctx, &pb.SemanticSearchRequest{Query: "hotels"})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.ResourceExhausted {
+			t.Errorf("expected the quota to be used, got %v", err)
+		}
+	}))
+
+	t.Run("is unimplemented without an index", func(t *testing.T) {
+		_, err := NewServer(nil, &MockAssistant{}).SemanticSearch(ctx, &pb.SemanticSearchRequest{Query: "hotels"})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.Unimplemented {
+			t.Errorf("expected unimplemented, got %v", err)
+		}
+	})
+}
+
+func TestServer_Memories(t *testing.T) {
+	t.Run("lists and deletes the caller's memories", WithFixture(func(t *testing.T, f *Fixture) {
+		ctx := httpx.WithClient(context.Background(), "key:"+primitive.NewObjectID().Hex())
+		server := NewServer(f.Repository, &MockAssistant{})
+
+		memory := &model.Memory{ID: primitive.NewObjectID(), User: httpx.ClientFromContext(ctx), Content: "Is vegetarian", CreatedAt: time.Now()}
+		if err := f.SaveMemory(ctx, memory); err != nil {
+			t.Fatalf("failed to save memory: %v", err)
+		}
+
+		out, err := server.ListMemories(ctx, &pb.ListMemoriesRequest{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(out.GetMemories()) != 1 || out.GetMemories()[0].GetContent() != "Is vegetarian" {
+			t.Fatalf("expected the saved memory, got %v", out.GetMemories())
+		}
+
+		// Other clients can neither see nor delete it
+		other := httpx.WithClient(context.Background(), "key:"+primitive.NewObjectID().Hex())
+		_, err = server.DeleteMemory(other, &pb.DeleteMemoryRequest{MemoryId: memory.ID.Hex()})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.NotFound {
+			t.Errorf("expected not found for another client, got %v", err)
+		}
+
+		if _, err := server.DeleteMemory(ctx, &pb.DeleteMemoryRequest{MemoryId: memory.ID.Hex()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out, _ := server.ListMemories(ctx, &pb.ListMemoriesRequest{}); len(out.GetMemories()) != 0 {
+			t.Errorf("expected the memory to be deleted, got %v", out.GetMemories())
+		}
+	}))
+}
+
+func TestServer_MemoriesNeedAnAPIKey(t *testing.T) {
+	ctx := httpx.WithClient(context.Background(), "ip:10.0.0.1")
+	server := NewServer(nil, &MockAssistant{})
+
+	if _, err := server.ListMemories(ctx, &pb.ListMemoriesRequest{}); err != errNoUser {
+		t.Errorf("expected unauthenticated, got %v", err)
+	}
+
+	if _, err := server.DeleteMemory(ctx, &pb.DeleteMemoryRequest{MemoryId: primitive.NewObjectID().Hex()}); err != errNoUser {
+		t.Errorf("expected unauthenticated, got %v", err)
+	}
+}
+
+// meteredEmbedder charges tokens for every batch it embeds
+type meteredEmbedder struct{ keywordEmbedder }
+
+func (m meteredEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, *model.Usage, error) {
+	vectors, _, err := m.keywordEmbedder.Embed(ctx, texts)
+	return vectors, &model.Usage{Model: "keywords", PromptTokens: 60}, err
+}
+
+func TestServer_UploadAttachment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("charges the embeddings to the quota", WithFixture(func(t *testing.T, f *Fixture) {
+		ctx := httpx.WithClient(context.Background(), "test:"+primitive.NewObjectID().Hex())
+		c := f.CreateConversation()
+		store := attachment.NewStore(f.Repository, meteredEmbedder{keywordEmbedder{"hotel"}})
+		server := NewServer(f.Repository, &MockAssistant{}, WithAttachments(store), WithDailyTokenQuota(100))
+
+		upload := func() error {
+			_, err := server.UploadAttachment(ctx, &pb.UploadAttachmentRequest{
+				ConversationId: c.ID.Hex(),
+				Filename:       "booking.md",
+				Content:        []byte("Hotel Alfama, two nights."),
+			})
+			return err
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := upload(); err != nil {
+				t.Fatalf("upload %d: unexpected error: %v", i+1, err)
+			}
+		}
+
+		err := upload()
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.ResourceExhausted {
+			t.Errorf("expected the quota to be used, got %v", err)
+		}
+	}))
+
+	t.Run("stores the document for retrieval", WithFixture(func(t *testing.T, f *Fixture) {
+		c := f.CreateConversation()
+		store := attachment.NewStore(f.Repository, keywordEmbedder{"hotel", "flight"})
+		server := NewServer(f.Repository, &MockAssistant{}, WithAttachments(store))
+
+		out, err := server.UploadAttachment(ctx, &pb.UploadAttachmentRequest{
+			ConversationId: c.ID.Hex(),
+			Filename:       "booking.md",
+			Content:        []byte("# Booking\n\nHotel Alfama, two nights.\n\nFlight TP1234 on Friday."),
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out.GetAttachment().GetContentType() != "text/markdown" || out.GetAttachment().GetChunks() != 1 {
+			t.Errorf("unexpected attachment %v", out.GetAttachment())
+		}
+
+		matches, _, err := store.Search(ctx, c.ID, "Which hotel?", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(matches) != 1 || !strings.Contains(matches[0].Content, "Hotel Alfama") {
+			t.Errorf("expected the booking to be retrieved, got %v", matches)
+		}
+
+		list, err := server.ListAttachments(ctx, &pb.ListAttachmentsRequest{ConversationId: c.ID.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(list.GetAttachments()) != 1 || list.GetAttachments()[0].GetFilename() != "booking.md" {
+			t.Errorf("expected the attachment to be listed, got %v", list.GetAttachments())
+		}
+	}))
+
+	t.Run("rejects unsupported documents", WithFixture(func(t *testing.T, f *Fixture) {
+		c := f.CreateConversation()
+		server := NewServer(f.Repository, &MockAssistant{}, WithAttachments(attachment.NewStore(f.Repository, keywordEmbedder{})))
+
+		_, err := server.UploadAttachment(ctx, &pb.UploadAttachmentRequest{
+			ConversationId: c.ID.Hex(),
+			Filename:       "photo.png",
+			Content:        []byte("\x89PNG\r\n\x1a\n"),
+		})
+
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+			t.Errorf("expected invalid argument, got %v", err)
+		}
+	}))
+}
+
+func TestServer_Images(t *testing.T) {
+	ctx := context.Background()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	t.Run("stores uploaded images and references them", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, &MockAssistant{
+			ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				if images := conv.Messages[0].Images(); len(images) != 2 {
+					t.Errorf("expected the assistant to get 2 images, got %d", len(images))
+				}
+				return "It says 'Exit'.", nil
+			},
+		})
+
+		out, err := server.StartConversation(ctx, &pb.StartConversationRequest{
+			Message: "What does this sign say?",
+			Parts: []*pb.Part{
+				{Part: &pb.Part_Image{Image: &pb.Image{Data: png}}},
+				{Part: &pb.Part_Image{Image: &pb.Image{Url: "https://example.com/sign.jpg"}}},
+			},
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		conv, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: out.GetConversationId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		msg := conv.GetConversation().GetMessages()[0]
+		if msg.GetContent() != "What does this sign say?" || len(msg.GetParts()) != 3 {
+			t.Fatalf("unexpected message %v", msg)
+		}
+
+		uploaded := msg.GetParts()[1].GetImage()
+		if uploaded.GetId() == "" || uploaded.GetContentType() != "image/png" || uploaded.GetData() != nil {
+			t.Fatalf("expected a reference to the uploaded image, got %v", uploaded)
+		}
+
+		image, err := server.GetImage(ctx, &pb.GetImageRequest{ImageId: uploaded.GetId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if string(image.GetImage().GetData()) != string(png) {
+			t.Errorf("expected the uploaded data, got %q", image.GetImage().GetData())
+		}
+	}))
+
+	t.Run("validates images", func(t *testing.T) {
+		server := NewServer(nil, &MockAssistant{})
+
+		for name, image := range map[string]*pb.Image{
+			"relative URL":     {Url: "sign.jpg"},
+			"unsupported type": {Data: []byte("%PDF-1.4")},
+			"empty":            {},
+		} {
+			_, err := server.userMessage(ctx, "Sign?", []*pb.Part{{Part: &pb.Part_Image{Image: image}}})
+			if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+				t.Errorf("%s: expected invalid argument, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestServer_StructuredReply(t *testing.T) {
+	ctx := context.Background()
+
+	schema, err := structpb.NewStruct(map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"city": map[string]any{"type": "string"}},
+		"required":   []any{"city"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("returns the reply as structured data", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				if conv.Messages[0].ResponseSchema == "" {
+					t.Error("expected the user message to carry the response schema")
+				}
+				return []*model.Message{{Role: model.RoleAssistant, Content: `{"city":"Barcelona"}`, Structured: true}}, nil
+			},
+		})
+
+		out, err := server.StartConversation(ctx, &pb.StartConversationRequest{
+			Message:        "Where is the Sagrada Familia?",
+			ResponseSchema: schema,
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if city := out.GetStructuredReply().GetStructValue().GetFields()["city"].GetStringValue(); city != "Barcelona" {
+			t.Errorf("expected city Barcelona, got %v", out.GetStructuredReply())
+		}
+	}))
+
+	t.Run("leaves free text replies unstructured", WithFixture(func(t *testing.T, f *Fixture) {
+		out, err := NewServer(f.Repository, &MockAssistant{}).StartConversation(ctx, &pb.StartConversationRequest{Message: "Hello"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out.GetStructuredReply() != nil {
+			t.Errorf("expected no structured reply, got %v", out.GetStructuredReply())
+		}
+	}))
+
+	t.Run("rejects invalid schemas", func(t *testing.T) {
+		for name, fields := range map[string]map[string]any{
+			"invalid pattern": {"type": "object", "properties": map[string]any{"code": map[string]any{"type": "string", "pattern": "("}}},
+			"not an object":   {"type": "array"},
+		} {
+			schema, err := structpb.NewStruct(fields)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = responseSchema(schema)
+			if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+				t.Errorf("%s: expected invalid argument, got %v", name, err)
+			}
+		}
+	})
+}
+
+// fakeTitleQueue records the conversations queued for titles
+type fakeTitleQueue struct {
+	queued []primitive.ObjectID
+}
+
+func (q *fakeTitleQueue) Enqueue(ctx context.Context, id primitive.ObjectID) {
+	q.queued = append(q.queued, id)
+}
+
+func (q *fakeTitleQueue) Refresh(ctx context.Context, conv *model.Conversation) {
+	if conv.Title == model.UntitledConversation {
+		q.Enqueue(ctx, conv.ID)
+	}
+}
+
+func TestServer_TitleQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("starts conversations without waiting for a title", WithFixture(func(t *testing.T, f *Fixture) {
+		queue := &fakeTitleQueue{}
+		server := NewServer(f.Repository, &MockAssistant{
+			TitleFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				t.Error("expected the title not to be generated while starting the conversation")
+				return "", nil
+			},
+		}, WithTitleQueue(queue))
+
+		out, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "What's the weather like in Barcelona?"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out.GetTitle() != model.UntitledConversation {
+			t.Errorf("expected the default title, got %q", out.GetTitle())
+		}
+
+		if len(queue.queued) != 1 || queue.queued[0].Hex() != out.GetConversationId() {
+			t.Fatalf("expected the conversation to be queued, got %v", queue.queued)
+		}
+
+		// A title saved while a reply is generated is not overwritten when the reply is saved
+		conv, err := f.Repository.DescribeConversation(ctx, out.GetConversationId())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := f.Repository.SetTitle(ctx, conv.ID, "Weather in Barcelona", 1, &model.Usage{PromptTokens: 10}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		conv.Append(&model.Message{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "And tomorrow?"})
+		if err := f.Repository.UpdateConversation(ctx, conv); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		saved, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: out.GetConversationId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if saved.GetConversation().GetTitle() != "Weather in Barcelona" || len(saved.GetConversation().GetMessages()) != 3 {
+			t.Errorf("expected the title and the new message, got %v", saved.GetConversation())
+		}
+
+		if saved.GetConversation().GetUsage().GetPromptTokens() != 10 {
+			t.Errorf("expected the title usage in the total, got %v", saved.GetConversation().GetUsage())
+		}
+	}))
+}
+
+// fakeJobs keeps enqueued jobs so tests can run them as a worker would
+type fakeJobs struct {
+	jobs []*jobs.Job
+}
+
+func (q *fakeJobs) Enqueue(ctx context.Context, kind string, payload any, opts ...jobs.EnqueueOption) (primitive.ObjectID, error) {
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	job := &jobs.Job{ID: primitive.NewObjectID(), Kind: kind, Payload: raw, Attempts: 1}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	q.jobs = append(q.jobs, job)
+	return job.ID, nil
+}
+
+func TestServer_AsyncReply(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("generates the reply in the background", WithFixture(func(t *testing.T, f *Fixture) {
+		queue := &fakeJobs{}
+		server := NewServer(f.Repository, &MockAssistant{
+			ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "Sunny all week.", nil
+			},
+		}, WithAsyncReplies(queue, nil))
+
+		conv := f.CreateConversation()
+		out, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{ConversationId: conv.ID.Hex(), Message: "And next week?", Async: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out.GetPendingMessageId() == "" || out.GetReply() != "" || len(queue.jobs) != 1 {
+			t.Fatalf("expected a pending reply and a job, got %v and %d jobs", out, len(queue.jobs))
+		}
+
+		get := &pb.GetMessageRequest{ConversationId: conv.ID.Hex(), MessageId: out.GetPendingMessageId()}
+		msg, err := server.GetMessage(ctx, get)
+		if err != nil || msg.GetStatus() != pb.GetMessageResponse_PENDING {
+			t.Fatalf("expected a pending message, got %v, %v", msg, err)
+		}
+
+		_, err = server.ContinueConversation(ctx, &pb.ContinueConversationRequest{ConversationId: conv.ID.Hex(), Message: "Hello?"})
+		if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.FailedPrecondition {
+			t.Errorf("expected failed precondition while the reply is pending, got %v", err)
+		}
+
+		if err := server.HandleReply(ctx, queue.jobs[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		msg, err = server.GetMessage(ctx, get)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if msg.GetStatus() != pb.GetMessageResponse_COMPLETE || msg.GetMessage().GetContent() != "Sunny all week." {
+			t.Errorf("expected the completed reply, got %v", msg)
+		}
+	}))
+
+	t.Run("reports replies that failed", WithFixture(func(t *testing.T, f *Fixture) {
+		queue := &fakeJobs{}
+		server := NewServer(f.Repository, &MockAssistant{
+			ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "", fmt.Errorf("model unavailable")
+			},
+		}, WithAsyncReplies(queue, nil))
+
+		conv := f.CreateConversation()
+		out, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{ConversationId: conv.ID.Hex(), Message: "And next week?", Async: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		job := queue.jobs[0]
+		if err := server.HandleReply(ctx, job); err == nil || jobs.IsPermanent(err) {
+			t.Fatalf("expected the first attempt to be retried, got %v", err)
+		}
+
+		job.Attempts = job.MaxAttempts
+		if err := server.HandleReply(ctx, job); !jobs.IsPermanent(err) {
+			t.Fatalf("expected the last attempt to fail the reply, got %v", err)
+		}
+
+		msg, err := server.GetMessage(ctx, &pb.GetMessageRequest{ConversationId: conv.ID.Hex(), MessageId: out.GetPendingMessageId()})
+		if err != nil || msg.GetStatus() != pb.GetMessageResponse_FAILED || msg.GetError() == "" {
+			t.Fatalf("expected a failed reply with its error, got %v, %v", msg, err)
+		}
+
+		// A failed reply no longer blocks the conversation
+		if _, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{ConversationId: conv.ID.Hex(), Message: "Try again", Async: true}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}))
+
+	t.Run("validates async options", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			server *Server
+			req    *pb.ContinueConversationRequest
+			code   twirp.ErrorCode
+		}{
+			"disabled": {
+				server: NewServer(nil, &MockAssistant{}),
+				req:    &pb.ContinueConversationRequest{Async: true},
+				code:   twirp.Unimplemented,
+			},
+			"webhook without async": {
+				server: NewServer(nil, &MockAssistant{}, WithAsyncReplies(&fakeJobs{}, nil)),
+				req:    &pb.ContinueConversationRequest{WebhookUrl: "https://example.com/hook"},
+				code:   twirp.InvalidArgument,
+			},
+			"webhooks disabled": {
+				server: NewServer(nil, &MockAssistant{}, WithAsyncReplies(&fakeJobs{}, nil)),
+				req:    &pb.ContinueConversationRequest{Async: true, WebhookUrl: "https://example.com/hook"},
+				code:   twirp.InvalidArgument,
+			},
+			"relative webhook": {
+				server: NewServer(nil, &MockAssistant{}, WithAsyncReplies(&fakeJobs{}, webhook.NewSender("s3cret", &fakeJobs{}))),
+				req:    &pb.ContinueConversationRequest{Async: true, WebhookUrl: "/hook"},
+				code:   twirp.InvalidArgument,
+			},
+			"private webhook": {
+				server: NewServer(nil, &MockAssistant{}, WithAsyncReplies(&fakeJobs{}, webhook.NewSender("s3cret", &fakeJobs{}))),
+				req:    &pb.ContinueConversationRequest{Async: true, WebhookUrl: "http://169.254.169.254/latest/meta-data/"},
+				code:   twirp.InvalidArgument,
+			},
+		} {
+			tc.req.ConversationId = primitive.NewObjectID().Hex()
+			tc.req.Message = "Hello"
+
+			_, err := tc.server.ContinueConversation(ctx, tc.req)
+			if te, ok := err.(twirp.Error); !ok || te.Code() != tc.code {
+				t.Errorf("%s: expected %s, got %v", name, tc.code, err)
+			}
+		}
+	})
+}
+
+func TestServer_CancelReply(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stores the reply up to where it was cancelled", WithFixture(func(t *testing.T, f *Fixture) {
+		started := make(chan struct{})
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				close(started)
+				<-ctx.Done()
+
+				call := &model.ToolCall{ID: "call_1", Name: "get_weather", Arguments: `{"location":"Lisbon"}`}
+				return []*model.Message{
+					{Role: model.RoleAssistant, ToolCalls: []*model.ToolCall{call}},
+					{Role: model.RoleTool, ToolCallID: call.ID, Content: "tool execution failed: context canceled"},
+				}, ctx.Err()
+			},
+		})
+
+		conv := f.CreateConversation()
+
+		errs := make(chan error)
+		go func() {
+			_, err := server.ContinueConversation(ctx, &pb.ContinueConversationRequest{ConversationId: conv.ID.Hex(), Message: "And in Lisbon?"})
+			errs <- err
+		}()
+
+		<-started
+
+		out, err := server.CancelReply(ctx, &pb.CancelReplyRequest{ConversationId: conv.ID.Hex()})
+		if err != nil || out.GetCancelled() != 1 {
+			t.Fatalf("expected one reply to be cancelled, got %v, %v", out, err)
+		}
+
+		if te, ok := (<-errs).(twirp.Error); !ok || te.Code() != twirp.Canceled || te.Meta("message_id") == "" {
+			t.Fatalf("expected a canceled error with the message ID, got %v", te)
+		}
+
+		described, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: conv.ID.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The question, the call, its result and the cancelled answer follow the first message
+		messages := described.GetConversation().GetMessages()
+		if len(messages) != 5 {
+			t.Fatalf("expected 5 messages, got %d", len(messages))
+		}
+
+		if last := messages[4]; last.GetRole() != pb.Conversation_ASSISTANT || !last.GetCancelled() {
+			t.Errorf("expected a cancelled assistant message, got %v", last)
+		}
+
+		// Nothing is left to cancel
+		if out, _ := server.CancelReply(ctx, &pb.CancelReplyRequest{ConversationId: conv.ID.Hex()}); out.GetCancelled() != 0 {
+			t.Errorf("expected no reply in flight, got %d", out.GetCancelled())
+		}
+	}))
+
+	t.Run("cancels the first reply of a conversation", WithFixture(func(t *testing.T, f *Fixture) {
+		started := make(chan primitive.ObjectID)
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				started <- conv.ID
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		})
+
+		errs := make(chan error)
+		go func() {
+			_, err := server.StartConversation(ctx, &pb.StartConversationRequest{Message: "What is the weather in Lisbon?"})
+			errs <- err
+		}()
+
+		id := <-started
+		out, err := server.CancelReply(ctx, &pb.CancelReplyRequest{ConversationId: id.Hex()})
+		if err != nil || out.GetCancelled() != 1 {
+			t.Fatalf("expected one reply to be cancelled, got %v, %v", out, err)
+		}
+
+		if te, ok := (<-errs).(twirp.Error); !ok || te.Code() != twirp.Canceled || te.Meta("conversation_id") != id.Hex() {
+			t.Fatalf("expected a canceled error with the conversation ID, got %v", te)
+		}
+
+		described, err := server.DescribeConversation(ctx, &pb.DescribeConversationRequest{ConversationId: id.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		messages := described.GetConversation().GetMessages()
+		if len(messages) != 2 || !messages[1].GetCancelled() {
+			t.Errorf("expected the question and a cancelled answer, got %v", messages)
+		}
+	}))
+
+	t.Run("only cancels replies to the conversation", func(t *testing.T) {
+		var g generations
+		a, b := primitive.NewObjectID(), primitive.NewObjectID()
+
+		ctxA, doneA := g.start(ctx, a)
+		ctxB, doneB := g.start(ctx, b)
+		defer doneB()
+
+		if n := g.cancel(a); n != 1 || ctxA.Err() == nil || ctxB.Err() != nil {
+			t.Errorf("expected only the reply to a to be cancelled, got %d", n)
+		}
+
+		doneA()
+		if n := g.cancel(a); n != 0 {
+			t.Errorf("expected finished replies to be forgotten, got %d", n)
+		}
+	})
+
+	t.Run("validates the conversation ID", func(t *testing.T) {
+		server := NewServer(nil, &MockAssistant{})
+
+		for _, id := range []string{"", "not-an-id"} {
+			_, err := server.CancelReply(ctx, &pb.CancelReplyRequest{ConversationId: id})
+			if te, ok := err.(twirp.Error); !ok || te.Code() != twirp.InvalidArgument {
+				t.Errorf("%q: expected invalid argument, got %v", id, err)
+			}
+		}
+	})
+}
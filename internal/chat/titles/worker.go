@@ -0,0 +1,202 @@
+package titles
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kind identifies title jobs in the job queue
+const Kind = "title"
+
+const (
+	// maxAttempts is the number of times a title is tried before the job is dead-lettered
+	maxAttempts = 5
+
+	// driftMessages is the number of user messages after which a title is reviewed for topic drift
+	driftMessages = 5
+
+	// sweepBatch bounds the untitled conversations queued by a sweep, and those loaded at once
+	sweepBatch = 50
+)
+
+// Store loads conversations and saves their titles
+type Store interface {
+	DescribeConversation(ctx context.Context, id string) (*model.Conversation, error)
+	SetTitle(ctx context.Context, id primitive.ObjectID, title string, titled int, usage *model.Usage) error
+	UntitledConversations(ctx context.Context, before time.Time, after primitive.ObjectID, limit int64) ([]primitive.ObjectID, error)
+}
+
+// Titler generates titles for conversations, Retitle reviews an existing title
+type Titler interface {
+	Title(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error)
+	Retitle(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error)
+}
+
+// Quota records the tokens each client used per day
+type Quota interface {
+	AddDailyUsage(ctx context.Context, client string, t time.Time, usage *model.Usage) error
+}
+
+type payload struct {
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+
+	// Client is charged for the title, it is empty for conversations queued by the sweep
+	Client string `bson:"client,omitempty"`
+}
+
+// Worker generates conversation titles as jobs of the job queue, which retries them with backoff.
+// A periodic sweep queues conversations that are still untitled, e.g. because their job was
+// dead-lettered.
+type Worker struct {
+	store  Store
+	titler Titler
+	queue  jobs.Enqueuer
+	quota  Quota
+
+	sweepInterval time.Duration
+	sweepAge      time.Duration
+}
+
+// Option configures optional behaviour of the worker
+type Option func(*Worker)
+
+// WithQuota charges the tokens used for a title to the client whose request queued it
+func WithQuota(quota Quota) Option {
+	return func(w *Worker) {
+		w.quota = quota
+	}
+}
+
+func NewWorker(store Store, titler Titler, queue jobs.Enqueuer, opts ...Option) *Worker {
+	w := &Worker{
+		store:         store,
+		titler:        titler,
+		queue:         queue,
+		sweepInterval: 5 * time.Minute,
+		sweepAge:      time.Minute,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Enqueue queues the title of a conversation to be generated, or reviewed if it already has one,
+// for the client of the context. It does nothing if the conversation is already queued.
+func (w *Worker) Enqueue(ctx context.Context, id primitive.ObjectID) {
+	w.enqueue(ctx, id, payload{ConversationID: id, Client: httpx.ClientFromContext(ctx)})
+}
+
+// enqueue reports whether the title is queued, it is not when queuing fails or is skipped
+func (w *Worker) enqueue(ctx context.Context, id primitive.ObjectID, p payload, opts ...jobs.EnqueueOption) bool {
+	opts = append(opts, jobs.WithKey(id.Hex()), jobs.WithMaxAttempts(maxAttempts))
+	jobID, err := w.queue.Enqueue(ctx, Kind, p, opts...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to queue conversation title", "conversation_id", id.Hex(), "error", err)
+		return false
+	}
+	return !jobID.IsZero()
+}
+
+// Refresh queues the title of a conversation that is untitled or had enough new user messages since
+// its title was generated for the topic to have drifted
+func (w *Worker) Refresh(ctx context.Context, conv *model.Conversation) {
+	if conv.Title == model.UntitledConversation || conv.UserMessages()-conv.TitledMessages >= driftMessages {
+		w.Enqueue(ctx, conv.ID)
+	}
+}
+
+// Handle runs a title job, register it with the job queue for Kind
+func (w *Worker) Handle(ctx context.Context, job *jobs.Job) error {
+	var p payload
+	if err := job.Decode(&p); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	conv, err := w.store.DescribeConversation(ctx, p.ConversationID.Hex())
+	if te, ok := err.(twirp.Error); ok && te.Code() == twirp.NotFound {
+		return jobs.Permanent(err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	generate := w.titler.Retitle
+	if conv.Title == model.UntitledConversation {
+		generate = w.titler.Title
+	}
+
+	title, usage, err := generate(ctx, conv)
+	if err != nil {
+		return err
+	}
+
+	if title != conv.Title {
+		slog.InfoContext(ctx, "Conversation titled", "conversation_id", conv.ID.Hex(), "title", title)
+	}
+
+	if err := w.store.SetTitle(ctx, conv.ID, title, conv.UserMessages(), usage); err != nil {
+		return err
+	}
+
+	// The title is saved, so failing to record its usage is logged rather than retried
+	if w.quota != nil && p.Client != "" && usage.Tokens() > 0 {
+		if err := w.quota.AddDailyUsage(ctx, p.Client, time.Now(), usage); err != nil {
+			slog.ErrorContext(ctx, "Failed to record quota usage", "conversation_id", conv.ID.Hex(), "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Run sweeps for untitled conversations until the context is done, starting right away so
+// conversations left untitled by a previous run are picked up
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		w.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep queues conversations that are still untitled a while after their last update. Conversations
+// whose title job was dead-lettered are skipped, so a title that keeps failing is not retried forever;
+// their next message queues it again. The sweep pages past them until it queued sweepBatch titles.
+func (w *Worker) sweep(ctx context.Context) {
+	before := time.Now().Add(-w.sweepAge)
+
+	queued := 0
+	for after := primitive.NilObjectID; queued < sweepBatch; {
+		ids, err := w.store.UntitledConversations(ctx, before, after, sweepBatch)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to find untitled conversations", "error", err)
+			return
+		}
+
+		for _, id := range ids {
+			if w.enqueue(ctx, id, payload{ConversationID: id}, jobs.SkipDead()) {
+				queued++
+			}
+		}
+
+		if len(ids) < sweepBatch {
+			return
+		}
+		after = ids[len(ids)-1]
+	}
+}
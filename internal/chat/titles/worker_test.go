@@ -0,0 +1,299 @@
+package titles
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	convs    map[primitive.ObjectID]*model.Conversation
+	untitled []primitive.ObjectID
+}
+
+func newFakeStore(convs ...*model.Conversation) *fakeStore {
+	s := &fakeStore{convs: map[primitive.ObjectID]*model.Conversation{}}
+	for _, c := range convs {
+		s.convs[c.ID] = c
+	}
+	return s
+}
+
+func (s *fakeStore) DescribeConversation(ctx context.Context, id string) (*model.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oid, _ := primitive.ObjectIDFromHex(id)
+	c, ok := s.convs[oid]
+	if !ok {
+		return nil, twirp.NotFoundError("conversation not found")
+	}
+
+	copied := *c
+	return &copied, nil
+}
+
+func (s *fakeStore) SetTitle(ctx context.Context, id primitive.ObjectID, title string, titled int, usage *model.Usage) error {
+	s.mu.Lock()
+	s.convs[id].Title = title
+	s.convs[id].TitledMessages = titled
+	s.convs[id].TitleUsage.Add(usage)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *fakeStore) UntitledConversations(ctx context.Context, before time.Time, after primitive.ObjectID, limit int64) ([]primitive.ObjectID, error) {
+	var ids []primitive.ObjectID
+	for _, id := range s.untitled {
+		if id.Hex() > after.Hex() && int64(len(ids)) < limit {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *fakeStore) conversation(id primitive.ObjectID) model.Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.convs[id]
+}
+
+// fakeTitler fails as many calls as failures before it returns titles
+type fakeTitler struct {
+	mu       sync.Mutex
+	failures int
+	calls    []string
+}
+
+func (t *fakeTitler) Title(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error) {
+	return t.generate("title", "Weather in Barcelona")
+}
+
+func (t *fakeTitler) Retitle(ctx context.Context, conv *model.Conversation) (string, *model.Usage, error) {
+	return t.generate("retitle", "Hotels in Lisbon")
+}
+
+func (t *fakeTitler) generate(call, title string) (string, *model.Usage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.calls = append(t.calls, call)
+	if len(t.calls) <= t.failures {
+		return "", nil, errors.New("model unavailable")
+	}
+
+	return title, &model.Usage{PromptTokens: 10, CompletionTokens: 2}, nil
+}
+
+func conversation(title string, userMessages int) *model.Conversation {
+	c := &model.Conversation{ID: primitive.NewObjectID(), Title: title}
+	for i := 0; i < userMessages; i++ {
+		c.Append(&model.Message{ID: primitive.NewObjectID(), Role: model.RoleUser, Content: "Hi"})
+		c.Append(&model.Message{ID: primitive.NewObjectID(), Role: model.RoleAssistant, Content: "Hello"})
+	}
+	return c
+}
+
+// fakeQuota records the tokens charged to each client
+type fakeQuota map[string]int64
+
+func (q fakeQuota) AddDailyUsage(ctx context.Context, client string, t time.Time, usage *model.Usage) error {
+	q[client] += usage.Tokens()
+	return nil
+}
+
+// fakeQueue records enqueued jobs, deduplicated by key like the job queue, and skips the keys in dead
+// for SkipDead
+type fakeQueue struct {
+	jobs []*jobs.Job
+	dead map[string]bool
+}
+
+func (q *fakeQueue) Enqueue(ctx context.Context, kind string, p any, opts ...jobs.EnqueueOption) (primitive.ObjectID, error) {
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	job := &jobs.Job{ID: primitive.NewObjectID(), Kind: kind, Payload: raw}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	if job.SkipDead && q.dead[job.Key] {
+		return primitive.NilObjectID, nil
+	}
+
+	for _, j := range q.jobs {
+		if j.Key == job.Key {
+			return j.ID, nil
+		}
+	}
+
+	q.jobs = append(q.jobs, job)
+	return job.ID, nil
+}
+
+func TestWorker_Handle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("titles untitled conversations", func(t *testing.T) {
+		conv := conversation(model.UntitledConversation, 1)
+		store := newFakeStore(conv)
+		queue := &fakeQueue{}
+		w := NewWorker(store, &fakeTitler{}, queue)
+
+		w.Enqueue(ctx, conv.ID)
+		if len(queue.jobs) != 1 || queue.jobs[0].Kind != Kind || queue.jobs[0].MaxAttempts != maxAttempts {
+			t.Fatalf("expected a title job, got %v", queue.jobs)
+		}
+
+		if err := w.Handle(ctx, queue.jobs[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		saved := store.conversation(conv.ID)
+		if saved.Title != "Weather in Barcelona" || saved.TitledMessages != 1 {
+			t.Errorf("unexpected title %q from %d messages", saved.Title, saved.TitledMessages)
+		}
+
+		if saved.TitleUsage.PromptTokens != 10 {
+			t.Errorf("expected the title usage to be recorded, got %+v", saved.TitleUsage)
+		}
+	})
+
+	t.Run("charges the title to the client that queued it", func(t *testing.T) {
+		conv := conversation(model.UntitledConversation, 1)
+		queue := &fakeQueue{}
+		quota := fakeQuota{}
+		w := NewWorker(newFakeStore(conv), &fakeTitler{}, queue, WithQuota(quota))
+
+		w.Enqueue(httpx.WithClient(ctx, "key:alice"), conv.ID)
+		if err := w.Handle(ctx, queue.jobs[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if quota["key:alice"] != 12 || len(quota) != 1 {
+			t.Errorf("expected 12 tokens charged to the client, got %v", quota)
+		}
+	})
+
+	t.Run("reviews existing titles", func(t *testing.T) {
+		conv := conversation("Weather in Barcelona", 6)
+		store := newFakeStore(conv)
+		queue := &fakeQueue{}
+		titler := &fakeTitler{}
+		w := NewWorker(store, titler, queue)
+
+		w.Enqueue(ctx, conv.ID)
+		if err := w.Handle(ctx, queue.jobs[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := store.conversation(conv.ID).Title; got != "Hotels in Lisbon" || titler.calls[0] != "retitle" {
+			t.Errorf("expected the title to be reviewed, got %q after %v", got, titler.calls)
+		}
+	})
+
+	t.Run("retries failures unless the conversation is gone", func(t *testing.T) {
+		conv := conversation(model.UntitledConversation, 1)
+		queue := &fakeQueue{}
+		w := NewWorker(newFakeStore(conv), &fakeTitler{failures: 1}, queue)
+
+		w.Enqueue(ctx, conv.ID)
+		w.Enqueue(ctx, primitive.NewObjectID())
+
+		if err := w.Handle(ctx, queue.jobs[0]); err == nil || jobs.IsPermanent(err) {
+			t.Errorf("expected a retryable error, got %v", err)
+		}
+
+		if err := w.Handle(ctx, queue.jobs[1]); !jobs.IsPermanent(err) {
+			t.Errorf("expected a permanent error, got %v", err)
+		}
+	})
+
+	t.Run("sweeps untitled conversations", func(t *testing.T) {
+		conv := conversation(model.UntitledConversation, 1)
+		store := newFakeStore(conv)
+		store.untitled = []primitive.ObjectID{conv.ID}
+		queue := &fakeQueue{}
+
+		NewWorker(store, &fakeTitler{}, queue).sweep(ctx)
+
+		if len(queue.jobs) != 1 || queue.jobs[0].Key != conv.ID.Hex() {
+			t.Errorf("expected conversation %s to be queued, got %v", conv.ID.Hex(), queue.jobs)
+		}
+	})
+
+	t.Run("does not sweep conversations whose title job is dead", func(t *testing.T) {
+		conv := conversation(model.UntitledConversation, 1)
+		store := newFakeStore(conv)
+		store.untitled = []primitive.ObjectID{conv.ID}
+		queue := &fakeQueue{dead: map[string]bool{conv.ID.Hex(): true}}
+		w := NewWorker(store, &fakeTitler{}, queue)
+
+		w.sweep(ctx)
+		if len(queue.jobs) != 0 {
+			t.Fatalf("expected the sweep to skip the conversation, got %v", queue.jobs)
+		}
+
+		// A new message still queues it
+		w.Refresh(ctx, conv)
+		if len(queue.jobs) != 1 {
+			t.Errorf("expected the conversation to be queued on refresh, got %v", queue.jobs)
+		}
+	})
+
+	t.Run("sweeps past conversations whose title job is dead", func(t *testing.T) {
+		store := newFakeStore()
+		queue := &fakeQueue{dead: map[string]bool{}}
+		for i := 0; i < sweepBatch+10; i++ {
+			id := primitive.NewObjectID()
+			store.untitled = append(store.untitled, id)
+			queue.dead[id.Hex()] = true
+		}
+
+		fresh := primitive.NewObjectID()
+		store.untitled = append(store.untitled, fresh)
+
+		NewWorker(store, &fakeTitler{}, queue).sweep(ctx)
+
+		if len(queue.jobs) != 1 || queue.jobs[0].Key != fresh.Hex() {
+			t.Errorf("expected conversation %s to be queued, got %v", fresh.Hex(), queue.jobs)
+		}
+	})
+}
+
+func TestWorker_Refresh(t *testing.T) {
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		conv *model.Conversation
+		want bool
+	}{
+		"untitled":      {conv: conversation(model.UntitledConversation, 1), want: true},
+		"recent title":  {conv: conversation("Weather in Barcelona", 3), want: false},
+		"drifted title": {conv: conversation("Weather in Barcelona", 5), want: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			queue := &fakeQueue{}
+			NewWorker(newFakeStore(), &fakeTitler{}, queue).Refresh(ctx, tc.conv)
+
+			if got := len(queue.jobs) == 1; got != tc.want {
+				t.Errorf("expected queued to be %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
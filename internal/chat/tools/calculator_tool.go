@@ -28,9 +28,10 @@ func (t *CalculatorTool) Definition() openai.ChatCompletionToolUnionParam {
 		Parameters: openai.FunctionParameters{
 			"type": "object",
 			"properties": map[string]any{
-				"expression": map[string]string{
+				"expression": map[string]any{
 					"type":        "string",
 					"description": "Mathematical expression to evaluate (e.g., '2 + 2', '(10 * 5) / 2', 'sqrt(16)')",
+					"minLength":   1,
 				},
 			},
 			"required": []string{"expression"},
@@ -0,0 +1,26 @@
+package tools
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCalculatorTool_Validation(t *testing.T) {
+	assertValidation(t, NewCalculatorTool(), []validationCase{
+		{name: "valid expression", arguments: `{"expression":"(10 * 5) / 2"}`},
+		{name: "missing expression", arguments: `{}`, errors: []string{"expression: is required"}},
+		{name: "empty expression", arguments: `{"expression":""}`, errors: []string{"expression: must not be empty"}},
+		{name: "non-string expression", arguments: `{"expression":4}`, errors: []string{"expression: must be of type string"}},
+	})
+}
+
+func TestCalculatorTool_Execute(t *testing.T) {
+	out, err := NewCalculatorTool().Execute(context.Background(), `{"expression":"(10 * 5) / 2"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out != "25" {
+		t.Errorf("expected 25, got %q", out)
+	}
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config declares tools that are provided outside of this repository and which tools are disabled
+type Config struct {
+	External   []ExternalToolConfig `json:"external,omitempty"`
+	MCPServers []MCPServerConfig    `json:"mcp_servers,omitempty"`
+
+	// Disabled lists tools, built-in or not, that must not be offered to the model
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+// LoadConfig reads a JSON tools configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tools config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tools config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// Tools builds the tools declared in the configuration
+func (c *Config) Tools() ([]Tool, error) {
+	tools := make([]Tool, 0, len(c.External))
+	for _, ext := range c.External {
+		tool, err := NewExternalTool(ext)
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, tool)
+	}
+	return tools, nil
+}
+
+// Duration is a time.Duration that is written as a string (e.g. "5s") in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
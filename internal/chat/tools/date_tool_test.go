@@ -0,0 +1,26 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDateTool_Validation(t *testing.T) {
+	assertValidation(t, NewDateTool(), []validationCase{
+		{name: "no arguments", arguments: ``},
+		{name: "empty object", arguments: `{}`},
+		{name: "not an object", arguments: `"today"`, errors: []string{"must be of type object"}},
+	})
+}
+
+func TestDateTool_Execute(t *testing.T) {
+	out, err := NewDateTool().Execute(context.Background(), `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := time.Parse(time.RFC3339, out); err != nil {
+		t.Errorf("expected RFC3339 date, got %q", out)
+	}
+}
@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+)
+
+const (
+	defaultExternalTimeout   = 10 * time.Second
+	defaultExternalMaxOutput = 64 << 10
+)
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ExternalToolConfig declares a tool implemented by an executable or an HTTP webhook.
+// Arguments are passed as JSON on stdin (executables) or as the POST body (webhooks),
+// and whatever the tool writes to stdout or responds with is returned to the model.
+type ExternalToolConfig struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+
+	// Command is the executable and its arguments, mutually exclusive with URL
+	Command []string `json:"command,omitempty"`
+
+	// URL is the webhook endpoint, mutually exclusive with Command
+	URL string `json:"url,omitempty"`
+
+	// Headers are sent with webhook requests, values are expanded from the environment
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Timeout limits a single invocation, defaults to 10s
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// MaxOutputBytes limits the size of the result, defaults to 64KiB
+	MaxOutputBytes int64 `json:"max_output_bytes,omitempty"`
+
+	// Risk is "low" (default), "medium" or "high", calls to medium and high risk tools need the user's approval
+	Risk Risk `json:"risk,omitempty"`
+}
+
+// ExternalTool invokes a tool that lives outside of this process
+type ExternalTool struct {
+	cfg    ExternalToolConfig
+	client *http.Client
+}
+
+// NewExternalTool validates the configuration and creates an external tool
+func NewExternalTool(cfg ExternalToolConfig) (*ExternalTool, error) {
+	if !toolNamePattern.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("external tool %q: name must match %s", cfg.Name, toolNamePattern)
+	}
+
+	if (len(cfg.Command) == 0) == (cfg.URL == "") {
+		return nil, fmt.Errorf("external tool %q: exactly one of command or url must be set", cfg.Name)
+	}
+
+	if cfg.Parameters == nil {
+		cfg.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = Duration(defaultExternalTimeout)
+	}
+
+	if cfg.MaxOutputBytes <= 0 {
+		cfg.MaxOutputBytes = defaultExternalMaxOutput
+	}
+
+	return &ExternalTool{cfg: cfg, client: &http.Client{}}, nil
+}
+
+func (t *ExternalTool) Name() string {
+	return t.cfg.Name
+}
+
+func (t *ExternalTool) Risk() Risk {
+	return t.cfg.Risk
+}
+
+func (t *ExternalTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String(t.cfg.Description),
+		Parameters:  openai.FunctionParameters(t.cfg.Parameters),
+	})
+}
+
+func (t *ExternalTool) Execute(ctx context.Context, arguments string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t.cfg.Timeout))
+	defer cancel()
+
+	if len(t.cfg.Command) > 0 {
+		return t.run(ctx, arguments)
+	}
+
+	return t.post(ctx, arguments)
+}
+
+// run executes the configured command with arguments on stdin
+func (t *ExternalTool) run(ctx context.Context, arguments string) (string, error) {
+	stdout := &limitedBuffer{limit: t.cfg.MaxOutputBytes}
+	stderr := &limitedBuffer{limit: 4 << 10}
+
+	cmd := exec.CommandContext(ctx, t.cfg.Command[0], t.cfg.Command[1:]...)
+	cmd.Stdin = strings.NewReader(arguments)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("tool timed out after %s", time.Duration(t.cfg.Timeout))
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	if stdout.overflow {
+		return "", fmt.Errorf("tool output exceeds %d bytes", t.cfg.MaxOutputBytes)
+	}
+
+	return stdout.String(), nil
+}
+
+// post sends arguments to the configured webhook
+func (t *ExternalTool) post(ctx context.Context, arguments string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, strings.NewReader(arguments))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("tool timed out after %s", time.Duration(t.cfg.Timeout))
+		}
+		return "", fmt.Errorf("failed to call tool: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.cfg.MaxOutputBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("tool returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if int64(len(body)) > t.cfg.MaxOutputBytes {
+		return "", fmt.Errorf("tool output exceeds %d bytes", t.cfg.MaxOutputBytes)
+	}
+
+	return string(body), nil
+}
+
+// limitedBuffer keeps at most limit bytes and remembers whether anything was dropped
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(b.buf.Len()); int64(len(p)) > room {
+		b.overflow = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExternalTool_Command(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passes arguments on stdin", func(t *testing.T) {
+		tool, err := NewExternalTool(ExternalToolConfig{Name: "echo", Command: []string{"cat"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		out, err := tool.Execute(ctx, `{"city":"Lisbon"}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out != `{"city":"Lisbon"}` {
+			t.Errorf("expected arguments to be echoed back, got %q", out)
+		}
+	})
+
+	t.Run("fails when the command exits with an error", func(t *testing.T) {
+		tool, _ := NewExternalTool(ExternalToolConfig{Name: "fail", Command: []string{"sh", "-c", "echo boom >&2; exit 3"}})
+
+		_, err := tool.Execute(ctx, `{}`)
+		if err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("expected error containing stderr, got %v", err)
+		}
+	})
+
+	t.Run("enforces timeout", func(t *testing.T) {
+		tool, _ := NewExternalTool(ExternalToolConfig{
+			Name:    "slow",
+			Command: []string{"sleep", "5"},
+			Timeout: Duration(100 * time.Millisecond),
+		})
+
+		start := time.Now()
+		_, err := tool.Execute(ctx, `{}`)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+
+		if time.Since(start) > 3*time.Second {
+			t.Errorf("tool was not stopped on timeout")
+		}
+	})
+
+	t.Run("enforces output limit", func(t *testing.T) {
+		tool, _ := NewExternalTool(ExternalToolConfig{
+			Name:           "chatty",
+			Command:        []string{"sh", "-c", "head -c 100 /dev/zero"},
+			MaxOutputBytes: 10,
+		})
+
+		_, err := tool.Execute(ctx, `{}`)
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Fatalf("expected output limit error, got %v", err)
+		}
+	})
+}
+
+func TestExternalTool_Webhook(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("posts arguments and returns the response body", func(t *testing.T) {
+		t.Setenv("TEST_TOOL_TOKEN", "secret")
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+				t.Errorf("expected expanded authorization header, got %q", got)
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write([]byte("received " + string(body)))
+		}))
+		defer srv.Close()
+
+		tool, err := NewExternalTool(ExternalToolConfig{
+			Name:    "hook",
+			URL:     srv.URL,
+			Headers: map[string]string{"Authorization": "Bearer ${TEST_TOOL_TOKEN}"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		out, err := tool.Execute(ctx, `{"a":1}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out != `received {"a":1}` {
+			t.Errorf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("fails on non-2xx status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		tool, _ := NewExternalTool(ExternalToolConfig{Name: "hook", URL: srv.URL})
+
+		_, err := tool.Execute(ctx, `{}`)
+		if err == nil || !strings.Contains(err.Error(), "502") {
+			t.Fatalf("expected status error, got %v", err)
+		}
+	})
+}
+
+func TestNewExternalTool_Validation(t *testing.T) {
+	for name, cfg := range map[string]ExternalToolConfig{
+		"invalid name":         {Name: "has spaces", Command: []string{"cat"}},
+		"no command nor url":   {Name: "empty"},
+		"both command and url": {Name: "both", Command: []string{"cat"}, URL: "http://localhost"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewExternalTool(cfg); err == nil {
+				t.Error("expected validation error, got nil")
+			}
+		})
+	}
+}
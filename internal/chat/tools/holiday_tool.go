@@ -3,7 +3,6 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"os"
 	"strings"
 	"time"
 
@@ -12,12 +11,17 @@ import (
 	"github.com/openai/openai-go/v2"
 )
 
+// DefaultHolidayCalendarLink is the calendar of the holidays in Catalonia
+const DefaultHolidayCalendarLink = "https://www.officeholidays.com/ics/spain/catalonia"
+
 // HolidayTool provides holiday information
-type HolidayTool struct{}
+type HolidayTool struct {
+	link string
+}
 
-// NewHolidayTool creates a new holiday tool
-func NewHolidayTool() *HolidayTool {
-	return &HolidayTool{}
+// NewHolidayTool creates a new holiday tool reading the holidays from the iCalendar feed at link
+func NewHolidayTool(link string) *HolidayTool {
+	return &HolidayTool{link: link}
 }
 
 func (t *HolidayTool) Name() string {
@@ -33,15 +37,18 @@ func (t *HolidayTool) Definition() openai.ChatCompletionToolUnionParam {
 			"properties": map[string]any{
 				"before_date": map[string]string{
 					"type":        "string",
-					"description": "Optional date in RFC3339 format to get holidays before this date. If not provided, all holidays will be returned.",
+					"format":      "date-time",
+					"description": "Optional date in RFC3339 format (e.g. 2025-12-31T00:00:00Z) to get holidays before this date. If not provided, all holidays will be returned.",
 				},
 				"after_date": map[string]string{
 					"type":        "string",
-					"description": "Optional date in RFC3339 format to get holidays after this date. If not provided, all holidays will be returned.",
+					"format":      "date-time",
+					"description": "Optional date in RFC3339 format (e.g. 2025-01-01T00:00:00Z) to get holidays after this date. If not provided, all holidays will be returned.",
 				},
-				"max_count": map[string]string{
+				"max_count": map[string]any{
 					"type":        "integer",
 					"description": "Optional maximum number of holidays to return. If not provided, all holidays will be returned.",
+					"minimum":     1,
 				},
 			},
 		},
@@ -49,13 +56,8 @@ func (t *HolidayTool) Definition() openai.ChatCompletionToolUnionParam {
 }
 
 func (t *HolidayTool) Execute(ctx context.Context, arguments string) (string, error) {
-	link := "https://www.officeholidays.com/ics/spain/catalonia"
-	if v := os.Getenv("HOLIDAY_CALENDAR_LINK"); v != "" {
-		link = v
-	}
-
 	// Use calendarclient package
-	events, err := calendarclient.LoadCalendar(ctx, link)
+	events, err := calendarclient.LoadCalendar(ctx, t.link)
 	if err != nil {
 		return "failed to load holiday events", nil
 	}
@@ -94,3 +96,9 @@ func (t *HolidayTool) Execute(ctx context.Context, arguments string) (string, er
 
 	return strings.Join(holidays, "\n"), nil
 }
+
+// Ping checks the calendar can be loaded
+func (t *HolidayTool) Ping(ctx context.Context) error {
+	_, err := calendarclient.LoadCalendar(ctx, t.link)
+	return err
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//EN
+BEGIN:VEVENT
+UID:1
+DTSTART;VALUE=DATE:20250101
+SUMMARY:New Year's Day
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+DTSTART;VALUE=DATE:20250623
+SUMMARY:Sant Joan
+END:VEVENT
+BEGIN:VEVENT
+UID:3
+DTSTART;VALUE=DATE:20251225
+SUMMARY:Christmas Day
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestHolidayTool_Validation(t *testing.T) {
+	assertValidation(t, NewHolidayTool(DefaultHolidayCalendarLink), []validationCase{
+		{name: "no filters", arguments: `{}`},
+		{name: "all filters", arguments: `{"after_date":"2025-01-01T00:00:00Z","before_date":"2025-12-31T00:00:00+01:00","max_count":3}`},
+		{name: "date without time", arguments: `{"after_date":"2025-01-01"}`, errors: []string{"after_date: must be an RFC3339 date-time"}},
+		{name: "free-form date", arguments: `{"before_date":"next friday"}`, errors: []string{"before_date: must be an RFC3339 date-time"}},
+		{name: "zero max count", arguments: `{"max_count":0}`, errors: []string{"max_count: must be greater than or equal to 1"}},
+		{name: "string max count", arguments: `{"max_count":"3"}`, errors: []string{"max_count: must be of type integer"}},
+	})
+}
+
+func TestHolidayTool_Execute(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte(testCalendar))
+	}))
+	defer srv.Close()
+
+	out, err := NewHolidayTool(srv.URL).Execute(context.Background(), `{"after_date":"2025-02-01T00:00:00Z"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "2025-06-23: Sant Joan\n2025-12-25: Christmas Day"
+	if out != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+}
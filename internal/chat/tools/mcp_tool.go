@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/mcp"
+	"github.com/openai/openai-go/v2"
+)
+
+const defaultMCPTimeout = 30 * time.Second
+
+// MCPServerConfig declares a Model Context Protocol server whose tools are exposed to the assistant
+type MCPServerConfig struct {
+	// Name identifies the server, tools are registered as "<name>__<tool>"
+	Name string `json:"name"`
+
+	// Command starts a server speaking the stdio transport, mutually exclusive with URL
+	Command []string          `json:"command,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+
+	// URL points to a server speaking the streamable HTTP transport, mutually exclusive with Command
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Allow lists the server tools exposed to the assistant, use "*" to expose all of them
+	Allow []string `json:"allow"`
+
+	// Timeout limits a single tool call, defaults to 30s
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Risk applies to all tools of the server, see ExternalToolConfig.Risk
+	Risk Risk `json:"risk,omitempty"`
+}
+
+func (c MCPServerConfig) allows(tool string) bool {
+	return slices.Contains(c.Allow, "*") || slices.Contains(c.Allow, tool)
+}
+
+// ConnectMCPServer starts a session with the server and returns its allowed tools.
+// The returned client must be closed when the tools are no longer used.
+func ConnectMCPServer(ctx context.Context, cfg MCPServerConfig) (*mcp.Client, []Tool, error) {
+	if !toolNamePattern.MatchString(cfg.Name) {
+		return nil, nil, fmt.Errorf("mcp server %q: name must match %s", cfg.Name, toolNamePattern)
+	}
+
+	if (len(cfg.Command) == 0) == (cfg.URL == "") {
+		return nil, nil, fmt.Errorf("mcp server %q: exactly one of command or url must be set", cfg.Name)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = Duration(defaultMCPTimeout)
+	}
+
+	var client *mcp.Client
+	if len(cfg.Command) > 0 {
+		var err error
+		if client, err = mcp.NewStdioClient(cfg.Command, cfg.Env); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		client = mcp.NewHTTPClient(cfg.URL, cfg.Headers)
+	}
+
+	if err := client.Initialize(ctx); err != nil {
+		_ = client.Close()
+		return nil, nil, fmt.Errorf("mcp server %q: %w", cfg.Name, err)
+	}
+
+	remote, err := client.ListTools(ctx)
+	if err != nil {
+		_ = client.Close()
+		return nil, nil, fmt.Errorf("mcp server %q: failed to list tools: %w", cfg.Name, err)
+	}
+
+	var tools []Tool
+	for _, t := range remote {
+		if !cfg.allows(t.Name) {
+			slog.DebugContext(ctx, "Skipping MCP tool not in allowlist", "server", cfg.Name, "tool", t.Name)
+			continue
+		}
+
+		name := cfg.Name + "__" + t.Name
+		if !toolNamePattern.MatchString(name) {
+			slog.WarnContext(ctx, "Skipping MCP tool with unsupported name", "server", cfg.Name, "tool", t.Name)
+			continue
+		}
+
+		tools = append(tools, &MCPTool{
+			client:  client,
+			name:    name,
+			remote:  t,
+			timeout: time.Duration(cfg.Timeout),
+			risk:    cfg.Risk,
+		})
+	}
+
+	slog.InfoContext(ctx, "Connected to MCP server", "server", cfg.Name, "tools", len(tools), "available", len(remote))
+	return client, tools, nil
+}
+
+// MCPTool is a tool provided by an MCP server
+type MCPTool struct {
+	client  *mcp.Client
+	name    string
+	remote  mcp.Tool
+	timeout time.Duration
+	risk    Risk
+}
+
+func (t *MCPTool) Name() string {
+	return t.name
+}
+
+func (t *MCPTool) Risk() Risk {
+	return t.risk
+}
+
+func (t *MCPTool) Definition() openai.ChatCompletionToolUnionParam {
+	schema := t.remote.InputSchema
+	if schema == nil {
+		schema = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String(t.remote.Description),
+		Parameters:  openai.FunctionParameters(schema),
+	})
+}
+
+func (t *MCPTool) Execute(ctx context.Context, arguments string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+
+	result, err := t.client.CallTool(ctx, t.remote.Name, json.RawMessage(arguments))
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, c := range result.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Resource != nil && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content omitted]", c.Type))
+		}
+	}
+
+	out := strings.Join(parts, "\n")
+	if result.IsError {
+		return "", errors.New(out)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/mcp"
+)
+
+// newMCPServer serves the tools upper, lower and a tool whose name cannot be registered
+func newMCPServer(t *testing.T) string {
+	srv := mcp.NewServer("test", "0.0.1")
+	for _, tool := range []struct {
+		name    string
+		convert func(string) string
+	}{{"upper", strings.ToUpper}, {"lower", strings.ToLower}} {
+		srv.AddTool(mcp.Tool{Name: tool.name}, func(ctx context.Context, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+			var args struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return nil, err
+			}
+			return &mcp.CallToolResult{Content: []mcp.Content{mcp.TextContent(tool.convert(args.Text))}}, nil
+		})
+	}
+	srv.AddTool(mcp.Tool{Name: "not allowed!"}, func(ctx context.Context, arguments json.RawMessage) (*mcp.CallToolResult, error) {
+		return &mcp.CallToolResult{}, nil
+	})
+
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	return httpSrv.URL
+}
+
+func TestConnectMCPServer(t *testing.T) {
+	ctx := context.Background()
+	url := newMCPServer(t)
+
+	connect := func(t *testing.T, allow ...string) []Tool {
+		t.Helper()
+
+		client, tools, err := ConnectMCPServer(ctx, MCPServerConfig{Name: "text", URL: url, Allow: allow})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+
+		return tools
+	}
+
+	names := func(tools []Tool) string {
+		var names []string
+		for _, tool := range tools {
+			names = append(names, tool.Name())
+		}
+		return strings.Join(names, ",")
+	}
+
+	t.Run("registers only the allowed tools, prefixed with the server name", func(t *testing.T) {
+		tools := connect(t, "upper")
+		if got := names(tools); got != "text__upper" {
+			t.Fatalf("expected only the allowed tool, got %q", got)
+		}
+
+		out, err := tools[0].Execute(ctx, `{"text":"porto"}`)
+		if err != nil || out != "PORTO" {
+			t.Errorf("expected the tool to be called on the server, got %q, %v", out, err)
+		}
+	})
+
+	t.Run("registers all tools with a wildcard", func(t *testing.T) {
+		// Tools whose prefixed name is not a valid tool name are still left out
+		if got := names(connect(t, "*")); got != "text__upper,text__lower" {
+			t.Errorf("expected all the tools with valid names, got %q", got)
+		}
+	})
+
+	t.Run("registers nothing without an allowlist", func(t *testing.T) {
+		if got := names(connect(t)); got != "" {
+			t.Errorf("expected no tools, got %q", got)
+		}
+	})
+
+	t.Run("rejects invalid server names", func(t *testing.T) {
+		if _, _, err := ConnectMCPServer(ctx, MCPServerConfig{Name: "bad name", URL: url, Allow: []string{"*"}}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
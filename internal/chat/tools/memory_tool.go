@@ -0,0 +1,196 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/openai/openai-go/v2"
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps facts about users across conversations, implemented by model.Repository
+type MemoryStore interface {
+	SaveMemory(ctx context.Context, m *model.Memory) error
+	ListMemories(ctx context.Context, user string, limit int64) ([]*model.Memory, error)
+	DeleteMemory(ctx context.Context, user, id string) error
+}
+
+// errNoUser is the result of memory tools called for a caller without an API key, whose memories would be
+// shared with everyone behind the same address
+const errNoUser = "memories are only kept for users identified by an API key"
+
+// SaveMemoryTool lets the assistant remember a fact about the user
+type SaveMemoryTool struct {
+	store MemoryStore
+}
+
+// NewSaveMemoryTool creates a new save memory tool
+func NewSaveMemoryTool(store MemoryStore) *SaveMemoryTool {
+	return &SaveMemoryTool{store: store}
+}
+
+func (t *SaveMemoryTool) Name() string {
+	return "save_memory"
+}
+
+func (t *SaveMemoryTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String("Remembers a lasting fact or preference about the user for future conversations, e.g. 'Is vegetarian' or 'Prefers aisle seats'. Only save what the user would expect to be remembered."),
+		Parameters: openai.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"fact": map[string]any{
+					"type":        "string",
+					"description": "The fact to remember, as a short self-contained sentence",
+					"minLength":   1,
+					"maxLength":   500,
+				},
+			},
+			"required": []string{"fact"},
+		},
+	})
+}
+
+func (t *SaveMemoryTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var payload struct {
+		Fact string `json:"fact"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &payload); err != nil {
+		return "failed to parse tool call arguments: " + err.Error(), nil
+	}
+
+	user, ok := httpx.UserFromContext(ctx)
+	if !ok {
+		return errNoUser, nil
+	}
+
+	err := t.store.SaveMemory(ctx, &model.Memory{
+		ID:        primitive.NewObjectID(),
+		User:      user,
+		Content:   strings.TrimSpace(payload.Fact),
+		CreatedAt: time.Now(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return "remembered", nil
+}
+
+// RecallMemoriesTool lists what the assistant remembers about the user
+type RecallMemoriesTool struct {
+	store MemoryStore
+}
+
+// NewRecallMemoriesTool creates a new recall memories tool
+func NewRecallMemoriesTool(store MemoryStore) *RecallMemoriesTool {
+	return &RecallMemoriesTool{store: store}
+}
+
+func (t *RecallMemoriesTool) Name() string {
+	return "recall_memories"
+}
+
+func (t *RecallMemoriesTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String("Lists every fact remembered about the user, newest first. Each line is a single fact in the format 'ID: fact'."),
+	})
+}
+
+func (t *RecallMemoriesTool) Execute(ctx context.Context, arguments string) (string, error) {
+	user, ok := httpx.UserFromContext(ctx)
+	if !ok {
+		return errNoUser, nil
+	}
+
+	memories, err := t.store.ListMemories(ctx, user, 0)
+	if err != nil {
+		return "", err
+	}
+
+	if len(memories) == 0 {
+		return "nothing is remembered about the user", nil
+	}
+
+	return FormatMemories(memories), nil
+}
+
+// ForgetMemoryTool lets the assistant forget a fact about the user
+type ForgetMemoryTool struct {
+	store MemoryStore
+}
+
+// NewForgetMemoryTool creates a new forget memory tool
+func NewForgetMemoryTool(store MemoryStore) *ForgetMemoryTool {
+	return &ForgetMemoryTool{store: store}
+}
+
+func (t *ForgetMemoryTool) Name() string {
+	return "forget_memory"
+}
+
+func (t *ForgetMemoryTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String("Forgets a fact remembered about the user, when the user asks for it or the fact is no longer true."),
+		Parameters: openai.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"memory_id": map[string]any{
+					"type":        "string",
+					"description": "ID of the fact, as listed by recall_memories",
+					"pattern":     "^[0-9a-f]{24}$",
+				},
+			},
+			"required": []string{"memory_id"},
+		},
+	})
+}
+
+func (t *ForgetMemoryTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var payload struct {
+		MemoryID string `json:"memory_id"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &payload); err != nil {
+		return "failed to parse tool call arguments: " + err.Error(), nil
+	}
+
+	user, ok := httpx.UserFromContext(ctx)
+	if !ok {
+		return errNoUser, nil
+	}
+
+	err := t.store.DeleteMemory(ctx, user, payload.MemoryID)
+
+	var te twirp.Error
+	switch {
+	case errors.As(err, &te) && te.Code() == twirp.NotFound:
+		return "no fact with this ID is remembered", nil
+	case err != nil:
+		return "", err
+	}
+
+	return "forgotten", nil
+}
+
+// FormatMemories renders memories one per line in the format 'ID: fact'
+func FormatMemories(memories []*model.Memory) string {
+	lines := make([]string, len(memories))
+	for i, m := range memories {
+		lines[i] = fmt.Sprintf("%s: %s", m.ID.Hex(), m.Content)
+	}
+
+	return strings.Join(lines, "\n")
+}
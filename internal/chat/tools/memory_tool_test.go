@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/twitchtv/twirp"
+)
+
+// memoryStore keeps memories in a slice, oldest first
+type memoryStore []*model.Memory
+
+func (s *memoryStore) SaveMemory(ctx context.Context, m *model.Memory) error {
+	*s = append(*s, m)
+	return nil
+}
+
+func (s *memoryStore) ListMemories(ctx context.Context, user string, limit int64) ([]*model.Memory, error) {
+	var out []*model.Memory
+	for _, m := range slices.Backward(*s) {
+		if m.User == user {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) DeleteMemory(ctx context.Context, user, id string) error {
+	for i, m := range *s {
+		if m.User == user && m.ID.Hex() == id {
+			*s = slices.Delete(*s, i, i+1)
+			return nil
+		}
+	}
+	return twirp.NotFoundError("memory not found")
+}
+
+func TestMemoryTools_Validation(t *testing.T) {
+	assertValidation(t, NewSaveMemoryTool(nil), []validationCase{
+		{name: "fact", arguments: `{"fact":"Is vegetarian"}`},
+		{name: "missing fact", arguments: `{}`, errors: []string{"is required"}},
+		{name: "empty fact", arguments: `{"fact":""}`, errors: []string{"must not be empty"}},
+	})
+
+	assertValidation(t, NewForgetMemoryTool(nil), []validationCase{
+		{name: "memory ID", arguments: `{"memory_id":"68a5aa7b14ba62ef8448c917"}`},
+		{name: "missing memory ID", arguments: `{}`, errors: []string{"is required"}},
+		{name: "invalid memory ID", arguments: `{"memory_id":"abc"}`, errors: []string{"memory_id"}},
+	})
+}
+
+func TestMemoryTools_Execute(t *testing.T) {
+	store := &memoryStore{}
+	alice := httpx.WithClient(context.Background(), "key:alice")
+	bob := httpx.WithClient(context.Background(), "key:bob")
+
+	for _, fact := range []string{"Is vegetarian", " Prefers aisle seats "} {
+		if out, err := NewSaveMemoryTool(store).Execute(alice, `{"fact":"`+fact+`"}`); err != nil || out != "remembered" {
+			t.Fatalf("unexpected result %q, %v", out, err)
+		}
+	}
+
+	out, err := NewRecallMemoriesTool(store).Execute(alice, `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := (*store)[1].ID.Hex() + ": Prefers aisle seats\n" + (*store)[0].ID.Hex() + ": Is vegetarian"
+	if out != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+
+	if out, _ := NewRecallMemoriesTool(store).Execute(bob, `{}`); out != "nothing is remembered about the user" {
+		t.Errorf("expected no memories for another user, got %q", out)
+	}
+
+	id := (*store)[0].ID.Hex()
+	if out, _ := NewForgetMemoryTool(store).Execute(bob, `{"memory_id":"`+id+`"}`); out != "no fact with this ID is remembered" {
+		t.Errorf("expected memories of another user to be kept, got %q", out)
+	}
+
+	if out, err := NewForgetMemoryTool(store).Execute(alice, `{"memory_id":"`+id+`"}`); err != nil || out != "forgotten" {
+		t.Fatalf("unexpected result %q, %v", out, err)
+	}
+
+	if out, _ := NewRecallMemoriesTool(store).Execute(alice, `{}`); strings.Contains(out, "vegetarian") {
+		t.Errorf("expected the fact to be forgotten, got %q", out)
+	}
+
+	// Callers identified by IP address share it with everyone behind the same proxy
+	anonymous := httpx.WithClient(context.Background(), "ip:10.0.0.1")
+	if out, _ := NewSaveMemoryTool(store).Execute(anonymous, `{"fact":"Is vegetarian"}`); out != errNoUser {
+		t.Errorf("expected memories to need an API key, got %q", out)
+	}
+
+	if out, _ := NewRecallMemoriesTool(store).Execute(anonymous, `{}`); out != errNoUser {
+		t.Errorf("expected memories to need an API key, got %q", out)
+	}
+}
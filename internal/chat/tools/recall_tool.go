@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
+	"github.com/openai/openai-go/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Searcher finds messages of past conversations similar to a query, implemented by semantic.Index
+type Searcher interface {
+	Search(ctx context.Context, query string, limit int, exclude primitive.ObjectID) ([]*semantic.Match, *model.Usage, error)
+}
+
+type conversationKey struct{}
+
+// WithConversation returns a context carrying the ID of the conversation a tool is called from
+func WithConversation(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, conversationKey{}, id)
+}
+
+// ConversationFromContext returns the ID of the conversation a tool is called from, if any
+func ConversationFromContext(ctx context.Context) primitive.ObjectID {
+	id, _ := ctx.Value(conversationKey{}).(primitive.ObjectID)
+	return id
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context in which tools add the usage of the models they call to usage
+func WithUsage(ctx context.Context, usage *model.Usage) context.Context {
+	return context.WithValue(ctx, usageKey{}, usage)
+}
+
+// AddUsage adds usage of a model called by a tool to the usage of the context, if any
+func AddUsage(ctx context.Context, usage *model.Usage) {
+	if total, ok := ctx.Value(usageKey{}).(*model.Usage); ok {
+		total.Add(usage)
+	}
+}
+
+// RecallTool lets the assistant look up what was discussed in other conversations
+type RecallTool struct {
+	searcher Searcher
+}
+
+// NewRecallTool creates a new recall tool
+func NewRecallTool(searcher Searcher) *RecallTool {
+	return &RecallTool{searcher: searcher}
+}
+
+func (t *RecallTool) Name() string {
+	return "recall_past_conversations"
+}
+
+func (t *RecallTool) Definition() openai.ChatCompletionToolUnionParam {
+	return openai.ChatCompletionFunctionTool(openai.FunctionDefinitionParam{
+		Name:        t.Name(),
+		Description: openai.String("Searches the user's past conversations for messages related to a topic, by meaning rather than exact words. Use it when the user refers to something discussed before. Each result starts with the conversation title and date."),
+		Parameters: openai.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "What to look for, e.g. 'hotel recommendations in Lisbon'",
+					"minLength":   1,
+				},
+				"max_results": map[string]any{
+					"type":        "integer",
+					"description": "Optional maximum number of messages to return, defaults to 5",
+					"minimum":     1,
+					"maximum":     20,
+				},
+			},
+			"required": []string{"query"},
+		},
+	})
+}
+
+func (t *RecallTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var payload struct {
+		Query      string `json:"query"`
+		MaxResults int    `json:"max_results,omitempty"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &payload); err != nil {
+		return "failed to parse tool call arguments: " + err.Error(), nil
+	}
+
+	if payload.MaxResults == 0 {
+		payload.MaxResults = 5
+	}
+
+	// The current conversation is already known to the assistant
+	matches, usage, err := t.searcher.Search(ctx, payload.Query, payload.MaxResults, ConversationFromContext(ctx))
+	AddUsage(ctx, usage)
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "no related messages found in past conversations", nil
+	}
+
+	var b strings.Builder
+	for _, m := range matches {
+		fmt.Fprintf(&b, "%s (%s), %s: %s\n", m.Title, m.CreatedAt.Format(time.DateOnly), strings.ToUpper(string(m.Role)), m.Content)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	"github.com/acai-travel/tech-challenge/internal/chat/semantic"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type searcherFunc func(ctx context.Context, query string, limit int, exclude primitive.ObjectID) ([]*semantic.Match, *model.Usage, error)
+
+func (f searcherFunc) Search(ctx context.Context, query string, limit int, exclude primitive.ObjectID) ([]*semantic.Match, *model.Usage, error) {
+	return f(ctx, query, limit, exclude)
+}
+
+func TestRecallTool_Validation(t *testing.T) {
+	assertValidation(t, NewRecallTool(nil), []validationCase{
+		{name: "query", arguments: `{"query":"hotels in Lisbon"}`},
+		{name: "query and max results", arguments: `{"query":"hotels in Lisbon","max_results":3}`},
+		{name: "missing query", arguments: `{}`, errors: []string{"is required"}},
+		{name: "empty query", arguments: `{"query":""}`, errors: []string{"must not be empty"}},
+		{name: "too many results", arguments: `{"query":"hotels","max_results":50}`, errors: []string{"less than or equal to 20"}},
+	})
+}
+
+func TestRecallTool_Execute(t *testing.T) {
+	current := primitive.NewObjectID()
+
+	t.Run("excludes the current conversation", func(t *testing.T) {
+		tool := NewRecallTool(searcherFunc(func(ctx context.Context, query string, limit int, exclude primitive.ObjectID) ([]*semantic.Match, *model.Usage, error) {
+			if query != "hotels in Lisbon" || limit != 5 || exclude != current {
+				t.Errorf("unexpected search %q, %d, %s", query, limit, exclude.Hex())
+			}
+
+			return []*semantic.Match{{
+				Title:     "Trip to Portugal",
+				Role:      model.RoleAssistant,
+				Content:   "Stay in Alfama.",
+				CreatedAt: time.Date(2025, 8, 20, 10, 0, 0, 0, time.UTC),
+			}}, &model.Usage{PromptTokens: 4}, nil
+		}))
+
+		usage := &model.Usage{}
+		ctx := WithUsage(WithConversation(context.Background(), current), usage)
+		out, err := tool.Execute(ctx, `{"query":"hotels in Lisbon"}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := "Trip to Portugal (2025-08-20), ASSISTANT: Stay in Alfama."; out != want {
+			t.Errorf("expected %q, got %q", want, out)
+		}
+
+		if usage.PromptTokens != 4 {
+			t.Errorf("expected the query embedding to be counted, got %+v", usage)
+		}
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		tool := NewRecallTool(searcherFunc(func(context.Context, string, int, primitive.ObjectID) ([]*semantic.Match, *model.Usage, error) {
+			return nil, nil, nil
+		}))
+
+		out, err := tool.Execute(context.Background(), `{"query":"hotels"}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out != "no related messages found in past conversations" {
+			t.Errorf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("search error", func(t *testing.T) {
+		tool := NewRecallTool(searcherFunc(func(context.Context, string, int, primitive.ObjectID) ([]*semantic.Match, *model.Usage, error) {
+			return nil, nil, errors.New("embedding failed")
+		}))
+
+		if _, err := tool.Execute(context.Background(), `{"query":"hotels"}`); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
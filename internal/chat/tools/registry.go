@@ -1,46 +1,372 @@
 package tools
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/acai-travel/tech-challenge/internal/jsonschema"
 	"github.com/openai/openai-go/v2"
 )
 
-// Registry manages all available tools
+// Registry manages all available tools. Tools are always listed in name order,
+// so the definitions sent to the model are stable between requests.
+// It is safe for concurrent use, so tools can be reloaded while requests use them.
 type Registry struct {
-	tools map[string]Tool
+	mu      sync.RWMutex
+	tools   map[string]Tool
+	schemas map[string]*jsonschema.Schema
+
+	// enabled holds the tools enabled or disabled with SetEnabled, which wins over the configuration
+	enabled map[string]bool
+
+	// registered are the tools added with Register, loaded those added by the last Load, which win
+	// over registered tools of the same name
+	registered map[string]Tool
+	loaded     map[string]Tool
+
+	// loadedDisabled are the tools disabled by the configuration of the last Load
+	loadedDisabled map[string]bool
+
+	// sessions holds the MCP sessions of the loaded tools
+	sessions *sessions
+}
+
+// sessions are closed once the tool calls that started while they were in use have finished
+type sessions struct {
+	sync.RWMutex
+	closers []io.Closer
+}
+
+// close waits for the calls using the sessions, then closes them
+func (s *sessions) close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	var errs []error
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+	s.closers = nil
+	return errors.Join(errs...)
 }
 
 // NewRegistry creates a new tool registry
 func NewRegistry() *Registry {
 	return &Registry{
-		tools: make(map[string]Tool),
+		tools:          make(map[string]Tool),
+		schemas:        make(map[string]*jsonschema.Schema),
+		enabled:        make(map[string]bool),
+		registered:     make(map[string]Tool),
+		loaded:         make(map[string]Tool),
+		loadedDisabled: make(map[string]bool),
+		sessions:       &sessions{},
 	}
 }
 
-// Register adds a tool to the registry
+// Register adds a tool to the registry, replacing any registered tool of the same name. A loaded tool
+// of the same name keeps precedence, which is logged.
 func (r *Registry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.register(tool)
+}
+
+// register adds a registered tool, the caller holds the lock
+func (r *Registry) register(tool Tool) {
+	r.registered[tool.Name()] = tool
+	if _, shadowed := r.loaded[tool.Name()]; shadowed {
+		slog.Warn("Registered tool is replaced by a loaded tool of the same name", "tool", tool.Name())
+		return
+	}
+	r.add(tool)
+}
+
+// add makes the tool available, the caller holds the lock
+func (r *Registry) add(tool Tool) {
 	r.tools[tool.Name()] = tool
+
+	schema, err := jsonschema.Compile(Describe(tool).Parameters)
+	if err != nil {
+		// The tool stays usable, its arguments are just not validated
+		slog.Error("Invalid tool parameters schema", "tool", tool.Name(), "error", err)
+		delete(r.schemas, tool.Name())
+		return
+	}
+
+	r.schemas[tool.Name()] = schema
+}
+
+// Loaded are the tools of a configuration, connected but not yet available, see Prepare
+type Loaded struct {
+	tools    []Tool
+	disabled []string
+	sessions *sessions
+}
+
+// Load registers the tools declared in the configuration, connecting to MCP servers as needed.
+// A failing MCP server does not prevent the other tools from being registered. Loaded tools replace
+// registered tools of the same name, with a warning, until a later Load leaves them out.
+//
+// Each Load replaces the tools and disabled tools of the previous one at once. The MCP sessions of the
+// previous tools are closed once the calls already running on them have finished.
+func (r *Registry) Load(ctx context.Context, cfg *Config) error {
+	loaded, err := r.Prepare(ctx, cfg)
+	if loaded == nil {
+		return err
+	}
+
+	r.Apply(loaded, nil, nil)
+	return err
+}
+
+// Prepare reads the tools declared in the configuration and connects to its MCP servers, without changing
+// the registry, so Apply can make them available at once. It returns no tools when the configuration is
+// invalid; MCP servers that fail to connect are left out and reported in the error.
+func (r *Registry) Prepare(ctx context.Context, cfg *Config) (*Loaded, error) {
+	external, err := cfg.Tools()
+	if err != nil {
+		return nil, err
+	}
+
+	next := &sessions{}
+	var errs []error
+	for _, server := range cfg.MCPServers {
+		client, tools, err := ConnectMCPServer(ctx, server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		next.closers = append(next.closers, client)
+		external = append(external, tools...)
+	}
+
+	return &Loaded{tools: external, disabled: cfg.Disabled, sessions: next}, errors.Join(errs...)
+}
+
+// Apply replaces the tools of the previous Load with the loaded ones, registers the given tools and runs
+// then, if not nil, all under the lock of the registry so no call sees part of the change
+func (r *Registry) Apply(loaded *Loaded, register []Tool, then func()) {
+	r.mu.Lock()
+	for name := range r.loaded {
+		delete(r.tools, name)
+		delete(r.schemas, name)
+		if tool, ok := r.registered[name]; ok {
+			r.add(tool)
+		}
+	}
+	r.loaded = make(map[string]Tool, len(loaded.tools))
+	for _, tool := range loaded.tools {
+		if _, shadows := r.registered[tool.Name()]; shadows {
+			slog.Warn("Loaded tool replaces the registered tool of the same name", "tool", tool.Name())
+		}
+		r.loaded[tool.Name()] = tool
+		r.add(tool)
+	}
+
+	r.loadedDisabled = make(map[string]bool, len(loaded.disabled))
+	for _, name := range loaded.disabled {
+		r.loadedDisabled[name] = true
+	}
+
+	for _, tool := range register {
+		r.register(tool)
+	}
+
+	if then != nil {
+		then()
+	}
+
+	previous := r.sessions
+	r.sessions = loaded.sessions
+	r.mu.Unlock()
+
+	go func() {
+		if err := previous.close(); err != nil {
+			slog.Warn("Failed to close replaced MCP sessions", "error", err)
+		}
+	}()
+}
+
+// SetEnabled enables or disables a tool by name. Disabled tools are not offered
+// to the model and cannot be executed, the setting also applies to tools registered later
+// and wins over the tools disabled by the configuration, also after it is reloaded.
+func (r *Registry) SetEnabled(name string, enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.enabled[name] = enabled
+}
+
+// disabled reports whether a tool is disabled, with SetEnabled or else by the configuration
+func (r *Registry) disabled(name string) bool {
+	if enabled, ok := r.enabled[name]; ok {
+		return !enabled
+	}
+	return r.loadedDisabled[name]
+}
+
+// Enabled reports whether a tool is registered and enabled
+func (r *Registry) Enabled(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.available(name)
+}
+
+func (r *Registry) available(name string) bool {
+	_, exists := r.tools[name]
+	return exists && !r.disabled(name)
 }
 
-// Get retrieves a tool by name
+// Close releases connections held by registered tools
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	previous := r.sessions
+	r.sessions = &sessions{}
+	r.mu.Unlock()
+
+	return previous.close()
+}
+
+// Get retrieves an enabled tool by name
 func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if !r.available(name) {
+		return nil, false
+	}
+	return r.tools[name], true
+}
+
+// Validate checks arguments against the parameters declared by the tool.
+// It returns an *ArgumentsError when they do not conform.
+func (r *Registry) Validate(name, arguments string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.validate(name, arguments)
+}
+
+func (r *Registry) validate(name, arguments string) error {
+	if _, exists := r.tools[name]; !exists {
+		return fmt.Errorf("unknown tool: %s", name)
+	}
+
+	if r.disabled(name) {
+		return fmt.Errorf("tool is disabled: %s", name)
+	}
+
+	schema, ok := r.schemas[name]
+	if !ok {
+		return nil
+	}
+
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+
+	if err := schema.Validate([]byte(arguments)); err != nil {
+		var errs jsonschema.Errors
+		if errors.As(err, &errs) {
+			return &ArgumentsError{Tool: name, Errors: errs}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RequiresApproval reports whether calls to the tool must be confirmed by the user
+func (r *Registry) RequiresApproval(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	tool, exists := r.tools[name]
-	return tool, exists
+	return exists && RiskOf(tool).RequiresApproval()
+}
+
+// Execute validates the arguments and runs the tool. A call keeps the MCP session it started with
+// open until it finishes, even when the tools are reloaded meanwhile.
+func (r *Registry) Execute(ctx context.Context, name, arguments string) (string, error) {
+	r.mu.RLock()
+	if err := r.validate(name, arguments); err != nil {
+		r.mu.RUnlock()
+		return "", err
+	}
+
+	tool, sessions := r.tools[name], r.sessions
+	sessions.RLock()
+	defer sessions.RUnlock()
+	r.mu.RUnlock()
+
+	return tool.Execute(ctx, arguments)
+}
+
+// Ping checks the upstream service of an enabled tool, see Pinger
+func (r *Registry) Ping(ctx context.Context, name string) error {
+	tool, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("tool is not available: %s", name)
+	}
+
+	p, ok := tool.(Pinger)
+	if !ok {
+		return fmt.Errorf("tool has no upstream to check: %s", name)
+	}
+
+	return p.Ping(ctx)
 }
 
-// Definitions returns OpenAI tool definitions for all registered tools
+// Definitions returns OpenAI tool definitions for all enabled tools
 func (r *Registry) Definitions() []openai.ChatCompletionToolUnionParam {
-	defs := make([]openai.ChatCompletionToolUnionParam, 0, len(r.tools))
-	for _, tool := range r.tools {
-		defs = append(defs, tool.Definition())
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var defs []openai.ChatCompletionToolUnionParam
+	for _, name := range r.names() {
+		if r.available(name) {
+			defs = append(defs, r.tools[name].Definition())
+		}
 	}
 	return defs
 }
 
-// List returns names of all registered tools
+// List returns names of all registered tools, including disabled ones, in name order
 func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.names()
+}
+
+func (r *Registry) names() []string {
 	names := make([]string, 0, len(r.tools))
 	for name := range r.tools {
 		names = append(names, name)
 	}
+	slices.Sort(names)
 	return names
 }
+
+// Descriptors describes all registered tools, including disabled ones, in name order
+func (r *Registry) Descriptors() []Descriptor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	descriptors := make([]Descriptor, 0, len(r.tools))
+	for _, name := range r.names() {
+		d := Describe(r.tools[name])
+		d.Enabled = !r.disabled(name)
+		descriptors = append(descriptors, d)
+	}
+	return descriptors
+}
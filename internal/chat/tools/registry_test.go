@@ -0,0 +1,231 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/weatherclient"
+)
+
+// validationCase describes tool arguments and the validation errors they should produce
+type validationCase struct {
+	name      string
+	arguments string
+	errors    []string
+}
+
+// assertValidation checks the arguments of every case against the tool's declared parameters
+func assertValidation(t *testing.T, tool Tool, cases []validationCase) {
+	t.Helper()
+
+	registry := NewRegistry()
+	registry.Register(tool)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := registry.Validate(tool.Name(), tc.arguments)
+
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var argErr *ArgumentsError
+			if !errors.As(err, &argErr) {
+				t.Fatalf("expected *ArgumentsError, got %v", err)
+			}
+
+			if len(argErr.Errors) != len(tc.errors) {
+				t.Fatalf("expected %d errors, got %v", len(tc.errors), argErr.Errors)
+			}
+
+			for i, want := range tc.errors {
+				if got := argErr.Errors[i].Path + ": " + argErr.Errors[i].Message; !strings.Contains(got, want) {
+					t.Errorf("expected error containing %q, got %q", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistry_Execute(t *testing.T) {
+	ctx := context.Background()
+
+	registry := NewRegistry()
+	registry.Register(NewCalculatorTool())
+
+	t.Run("rejects unknown tools", func(t *testing.T) {
+		if _, err := registry.Execute(ctx, "book_flight", `{}`); err == nil {
+			t.Fatal("expected error for unknown tool, got nil")
+		}
+	})
+
+	t.Run("does not execute tools with invalid arguments", func(t *testing.T) {
+		_, err := registry.Execute(ctx, "calculate", `{"expression":""}`)
+
+		var argErr *ArgumentsError
+		if !errors.As(err, &argErr) {
+			t.Fatalf("expected *ArgumentsError, got %v", err)
+		}
+
+		msg := argErr.Message()
+		if !strings.Contains(msg, `"error":"invalid_arguments"`) || !strings.Contains(msg, `"path":"expression"`) {
+			t.Errorf("expected structured error message, got %s", msg)
+		}
+	})
+
+	t.Run("executes tools with valid arguments", func(t *testing.T) {
+		out, err := registry.Execute(ctx, "calculate", `{"expression":"6 * 7"}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out != "42" {
+			t.Errorf("expected 42, got %q", out)
+		}
+	})
+}
+
+func TestRegistry_Ordering(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(NewWeatherTool(weatherclient.New("")))
+	registry.Register(NewHolidayTool(DefaultHolidayCalendarLink))
+	registry.Register(NewCalculatorTool())
+	registry.Register(NewDateTool())
+	registry.SetEnabled("get_holidays", false)
+
+	for i := 0; i < 10; i++ {
+		var names []string
+		for _, def := range registry.Definitions() {
+			names = append(names, def.OfFunction.Function.Name)
+		}
+
+		if got, want := strings.Join(names, ","), "calculate,get_today_date,get_weather"; got != want {
+			t.Fatalf("expected enabled tools in name order %q, got %q", want, got)
+		}
+	}
+
+	if _, ok := registry.Get("get_holidays"); ok {
+		t.Error("expected disabled tool not to be returned")
+	}
+
+	if _, err := registry.Execute(context.Background(), "get_holidays", `{}`); err == nil {
+		t.Error("expected disabled tool not to be executed")
+	}
+
+	registry.SetEnabled("get_holidays", true)
+	if len(registry.Definitions()) != 4 {
+		t.Error("expected re-enabled tool to be offered again")
+	}
+}
+
+func TestRegistry_RequiresApproval(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"external": [
+		{"name": "lookup", "url": "https://example.com/lookup"},
+		{"name": "book_hotel", "url": "https://example.com/book", "risk": "high"}
+	]}`), &cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	registry := NewRegistry()
+	registry.Register(NewWeatherTool(weatherclient.New("")))
+	if err := registry.Load(context.Background(), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]bool{"get_weather": false, "lookup": false, "book_hotel": true, "missing": false} {
+		if got := registry.RequiresApproval(name); got != want {
+			t.Errorf("expected RequiresApproval(%q) to be %v, got %v", name, want, got)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"risk": "extreme"}`), &ExternalToolConfig{}); err == nil {
+		t.Error("expected unknown risk to be rejected")
+	}
+}
+
+func TestRegistry_Reload(t *testing.T) {
+	load := func(registry *Registry, config string) {
+		t.Helper()
+
+		var cfg Config
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := registry.Load(context.Background(), &cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	registry := NewRegistry()
+	registry.Register(NewWeatherTool(weatherclient.New("")))
+	registry.Register(NewDateTool())
+
+	load(registry, `{"external": [
+		{"name": "lookup", "url": "https://example.com/lookup"},
+		{"name": "get_weather", "url": "https://example.com/weather"}
+	], "disabled": ["get_today_date"]}`)
+
+	if got := strings.Join(registry.List(), ","); got != "get_today_date,get_weather,lookup" {
+		t.Errorf("unexpected tools %q", got)
+	}
+
+	if tool, _ := registry.Get("get_weather"); tool == nil || isWeatherTool(tool) {
+		t.Errorf("expected the loaded tool to replace the built-in one, got %v", tool)
+	}
+
+	load(registry, `{"external": [{"name": "book", "url": "https://example.com/book"}]}`)
+
+	if got := strings.Join(registry.List(), ","); got != "book,get_today_date,get_weather" {
+		t.Errorf("expected the tools of the previous load to be replaced, got %q", got)
+	}
+
+	if tool, _ := registry.Get("get_weather"); !isWeatherTool(tool) {
+		t.Errorf("expected the built-in tool to be restored, got %v", tool)
+	}
+
+	if !registry.Enabled("get_today_date") {
+		t.Error("expected the tools disabled by the previous load to be enabled again")
+	}
+
+	// Tools switched off by an operator stay off across reloads, whatever the configuration says
+	registry.SetEnabled("get_weather", false)
+	registry.SetEnabled("get_today_date", true)
+	load(registry, `{"disabled": ["get_today_date", "get_weather"]}`)
+	load(registry, `{}`)
+
+	if registry.Enabled("get_weather") || !registry.Enabled("get_today_date") {
+		t.Errorf("expected the operator settings to survive reloads, got %+v", registry.Descriptors())
+	}
+}
+
+func isWeatherTool(tool Tool) bool {
+	_, ok := tool.(*WeatherTool)
+	return ok
+}
+
+func TestRegistry_Ping(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(NewWeatherTool(weatherclient.New("")))
+	registry.Register(NewCalculatorTool())
+
+	if err := registry.Ping(context.Background(), "get_weather"); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("expected the weather client's error, got %v", err)
+	}
+
+	if err := registry.Ping(context.Background(), "calculate"); err == nil {
+		t.Error("expected tools without an upstream not to be pinged")
+	}
+
+	registry.SetEnabled("get_weather", false)
+	if err := registry.Ping(context.Background(), "get_weather"); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("expected disabled tools not to be pinged, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Risk describes the side effects of running a tool
+type Risk int
+
+const (
+	// RiskLow tools only read data and run without confirmation
+	RiskLow Risk = iota
+
+	// RiskMedium tools have side effects that can be undone, like drafting a booking
+	RiskMedium
+
+	// RiskHigh tools have side effects that cannot be undone, like paying or sending an email
+	RiskHigh
+)
+
+// RequiresApproval reports whether the user must confirm calls to tools with this risk
+func (r Risk) RequiresApproval() bool {
+	return r >= RiskMedium
+}
+
+func (r Risk) String() string {
+	switch r {
+	case RiskMedium:
+		return "medium"
+	case RiskHigh:
+		return "high"
+	default:
+		return "low"
+	}
+}
+
+func (r Risk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Risk) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("risk must be a string: %w", err)
+	}
+
+	switch s {
+	case "", "low":
+		*r = RiskLow
+	case "medium":
+		*r = RiskMedium
+	case "high":
+		*r = RiskHigh
+	default:
+		return fmt.Errorf("unknown risk %q, expected low, medium or high", s)
+	}
+
+	return nil
+}
+
+// RiskOf returns the risk declared by the tool, tools that do not declare one are low risk
+func RiskOf(tool Tool) Risk {
+	if r, ok := tool.(interface{ Risk() Risk }); ok {
+		return r.Risk()
+	}
+	return RiskLow
+}
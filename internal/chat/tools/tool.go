@@ -17,3 +17,33 @@ type Tool interface {
 	// Execute runs the tool with given arguments and returns the result
 	Execute(ctx context.Context, arguments string) (string, error)
 }
+
+// Pinger is implemented by tools that depend on an upstream service, to check it is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Descriptor is a transport independent description of a tool
+type Descriptor struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+	Enabled     bool
+	Risk        Risk
+}
+
+// Describe extracts the name, description and parameter schema from the tool definition
+func Describe(tool Tool) Descriptor {
+	d := Descriptor{Name: tool.Name(), Enabled: true, Risk: RiskOf(tool)}
+
+	if fn := tool.Definition().OfFunction; fn != nil {
+		d.Description = fn.Function.Description.Value
+		d.Parameters = fn.Function.Parameters
+	}
+
+	if d.Parameters == nil {
+		d.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+
+	return d
+}
@@ -0,0 +1,29 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/acai-travel/tech-challenge/internal/jsonschema"
+)
+
+// ArgumentsError reports tool arguments that do not match the declared parameters
+type ArgumentsError struct {
+	Tool   string
+	Errors jsonschema.Errors
+}
+
+func (e *ArgumentsError) Error() string {
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Tool, e.Errors)
+}
+
+// Message renders the error as JSON, so the model can correct its arguments and retry
+func (e *ArgumentsError) Message() string {
+	out, _ := json.Marshal(map[string]any{
+		"error":   "invalid_arguments",
+		"tool":    e.Tool,
+		"details": e.Errors,
+		"hint":    "Fix the arguments according to the tool's parameter schema and call the tool again.",
+	})
+	return string(out)
+}
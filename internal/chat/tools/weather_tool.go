@@ -9,11 +9,13 @@ import (
 )
 
 // WeatherTool provides weather information
-type WeatherTool struct{}
+type WeatherTool struct {
+	client *weatherclient.Client
+}
 
-// NewWeatherTool creates a new weather tool
-func NewWeatherTool() *WeatherTool {
-	return &WeatherTool{}
+// NewWeatherTool creates a new weather tool fetching the weather with the client
+func NewWeatherTool(client *weatherclient.Client) *WeatherTool {
+	return &WeatherTool{client: client}
 }
 
 func (t *WeatherTool) Name() string {
@@ -27,9 +29,11 @@ func (t *WeatherTool) Definition() openai.ChatCompletionToolUnionParam {
 		Parameters: openai.FunctionParameters{
 			"type": "object",
 			"properties": map[string]any{
-				"location": map[string]string{
+				"location": map[string]any{
 					"type":        "string",
 					"description": "City name, zip code, or coordinates (e.g., 'Barcelona', '10001', '48.8567,2.3508')",
+					"minLength":   1,
+					"pattern":     `\S`,
 				},
 				"include_forecast": map[string]any{
 					"type":        "boolean",
@@ -53,5 +57,10 @@ func (t *WeatherTool) Execute(ctx context.Context, arguments string) (string, er
 	}
 
 	// Use weatherclient package
-	return weatherclient.GetWeather(ctx, payload.Location, payload.IncludeForecast)
+	return t.client.GetWeather(ctx, payload.Location, payload.IncludeForecast)
+}
+
+// Ping checks WeatherAPI answers with the configured key
+func (t *WeatherTool) Ping(ctx context.Context) error {
+	return t.client.Ping(ctx)
 }
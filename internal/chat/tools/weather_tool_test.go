@@ -0,0 +1,18 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/weatherclient"
+)
+
+func TestWeatherTool_Validation(t *testing.T) {
+	assertValidation(t, NewWeatherTool(weatherclient.New("")), []validationCase{
+		{name: "location only", arguments: `{"location":"Barcelona"}`},
+		{name: "with forecast", arguments: `{"location":"48.8567,2.3508","include_forecast":true}`},
+		{name: "missing location", arguments: `{}`, errors: []string{"location: is required"}},
+		{name: "empty location", arguments: `{"location":""}`, errors: []string{"location: must not be empty", "location: must match pattern"}},
+		{name: "blank location", arguments: `{"location":"   "}`, errors: []string{"location: must match pattern"}},
+		{name: "non-boolean forecast", arguments: `{"location":"Porto","include_forecast":"yes"}`, errors: []string{"include_forecast: must be of type boolean"}},
+	})
+}
@@ -8,7 +8,6 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 )
 
 // Response represents the response from WeatherAPI
@@ -43,11 +42,20 @@ type Response struct {
 	} `json:"forecast,omitempty"`
 }
 
+// Client calls WeatherAPI with an API key
+type Client struct {
+	apiKey string
+}
+
+// New creates a client using the API key, calls fail when it is empty
+func New(apiKey string) *Client {
+	return &Client{apiKey: apiKey}
+}
+
 // GetWeather fetches current weather for a location
-func GetWeather(ctx context.Context, location string, includeForecast bool) (string, error) {
-	apiKey := os.Getenv("WEATHER_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("WEATHER_API_KEY environment variable not set")
+func (c *Client) GetWeather(ctx context.Context, location string, includeForecast bool) (string, error) {
+	if c.apiKey == "" {
+		return "", fmt.Errorf("the weather API key is not configured")
 	}
 
 	// Build URL
@@ -57,7 +65,7 @@ func GetWeather(ctx context.Context, location string, includeForecast bool) (str
 	}
 
 	params := url.Values{}
-	params.Add("key", apiKey)
+	params.Add("key", c.apiKey)
 	params.Add("q", location)
 	params.Add("aqi", "no")
 	if includeForecast {
@@ -115,3 +123,9 @@ func GetWeather(ctx context.Context, location string, includeForecast bool) (str
 
 	return result, nil
 }
+
+// Ping checks WeatherAPI answers with the key, by fetching the current weather of a city
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.GetWeather(ctx, "London", false)
+	return err
+}
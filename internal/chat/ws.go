@@ -0,0 +1,458 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/assistant"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/gorilla/websocket"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// wsMaxMessage bounds the size of client messages, which may carry images
+	wsMaxMessage = 16 << 20
+
+	// wsMaxInFlight bounds the requests a connection runs at the same time
+	wsMaxInFlight = 4
+
+	// wsWriteTimeout bounds the time spent writing a message to a client
+	wsWriteTimeout = 10 * time.Second
+
+	// wsPongTimeout is how long a client can stay silent before the connection is considered dead,
+	// it is pinged often enough to answer in time
+	wsPongTimeout  = time.Minute
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+var (
+	wsJSON = protojson.MarshalOptions{UseProtoNames: true}
+	wsRead = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// wsRequest is a message from the client. Type names the RPC to call with Request, the request
+// message in its JSON form, or is "cancel" to cancel the request in flight with the same ID.
+type wsRequest struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// wsEvent is a message to the client about the request with the same ID: the reply's text as it is
+// generated (delta), the tool calls it makes (tool_call) and their results (tool_result), and finally
+// the RPC's response, its error or the confirmation that it was cancelled.
+type wsEvent struct {
+	ID       string          `json:"id,omitempty"`
+	Type     string          `json:"type"`
+	Delta    string          `json:"delta,omitempty"`
+	ToolCall json.RawMessage `json:"tool_call,omitempty"`
+	Result   string          `json:"result,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *wsError        `json:"error,omitempty"`
+}
+
+// wsError has the fields of Twirp's JSON errors
+type wsError struct {
+	Code string            `json:"code"`
+	Msg  string            `json:"msg"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// wsCall decodes a request and calls the RPC serving it
+type wsCall func(ctx context.Context, request json.RawMessage) (proto.Message, error)
+
+// rpc adapts a method of the server to a wsCall
+func rpc[Req any, PReq interface {
+	*Req
+	proto.Message
+}, Resp proto.Message](method func(context.Context, PReq) (Resp, error)) wsCall {
+	return func(ctx context.Context, request json.RawMessage) (proto.Message, error) {
+		req := PReq(new(Req))
+		if len(request) > 0 {
+			if err := wsRead.Unmarshal(request, req); err != nil {
+				return nil, twirp.NewError(twirp.Malformed, "the request could not be decoded: "+err.Error())
+			}
+		}
+
+		resp, err := method(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// WebSocketHandler serves the conversation RPCs over WebSocket connections, streaming the progress
+// of replies while they are generated. Requests go through the same Server methods as Twirp ones,
+// so clients see the same history whichever transport they use.
+type WebSocketHandler struct {
+	calls    map[string]wsCall
+	upgrader websocket.Upgrader
+	limiter  *httpx.Limiter
+
+	mu      sync.Mutex
+	conns   map[*wsConn]struct{}
+	closing bool
+	done    sync.WaitGroup
+}
+
+// WebSocketOption configures optional behaviour of the WebSocket handler
+type WebSocketOption func(*WebSocketHandler)
+
+// WithOrigins lets pages served from other origins connect, the default only accepts pages served
+// by the same host. "*" accepts any origin.
+func WithOrigins(origins ...string) WebSocketOption {
+	return func(h *WebSocketHandler) {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin) {
+				return true
+			}
+
+			u, err := url.Parse(origin)
+			return err == nil && u.Host == r.Host
+		}
+	}
+}
+
+// WithMessageLimit applies the rate limit to each request sent over a connection, like it is
+// applied to each Twirp request
+func WithMessageLimit(limiter *httpx.Limiter) WebSocketOption {
+	return func(h *WebSocketHandler) {
+		h.limiter = limiter
+	}
+}
+
+func NewWebSocketHandler(s *Server, opts ...WebSocketOption) *WebSocketHandler {
+	h := &WebSocketHandler{
+		calls: map[string]wsCall{
+			"start_conversation":    rpc(s.StartConversation),
+			"continue_conversation": rpc(s.ContinueConversation),
+			"approve_tool_call":     rpc(s.ApproveToolCall),
+			"reject_tool_call":      rpc(s.RejectToolCall),
+		},
+		conns: map[*wsConn]struct{}{},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		slog.InfoContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	// Requests in flight are cancelled when the client goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	c := &wsConn{
+		handler:  h,
+		ws:       ws,
+		client:   httpx.ClientFromContext(ctx),
+		cancel:   cancel,
+		inFlight: map[string]context.CancelFunc{},
+	}
+
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		c.close(websocket.CloseGoingAway, "the server is shutting down")
+		_ = ws.Close()
+		return
+	}
+
+	h.conns[c] = struct{}{}
+	h.done.Add(1)
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.conns, c)
+		h.mu.Unlock()
+		h.done.Done()
+	}()
+
+	c.serve(ctx)
+}
+
+// Shutdown stops accepting connections and requests, lets the requests in flight finish and closes
+// the connections. Once ctx is done the requests still in flight are cancelled.
+func (h *WebSocketHandler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	for c := range h.conns {
+		c.drain()
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.done.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	h.mu.Lock()
+	for c := range h.conns {
+		c.cancel()
+	}
+	h.mu.Unlock()
+
+	<-done
+	return ctx.Err()
+}
+
+// wsConn is a client connection
+type wsConn struct {
+	handler *WebSocketHandler
+	ws      *websocket.Conn
+	client  string
+	cancel  context.CancelFunc
+
+	// writes serializes the messages to the client
+	writes sync.Mutex
+
+	// inFlight holds the cancel functions of the requests being served, by ID
+	mu       sync.Mutex
+	inFlight map[string]context.CancelFunc
+	requests sync.WaitGroup
+
+	draining atomic.Bool
+}
+
+func (c *wsConn) serve(ctx context.Context) {
+	defer func() { _ = c.ws.Close() }()
+
+	c.ws.SetReadLimit(wsMaxMessage)
+	_ = c.ws.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		if c.draining.Load() {
+			return nil
+		}
+		return c.ws.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	go c.ping(ctx)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(wsEvent{Type: "error", Error: wsErrorOf(twirp.NewError(twirp.Malformed, "the message could not be decoded: "+err.Error()))})
+			continue
+		}
+
+		c.handle(ctx, req)
+	}
+
+	if c.draining.Load() {
+		// The server is shutting down, requests in flight finish before the connection is closed
+		c.requests.Wait()
+		c.close(websocket.CloseGoingAway, "the server is shutting down")
+		return
+	}
+
+	c.cancel()
+	c.requests.Wait()
+}
+
+// drain stops reading requests from the client, see WebSocketHandler.Shutdown
+func (c *wsConn) drain() {
+	c.draining.Store(true)
+	_ = c.ws.SetReadDeadline(time.Now())
+}
+
+func (c *wsConn) handle(ctx context.Context, req wsRequest) {
+	if req.Type == "cancel" {
+		c.mu.Lock()
+		cancel, ok := c.inFlight[req.ID]
+		c.mu.Unlock()
+
+		if !ok {
+			c.send(wsEvent{ID: req.ID, Type: "error", Error: wsErrorOf(twirp.NotFoundError("no request in flight with this id"))})
+			return
+		}
+
+		cancel()
+		return
+	}
+
+	call, err := c.admit(req)
+	if err != nil {
+		c.send(wsEvent{ID: req.ID, Type: "error", Error: wsErrorOf(err)})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if err := c.track(req.ID, cancel); err != nil {
+		cancel()
+		c.send(wsEvent{ID: req.ID, Type: "error", Error: wsErrorOf(err)})
+		return
+	}
+
+	go func() {
+		defer c.untrack(req.ID)
+
+		resp, err := call(assistant.WithEvents(ctx, func(e assistant.Event) { c.event(req.ID, e) }), req.Request)
+
+		switch {
+		case err == nil:
+			raw, err := wsJSON.Marshal(resp)
+			if err != nil {
+				c.send(wsEvent{ID: req.ID, Type: "error", Error: wsErrorOf(err)})
+				return
+			}
+			c.send(wsEvent{ID: req.ID, Type: "response", Response: raw})
+		case ctx.Err() != nil:
+			c.send(wsEvent{ID: req.ID, Type: "cancelled"})
+		default:
+			c.send(wsEvent{ID: req.ID, Type: "error", Error: wsErrorOf(err)})
+		}
+	}()
+}
+
+// admit checks the request and returns the call serving it
+func (c *wsConn) admit(req wsRequest) (wsCall, error) {
+	if req.ID == "" {
+		return nil, twirp.RequiredArgumentError("id")
+	}
+
+	call, ok := c.handler.calls[req.Type]
+	if !ok {
+		return nil, twirp.InvalidArgumentError("type", "must be start_conversation, continue_conversation, approve_tool_call, reject_tool_call or cancel")
+	}
+
+	if l := c.handler.limiter; l != nil {
+		if ok, wait := l.Allow(c.client); !ok {
+			return nil, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded").
+				WithMeta("retry_after", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+	}
+
+	return call, nil
+}
+
+// track records a request in flight so it can be cancelled, see untrack
+func (c *wsConn) track(id string, cancel context.CancelFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.inFlight[id]; ok {
+		return twirp.InvalidArgumentError("id", "is already used by a request in flight")
+	}
+
+	if len(c.inFlight) >= wsMaxInFlight {
+		return twirp.NewError(twirp.ResourceExhausted, "too many requests in flight on this connection")
+	}
+
+	c.inFlight[id] = cancel
+	c.requests.Add(1)
+	return nil
+}
+
+// untrack forgets a request once it is served
+func (c *wsConn) untrack(id string) {
+	c.mu.Lock()
+	cancel := c.inFlight[id]
+	delete(c.inFlight, id)
+	c.mu.Unlock()
+
+	cancel()
+	c.requests.Done()
+}
+
+// event forwards the progress of a reply to the client
+func (c *wsConn) event(id string, e assistant.Event) {
+	out := wsEvent{ID: id, Type: string(e.Type), Delta: e.Delta}
+
+	if e.ToolCall != nil {
+		raw, err := wsJSON.Marshal(e.ToolCall.Proto())
+		if err != nil {
+			slog.Error("Failed to encode tool call", "error", err)
+			return
+		}
+		out.ToolCall = raw
+	}
+
+	if e.Result != nil {
+		out.Result = e.Result.Content
+	}
+
+	c.send(out)
+}
+
+// send writes a message to the client. A client that cannot be written to is gone, reading from it
+// fails too and ends the connection, so errors are only logged.
+func (c *wsConn) send(e wsEvent) {
+	c.writes.Lock()
+	defer c.writes.Unlock()
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.ws.WriteJSON(e); err != nil {
+		slog.Debug("Failed to write to WebSocket", "type", e.Type, "error", err)
+	}
+}
+
+// ping keeps the connection alive through proxies and detects dead clients
+func (c *wsConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.writes.Lock()
+	defer c.writes.Unlock()
+
+	_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+}
+
+// wsErrorOf converts an error like Twirp does for its responses
+func wsErrorOf(err error) *wsError {
+	var te twirp.Error
+	if !errors.As(err, &te) {
+		te = twirp.InternalErrorWith(err)
+	}
+
+	return &wsError{Code: string(te.Code()), Msg: te.Msg(), Meta: te.MetaMap()}
+}
@@ -0,0 +1,187 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/chat/model"
+	. "github.com/acai-travel/tech-challenge/internal/chat/testing"
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket connects to the WebSocket handler of the server
+func dialWebSocket(t *testing.T, server *Server, opts ...WebSocketOption) *websocket.Conn {
+	srv := httptest.NewServer(httpx.Client(nil)(NewWebSocketHandler(server, opts...)))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// readEvent reads the next event sent to the client
+func readEvent(t *testing.T, conn *websocket.Conn) wsEvent {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var e wsEvent
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	return e
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Run("serves requests and stores the conversation", WithFixture(func(t *testing.T, f *Fixture) {
+		server := NewServer(f.Repository, &MockAssistant{
+			ReplyFunc: func(ctx context.Context, conv *model.Conversation) (string, error) {
+				return "It is sunny.", nil
+			},
+		})
+		conn := dialWebSocket(t, server)
+
+		if err := conn.WriteJSON(wsRequest{ID: "1", Type: "start_conversation", Request: json.RawMessage(`{"message":"Weather?"}`)}); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		e := readEvent(t, conn)
+		if e.ID != "1" || e.Type != "response" {
+			t.Fatalf("expected a response, got %+v", e)
+		}
+
+		var out pb.StartConversationResponse
+		if err := wsRead.Unmarshal(e.Response, &out); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		if out.GetReply() != "It is sunny." {
+			t.Errorf("unexpected reply %q", out.GetReply())
+		}
+
+		// The conversation is the same one Twirp clients see
+		conv, err := server.DescribeConversation(context.Background(), &pb.DescribeConversationRequest{ConversationId: out.GetConversationId()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n := len(conv.GetConversation().GetMessages()); n != 2 {
+			t.Errorf("expected the message and its reply to be stored, got %d messages", n)
+		}
+	}))
+
+	t.Run("reports errors like Twirp", func(t *testing.T) {
+		conn := dialWebSocket(t, NewServer(nil, &MockAssistant{}))
+
+		for _, tc := range []struct {
+			req  string
+			code string
+		}{
+			{req: `{"id":"1","type":"start_conversation","request":{"message":" "}}`, code: "invalid_argument"},
+			{req: `{"id":"2","type":"delete_everything"}`, code: "invalid_argument"},
+			{req: `{"id":"3","type":"start_conversation","request":{"message":42}}`, code: "malformed"},
+			{req: `{"id":"4","type":"cancel"}`, code: "not_found"},
+		} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tc.req)); err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+
+			if e := readEvent(t, conn); e.Type != "error" || e.Error.Code != tc.code {
+				t.Errorf("%s: expected a %s error, got %+v", tc.req, tc.code, e)
+			}
+		}
+	})
+
+	t.Run("cancels requests in flight", WithFixture(func(t *testing.T, f *Fixture) {
+		started := make(chan struct{})
+		server := NewServer(f.Repository, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		})
+		conn := dialWebSocket(t, server)
+
+		conv := f.CreateConversation()
+		req, _ := json.Marshal(map[string]string{"conversation_id": conv.ID.Hex(), "message": "And tomorrow?"})
+		if err := conn.WriteJSON(wsRequest{ID: "1", Type: "continue_conversation", Request: req}); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		<-started
+
+		if err := conn.WriteJSON(wsRequest{ID: "1", Type: "cancel"}); err != nil {
+			t.Fatalf("failed to send cancel: %v", err)
+		}
+
+		if e := readEvent(t, conn); e.ID != "1" || e.Type != "cancelled" {
+			t.Errorf("expected the request to be cancelled, got %+v", e)
+		}
+
+		// The cancelled turn is stored
+		out, err := server.DescribeConversation(context.Background(), &pb.DescribeConversationRequest{ConversationId: conv.ID.Hex()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		messages := out.GetConversation().GetMessages()
+		if last := messages[len(messages)-1]; !last.GetCancelled() {
+			t.Errorf("expected the reply to be marked cancelled, got %v", last)
+		}
+	}))
+
+	t.Run("lets requests finish on shutdown", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		handler := NewWebSocketHandler(NewServer(nil, &MockAssistant{
+			MessagesFunc: func(ctx context.Context, conv *model.Conversation) ([]*model.Message, error) {
+				close(started)
+				<-release
+				return nil, fmt.Errorf("model unavailable")
+			},
+		}))
+
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if err := conn.WriteJSON(wsRequest{ID: "1", Type: "start_conversation", Request: json.RawMessage(`{"message":"Weather?"}`)}); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		<-started
+
+		shutdown := make(chan error)
+		go func() { shutdown <- handler.Shutdown(context.Background()) }()
+		close(release)
+
+		if e := readEvent(t, conn); e.Type != "error" || e.Error.Code != "internal" {
+			t.Errorf("expected the request to finish, got %+v", e)
+		}
+
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected the connection to be closed as going away, got %v", err)
+		}
+
+		if err := <-shutdown; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a config file for the test and returns its path
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func load(args ...string) (*Config, error) {
+	return Load(flag.NewFlagSet("test", flag.ContinueOnError), args)
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("uses the defaults", func(t *testing.T) {
+		cfg, err := load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cfg.HTTP.Port != 8080 || cfg.GRPC.Port != 9090 || cfg.Mongo.Database != "acai" {
+			t.Errorf("unexpected configuration %+v", cfg)
+		}
+	})
+
+	t.Run("overrides the file with the environment and the environment with flags", func(t *testing.T) {
+		path := writeFile(t, `{
+			"http": {"port": 8000, "read_timeout": "5s"},
+			"mongo": {"database": "from_file"},
+			"websocket": {"origins": ["https://a.example"]}
+		}`)
+		t.Setenv("MONGODB_DATABASE", "from_env")
+		t.Setenv("HTTP_WRITE_TIMEOUT", "1m")
+		t.Setenv("WEBSOCKET_ORIGINS", "https://b.example, https://c.example")
+
+		cfg, err := load("--config", path, "--mongo-database", "from_flag")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cfg.HTTP.Port != 8000 || cfg.HTTP.ReadTimeout != 5*time.Second {
+			t.Errorf("expected the file to override the defaults, got %+v", cfg.HTTP)
+		}
+
+		if cfg.HTTP.WriteTimeout != time.Minute || strings.Join(cfg.WebSocket.Origins, " ") != "https://b.example https://c.example" {
+			t.Errorf("expected the environment to override the file, got %+v %+v", cfg.HTTP, cfg.WebSocket)
+		}
+
+		if cfg.Mongo.Database != "from_flag" {
+			t.Errorf("expected the flag to override the environment, got %q", cfg.Mongo.Database)
+		}
+	})
+
+	t.Run("rejects unknown settings and invalid values", func(t *testing.T) {
+		if _, err := load("--config", writeFile(t, `{"http": {"prot": 8000}}`)); err == nil || !strings.Contains(err.Error(), "unknown setting http.prot") {
+			t.Errorf("expected an unknown setting error, got %v", err)
+		}
+
+		if _, err := load("--config", writeFile(t, `{"http": {"read_timeout": 30}}`)); err == nil {
+			t.Error("expected durations as numbers to be rejected")
+		}
+
+		t.Setenv("GRPC_PORT", "ninety")
+		if _, err := load(); err == nil || !strings.Contains(err.Error(), "GRPC_PORT") {
+			t.Errorf("expected an invalid GRPC_PORT error, got %v", err)
+		}
+	})
+
+	t.Run("validates every setting", func(t *testing.T) {
+		cfg, err := load("--http-port", "9090", "--mongo-uri", "localhost", "--limits-rate-burst", "0", "--tools-holiday-calendar-link", "calendar.ics")
+		if cfg == nil {
+			t.Fatalf("expected the configuration to be returned with the error, got %v", err)
+		}
+
+		for _, want := range []string{"http.port and grpc.port must differ", "mongo.uri", "limits.rate_burst", "tools.holiday_calendar_link"} {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("expected an error about %s, got %v", want, err)
+			}
+		}
+	})
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg := Default()
+	cfg.Mongo.URI = "mongodb://acai:hunter2@db:27017"
+	cfg.OpenAI.APIKey = "sk-secret"
+	cfg.Webhooks.Secret = "shh"
+	cfg.Limits.APIKeys = []string{"key-alice", "key-bob"}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "sk-secret", "shh", "key-alice"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted:\n%s", secret, out)
+		}
+	}
+
+	if !strings.Contains(out, "mongodb://acai:xxxxx@db:27017") {
+		t.Errorf("expected the URI to keep everything but its password:\n%s", out)
+	}
+
+	// The printed configuration can be loaded back
+	loaded, err := load("--config", writeFile(t, out))
+	if err != nil {
+		t.Fatalf("failed to load the printed configuration: %v", err)
+	}
+
+	if loaded.HTTP != cfg.HTTP {
+		t.Errorf("expected %+v, got %+v", cfg.HTTP, loaded.HTTP)
+	}
+}
+
+func TestLoader_Reload(t *testing.T) {
+	path := writeFile(t, `{"http": {"port": 8000}, "assistant": {"system_prompt": "You are a pirate."}}`)
+
+	loader, err := NewLoader(flag.NewFlagSet("test", flag.ContinueOnError), []string{"--config", path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	running, err := loader.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var applied *Config
+	apply := func(ctx context.Context, cfg *Config) error {
+		applied = cfg
+		return nil
+	}
+
+	stamps := loader.stamps(running)
+	if err := os.WriteFile(path, []byte(`{"http": {"port": 8001}, "assistant": {"system_prompt": "You are a poet.", "reply_model": "gpt-4o"}}`), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	if loader.stamps(running) == stamps {
+		t.Error("expected the change of the file to be noticed")
+	}
+
+	t.Run("applies the reloadable settings", func(t *testing.T) {
+		next := loader.reload(context.Background(), running, apply, "file", nil)
+
+		if applied != next || next.Assistant.SystemPrompt != "You are a poet." || next.Assistant.ReplyModel != "gpt-4o" {
+			t.Errorf("expected the new assistant settings to be applied, got %+v", next.Assistant)
+		}
+
+		if next.HTTP.Port != 8000 {
+			t.Errorf("expected the port to need a restart, got %d", next.HTTP.Port)
+		}
+	})
+
+	t.Run("keeps the running configuration when the new one is invalid", func(t *testing.T) {
+		applied = nil
+		if err := os.WriteFile(path, []byte(`{"assistant": {"reply_model": ""}}`), 0o600); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+
+		if next := loader.reload(context.Background(), running, apply, "signal", nil); next != running || applied != nil {
+			t.Errorf("expected nothing to be applied, got %+v", applied)
+		}
+	})
+}
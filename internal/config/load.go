@@ -0,0 +1,270 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a field of the configuration
+type setting struct {
+	name   string // the section and field, like http.port
+	env    string
+	help   string
+	secret string
+	reload bool
+	value  reflect.Value
+}
+
+// flag names the setting's command line flag, like http-port
+func (s setting) flag() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.name)
+}
+
+// settings lists the fields of the configuration, in declaration order
+func (c *Config) settings() []setting {
+	var out []setting
+
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("json")
+
+		fields := sections.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			f := fields.Type().Field(j)
+			out = append(out, setting{
+				name:   section + "." + f.Tag.Get("json"),
+				env:    f.Tag.Get("env"),
+				help:   f.Tag.Get("help"),
+				secret: f.Tag.Get("secret"),
+				reload: f.Tag.Get("reload") == "true",
+				value:  fields.Field(j),
+			})
+		}
+	}
+
+	return out
+}
+
+// Loader loads the configuration from the sources given on the command line, again on every reload
+type Loader struct {
+	path  string
+	flags map[string]string
+}
+
+// NewLoader registers a flag for each setting on fs and parses args with it. The JSON file is read
+// from the --config flag or CONFIG_FILE.
+func NewLoader(fs *flag.FlagSet, args []string) (*Loader, error) {
+	l := &Loader{flags: map[string]string{}}
+
+	fs.StringVar(&l.path, "config", os.Getenv("CONFIG_FILE"), "JSON configuration file")
+	for _, s := range Default().settings() {
+		help := fmt.Sprintf("%s (%s, default %s)", s.help, s.env, format(s.value))
+		fs.Func(s.flag(), help, func(v string) error {
+			l.flags[s.flag()] = v
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Load reads the configuration, see NewLoader
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	l, err := NewLoader(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	return l.Load()
+}
+
+// Load reads the file, the environment and the flags. Settings are validated; when they are not,
+// the configuration is returned with the error so it can still be printed.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	if l.path != "" {
+		if err := cfg.loadFile(l.path, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := parse(s.value, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := l.flags[s.flag()]; ok {
+			if err := parse(s.value, v); err != nil {
+				return nil, fmt.Errorf("invalid --%s: %w", s.flag(), err)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// loadFile sets the settings found in the JSON file, which has an object per section
+func (c *Config) loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	byName := map[string]setting{}
+	for _, s := range settings {
+		byName[s.name] = s
+	}
+
+	for section, fields := range file {
+		for field, raw := range fields {
+			s, ok := byName[section+"."+field]
+			if !ok {
+				return fmt.Errorf("config file %s: unknown setting %s.%s", path, section, field)
+			}
+
+			if err := parseJSON(s.value, raw); err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %w", path, s.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseJSON sets the value from its JSON form, durations are strings like "30s"
+func parseJSON(v reflect.Value, raw json.RawMessage) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("must be a duration like \"30s\"")
+		}
+		return parse(v, s)
+	}
+
+	return json.Unmarshal(raw, v.Addr().Interface())
+}
+
+// parse sets the value from its text form, lists are comma separated
+func parse(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+
+	return nil
+}
+
+// format returns the text form of the value, as parse reads it
+func format(v reflect.Value) string {
+	switch val := v.Interface().(type) {
+	case time.Duration:
+		return val.String()
+	case []string:
+		return strings.Join(val, ",")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// Print writes the configuration as a JSON file it could be loaded from, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	file := map[string]map[string]any{}
+	for _, s := range c.settings() {
+		section, field, _ := strings.Cut(s.name, ".")
+		if file[section] == nil {
+			file[section] = map[string]any{}
+		}
+
+		var value any = s.value.Interface()
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			value = redact(v, s.secret)
+		case []string:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = redact(item, s.secret)
+			}
+			value = items
+		}
+		file[section][field] = value
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(file); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func redact(value, secret string) string {
+	switch {
+	case value == "" || secret == "":
+		return value
+	case secret == "url":
+		if u, err := url.Parse(value); err == nil {
+			return u.Redacted()
+		}
+	}
+	return "REDACTED"
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// watchInterval is how often the watched files are checked for changes
+const watchInterval = 5 * time.Second
+
+// Watch reloads the configuration when the process receives SIGHUP or when the configuration file or
+// the tools configuration changes, and passes it to apply. Only the settings tagged reload change,
+// the others keep their running values until a restart. An invalid configuration is logged and
+// skipped, the running one stays in place. Watch returns when ctx is done.
+func (l *Loader) Watch(ctx context.Context, running *Config, apply func(context.Context, *Config) error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	metrics := newReloadMetrics()
+	stamps := l.stamps(running)
+
+	for {
+		var reason string
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reason = "signal"
+		case <-ticker.C:
+			if l.stamps(running) == stamps {
+				continue
+			}
+			reason = "file"
+		}
+
+		running = l.reload(ctx, running, apply, reason, metrics)
+		stamps = l.stamps(running)
+	}
+}
+
+// reload loads the configuration and applies its reloadable settings, returning the configuration
+// now running
+func (l *Loader) reload(ctx context.Context, running *Config, apply func(context.Context, *Config) error, reason string, metrics *reloadMetrics) *Config {
+	start := time.Now()
+
+	next, err := l.Load()
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid configuration, keeping the running one", "reason", reason, "error", err)
+		metrics.record(ctx, "invalid", time.Since(start))
+		return running
+	}
+
+	merged := *running
+	current, loaded := merged.settings(), next.settings()
+
+	var changed, ignored []string
+	for i, s := range current {
+		if reflect.DeepEqual(s.value.Interface(), loaded[i].value.Interface()) {
+			continue
+		}
+
+		if !s.reload {
+			ignored = append(ignored, s.name)
+			continue
+		}
+
+		changed = append(changed, s.name)
+		s.value.Set(loaded[i].value)
+	}
+
+	if len(ignored) > 0 {
+		slog.WarnContext(ctx, "Settings changed that only apply after a restart", "settings", ignored)
+	}
+
+	if err := apply(ctx, &merged); err != nil {
+		slog.ErrorContext(ctx, "Configuration reloaded with errors", "reason", reason, "changed", changed, "error", err)
+		metrics.record(ctx, "failed", time.Since(start))
+		return &merged
+	}
+
+	slog.InfoContext(ctx, "Configuration reloaded", "reason", reason, "changed", changed, "duration", time.Since(start))
+	metrics.record(ctx, "success", time.Since(start))
+	return &merged
+}
+
+// stamps identifies the versions of the watched files by their size and modification time
+func (l *Loader) stamps(running *Config) string {
+	var out string
+	for _, path := range []string{l.path, running.Tools.ConfigFile} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			out += path + ": missing\n"
+			continue
+		}
+		out += fmt.Sprintf("%s: %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return out
+}
+
+// reloadMetrics exports the number and duration of reloads, by result (success, failed, invalid)
+type reloadMetrics struct {
+	reloads  metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newReloadMetrics() *reloadMetrics {
+	meter := otel.Meter("acai-travel-chat-service")
+
+	reloads, err := meter.Int64Counter(
+		"config.reloads",
+		metric.WithDescription("Configuration reloads, by result (success, failed, invalid)"),
+		metric.WithUnit("{reload}"),
+	)
+	if err != nil {
+		slog.Error("Failed to create reload counter", "error", err)
+		return nil
+	}
+
+	duration, err := meter.Float64Histogram(
+		"config.reload.duration",
+		metric.WithDescription("Time spent reloading the configuration, connecting to MCP servers included"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		slog.Error("Failed to create reload duration histogram", "error", err)
+		return nil
+	}
+
+	return &reloadMetrics{reloads: reloads, duration: duration}
+}
+
+func (m *reloadMetrics) record(ctx context.Context, result string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	byResult := metric.WithAttributes(attribute.String("result", result))
+	m.reloads.Add(ctx, 1, byResult)
+	m.duration.Record(ctx, elapsed.Seconds(), byResult)
+}
@@ -0,0 +1,161 @@
+package grpcx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Recovery turns panics in handlers into internal errors
+func Recovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				e, ok := v.(error)
+				if !ok {
+					e = fmt.Errorf("%v", v)
+				}
+
+				err = status.Error(codes.Internal, "Internal Server Error")
+				slog.ErrorContext(ctx, "gRPC handler recovered from panic", "error", e, "grpc_method", info.FullMethod)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// Logger logs each call with its status code
+func Logger() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+
+		switch code := status.Code(err); code {
+		case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+			slog.ErrorContext(ctx, "gRPC call failed", "grpc_method", info.FullMethod, "grpc_code", code.String(), "error", err)
+		default:
+			slog.InfoContext(ctx, "gRPC call complete", "grpc_method", info.FullMethod, "grpc_code", code.String())
+		}
+
+		return resp, err
+	}
+}
+
+// Client stores the caller in the context like httpx.Client does for HTTP requests: by the API key sent
+// in the x-api-key metadata or as a bearer token when it is one of the keys, by peer address otherwise
+func Client(keys *httpx.Keys) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		key := first(md.Get("x-api-key"))
+		if key == "" {
+			key, _ = strings.CutPrefix(first(md.Get("authorization")), "Bearer ")
+		}
+
+		var addr string
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			addr = p.Addr.String()
+		}
+
+		return handler(httpx.WithClient(ctx, keys.ClientIDFor(key, addr)), req)
+	}
+}
+
+// RateLimit limits the calls of each client with the limiter, which can be shared with the HTTP transports.
+// It must run after Client.
+func RateLimit(l *httpx.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ok, wait := l.Allow(httpx.ClientFromContext(ctx)); !ok {
+			return nil, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded").
+				WithMeta("retry_after", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Errors converts the Twirp errors returned by the server into gRPC statuses, their metadata is sent as
+// trailers. It must run before the interceptors that can return Twirp errors.
+func Errors() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		if _, ok := status.FromError(err); ok {
+			return resp, err
+		}
+
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if meta := twerr.MetaMap(); len(meta) > 0 {
+			trailer := metadata.MD{}
+			for k, v := range meta {
+				trailer.Set(k, v)
+			}
+			_ = grpc.SetTrailer(ctx, trailer)
+		}
+
+		return nil, status.Error(Code(twerr.Code()), twerr.Msg())
+	}
+}
+
+// Code returns the gRPC code matching a Twirp error code
+func Code(code twirp.ErrorCode) codes.Code {
+	switch code {
+	case twirp.Canceled:
+		return codes.Canceled
+	case twirp.InvalidArgument, twirp.Malformed:
+		return codes.InvalidArgument
+	case twirp.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case twirp.NotFound:
+		return codes.NotFound
+	case twirp.BadRoute, twirp.Unimplemented:
+		return codes.Unimplemented
+	case twirp.AlreadyExists:
+		return codes.AlreadyExists
+	case twirp.PermissionDenied:
+		return codes.PermissionDenied
+	case twirp.Unauthenticated:
+		return codes.Unauthenticated
+	case twirp.ResourceExhausted:
+		return codes.ResourceExhausted
+	case twirp.FailedPrecondition:
+		return codes.FailedPrecondition
+	case twirp.Aborted:
+		return codes.Aborted
+	case twirp.OutOfRange:
+		return codes.OutOfRange
+	case twirp.Internal:
+		return codes.Internal
+	case twirp.Unavailable:
+		return codes.Unavailable
+	case twirp.DataLoss:
+		return codes.DataLoss
+	default:
+		return codes.Unknown
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
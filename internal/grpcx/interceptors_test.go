@@ -0,0 +1,128 @@
+package grpcx
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial serves svc in memory and returns a client connected to it
+func dial(t *testing.T, svc pb.ChatServiceServer, opts ...Option) pb.ChatServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	server := NewServer(svc, opts...)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return pb.NewChatServiceClient(conn)
+}
+
+// fakeService answers like the chat server does
+type fakeService struct {
+	pb.UnimplementedChatServiceServer
+}
+
+func (fakeService) StartConversation(context.Context, *pb.StartConversationRequest) (*pb.StartConversationResponse, error) {
+	return nil, twirp.RequiredArgumentError("message")
+}
+
+func (fakeService) CancelReply(context.Context, *pb.CancelReplyRequest) (*pb.CancelReplyResponse, error) {
+	return &pb.CancelReplyResponse{Cancelled: 1}, nil
+}
+
+func (fakeService) ListTools(context.Context, *pb.ListToolsRequest) (*pb.ListToolsResponse, error) {
+	panic("boom")
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("serves the chat service", func(t *testing.T) {
+		client := dial(t, fakeService{})
+
+		out, err := client.CancelReply(ctx, &pb.CancelReplyRequest{ConversationId: "68a5aa7b14ba62ef8448c917"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if out.GetCancelled() != 1 {
+			t.Errorf("expected the response of the service, got %v", out)
+		}
+	})
+
+	t.Run("converts Twirp errors with their metadata", func(t *testing.T) {
+		client := dial(t, fakeService{})
+
+		var trailer metadata.MD
+		_, err := client.StartConversation(ctx, &pb.StartConversationRequest{Message: " "}, grpc.Trailer(&trailer))
+
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected an invalid argument error, got %v", err)
+		}
+
+		if got := trailer.Get("argument"); len(got) != 1 || got[0] != "message" {
+			t.Errorf("expected the argument in the trailer, got %v", trailer)
+		}
+	})
+
+	t.Run("rate limits each client", func(t *testing.T) {
+		client := dial(t, fakeService{}, WithRateLimit(httpx.NewLimiter(1, 1)), WithKeys(httpx.NewKeys("first", "second")))
+
+		first := metadata.AppendToOutgoingContext(ctx, "x-api-key", "first")
+		second := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer second")
+		req := &pb.CancelReplyRequest{ConversationId: "68a5aa7b14ba62ef8448c917"}
+
+		if _, err := client.CancelReply(first, req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var trailer metadata.MD
+		_, err := client.CancelReply(first, req, grpc.Trailer(&trailer))
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("expected the second call to be rate limited, got %v", err)
+		}
+
+		if len(trailer.Get("retry_after")) != 1 {
+			t.Errorf("expected retry_after in the trailer, got %v", trailer)
+		}
+
+		if _, err := client.CancelReply(second, req); err != nil {
+			t.Errorf("expected another client to have its own budget, got %v", err)
+		}
+
+		if _, err := client.CancelReply(ctx, req); err != nil {
+			t.Fatalf("expected the first call without a key to pass, got %v", err)
+		}
+
+		unknown := metadata.AppendToOutgoingContext(ctx, "x-api-key", "made-up")
+		if _, err := client.CancelReply(unknown, req); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected an unknown key to share the budget of the peer address, got %v", err)
+		}
+	})
+
+	t.Run("recovers from panics", func(t *testing.T) {
+		client := dial(t, fakeService{})
+
+		if _, err := client.ListTools(ctx, &pb.ListToolsRequest{}); status.Code(err) != codes.Internal {
+			t.Errorf("expected an internal error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package grpcx
+
+import (
+	"github.com/acai-travel/tech-challenge/internal/httpx"
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/acai-travel/tech-challenge/internal/rest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type Option func(*options)
+
+type options struct {
+	limiter *httpx.Limiter
+	keys    *httpx.Keys
+}
+
+// WithKeys identifies callers sending one of the API keys by their key, see Client
+func WithKeys(keys *httpx.Keys) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithRateLimit limits the calls of each client with the limiter, see RateLimit
+func WithRateLimit(l *httpx.Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// NewServer returns a gRPC server serving the chat service, with reflection so tools like grpcurl can
+// list and call its methods. Messages can be as large as REST bodies, so attachments fit over both.
+func NewServer(svc pb.ChatServiceServer, opts ...Option) *grpc.Server {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{Logger(), Recovery(), Errors(), Client(o.keys)}
+	if o.limiter != nil {
+		interceptors = append(interceptors, RateLimit(o.limiter))
+	}
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.MaxRecvMsgSize(rest.MaxBodyBytes),
+	)
+	pb.RegisterChatServiceServer(server, svc)
+	reflection.Register(server)
+
+	return server
+}
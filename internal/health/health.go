@@ -0,0 +1,182 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timeout bounds each check, so a hanging dependency cannot hold probes
+const timeout = 3 * time.Second
+
+// Check is a dependency of the service. The service is not ready while a required check fails, the
+// others are only reported. Results are cached for TTL so probes do not load the dependency.
+type Check struct {
+	Name     string
+	Required bool
+	TTL      time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of the last run of a check
+type Result struct {
+	Name      string    `json:"name"`
+	Required  bool      `json:"required"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// probe runs a check, at most once per TTL
+type probe struct {
+	check Check
+
+	mu   sync.Mutex
+	last *Result
+}
+
+// Checker reports whether the service is alive, ready to serve traffic, and how its dependencies are doing
+type Checker struct {
+	probes   []*probe
+	draining atomic.Bool
+	now      func() time.Time
+}
+
+// New returns a checker of the given dependencies
+func New(checks ...Check) *Checker {
+	c := &Checker{now: time.Now}
+	for _, check := range checks {
+		c.probes = append(c.probes, &probe{check: check})
+	}
+	return c
+}
+
+// Drain marks the service as not ready, so load balancers stop sending it traffic before it shuts down
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether Drain was called
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Results runs the checks whose cached result expired, concurrently, and returns the result of every check
+func (c *Checker) Results(ctx context.Context) []Result {
+	results := make([]Result, len(c.probes))
+
+	var wg sync.WaitGroup
+	for i, p := range c.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Ready reports whether every required check passes and the service is not draining
+func (c *Checker) Ready(ctx context.Context) (bool, []Result) {
+	results := c.Results(ctx)
+	if c.Draining() {
+		return false, results
+	}
+
+	for _, r := range results {
+		if r.Required && !r.OK {
+			return false, results
+		}
+	}
+	return true, results
+}
+
+func (c *Checker) run(ctx context.Context, p *probe) Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last != nil && c.now().Sub(p.last.CheckedAt) < p.check.TTL {
+		return *p.last
+	}
+
+	// A probe giving up must not cancel the check for the probes waiting on its result
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	start := c.now()
+	err := p.check.Run(ctx)
+
+	result := Result{
+		Name:      p.check.Name,
+		Required:  p.check.Required,
+		OK:        err == nil,
+		LatencyMS: c.now().Sub(start).Milliseconds(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		slog.WarnContext(ctx, "Dependency check failed", "check", p.check.Name, "required", p.check.Required, "error", err)
+	}
+
+	p.last = &result
+	return result
+}
+
+// Liveness answers 200 as long as the process serves requests, it does not look at dependencies so a
+// failing database does not get the service restarted
+func (c *Checker) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+}
+
+// Readiness answers 200 when the service can serve traffic and 503 when a required dependency fails or
+// the service is draining, with the failing checks
+func (c *Checker) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ready, results := c.Ready(r.Context())
+		if ready {
+			write(w, http.StatusOK, map[string]any{"status": "ready"})
+			return
+		}
+
+		var failing []Result
+		for _, r := range results {
+			if r.Required && !r.OK {
+				failing = append(failing, r)
+			}
+		}
+
+		status := "unavailable"
+		if c.Draining() {
+			status = "draining"
+		}
+		write(w, http.StatusServiceUnavailable, map[string]any{"status": status, "failing": failing})
+	})
+}
+
+// Status answers 200 with the result and latency of every check, whether the service is ready or not
+func (c *Checker) Status() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ready, results := c.Ready(r.Context())
+		write(w, http.StatusOK, map[string]any{
+			"ready":        ready,
+			"draining":     c.Draining(),
+			"dependencies": results,
+		})
+	})
+}
+
+func write(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	now := time.Date(2025, 8, 20, 10, 0, 0, 0, time.UTC)
+
+	var dbErr, weatherErr error
+	var dbRuns atomic.Int32
+	c := New(
+		Check{Name: "mongo", Required: true, TTL: 10 * time.Second, Run: func(ctx context.Context) error {
+			dbRuns.Add(1)
+			return dbErr
+		}},
+		Check{Name: "weather", TTL: time.Minute, Run: func(ctx context.Context) error {
+			return weatherErr
+		}},
+	)
+	c.now = func() time.Time { return now }
+
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	t.Run("is ready when the required checks pass", func(t *testing.T) {
+		weatherErr = errors.New("weather is down")
+
+		if w := get(c.Readiness()); w.Code != http.StatusOK {
+			t.Errorf("expected an optional failure not to matter, got %d: %s", w.Code, w.Body)
+		}
+
+		var status struct {
+			Ready        bool     `json:"ready"`
+			Dependencies []Result `json:"dependencies"`
+		}
+		w := get(c.Status())
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("failed to decode status: %v", err)
+		}
+
+		if !status.Ready || len(status.Dependencies) != 2 || status.Dependencies[1].Error != "weather is down" {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("caches results until they expire", func(t *testing.T) {
+		dbErr = errors.New("no primary")
+
+		if w := get(c.Readiness()); w.Code != http.StatusOK || dbRuns.Load() != 1 {
+			t.Errorf("expected the cached result, got %d after %d runs", w.Code, dbRuns.Load())
+		}
+
+		now = now.Add(10 * time.Second)
+		if w := get(c.Readiness()); w.Code != http.StatusServiceUnavailable || dbRuns.Load() != 2 {
+			t.Errorf("expected the failure once the result expired, got %d after %d runs", w.Code, dbRuns.Load())
+		}
+	})
+
+	t.Run("stops being ready when draining but stays alive", func(t *testing.T) {
+		dbErr = nil
+		now = now.Add(10 * time.Second)
+		c.Drain()
+
+		w := get(c.Readiness())
+		var body struct {
+			Status string `json:"status"`
+		}
+		_ = json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusServiceUnavailable || body.Status != "draining" {
+			t.Errorf("expected 503 draining, got %d %q", w.Code, body.Status)
+		}
+
+		if w := get(c.Liveness()); w.Code != http.StatusOK {
+			t.Errorf("expected liveness to pass, got %d", w.Code)
+		}
+	})
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	c := New(Check{Name: "hanging", Required: true, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	// The caller giving up does not cancel the check, its own timeout does
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	results := c.Results(ctx)
+	if results[0].OK || time.Since(start) < timeout {
+		t.Errorf("expected the check to time out after %v, got %+v", timeout, results[0])
+	}
+}
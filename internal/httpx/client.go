@@ -0,0 +1,94 @@
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientKey struct{}
+
+// Keys is the set of API keys clients can identify with. Keys are stored hashed.
+type Keys struct {
+	hashes map[string]bool
+}
+
+// NewKeys returns the set of the given API keys, empty keys are ignored
+func NewKeys(keys ...string) *Keys {
+	k := &Keys{hashes: map[string]bool{}}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			k.hashes[hash(key)] = true
+		}
+	}
+	return k
+}
+
+// ClientID identifies the caller of a request: by API key when one of the keys is sent in the X-API-Key
+// header or as a bearer token, by IP address otherwise. Unknown keys are ignored, so callers cannot get a
+// fresh identity, and with it a fresh rate limit and quota, by making keys up.
+func (k *Keys) ClientID(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	return k.ClientIDFor(key, r.RemoteAddr)
+}
+
+// ClientIDFor identifies a caller by API key when it sent one of the keys, by the host of its address
+// otherwise, for transports other than HTTP. See ClientID.
+func (k *Keys) ClientIDFor(key, addr string) string {
+	if key = strings.TrimSpace(key); key != "" && k != nil && k.hashes[hash(key)] {
+		return keyPrefix + hash(key)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return "ip:" + host
+}
+
+// keyPrefix starts the ClientID of callers identified by API key
+const keyPrefix = "key:"
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Client stores the ClientID of the request in its context, see ClientFromContext. A nil set of keys
+// identifies every caller by IP address.
+func Client(keys *Keys) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r.WithContext(WithClient(r.Context(), keys.ClientID(r))))
+		})
+	}
+}
+
+// WithClient returns a context identifying the caller as client
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the caller stored by the Client middleware, or "anonymous"
+// for calls that did not come through it, like the MCP stdio transport
+func ClientFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(clientKey{}).(string); ok {
+		return id
+	}
+	return "anonymous"
+}
+
+// UserFromContext returns the caller when it authenticated with one of the API keys. Callers identified
+// by IP address are not users: everyone behind the same proxy shares the address.
+func UserFromContext(ctx context.Context) (string, bool) {
+	client := ClientFromContext(ctx)
+	return client, strings.HasPrefix(client, keyPrefix)
+}
@@ -0,0 +1,103 @@
+package httpx
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twitchtv/twirp"
+)
+
+// RateLimit limits each client, as identified by the Client middleware it must run after, to perMinute requests on average with bursts
+// of up to burst requests. Limited requests get a Twirp resource_exhausted error with status 429, the
+// number of seconds to wait is sent in the Retry-After header and in the retry_after error metadata.
+func RateLimit(perMinute float64, burst int) func(handler http.Handler) http.Handler {
+	return NewLimiter(perMinute, burst).Middleware
+}
+
+// NewLimiter returns a limiter allowing each client perMinute requests on average with bursts of up
+// to burst requests. It can be shared by several transports so clients get the same budget on all.
+func NewLimiter(perMinute float64, burst int) *Limiter {
+	return newLimiter(perMinute/60, float64(burst))
+}
+
+// Middleware limits the requests of each client, see RateLimit
+func (l *Limiter) Middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(ClientFromContext(r.Context())); !ok {
+			retryAfter := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			w.Header().Set("Retry-After", retryAfter)
+			_ = twirp.WriteError(w, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded").
+				WithMeta("retry_after", retryAfter))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Limiter keeps a token bucket per client
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens added per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate, burst float64) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   max(burst, 1),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the client's bucket, when it is empty it returns how long until the next token
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep forgets buckets that have refilled, they behave exactly like new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2025, 8, 20, 10, 0, 0, 0, time.UTC)
+
+	l := newLimiter(0.5, 2) // a token every 2 seconds
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("a")
+	if ok || wait != 2*time.Second {
+		t.Fatalf("expected to wait 2s once the burst is used, got %v and %v", ok, wait)
+	}
+
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("expected other clients not to be limited")
+	}
+
+	now = now.Add(2 * time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("expected the bucket to refill over time")
+	}
+
+	now = now.Add(time.Hour)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("expected idle buckets to be forgotten")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limited := RateLimit(60, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler := Client(NewKeys("first", "second"))(limited)
+
+	request := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/twirp/acai.chat.ChatService/StartConversation", nil)
+		r.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("first"); w.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", w.Code)
+	}
+
+	w := request("first")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After, got %d and %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if w := request("second"); w.Code != http.StatusOK {
+		t.Errorf("expected a different API key to have its own limit, got %d", w.Code)
+	}
+
+	if w := request(""); w.Code != http.StatusOK {
+		t.Fatalf("expected the first request without a key to pass, got %d", w.Code)
+	}
+
+	if w := request("made-up"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected an unknown key to share the limit of the IP address, got %d", w.Code)
+	}
+}
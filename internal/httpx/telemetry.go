@@ -1,7 +1,9 @@
 package httpx
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
@@ -91,6 +93,18 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// Hijack lets WebSocket handlers take over the connection, which is recorded as switching protocols
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", rw.ResponseWriter)
+	}
+
+	rw.statusCode = http.StatusSwitchingProtocols
+	rw.written = true
+	return h.Hijack()
+}
+
 // Middleware returns HTTP middleware that instruments requests with metrics and tracing
 func (t *Telemetry) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,84 @@
+package jobs
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Status string
+
+const (
+	// StatusPending jobs wait for their run_at time to be claimed by a worker
+	StatusPending Status = "pending"
+
+	// StatusRunning jobs are leased by a worker until leased_until, expired leases are claimed again
+	StatusRunning Status = "running"
+
+	// StatusDone jobs completed, they are removed after a week
+	StatusDone Status = "done"
+
+	// StatusDead jobs failed permanently or ran out of attempts, they are kept until requeued or removed
+	StatusDead Status = "dead"
+)
+
+// Job is a unit of background work stored in the jobs collection
+type Job struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Kind    string             `bson:"kind"`
+	Payload bson.Raw           `bson:"payload,omitempty"`
+
+	// Key deduplicates pending jobs of a kind, a job is not enqueued while one with the same key is pending
+	Key string `bson:"key,omitempty"`
+
+	// SkipDead is set by the SkipDead option, it is not stored
+	SkipDead bool `bson:"-"`
+
+	Status      Status    `bson:"status"`
+	Attempts    int       `bson:"attempts"`
+	MaxAttempts int       `bson:"max_attempts"`
+	RunAt       time.Time `bson:"run_at"`
+
+	LeasedBy    string    `bson:"leased_by,omitempty"`
+	LeasedUntil time.Time `bson:"leased_until,omitempty"`
+
+	// Lease is a token generated by each claim, only the worker holding it can update the job
+	Lease string `bson:"lease,omitempty"`
+
+	// LastError is the error of the last failed attempt
+	LastError string `bson:"last_error,omitempty"`
+
+	CreatedAt  time.Time  `bson:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v any) error {
+	if len(j.Payload) == 0 {
+		return errors.New("job has no payload")
+	}
+
+	return bson.Unmarshal(j.Payload, v)
+}
+
+// permanentError marks errors that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error returned by a handler to move the job to the dead letters without retrying it
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether a handler error was marked with Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
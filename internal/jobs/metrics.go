@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// metrics exports the outcome and duration of jobs by kind, and the number of jobs by status
+type metrics struct {
+	runs     metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newMetrics(q *Queue) *metrics {
+	meter := otel.Meter("acai-travel-chat-service")
+
+	runs, err := meter.Int64Counter(
+		"jobs.runs",
+		metric.WithDescription("Job runs by kind and outcome (done, retried, dead, released)"),
+		metric.WithUnit("{run}"),
+	)
+	if err != nil {
+		slog.Error("Failed to create job run counter", "error", err)
+		return nil
+	}
+
+	duration, err := meter.Float64Histogram(
+		"jobs.duration",
+		metric.WithDescription("Duration of job runs by kind"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		slog.Error("Failed to create job duration histogram", "error", err)
+		return nil
+	}
+
+	_, err = meter.Int64ObservableGauge(
+		"jobs.queued",
+		metric.WithDescription("Jobs in the queue by status (pending, running, dead)"),
+		metric.WithUnit("{job}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			counts, err := q.counts(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, status := range []Status{StatusPending, StatusRunning, StatusDead} {
+				o.Observe(counts[status], metric.WithAttributes(attribute.String("status", string(status))))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		slog.Error("Failed to create queued jobs gauge", "error", err)
+	}
+
+	return &metrics{runs: runs, duration: duration}
+}
+
+func (m *metrics) record(ctx context.Context, kind, outcome string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	byKind := attribute.String("kind", kind)
+	m.runs.Add(ctx, 1, metric.WithAttributes(byKind, attribute.String("outcome", outcome)))
+	m.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(byKind))
+}
+
+// counts returns the number of jobs by status, completed jobs are left out
+func (q *Queue) counts(ctx context.Context) (map[Status]int64, error) {
+	cursor, err := q.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"status": bson.M{"$ne": StatusDone}}},
+		bson.M{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Status Status `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[Status]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,492 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/twitchtv/twirp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collection = "jobs"
+
+	// defaultMaxAttempts is the number of times a job runs before it is dead-lettered
+	defaultMaxAttempts = 5
+
+	// maxBackoff caps the delay between attempts
+	maxBackoff = 10 * time.Minute
+
+	// doneRetention is how long completed jobs are kept
+	doneRetention = 7 * 24 * time.Hour
+)
+
+// Enqueuer stores jobs, it is implemented by Queue and lets packages enqueue jobs without running them
+type Enqueuer interface {
+	Enqueue(ctx context.Context, kind string, payload any, opts ...EnqueueOption) (primitive.ObjectID, error)
+}
+
+// Handler runs a job. Returned errors are retried with backoff unless wrapped with Permanent.
+type Handler func(ctx context.Context, job *Job) error
+
+// Queue stores jobs in MongoDB and runs them with the registered handlers. Workers lease the jobs
+// they claim and keep extending the lease while running them, jobs of workers that crashed are
+// claimed again once their lease expires.
+type Queue struct {
+	coll     *mongo.Collection
+	owner    string
+	handlers map[string]Handler
+
+	workers         int
+	pollInterval    time.Duration
+	lease           time.Duration
+	backoff         time.Duration
+	shutdownTimeout time.Duration
+
+	// wake signals idle workers that a job was enqueued by this process
+	wake    chan struct{}
+	metrics *metrics
+	now     func() time.Time
+}
+
+// Option configures a queue
+type Option func(*Queue)
+
+// WithWorkers sets the number of jobs run concurrently, 4 by default
+func WithWorkers(n int) Option {
+	return func(q *Queue) {
+		q.workers = n
+	}
+}
+
+// WithPollInterval sets how often idle workers look for jobs, 1 second by default
+func WithPollInterval(d time.Duration) Option {
+	return func(q *Queue) {
+		q.pollInterval = d
+	}
+}
+
+// WithLease sets how long a claimed job is reserved for its worker without a heartbeat, 1 minute by default
+func WithLease(d time.Duration) Option {
+	return func(q *Queue) {
+		q.lease = d
+	}
+}
+
+// WithBackoff sets the delay before the first retry, doubled on each attempt, 2 seconds by default
+func WithBackoff(d time.Duration) Option {
+	return func(q *Queue) {
+		q.backoff = d
+	}
+}
+
+// WithShutdownTimeout sets how long running jobs can finish after Run is stopped, 10 seconds by default.
+// Jobs still running then are cancelled and released for another worker.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(q *Queue) {
+		q.shutdownTimeout = d
+	}
+}
+
+func New(db *mongo.Database, opts ...Option) *Queue {
+	host, _ := os.Hostname()
+
+	q := &Queue{
+		coll:            db.Collection(collection),
+		owner:           fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+		handlers:        make(map[string]Handler),
+		workers:         4,
+		pollInterval:    time.Second,
+		lease:           time.Minute,
+		backoff:         2 * time.Second,
+		shutdownTimeout: 10 * time.Second,
+		wake:            make(chan struct{}, 1),
+		now:             time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	q.metrics = newMetrics(q)
+	return q
+}
+
+// Handle registers the handler of a kind of jobs, it must be called before Run
+func (q *Queue) Handle(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// EnqueueOption configures an enqueued job
+type EnqueueOption func(*Job)
+
+// WithKey skips enqueueing the job while another job of the same kind and key is pending
+func WithKey(key string) EnqueueOption {
+	return func(j *Job) {
+		j.Key = key
+	}
+}
+
+// SkipDead skips enqueueing the job, with WithKey, when a job of the same kind and key was dead-lettered,
+// so work that keeps failing is not tried again until the dead job is requeued or removed
+func SkipDead() EnqueueOption {
+	return func(j *Job) {
+		j.SkipDead = true
+	}
+}
+
+// WithDelay runs the job no earlier than after the delay
+func WithDelay(d time.Duration) EnqueueOption {
+	return func(j *Job) {
+		j.RunAt = j.RunAt.Add(d)
+	}
+}
+
+// WithMaxAttempts sets the number of times the job runs before it is dead-lettered, 5 by default
+func WithMaxAttempts(n int) EnqueueOption {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// Enqueue stores a job of the given kind, the payload is stored as BSON and read back with Job.Decode.
+// With WithKey it returns the ID of the pending job with the same key, if there is one. With SkipDead it
+// returns a zero ID when the job was skipped.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any, opts ...EnqueueOption) (primitive.ObjectID, error) {
+	now := q.now()
+	job := &Job{
+		ID:          primitive.NewObjectID(),
+		Kind:        kind,
+		Status:      StatusPending,
+		MaxAttempts: defaultMaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if payload != nil {
+		raw, err := bson.Marshal(payload)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("invalid payload: %w", err)
+		}
+		job.Payload = raw
+	}
+
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	defer q.notify()
+
+	if job.Key == "" {
+		_, err := q.coll.InsertOne(ctx, job)
+		return job.ID, err
+	}
+
+	if job.SkipDead {
+		n, err := q.coll.CountDocuments(ctx, bson.M{"kind": kind, "key": job.Key, "status": StatusDead}, options.Count().SetLimit(1))
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if n > 0 {
+			return primitive.NilObjectID, nil
+		}
+	}
+
+	var existing Job
+	err := q.coll.FindOneAndUpdate(ctx,
+		bson.M{"kind": kind, "key": job.Key, "status": StatusPending},
+		bson.M{"$setOnInsert": job},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&existing)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return existing.ID, nil
+}
+
+// Requeue gives a dead job a fresh set of attempts
+func (q *Queue) Requeue(ctx context.Context, id primitive.ObjectID) error {
+	now := q.now()
+	res, err := q.coll.UpdateOne(ctx,
+		bson.M{"_id": id, "status": StatusDead},
+		bson.M{"$set": bson.M{"status": StatusPending, "attempts": 0, "run_at": now, "updated_at": now}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return twirp.NotFoundError("dead job not found")
+	}
+
+	return nil
+}
+
+// DeadJobs lists the dead-lettered jobs, most recently failed first
+func (q *Queue) DeadJobs(ctx context.Context, limit int64) ([]*Job, error) {
+	cursor, err := q.coll.Find(ctx, bson.M{"status": StatusDead},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Job
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// EnsureIndexes creates the indexes used to claim jobs, and removes completed jobs after a week
+func (q *Queue) EnsureIndexes(ctx context.Context) error {
+	_, err := q.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "kind", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "key", Value: 1}, {Key: "status", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(doneRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Run claims and runs jobs until the context is done. It then stops claiming jobs and waits for the
+// running ones, up to the shutdown timeout.
+func (q *Queue) Run(ctx context.Context) {
+	// Handlers get a context that outlives ctx by the shutdown timeout, so they can finish their job
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(q.shutdownTimeout, cancelJobs)
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, jobCtx)
+		}()
+	}
+
+	slog.Info("Job queue started", "workers", q.workers, "kinds", len(q.handlers))
+	wg.Wait()
+	slog.Info("Job queue stopped")
+}
+
+func (q *Queue) work(ctx, jobCtx context.Context) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Failed to claim job", "error", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-q.wake:
+			case <-time.After(q.pollInterval):
+			}
+			continue
+		}
+
+		q.run(jobCtx, job)
+	}
+}
+
+// claim leases the next due job, or a running job whose worker stopped renewing its lease
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	now := q.now()
+	var job Job
+	err := q.coll.FindOneAndUpdate(ctx,
+		bson.M{
+			"kind": bson.M{"$in": kinds},
+			"$or": bson.A{
+				bson.M{"status": StatusPending, "run_at": bson.M{"$lte": now}},
+				bson.M{"status": StatusRunning, "leased_until": bson.M{"$lt": now}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":       StatusRunning,
+				"leased_by":    q.owner,
+				"leased_until": now.Add(q.lease),
+				"lease":        primitive.NewObjectID().Hex(),
+				"updated_at":   now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "run_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&job)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (q *Queue) run(ctx context.Context, job *Job) {
+	log := slog.With("job_id", job.ID.Hex(), "kind", job.Kind, "attempt", job.Attempts)
+
+	// A job claimed again after its worker died may have used up its attempts without failing
+	if job.Attempts > job.MaxAttempts {
+		q.finish(ctx, job, StatusDead, errors.New("lease expired on the last attempt"))
+		q.metrics.record(ctx, job.Kind, "dead", 0)
+		log.Error("Job moved to dead letters", "error", job.LastError)
+		return
+	}
+
+	heartbeat, stopHeartbeat := context.WithCancel(ctx)
+	go q.heartbeat(heartbeat, job)
+
+	start := q.now()
+	err := q.call(ctx, job)
+	stopHeartbeat()
+	elapsed := q.now().Sub(start)
+
+	switch {
+	case err == nil:
+		q.finish(ctx, job, StatusDone, nil)
+		q.metrics.record(ctx, job.Kind, "done", elapsed)
+		log.Info("Job done", "duration", elapsed)
+	case ctx.Err() != nil:
+		// Interrupted by the shutdown, another worker runs it again without counting this attempt
+		q.release(job)
+		q.metrics.record(ctx, job.Kind, "released", elapsed)
+		log.Warn("Job interrupted by shutdown, released", "error", err)
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		q.finish(ctx, job, StatusDead, err)
+		q.metrics.record(ctx, job.Kind, "dead", elapsed)
+		log.Error("Job moved to dead letters", "error", err)
+	default:
+		delay := q.retryDelay(job.Attempts)
+		q.retry(ctx, job, err, delay)
+		q.metrics.record(ctx, job.Kind, "retried", elapsed)
+		log.Warn("Job failed, retrying", "retry_in", delay, "error", err)
+	}
+}
+
+// call runs the handler, turning panics into errors so they are retried like failures
+func (q *Queue) call(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	}
+
+	return handler(ctx, job)
+}
+
+// heartbeat extends the lease of a running job until the context is done
+func (q *Queue) heartbeat(ctx context.Context, job *Job) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := q.coll.UpdateOne(ctx,
+				bson.M{"_id": job.ID, "lease": job.Lease},
+				bson.M{"$set": bson.M{"leased_until": q.now().Add(q.lease)}})
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to extend job lease", "job_id", job.ID.Hex(), "error", err)
+			}
+		}
+	}
+}
+
+// retryDelay doubles the backoff with each attempt, up to maxBackoff
+func (q *Queue) retryDelay(attempt int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
+
+func (q *Queue) finish(ctx context.Context, job *Job, status Status, cause error) {
+	now := q.now()
+	set := bson.M{"status": status, "updated_at": now}
+	if status == StatusDone {
+		set["finished_at"] = now
+	}
+	if cause != nil {
+		set["last_error"] = cause.Error()
+		job.LastError = cause.Error()
+	}
+
+	q.update(ctx, job, bson.M{"$set": set, "$unset": bson.M{"leased_by": "", "leased_until": "", "lease": ""}})
+}
+
+func (q *Queue) retry(ctx context.Context, job *Job, cause error, delay time.Duration) {
+	now := q.now()
+	q.update(ctx, job, bson.M{
+		"$set":   bson.M{"status": StatusPending, "run_at": now.Add(delay), "last_error": cause.Error(), "updated_at": now},
+		"$unset": bson.M{"leased_by": "", "leased_until": "", "lease": ""},
+	})
+}
+
+// release returns an interrupted job to the queue, it runs on a fresh context as the job's is cancelled
+func (q *Queue) release(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := q.now()
+	q.update(ctx, job, bson.M{
+		"$set":   bson.M{"status": StatusPending, "run_at": now, "updated_at": now},
+		"$inc":   bson.M{"attempts": -1},
+		"$unset": bson.M{"leased_by": "", "leased_until": "", "lease": ""},
+	})
+}
+
+// update changes a job this worker still holds the lease of. The lease token is checked rather than the
+// owner, which all workers of the process share, so a worker whose lease expired and was claimed again
+// by another worker of the process cannot update the job.
+func (q *Queue) update(ctx context.Context, job *Job, update bson.M) {
+	res, err := q.coll.UpdateOne(ctx, bson.M{"_id": job.ID, "lease": job.Lease}, update)
+	if err != nil {
+		slog.Error("Failed to update job", "job_id", job.ID.Hex(), "error", err)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		slog.Warn("Job lease was lost before it was updated", "job_id", job.ID.Hex())
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
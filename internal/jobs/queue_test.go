@@ -0,0 +1,269 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	chattesting "github.com/acai-travel/tech-challenge/internal/chat/testing"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type greeting struct {
+	Name string `bson:"name"`
+}
+
+func newTestQueue(t *testing.T) (*Queue, string) {
+	t.Helper()
+
+	q := New(chattesting.ConnectMongo(), WithPollInterval(10*time.Millisecond), WithBackoff(time.Millisecond))
+	kind := "test-" + uuid.NewString()
+
+	t.Cleanup(func() {
+		_, _ = q.coll.DeleteMany(context.Background(), bson.M{"kind": kind})
+	})
+
+	return q, kind
+}
+
+func start(t *testing.T, q *Queue) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func status(t *testing.T, q *Queue, id primitive.ObjectID) *Job {
+	t.Helper()
+
+	var job Job
+	if err := q.coll.FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	return &job
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries failed jobs until they succeed", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+
+		done := make(chan string, 1)
+		q.Handle(kind, func(ctx context.Context, job *Job) error {
+			if job.Attempts < 3 {
+				return errors.New("not yet")
+			}
+
+			var g greeting
+			if err := job.Decode(&g); err != nil {
+				return err
+			}
+			done <- g.Name
+			return nil
+		})
+
+		id, err := q.Enqueue(ctx, kind, greeting{Name: "Lisbon"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		start(t, q)
+
+		select {
+		case name := <-done:
+			if name != "Lisbon" {
+				t.Errorf("expected the payload to be decoded, got %q", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the job")
+		}
+
+		// The handler returned before the job was marked done
+		time.Sleep(100 * time.Millisecond)
+		if job := status(t, q, id); job.Status != StatusDone || job.Attempts != 3 || job.FinishedAt == nil {
+			t.Errorf("expected the job to be done after 3 attempts, got %s after %d", job.Status, job.Attempts)
+		}
+	})
+
+	t.Run("dead-letters permanent failures and requeues them", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+
+		runs := make(chan int, 2)
+		q.Handle(kind, func(ctx context.Context, job *Job) error {
+			runs <- job.Attempts
+			return Permanent(errors.New("bad payload"))
+		})
+
+		id, err := q.Enqueue(ctx, kind, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		start(t, q)
+		<-runs
+
+		deadline := time.Now().Add(5 * time.Second)
+		for status(t, q, id).Status != StatusDead {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the job to be dead-lettered")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if job := status(t, q, id); job.LastError != "bad payload" || job.Attempts != 1 {
+			t.Errorf("expected a single attempt with its error, got %d attempts and %q", job.Attempts, job.LastError)
+		}
+
+		if err := q.Requeue(ctx, id); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		select {
+		case attempt := <-runs:
+			if attempt != 1 {
+				t.Errorf("expected the requeued job to start over, got attempt %d", attempt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the requeued job")
+		}
+	})
+
+	t.Run("deduplicates pending jobs by key", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+
+		first, err := q.Enqueue(ctx, kind, nil, WithKey("conversation"), WithDelay(time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		second, err := q.Enqueue(ctx, kind, nil, WithKey("conversation"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if first != second {
+			t.Errorf("expected the pending job %s, got %s", first.Hex(), second.Hex())
+		}
+	})
+
+	t.Run("skips keys with dead jobs when asked to", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+
+		dead := &Job{ID: primitive.NewObjectID(), Kind: kind, Key: "conversation", Status: StatusDead}
+		if _, err := q.coll.InsertOne(ctx, dead); err != nil {
+			t.Fatalf("failed to insert dead job: %v", err)
+		}
+
+		if id, err := q.Enqueue(ctx, kind, nil, WithKey("conversation"), SkipDead()); err != nil || !id.IsZero() {
+			t.Errorf("expected the job to be skipped, got %s, %v", id.Hex(), err)
+		}
+
+		if id, err := q.Enqueue(ctx, kind, nil, WithKey("conversation")); err != nil || id.IsZero() {
+			t.Errorf("expected the job to be queued without SkipDead, got %s, %v", id.Hex(), err)
+		}
+	})
+
+	t.Run("only lets the latest claim update a job", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+		q.Handle(kind, func(ctx context.Context, job *Job) error { return nil })
+
+		id, err := q.Enqueue(ctx, kind, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		first, err := q.claim(ctx)
+		if err != nil || first == nil || first.ID != id {
+			t.Fatalf("expected the job to be claimed, got %v, %v", first, err)
+		}
+
+		// The lease expires and another worker of the same process claims the job again
+		q.now = func() time.Time { return time.Now().Add(2 * q.lease) }
+		second, err := q.claim(ctx)
+		if err != nil || second == nil || second.Lease == first.Lease {
+			t.Fatalf("expected the job to be claimed with a new lease, got %v, %v", second, err)
+		}
+
+		q.finish(ctx, first, StatusDone, nil)
+		if job := status(t, q, id); job.Status != StatusRunning || job.Lease != second.Lease {
+			t.Errorf("expected the expired claim not to finish the job, got %s", job.Status)
+		}
+
+		q.finish(ctx, second, StatusDone, nil)
+		if job := status(t, q, id); job.Status != StatusDone {
+			t.Errorf("expected the latest claim to finish the job, got %s", job.Status)
+		}
+	})
+
+	t.Run("releases jobs interrupted by the shutdown", func(t *testing.T) {
+		q, kind := newTestQueue(t)
+		q.shutdownTimeout = 10 * time.Millisecond
+
+		running := make(chan struct{})
+		q.Handle(kind, func(ctx context.Context, job *Job) error {
+			close(running)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		id, err := q.Enqueue(ctx, kind, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		runCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			q.Run(runCtx)
+		}()
+
+		<-running
+		cancel()
+		<-stopped
+
+		if job := status(t, q, id); job.Status != StatusPending || job.Attempts != 0 {
+			t.Errorf("expected the job to be pending again without using an attempt, got %s after %d", job.Status, job.Attempts)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	q := &Queue{backoff: 2 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{
+		1:  2 * time.Second,
+		2:  4 * time.Second,
+		4:  16 * time.Second,
+		20: maxBackoff,
+	} {
+		if got := q.retryDelay(attempt); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, want, got)
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	err := Permanent(errors.New("conversation not found"))
+
+	if !IsPermanent(err) || !IsPermanent(errors.Join(errors.New("title"), err)) {
+		t.Error("expected the error to be permanent")
+	}
+
+	if IsPermanent(errors.New("timeout")) {
+		t.Error("expected other errors to be retried")
+	}
+}
@@ -0,0 +1,360 @@
+// Package jsonschema validates JSON documents against the subset of JSON Schema
+// used for tool parameters and structured outputs: type, properties, required,
+// additionalProperties, items, enum, const, anyOf, string length/pattern/format,
+// numeric bounds and array length.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON schema
+type Schema struct {
+	root     map[string]any
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile prepares a schema for validation. The schema can be any value that
+// marshals to a JSON object, e.g. map[string]any or openai.FunctionParameters.
+func Compile(schema any) (*Schema, error) {
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	if root == nil {
+		root = map[string]any{}
+	}
+
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Schema) compilePatterns(node any) error {
+	switch n := node.(type) {
+	case map[string]any:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("invalid schema pattern %q: %w", p, err)
+			}
+			s.patterns[p] = re
+		}
+		for _, v := range n {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range n {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks a JSON document against the schema.
+// It returns Errors when the document does not conform.
+func (s *Schema) Validate(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return Errors{{Path: "", Message: "invalid JSON: " + err.Error()}}
+	}
+
+	if dec.More() {
+		return Errors{{Path: "", Message: "invalid JSON: unexpected data after top-level value"}}
+	}
+
+	if errs := s.validate(s.root, v, ""); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Error describes a single violation, Path is a dotted path to the offending value
+type Error struct {
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of violations found in a document
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		if err.Path == "" {
+			msgs[i] = err.Message
+		} else {
+			msgs[i] = err.Path + ": " + err.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (s *Schema) validate(schema map[string]any, v any, path string) Errors {
+	var errs Errors
+	fail := func(format string, args ...any) {
+		errs = append(errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+		fail("must be of type %s, got %s", strings.Join(types, " or "), typeOf(v))
+		return errs
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return equal(e, v) }) {
+		fail("must be one of %s", formatValues(enum))
+	}
+
+	if c, ok := schema["const"]; ok && !equal(c, v) {
+		fail("must be %s", formatValues([]any{c}))
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if m, ok := sub.(map[string]any); ok && len(s.validate(m, v, path)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one of the allowed schemas")
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		errs = append(errs, s.validateString(schema, val, path)...)
+	case json.Number:
+		errs = append(errs, validateNumber(schema, val, path)...)
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(val)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(val)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range val {
+				errs = append(errs, s.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]any:
+		errs = append(errs, s.validateObject(schema, val, path)...)
+	}
+
+	return errs
+}
+
+func (s *Schema) validateString(schema map[string]any, v, path string) Errors {
+	var errs Errors
+	fail := func(format string, args ...any) {
+		errs = append(errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := float64(utf8.RuneCountInString(v))
+	if n, ok := number(schema["minLength"]); ok && length < n {
+		if n == 1 {
+			fail("must not be empty")
+		} else {
+			fail("must be at least %v characters long", n)
+		}
+	}
+
+	if n, ok := number(schema["maxLength"]); ok && length > n {
+		fail("must be at most %v characters long", n)
+	}
+
+	if p, ok := schema["pattern"].(string); ok && !s.patterns[p].MatchString(v) {
+		fail("must match pattern %q", p)
+	}
+
+	switch schema["format"] {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			fail("must be an RFC3339 date-time like 2025-12-25T00:00:00Z, got %q", v)
+		}
+	case "date":
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			fail("must be a date like 2025-12-25, got %q", v)
+		}
+	}
+
+	return errs
+}
+
+func validateNumber(schema map[string]any, v json.Number, path string) Errors {
+	var errs Errors
+	fail := func(format string, args ...any) {
+		errs = append(errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	f, _ := v.Float64()
+
+	if n, ok := number(schema["minimum"]); ok && f < n {
+		fail("must be greater than or equal to %v", n)
+	}
+	if n, ok := number(schema["maximum"]); ok && f > n {
+		fail("must be less than or equal to %v", n)
+	}
+	if n, ok := number(schema["exclusiveMinimum"]); ok && f <= n {
+		fail("must be greater than %v", n)
+	}
+	if n, ok := number(schema["exclusiveMaximum"]); ok && f >= n {
+		fail("must be less than %v", n)
+	}
+
+	return errs
+}
+
+func (s *Schema) validateObject(schema map[string]any, v map[string]any, path string) Errors {
+	var errs Errors
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; !ok {
+				errs = append(errs, Error{Path: join(path, name), Message: "is required"})
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+
+	// Iterate in a stable order so error messages are deterministic
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		if prop, ok := properties[k].(map[string]any); ok {
+			errs = append(errs, s.validate(prop, v[k], join(path, k))...)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, Error{Path: join(path, k), Message: "is not a known property"})
+			}
+		case map[string]any:
+			errs = append(errs, s.validate(additional, v[k], join(path, k))...)
+		}
+	}
+
+	return errs
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	default:
+		return typeOf(v) == t || (t == "number" && typeOf(v) == "integer")
+	}
+}
+
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// number reads a numeric schema keyword, which is a float64 after compilation
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// equal compares a schema value with a document value
+func equal(schemaValue, v any) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		sf, sok := schemaValue.(float64)
+		return err == nil && sok && f == sf
+	}
+
+	a, _ := json.Marshal(schemaValue)
+	b, _ := json.Marshal(v)
+	return bytes.Equal(a, b)
+}
+
+func formatValues(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,89 @@
+package jsonschema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := Compile(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"city":   map[string]any{"type": "string", "minLength": 1},
+			"code":   map[string]any{"type": "string", "pattern": "^[A-Z]{3}$"},
+			"nights": map[string]any{"type": "integer", "minimum": 1, "maximum": 30},
+			"when":   map[string]any{"type": "string", "format": "date-time"},
+			"class":  map[string]any{"enum": []string{"economy", "business"}},
+			"tags":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": 2},
+			"budget": map[string]any{"type": []string{"number", "null"}},
+			"stop": map[string]any{
+				"type":                 "object",
+				"properties":           map[string]any{"airport": map[string]any{"type": "string"}},
+				"required":             []string{"airport"},
+				"additionalProperties": false,
+			},
+		},
+		"required": []string{"city"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		document string
+		errors   []string
+	}{
+		{name: "valid document", document: `{"city":"Porto","code":"OPO","nights":3,"when":"2025-06-01T10:00:00Z","class":"economy","tags":["beach"],"budget":null,"stop":{"airport":"LIS"}}`},
+		{name: "number accepted for nullable number", document: `{"city":"Porto","budget":120.5}`},
+		{name: "missing required property", document: `{}`, errors: []string{"city: is required"}},
+		{name: "empty string", document: `{"city":""}`, errors: []string{"city: must not be empty"}},
+		{name: "wrong type", document: `{"city":42}`, errors: []string{"city: must be of type string, got integer"}},
+		{name: "pattern mismatch", document: `{"city":"Porto","code":"opo"}`, errors: []string{`code: must match pattern "^[A-Z]{3}$"`}},
+		{name: "fractional integer", document: `{"city":"Porto","nights":1.5}`, errors: []string{"nights: must be of type integer, got number"}},
+		{name: "out of range", document: `{"city":"Porto","nights":31}`, errors: []string{"nights: must be less than or equal to 30"}},
+		{name: "invalid date-time", document: `{"city":"Porto","when":"2025-06-01"}`, errors: []string{"when: must be an RFC3339 date-time"}},
+		{name: "not in enum", document: `{"city":"Porto","class":"first"}`, errors: []string{`class: must be one of "economy", "business"`}},
+		{name: "invalid array item", document: `{"city":"Porto","tags":["a",1]}`, errors: []string{"tags[1]: must be of type string"}},
+		{name: "too many items", document: `{"city":"Porto","tags":["a","b","c"]}`, errors: []string{"tags: must have at most 2 items"}},
+		{name: "nested object", document: `{"city":"Porto","stop":{"gate":"A1"}}`, errors: []string{"stop.airport: is required", "stop.gate: is not a known property"}},
+		{name: "invalid JSON", document: `{"city":`, errors: []string{"invalid JSON"}},
+		{name: "trailing data", document: `{"city":"Porto"} {}`, errors: []string{"unexpected data after top-level value"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.document))
+
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation errors, got %v", err)
+			}
+
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("expected %d errors, got %d: %v", len(tt.errors), len(errs), errs)
+			}
+
+			for i, want := range tt.errors {
+				if got := errs[i:][:1].Error(); !strings.Contains(got, want) {
+					t.Errorf("expected error %q, got %q", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_InvalidPattern(t *testing.T) {
+	_, err := Compile(map[string]any{"type": "string", "pattern": "("})
+	if err == nil {
+		t.Fatal("expected error for invalid pattern, got nil")
+	}
+}
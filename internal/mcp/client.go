@@ -0,0 +1,109 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// transport delivers JSON-RPC messages to a server
+type transport interface {
+	// roundTrip sends a request and waits for its response
+	roundTrip(ctx context.Context, req *Message) (*Message, error)
+
+	// notify sends a notification, which has no response
+	notify(ctx context.Context, msg *Message) error
+
+	close() error
+}
+
+// Client is a session with a single MCP server
+type Client struct {
+	transport transport
+	nextID    atomic.Int64
+	server    InitializeResult
+}
+
+// Initialize performs the protocol handshake, it must be called before any other method
+func (c *Client) Initialize(ctx context.Context) error {
+	params := InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      Implementation{Name: "acai-chat", Version: "1.0.0"},
+	}
+
+	if err := c.call(ctx, "initialize", params, &c.server); err != nil {
+		return fmt.Errorf("failed to initialize session: %w", err)
+	}
+
+	return c.transport.notify(ctx, &Message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// Server returns the server information received during initialization
+func (c *Client) Server() Implementation {
+	return c.server.ServerInfo
+}
+
+// ListTools returns all tools exposed by the server
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	var cursor string
+
+	for {
+		var page ListToolsResult
+		if err := c.call(ctx, "tools/list", ListToolsParams{Cursor: cursor}, &page); err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool invokes a tool with JSON encoded arguments
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*CallToolResult, error) {
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", CallToolParams{Name: name, Arguments: arguments}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Close terminates the session
+func (c *Client) Close() error {
+	return c.transport.close()
+}
+
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode params: %w", err)
+	}
+
+	req := &Message{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10)),
+		Method:  method,
+		Params:  raw,
+	}
+
+	resp, err := c.transport.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+
+	return nil
+}
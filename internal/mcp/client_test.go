@@ -0,0 +1,172 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestMain lets the test binary act as a stdio MCP server for TestStdioClient
+func TestMain(m *testing.M) {
+	if os.Getenv("MCP_FAKE_SERVER") == "1" {
+		serveFakeStdio(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeResult answers requests the way a minimal MCP server with a single "echo" tool would
+func fakeResult(msg *Message) (any, *Error) {
+	switch msg.Method {
+	case "initialize":
+		return InitializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities:    map[string]any{"tools": map[string]any{}},
+			ServerInfo:      Implementation{Name: "fake", Version: "0.0.1"},
+		}, nil
+	case "tools/list":
+		var params ListToolsParams
+		_ = json.Unmarshal(msg.Params, &params)
+
+		// Serve two pages to exercise pagination
+		if params.Cursor == "" {
+			return ListToolsResult{Tools: []Tool{{Name: "echo", InputSchema: map[string]any{"type": "object"}}}, NextCursor: "2"}, nil
+		}
+		return ListToolsResult{Tools: []Tool{{Name: "secret", InputSchema: map[string]any{"type": "object"}}}}, nil
+	case "tools/call":
+		var params CallToolParams
+		_ = json.Unmarshal(msg.Params, &params)
+
+		if params.Name != "echo" {
+			return CallToolResult{Content: []Content{TextContent("unknown tool")}, IsError: true}, nil
+		}
+		return CallToolResult{Content: []Content{TextContent("echo: " + string(params.Arguments))}}, nil
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: msg.Method}
+	}
+}
+
+func fakeResponse(msg *Message) *Message {
+	result, rpcErr := fakeResult(msg)
+	resp := &Message{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr}
+	if rpcErr == nil {
+		resp.Result, _ = json.Marshal(result)
+	}
+	return resp
+}
+
+func serveFakeStdio(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	enc := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || !msg.IsRequest() {
+			continue
+		}
+		_ = enc.Encode(fakeResponse(&msg))
+	}
+}
+
+func exerciseClient(t *testing.T, c *Client) {
+	ctx := context.Background()
+
+	if err := c.Initialize(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := c.Server().Name; got != "fake" {
+		t.Errorf("expected server name 'fake', got %q", got)
+	}
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tools) != 2 || tools[0].Name != "echo" || tools[1].Name != "secret" {
+		t.Errorf("expected tools from both pages, got %+v", tools)
+	}
+
+	result, err := c.CallTool(ctx, "echo", json.RawMessage(`{"x":1}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Content) != 1 || result.Content[0].Text != `echo: {"x":1}` {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestStdioClient(t *testing.T) {
+	t.Setenv("MCP_FAKE_SERVER", "1")
+
+	c, err := NewStdioClient([]string{os.Args[0]}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	exerciseClient(t, c)
+}
+
+func TestHTTPClient(t *testing.T) {
+	var sessions, deleted int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted++
+			return
+		}
+
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if msg.Method == "initialize" {
+			sessions++
+			w.Header().Set(SessionHeader, "session-1")
+		} else if r.Header.Get(SessionHeader) != "session-1" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+
+		if msg.IsNotification() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		data, _ := json.Marshal(fakeResponse(&msg))
+
+		// Answer tool calls as an event stream, everything else as plain JSON
+		if msg.Method == "tools/call" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	c := NewHTTPClient(srv.URL, nil)
+	exerciseClient(t, c)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sessions != 1 || deleted != 1 {
+		t.Errorf("expected one session to be opened and terminated, got %d opened and %d terminated", sessions, deleted)
+	}
+}
@@ -0,0 +1,181 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SessionHeader carries the session ID on streamable HTTP transports
+const SessionHeader = "Mcp-Session-Id"
+
+// NewHTTPClient talks to a server using the streamable HTTP transport.
+// Header values are expanded from the environment.
+func NewHTTPClient(url string, headers map[string]string) *Client {
+	expanded := make(map[string]string, len(headers))
+	for k, v := range headers {
+		expanded[k] = os.ExpandEnv(v)
+	}
+
+	return &Client{transport: &httpTransport{
+		url:     url,
+		headers: expanded,
+		client:  &http.Client{},
+	}}
+}
+
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, req *Message) (*Message, error) {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var msg Message
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			return nil, fmt.Errorf("mcp: failed to decode response: %w", err)
+		}
+		return &msg, nil
+	case "text/event-stream":
+		return readEventStream(resp.Body, req.ID)
+	default:
+		return nil, fmt.Errorf("mcp: unexpected content type %q", mediaType)
+	}
+}
+
+func (t *httpTransport) notify(ctx context.Context, msg *Message) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (t *httpTransport) post(ctx context.Context, msg *Message) (*http.Response, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.setHeaders(req)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mcp: request failed: %w", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("mcp: server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if id := resp.Header.Get(SessionHeader); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+
+	return resp, nil
+}
+
+func (t *httpTransport) setHeaders(req *http.Request) {
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessionID != "" {
+		req.Header.Set(SessionHeader, t.sessionID)
+	}
+}
+
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+
+	if sessionID == "" {
+		return nil
+	}
+
+	// Servers may not support explicit termination, so the outcome is ignored
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+
+	t.setHeaders(req)
+	if resp, err := t.client.Do(req); err == nil {
+		_ = resp.Body.Close()
+	}
+
+	return nil
+}
+
+// readEventStream reads server-sent events until the response with the given ID arrives
+func readEventStream(r io.Reader, id json.RawMessage) (*Message, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line != "" {
+			if v, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(v, " "))
+			}
+			continue
+		}
+
+		// A blank line terminates an event
+		if data.Len() == 0 {
+			continue
+		}
+
+		var msg Message
+		err := json.Unmarshal([]byte(data.String()), &msg)
+		data.Reset()
+
+		if err == nil && msg.IsResponse() && bytes.Equal(msg.ID, id) {
+			return &msg, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("mcp: failed to read event stream: %w", err)
+	}
+
+	return nil, errors.New("mcp: event stream ended without a response")
+}
@@ -0,0 +1,209 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// ToolHandler executes a tool call received by the server
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (*CallToolResult, error)
+
+// Server exposes tools to MCP clients
+type Server struct {
+	info     Implementation
+	tools    []Tool
+	handlers map[string]ToolHandler
+
+	mu       sync.Mutex
+	sessions map[string]struct{}
+}
+
+// NewServer creates a server without any tools
+func NewServer(name, version string) *Server {
+	return &Server{
+		info:     Implementation{Name: name, Version: version},
+		handlers: make(map[string]ToolHandler),
+		sessions: make(map[string]struct{}),
+	}
+}
+
+// AddTool registers a tool and its handler
+func (s *Server) AddTool(tool Tool, handler ToolHandler) {
+	if tool.InputSchema == nil {
+		tool.InputSchema = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+
+	s.tools = append(s.tools, tool)
+	s.handlers[tool.Name] = handler
+}
+
+// Handle processes a single message and returns the response, or nil for notifications
+func (s *Server) Handle(ctx context.Context, msg *Message) *Message {
+	if !msg.IsRequest() {
+		return nil
+	}
+
+	result, err := s.dispatch(ctx, msg)
+	resp := &Message{JSONRPC: "2.0", ID: msg.ID}
+
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	raw, merr := json.Marshal(result)
+	if merr != nil {
+		resp.Error = &Error{Code: CodeInternalError, Message: merr.Error()}
+		return resp
+	}
+
+	resp.Result = raw
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, msg *Message) (any, *Error) {
+	switch msg.Method {
+	case "initialize":
+		return InitializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities:    map[string]any{"tools": map[string]any{}},
+			ServerInfo:      s.info,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return ListToolsResult{Tools: s.tools}, nil
+	case "tools/call":
+		var params CallToolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+		}
+
+		handler, ok := s.handlers[params.Name]
+		if !ok {
+			return nil, &Error{Code: CodeInvalidParams, Message: "unknown tool: " + params.Name}
+		}
+
+		result, err := handler(ctx, params.Arguments)
+		if err != nil {
+			// Tool failures are reported to the model as results, not protocol errors
+			return CallToolResult{Content: []Content{TextContent(err.Error())}, IsError: true}, nil
+		}
+		return result, nil
+	default:
+		return nil, &Error{Code: CodeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+}
+
+// ServeStdio serves newline delimited messages until r is exhausted or ctx is done.
+// Requests are handled concurrently, responses are written as they complete.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	var wg sync.WaitGroup
+	var wmu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	write := func(msg *Message) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		if err := enc.Encode(msg); err != nil {
+			slog.ErrorContext(ctx, "Failed to write MCP response", "error", err)
+		}
+	}
+
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			write(&Message{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := s.Handle(ctx, &msg); resp != nil {
+				write(resp)
+			}
+		}()
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+	return scanner.Err()
+}
+
+// ServeHTTP implements the streamable HTTP transport, answering every request with a single JSON response
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.sessions, r.Header.Get(SessionHeader))
+		s.mu.Unlock()
+		return
+	default:
+		// Server initiated streams are not supported
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg Message
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxLineSize)).Decode(&msg); err != nil {
+		http.Error(w, "invalid JSON-RPC message", http.StatusBadRequest)
+		return
+	}
+
+	if msg.Method == "initialize" {
+		id, err := newSessionID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.mu.Lock()
+		s.sessions[id] = struct{}{}
+		s.mu.Unlock()
+
+		w.Header().Set(SessionHeader, id)
+	} else {
+		s.mu.Lock()
+		_, ok := s.sessions[r.Header.Get(SessionHeader)]
+		s.mu.Unlock()
+
+		if !ok {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+	}
+
+	resp := s.Handle(r.Context(), &msg)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer() *Server {
+	srv := NewServer("test", "0.0.1")
+
+	srv.AddTool(Tool{Name: "upper"}, func(ctx context.Context, arguments json.RawMessage) (*CallToolResult, error) {
+		var args struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return nil, err
+		}
+		return &CallToolResult{Content: []Content{TextContent(strings.ToUpper(args.Text))}}, nil
+	})
+
+	srv.AddTool(Tool{Name: "broken"}, func(ctx context.Context, arguments json.RawMessage) (*CallToolResult, error) {
+		return nil, errors.New("something went wrong")
+	})
+
+	return srv
+}
+
+func TestServer_HTTP(t *testing.T) {
+	ctx := context.Background()
+
+	httpSrv := httptest.NewServer(newTestServer())
+	defer httpSrv.Close()
+
+	c := NewHTTPClient(httpSrv.URL, nil)
+	defer c.Close()
+
+	if err := c.Initialize(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tools) != 2 || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("expected two tools with default schema, got %+v", tools)
+	}
+
+	t.Run("calls tool", func(t *testing.T) {
+		result, err := c.CallTool(ctx, "upper", json.RawMessage(`{"text":"porto"}`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if result.IsError || result.Content[0].Text != "PORTO" {
+			t.Errorf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("reports tool failures as results", func(t *testing.T) {
+		result, err := c.CallTool(ctx, "broken", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !result.IsError || result.Content[0].Text != "something went wrong" {
+			t.Errorf("expected error result, got %+v", result)
+		}
+	})
+
+	t.Run("rejects unknown tools", func(t *testing.T) {
+		_, err := c.CallTool(ctx, "missing", nil)
+
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+			t.Errorf("expected invalid params error, got %v", err)
+		}
+	})
+}
+
+func TestServer_ServeStdio(t *testing.T) {
+	in := strings.NewReader(strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"upper","arguments":{"text":"faro"}}}`,
+	}, "\n"))
+
+	var out bytes.Buffer
+	if err := newTestServer().ServeStdio(context.Background(), in, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	responses := map[string]Message{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid response %q: %v", line, err)
+		}
+		responses[string(msg.ID)] = msg
+	}
+
+	if len(responses) != 2 {
+		t.Fatalf("expected responses to both requests only, got %d", len(responses))
+	}
+
+	var result CallToolResult
+	_ = json.Unmarshal(responses["2"].Result, &result)
+	if len(result.Content) != 1 || result.Content[0].Text != "FARO" {
+		t.Errorf("unexpected tool result %s", responses["2"].Result)
+	}
+}
@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// maxLineSize bounds a single JSON-RPC message on stdio transports
+const maxLineSize = 16 << 20
+
+// NewStdioClient starts the server process and talks to it over stdin/stdout.
+// The process is stopped when the client is closed.
+func NewStdioClient(command []string, env map[string]string) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("mcp: empty command")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mcp: failed to start %s: %w", command[0], err)
+	}
+
+	t := &stdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[string]chan *Message),
+		done:    make(chan struct{}),
+	}
+
+	go t.readLoop(stdout)
+
+	return &Client{transport: t}, nil
+}
+
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	wmu   sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *Message
+	done    chan struct{}
+	err     error
+}
+
+func (t *stdioTransport) roundTrip(ctx context.Context, req *Message) (*Message, error) {
+	ch := make(chan *Message, 1)
+
+	t.mu.Lock()
+	t.pending[string(req.ID)] = ch
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, string(req.ID))
+		t.mu.Unlock()
+	}()
+
+	if err := t.write(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		return nil, fmt.Errorf("mcp: server exited: %w", t.err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) notify(_ context.Context, msg *Message) error {
+	return t.write(msg)
+}
+
+func (t *stdioTransport) write(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	if _, err := t.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("mcp: failed to write to server: %w", err)
+	}
+
+	return nil
+}
+
+func (t *stdioTransport) readLoop(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			slog.Warn("Ignoring malformed MCP message", "error", err)
+			continue
+		}
+
+		switch {
+		case msg.IsResponse():
+			t.mu.Lock()
+			ch, ok := t.pending[string(msg.ID)]
+			t.mu.Unlock()
+
+			if ok {
+				ch <- &msg
+			}
+		case msg.IsRequest():
+			// The client does not offer any capabilities, only pings are answered
+			resp := &Message{JSONRPC: "2.0", ID: msg.ID}
+			if msg.Method == "ping" {
+				resp.Result = json.RawMessage("{}")
+			} else {
+				resp.Error = &Error{Code: CodeMethodNotFound, Message: "method not found: " + msg.Method}
+			}
+			_ = t.write(resp)
+		}
+	}
+
+	t.err = scanner.Err()
+	if t.err == nil {
+		t.err = io.EOF
+	}
+	close(t.done)
+}
+
+func (t *stdioTransport) close() error {
+	_ = t.stdin.Close()
+
+	select {
+	case <-t.done:
+	case <-time.After(2 * time.Second):
+		_ = t.cmd.Process.Kill()
+	}
+
+	_ = t.cmd.Wait()
+	return nil
+}
@@ -32,18 +32,63 @@ const _ = twirp.TwirpPackageMinVersion_8_1_0
 // =====================
 
 type ChatService interface {
-	// create a new conversation by sending a message and getting a reply
+	// Create a new conversation by sending a message and getting a reply
 	// use ContinueConversation with the returned conversation_id to continue the conversation
 	StartConversation(context.Context, *StartConversationRequest) (*StartConversationResponse, error)
 
-	// continue an existing conversation by adding a new message and getting a reply
+	// Continue an existing conversation by adding a new message and getting a reply
 	ContinueConversation(context.Context, *ContinueConversationRequest) (*ContinueConversationResponse, error)
 
-	// list most recent conversations
+	// List most recent conversations
 	ListConversations(context.Context, *ListConversationsRequest) (*ListConversationsResponse, error)
 
-	// describe a conversation by its ID
+	// Describe a conversation by its ID
 	DescribeConversation(context.Context, *DescribeConversationRequest) (*DescribeConversationResponse, error)
+
+	// List the tools the assistant can use
+	ListTools(context.Context, *ListToolsRequest) (*ListToolsResponse, error)
+
+	// Approve a pending tool call, the assistant runs the tool and resumes its reply
+	ApproveToolCall(context.Context, *ApproveToolCallRequest) (*ApproveToolCallResponse, error)
+
+	// Reject a pending tool call, the assistant is told the user declined it and resumes its reply
+	RejectToolCall(context.Context, *RejectToolCallRequest) (*RejectToolCallResponse, error)
+
+	// Generate a new reply to a user message, the previous reply is kept in another branch
+	RegenerateReply(context.Context, *RegenerateReplyRequest) (*RegenerateReplyResponse, error)
+
+	// Replace a user message and get a reply to it, the original message and what followed are kept in another branch
+	EditMessage(context.Context, *EditMessageRequest) (*EditMessageResponse, error)
+
+	// Copy a conversation up to a message into a new conversation, to explore an alternative without changing the original
+	ForkConversation(context.Context, *ForkConversationRequest) (*ForkConversationResponse, error)
+
+	// Find conversations by words in their title or messages, most relevant first
+	SearchConversations(context.Context, *SearchConversationsRequest) (*SearchConversationsResponse, error)
+
+	// Find messages of past conversations by meaning, most similar first
+	SemanticSearch(context.Context, *SemanticSearchRequest) (*SemanticSearchResponse, error)
+
+	// List the facts the assistant remembers about the caller, newest first
+	ListMemories(context.Context, *ListMemoriesRequest) (*ListMemoriesResponse, error)
+
+	// Make the assistant forget a fact about the caller
+	DeleteMemory(context.Context, *DeleteMemoryRequest) (*DeleteMemoryResponse, error)
+
+	// Add a document to a conversation, the assistant answers with the relevant parts of its text
+	UploadAttachment(context.Context, *UploadAttachmentRequest) (*UploadAttachmentResponse, error)
+
+	// List the documents added to a conversation
+	ListAttachments(context.Context, *ListAttachmentsRequest) (*ListAttachmentsResponse, error)
+
+	// Download an image uploaded with a message
+	GetImage(context.Context, *GetImageRequest) (*GetImageResponse, error)
+
+	// Get a message by ID, or the status of a reply still being generated in the background
+	GetMessage(context.Context, *GetMessageRequest) (*GetMessageResponse, error)
+
+	// Stop the replies being generated in a conversation, what was generated so far is kept and the reply is marked cancelled
+	CancelReply(context.Context, *CancelReplyRequest) (*CancelReplyResponse, error)
 }
 
 // ===========================
@@ -52,7 +97,7 @@ type ChatService interface {
 
 type chatServiceProtobufClient struct {
 	client      HTTPClient
-	urls        [4]string
+	urls        [19]string
 	interceptor twirp.Interceptor
 	opts        twirp.ClientOptions
 }
@@ -80,11 +125,26 @@ func NewChatServiceProtobufClient(baseURL string, client HTTPClient, opts ...twi
 	// Build method URLs: <baseURL>[<prefix>]/<package>.<Service>/<Method>
 	serviceURL := sanitizeBaseURL(baseURL)
 	serviceURL += baseServicePath(pathPrefix, "acai.chat", "ChatService")
-	urls := [4]string{
+	urls := [19]string{
 		serviceURL + "StartConversation",
 		serviceURL + "ContinueConversation",
 		serviceURL + "ListConversations",
 		serviceURL + "DescribeConversation",
+		serviceURL + "ListTools",
+		serviceURL + "ApproveToolCall",
+		serviceURL + "RejectToolCall",
+		serviceURL + "RegenerateReply",
+		serviceURL + "EditMessage",
+		serviceURL + "ForkConversation",
+		serviceURL + "SearchConversations",
+		serviceURL + "SemanticSearch",
+		serviceURL + "ListMemories",
+		serviceURL + "DeleteMemory",
+		serviceURL + "UploadAttachment",
+		serviceURL + "ListAttachments",
+		serviceURL + "GetImage",
+		serviceURL + "GetMessage",
+		serviceURL + "CancelReply",
 	}
 
 	return &chatServiceProtobufClient{
@@ -279,75 +339,118 @@ func (c *chatServiceProtobufClient) callDescribeConversation(ctx context.Context
 	return out, nil
 }
 
-// =======================
-// ChatService JSON Client
-// =======================
-
-type chatServiceJSONClient struct {
-	client      HTTPClient
-	urls        [4]string
-	interceptor twirp.Interceptor
-	opts        twirp.ClientOptions
+func (c *chatServiceProtobufClient) ListTools(ctx context.Context, in *ListToolsRequest) (*ListToolsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListTools")
+	caller := c.callListTools
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListToolsRequest) (*ListToolsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListToolsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListToolsRequest) when calling interceptor")
+					}
+					return c.callListTools(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListToolsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListToolsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
 }
 
-// NewChatServiceJSONClient creates a JSON client that implements the ChatService interface.
-// It communicates using JSON and can be configured with a custom HTTPClient.
-func NewChatServiceJSONClient(baseURL string, client HTTPClient, opts ...twirp.ClientOption) ChatService {
-	if c, ok := client.(*http.Client); ok {
-		client = withoutRedirects(c)
+func (c *chatServiceProtobufClient) callListTools(ctx context.Context, in *ListToolsRequest) (*ListToolsResponse, error) {
+	out := new(ListToolsResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[4], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
 
-	clientOpts := twirp.ClientOptions{}
-	for _, o := range opts {
-		o(&clientOpts)
-	}
+	callClientResponseReceived(ctx, c.opts.Hooks)
 
-	// Using ReadOpt allows backwards and forwards compatibility with new options in the future
-	literalURLs := false
-	_ = clientOpts.ReadOpt("literalURLs", &literalURLs)
-	var pathPrefix string
-	if ok := clientOpts.ReadOpt("pathPrefix", &pathPrefix); !ok {
-		pathPrefix = "/twirp" // default prefix
-	}
+	return out, nil
+}
 
-	// Build method URLs: <baseURL>[<prefix>]/<package>.<Service>/<Method>
-	serviceURL := sanitizeBaseURL(baseURL)
-	serviceURL += baseServicePath(pathPrefix, "acai.chat", "ChatService")
-	urls := [4]string{
-		serviceURL + "StartConversation",
-		serviceURL + "ContinueConversation",
-		serviceURL + "ListConversations",
-		serviceURL + "DescribeConversation",
+func (c *chatServiceProtobufClient) ApproveToolCall(ctx context.Context, in *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ApproveToolCall")
+	caller := c.callApproveToolCall
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ApproveToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ApproveToolCallRequest) when calling interceptor")
+					}
+					return c.callApproveToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ApproveToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ApproveToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
 	}
+	return caller(ctx, in)
+}
 
-	return &chatServiceJSONClient{
-		client:      client,
-		urls:        urls,
-		interceptor: twirp.ChainInterceptors(clientOpts.Interceptors...),
-		opts:        clientOpts,
+func (c *chatServiceProtobufClient) callApproveToolCall(ctx context.Context, in *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+	out := new(ApproveToolCallResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[5], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
 }
 
-func (c *chatServiceJSONClient) StartConversation(ctx context.Context, in *StartConversationRequest) (*StartConversationResponse, error) {
+func (c *chatServiceProtobufClient) RejectToolCall(ctx context.Context, in *RejectToolCallRequest) (*RejectToolCallResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
 	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
-	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
-	caller := c.callStartConversation
+	ctx = ctxsetters.WithMethodName(ctx, "RejectToolCall")
+	caller := c.callRejectToolCall
 	if c.interceptor != nil {
-		caller = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+		caller = func(ctx context.Context, req *RejectToolCallRequest) (*RejectToolCallResponse, error) {
 			resp, err := c.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*StartConversationRequest)
+					typedReq, ok := req.(*RejectToolCallRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*RejectToolCallRequest) when calling interceptor")
 					}
-					return c.callStartConversation(ctx, typedReq)
+					return c.callRejectToolCall(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*StartConversationResponse)
+				typedResp, ok := resp.(*RejectToolCallResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*RejectToolCallResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -357,9 +460,9 @@ func (c *chatServiceJSONClient) StartConversation(ctx context.Context, in *Start
 	return caller(ctx, in)
 }
 
-func (c *chatServiceJSONClient) callStartConversation(ctx context.Context, in *StartConversationRequest) (*StartConversationResponse, error) {
-	out := new(StartConversationResponse)
-	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[0], in, out)
+func (c *chatServiceProtobufClient) callRejectToolCall(ctx context.Context, in *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+	out := new(RejectToolCallResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[6], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -374,26 +477,26 @@ func (c *chatServiceJSONClient) callStartConversation(ctx context.Context, in *S
 	return out, nil
 }
 
-func (c *chatServiceJSONClient) ContinueConversation(ctx context.Context, in *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+func (c *chatServiceProtobufClient) RegenerateReply(ctx context.Context, in *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
 	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
-	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
-	caller := c.callContinueConversation
+	ctx = ctxsetters.WithMethodName(ctx, "RegenerateReply")
+	caller := c.callRegenerateReply
 	if c.interceptor != nil {
-		caller = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+		caller = func(ctx context.Context, req *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
 			resp, err := c.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ContinueConversationRequest)
+					typedReq, ok := req.(*RegenerateReplyRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*RegenerateReplyRequest) when calling interceptor")
 					}
-					return c.callContinueConversation(ctx, typedReq)
+					return c.callRegenerateReply(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ContinueConversationResponse)
+				typedResp, ok := resp.(*RegenerateReplyResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*RegenerateReplyResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -403,9 +506,9 @@ func (c *chatServiceJSONClient) ContinueConversation(ctx context.Context, in *Co
 	return caller(ctx, in)
 }
 
-func (c *chatServiceJSONClient) callContinueConversation(ctx context.Context, in *ContinueConversationRequest) (*ContinueConversationResponse, error) {
-	out := new(ContinueConversationResponse)
-	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[1], in, out)
+func (c *chatServiceProtobufClient) callRegenerateReply(ctx context.Context, in *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+	out := new(RegenerateReplyResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[7], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -420,26 +523,26 @@ func (c *chatServiceJSONClient) callContinueConversation(ctx context.Context, in
 	return out, nil
 }
 
-func (c *chatServiceJSONClient) ListConversations(ctx context.Context, in *ListConversationsRequest) (*ListConversationsResponse, error) {
+func (c *chatServiceProtobufClient) EditMessage(ctx context.Context, in *EditMessageRequest) (*EditMessageResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
 	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
-	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
-	caller := c.callListConversations
+	ctx = ctxsetters.WithMethodName(ctx, "EditMessage")
+	caller := c.callEditMessage
 	if c.interceptor != nil {
-		caller = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+		caller = func(ctx context.Context, req *EditMessageRequest) (*EditMessageResponse, error) {
 			resp, err := c.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ListConversationsRequest)
+					typedReq, ok := req.(*EditMessageRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*EditMessageRequest) when calling interceptor")
 					}
-					return c.callListConversations(ctx, typedReq)
+					return c.callEditMessage(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ListConversationsResponse)
+				typedResp, ok := resp.(*EditMessageResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*EditMessageResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -449,9 +552,9 @@ func (c *chatServiceJSONClient) ListConversations(ctx context.Context, in *ListC
 	return caller(ctx, in)
 }
 
-func (c *chatServiceJSONClient) callListConversations(ctx context.Context, in *ListConversationsRequest) (*ListConversationsResponse, error) {
-	out := new(ListConversationsResponse)
-	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[2], in, out)
+func (c *chatServiceProtobufClient) callEditMessage(ctx context.Context, in *EditMessageRequest) (*EditMessageResponse, error) {
+	out := new(EditMessageResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[8], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -466,26 +569,26 @@ func (c *chatServiceJSONClient) callListConversations(ctx context.Context, in *L
 	return out, nil
 }
 
-func (c *chatServiceJSONClient) DescribeConversation(ctx context.Context, in *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+func (c *chatServiceProtobufClient) ForkConversation(ctx context.Context, in *ForkConversationRequest) (*ForkConversationResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
 	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
-	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
-	caller := c.callDescribeConversation
+	ctx = ctxsetters.WithMethodName(ctx, "ForkConversation")
+	caller := c.callForkConversation
 	if c.interceptor != nil {
-		caller = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+		caller = func(ctx context.Context, req *ForkConversationRequest) (*ForkConversationResponse, error) {
 			resp, err := c.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*DescribeConversationRequest)
+					typedReq, ok := req.(*ForkConversationRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*ForkConversationRequest) when calling interceptor")
 					}
-					return c.callDescribeConversation(ctx, typedReq)
+					return c.callForkConversation(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*DescribeConversationResponse)
+				typedResp, ok := resp.(*ForkConversationResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*ForkConversationResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -495,9 +598,9 @@ func (c *chatServiceJSONClient) DescribeConversation(ctx context.Context, in *De
 	return caller(ctx, in)
 }
 
-func (c *chatServiceJSONClient) callDescribeConversation(ctx context.Context, in *DescribeConversationRequest) (*DescribeConversationResponse, error) {
-	out := new(DescribeConversationResponse)
-	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[3], in, out)
+func (c *chatServiceProtobufClient) callForkConversation(ctx context.Context, in *ForkConversationRequest) (*ForkConversationResponse, error) {
+	out := new(ForkConversationResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[9], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -512,123 +615,4220 @@ func (c *chatServiceJSONClient) callDescribeConversation(ctx context.Context, in
 	return out, nil
 }
 
-// ==========================
-// ChatService Server Handler
-// ==========================
-
-type chatServiceServer struct {
-	ChatService
-	interceptor      twirp.Interceptor
-	hooks            *twirp.ServerHooks
-	pathPrefix       string // prefix for routing
-	jsonSkipDefaults bool   // do not include unpopulated fields (default values) in the response
-	jsonCamelCase    bool   // JSON fields are serialized as lowerCamelCase rather than keeping the original proto names
+func (c *chatServiceProtobufClient) SearchConversations(ctx context.Context, in *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "SearchConversations")
+	caller := c.callSearchConversations
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SearchConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SearchConversationsRequest) when calling interceptor")
+					}
+					return c.callSearchConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SearchConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SearchConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
 }
 
-// NewChatServiceServer builds a TwirpServer that can be used as an http.Handler to handle
-// HTTP requests that are routed to the right method in the provided svc implementation.
-// The opts are twirp.ServerOption modifiers, for example twirp.WithServerHooks(hooks).
-func NewChatServiceServer(svc ChatService, opts ...interface{}) TwirpServer {
-	serverOpts := newServerOpts(opts)
-
-	// Using ReadOpt allows backwards and forwards compatibility with new options in the future
-	jsonSkipDefaults := false
-	_ = serverOpts.ReadOpt("jsonSkipDefaults", &jsonSkipDefaults)
-	jsonCamelCase := false
-	_ = serverOpts.ReadOpt("jsonCamelCase", &jsonCamelCase)
-	var pathPrefix string
-	if ok := serverOpts.ReadOpt("pathPrefix", &pathPrefix); !ok {
-		pathPrefix = "/twirp" // default prefix
+func (c *chatServiceProtobufClient) callSearchConversations(ctx context.Context, in *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+	out := new(SearchConversationsResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[10], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
 
-	return &chatServiceServer{
-		ChatService:      svc,
-		hooks:            serverOpts.Hooks,
-		interceptor:      twirp.ChainInterceptors(serverOpts.Interceptors...),
-		pathPrefix:       pathPrefix,
-		jsonSkipDefaults: jsonSkipDefaults,
-		jsonCamelCase:    jsonCamelCase,
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) SemanticSearch(ctx context.Context, in *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "SemanticSearch")
+	caller := c.callSemanticSearch
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SemanticSearchRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SemanticSearchRequest) when calling interceptor")
+					}
+					return c.callSemanticSearch(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SemanticSearchResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SemanticSearchResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
 	}
+	return caller(ctx, in)
 }
 
-// writeError writes an HTTP response with a valid Twirp error format, and triggers hooks.
-// If err is not a twirp.Error, it will get wrapped with twirp.InternalErrorWith(err)
-func (s *chatServiceServer) writeError(ctx context.Context, resp http.ResponseWriter, err error) {
-	writeError(ctx, resp, err, s.hooks)
+func (c *chatServiceProtobufClient) callSemanticSearch(ctx context.Context, in *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+	out := new(SemanticSearchResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[11], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
 }
 
-// handleRequestBodyError is used to handle error when the twirp server cannot read request
-func (s *chatServiceServer) handleRequestBodyError(ctx context.Context, resp http.ResponseWriter, msg string, err error) {
-	if context.Canceled == ctx.Err() {
-		s.writeError(ctx, resp, twirp.NewError(twirp.Canceled, "failed to read request: context canceled"))
+func (c *chatServiceProtobufClient) ListMemories(ctx context.Context, in *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListMemories")
+	caller := c.callListMemories
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListMemoriesRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListMemoriesRequest) when calling interceptor")
+					}
+					return c.callListMemories(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListMemoriesResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListMemoriesResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callListMemories(ctx context.Context, in *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+	out := new(ListMemoriesResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[12], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) DeleteMemory(ctx context.Context, in *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteMemory")
+	caller := c.callDeleteMemory
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DeleteMemoryRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DeleteMemoryRequest) when calling interceptor")
+					}
+					return c.callDeleteMemory(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DeleteMemoryResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DeleteMemoryResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callDeleteMemory(ctx context.Context, in *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+	out := new(DeleteMemoryResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[13], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) UploadAttachment(ctx context.Context, in *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "UploadAttachment")
+	caller := c.callUploadAttachment
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*UploadAttachmentRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*UploadAttachmentRequest) when calling interceptor")
+					}
+					return c.callUploadAttachment(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*UploadAttachmentResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*UploadAttachmentResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callUploadAttachment(ctx context.Context, in *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+	out := new(UploadAttachmentResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[14], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) ListAttachments(ctx context.Context, in *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListAttachments")
+	caller := c.callListAttachments
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListAttachmentsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListAttachmentsRequest) when calling interceptor")
+					}
+					return c.callListAttachments(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListAttachmentsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListAttachmentsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callListAttachments(ctx context.Context, in *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+	out := new(ListAttachmentsResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[15], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) GetImage(ctx context.Context, in *GetImageRequest) (*GetImageResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "GetImage")
+	caller := c.callGetImage
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *GetImageRequest) (*GetImageResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*GetImageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*GetImageRequest) when calling interceptor")
+					}
+					return c.callGetImage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*GetImageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*GetImageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callGetImage(ctx context.Context, in *GetImageRequest) (*GetImageResponse, error) {
+	out := new(GetImageResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[16], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) GetMessage(ctx context.Context, in *GetMessageRequest) (*GetMessageResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "GetMessage")
+	caller := c.callGetMessage
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *GetMessageRequest) (*GetMessageResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*GetMessageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*GetMessageRequest) when calling interceptor")
+					}
+					return c.callGetMessage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*GetMessageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*GetMessageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callGetMessage(ctx context.Context, in *GetMessageRequest) (*GetMessageResponse, error) {
+	out := new(GetMessageResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[17], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceProtobufClient) CancelReply(ctx context.Context, in *CancelReplyRequest) (*CancelReplyResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "CancelReply")
+	caller := c.callCancelReply
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *CancelReplyRequest) (*CancelReplyResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*CancelReplyRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*CancelReplyRequest) when calling interceptor")
+					}
+					return c.callCancelReply(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*CancelReplyResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*CancelReplyResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceProtobufClient) callCancelReply(ctx context.Context, in *CancelReplyRequest) (*CancelReplyResponse, error) {
+	out := new(CancelReplyResponse)
+	ctx, err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[18], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+// =======================
+// ChatService JSON Client
+// =======================
+
+type chatServiceJSONClient struct {
+	client      HTTPClient
+	urls        [19]string
+	interceptor twirp.Interceptor
+	opts        twirp.ClientOptions
+}
+
+// NewChatServiceJSONClient creates a JSON client that implements the ChatService interface.
+// It communicates using JSON and can be configured with a custom HTTPClient.
+func NewChatServiceJSONClient(baseURL string, client HTTPClient, opts ...twirp.ClientOption) ChatService {
+	if c, ok := client.(*http.Client); ok {
+		client = withoutRedirects(c)
+	}
+
+	clientOpts := twirp.ClientOptions{}
+	for _, o := range opts {
+		o(&clientOpts)
+	}
+
+	// Using ReadOpt allows backwards and forwards compatibility with new options in the future
+	literalURLs := false
+	_ = clientOpts.ReadOpt("literalURLs", &literalURLs)
+	var pathPrefix string
+	if ok := clientOpts.ReadOpt("pathPrefix", &pathPrefix); !ok {
+		pathPrefix = "/twirp" // default prefix
+	}
+
+	// Build method URLs: <baseURL>[<prefix>]/<package>.<Service>/<Method>
+	serviceURL := sanitizeBaseURL(baseURL)
+	serviceURL += baseServicePath(pathPrefix, "acai.chat", "ChatService")
+	urls := [19]string{
+		serviceURL + "StartConversation",
+		serviceURL + "ContinueConversation",
+		serviceURL + "ListConversations",
+		serviceURL + "DescribeConversation",
+		serviceURL + "ListTools",
+		serviceURL + "ApproveToolCall",
+		serviceURL + "RejectToolCall",
+		serviceURL + "RegenerateReply",
+		serviceURL + "EditMessage",
+		serviceURL + "ForkConversation",
+		serviceURL + "SearchConversations",
+		serviceURL + "SemanticSearch",
+		serviceURL + "ListMemories",
+		serviceURL + "DeleteMemory",
+		serviceURL + "UploadAttachment",
+		serviceURL + "ListAttachments",
+		serviceURL + "GetImage",
+		serviceURL + "GetMessage",
+		serviceURL + "CancelReply",
+	}
+
+	return &chatServiceJSONClient{
+		client:      client,
+		urls:        urls,
+		interceptor: twirp.ChainInterceptors(clientOpts.Interceptors...),
+		opts:        clientOpts,
+	}
+}
+
+func (c *chatServiceJSONClient) StartConversation(ctx context.Context, in *StartConversationRequest) (*StartConversationResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
+	caller := c.callStartConversation
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*StartConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+					}
+					return c.callStartConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*StartConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callStartConversation(ctx context.Context, in *StartConversationRequest) (*StartConversationResponse, error) {
+	out := new(StartConversationResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[0], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ContinueConversation(ctx context.Context, in *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
+	caller := c.callContinueConversation
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ContinueConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+					}
+					return c.callContinueConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ContinueConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callContinueConversation(ctx context.Context, in *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+	out := new(ContinueConversationResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[1], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ListConversations(ctx context.Context, in *ListConversationsRequest) (*ListConversationsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
+	caller := c.callListConversations
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+					}
+					return c.callListConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callListConversations(ctx context.Context, in *ListConversationsRequest) (*ListConversationsResponse, error) {
+	out := new(ListConversationsResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[2], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) DescribeConversation(ctx context.Context, in *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
+	caller := c.callDescribeConversation
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DescribeConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+					}
+					return c.callDescribeConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DescribeConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callDescribeConversation(ctx context.Context, in *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+	out := new(DescribeConversationResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[3], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ListTools(ctx context.Context, in *ListToolsRequest) (*ListToolsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListTools")
+	caller := c.callListTools
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListToolsRequest) (*ListToolsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListToolsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListToolsRequest) when calling interceptor")
+					}
+					return c.callListTools(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListToolsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListToolsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callListTools(ctx context.Context, in *ListToolsRequest) (*ListToolsResponse, error) {
+	out := new(ListToolsResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[4], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ApproveToolCall(ctx context.Context, in *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ApproveToolCall")
+	caller := c.callApproveToolCall
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ApproveToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ApproveToolCallRequest) when calling interceptor")
+					}
+					return c.callApproveToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ApproveToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ApproveToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callApproveToolCall(ctx context.Context, in *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+	out := new(ApproveToolCallResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[5], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) RejectToolCall(ctx context.Context, in *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "RejectToolCall")
+	caller := c.callRejectToolCall
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RejectToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RejectToolCallRequest) when calling interceptor")
+					}
+					return c.callRejectToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RejectToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RejectToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callRejectToolCall(ctx context.Context, in *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+	out := new(RejectToolCallResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[6], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) RegenerateReply(ctx context.Context, in *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "RegenerateReply")
+	caller := c.callRegenerateReply
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RegenerateReplyRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RegenerateReplyRequest) when calling interceptor")
+					}
+					return c.callRegenerateReply(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RegenerateReplyResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RegenerateReplyResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callRegenerateReply(ctx context.Context, in *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+	out := new(RegenerateReplyResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[7], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) EditMessage(ctx context.Context, in *EditMessageRequest) (*EditMessageResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "EditMessage")
+	caller := c.callEditMessage
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *EditMessageRequest) (*EditMessageResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*EditMessageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*EditMessageRequest) when calling interceptor")
+					}
+					return c.callEditMessage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*EditMessageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*EditMessageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callEditMessage(ctx context.Context, in *EditMessageRequest) (*EditMessageResponse, error) {
+	out := new(EditMessageResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[8], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ForkConversation(ctx context.Context, in *ForkConversationRequest) (*ForkConversationResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ForkConversation")
+	caller := c.callForkConversation
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ForkConversationRequest) (*ForkConversationResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ForkConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ForkConversationRequest) when calling interceptor")
+					}
+					return c.callForkConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ForkConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ForkConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callForkConversation(ctx context.Context, in *ForkConversationRequest) (*ForkConversationResponse, error) {
+	out := new(ForkConversationResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[9], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) SearchConversations(ctx context.Context, in *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "SearchConversations")
+	caller := c.callSearchConversations
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SearchConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SearchConversationsRequest) when calling interceptor")
+					}
+					return c.callSearchConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SearchConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SearchConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callSearchConversations(ctx context.Context, in *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+	out := new(SearchConversationsResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[10], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) SemanticSearch(ctx context.Context, in *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "SemanticSearch")
+	caller := c.callSemanticSearch
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SemanticSearchRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SemanticSearchRequest) when calling interceptor")
+					}
+					return c.callSemanticSearch(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SemanticSearchResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SemanticSearchResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callSemanticSearch(ctx context.Context, in *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+	out := new(SemanticSearchResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[11], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ListMemories(ctx context.Context, in *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListMemories")
+	caller := c.callListMemories
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListMemoriesRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListMemoriesRequest) when calling interceptor")
+					}
+					return c.callListMemories(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListMemoriesResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListMemoriesResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callListMemories(ctx context.Context, in *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+	out := new(ListMemoriesResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[12], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) DeleteMemory(ctx context.Context, in *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteMemory")
+	caller := c.callDeleteMemory
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DeleteMemoryRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DeleteMemoryRequest) when calling interceptor")
+					}
+					return c.callDeleteMemory(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DeleteMemoryResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DeleteMemoryResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callDeleteMemory(ctx context.Context, in *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+	out := new(DeleteMemoryResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[13], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) UploadAttachment(ctx context.Context, in *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "UploadAttachment")
+	caller := c.callUploadAttachment
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*UploadAttachmentRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*UploadAttachmentRequest) when calling interceptor")
+					}
+					return c.callUploadAttachment(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*UploadAttachmentResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*UploadAttachmentResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callUploadAttachment(ctx context.Context, in *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+	out := new(UploadAttachmentResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[14], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) ListAttachments(ctx context.Context, in *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "ListAttachments")
+	caller := c.callListAttachments
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListAttachmentsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListAttachmentsRequest) when calling interceptor")
+					}
+					return c.callListAttachments(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListAttachmentsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListAttachmentsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callListAttachments(ctx context.Context, in *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
+	out := new(ListAttachmentsResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[15], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) GetImage(ctx context.Context, in *GetImageRequest) (*GetImageResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "GetImage")
+	caller := c.callGetImage
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *GetImageRequest) (*GetImageResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*GetImageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*GetImageRequest) when calling interceptor")
+					}
+					return c.callGetImage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*GetImageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*GetImageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callGetImage(ctx context.Context, in *GetImageRequest) (*GetImageResponse, error) {
+	out := new(GetImageResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[16], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) GetMessage(ctx context.Context, in *GetMessageRequest) (*GetMessageResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "GetMessage")
+	caller := c.callGetMessage
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *GetMessageRequest) (*GetMessageResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*GetMessageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*GetMessageRequest) when calling interceptor")
+					}
+					return c.callGetMessage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*GetMessageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*GetMessageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callGetMessage(ctx context.Context, in *GetMessageRequest) (*GetMessageResponse, error) {
+	out := new(GetMessageResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[17], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *chatServiceJSONClient) CancelReply(ctx context.Context, in *CancelReplyRequest) (*CancelReplyResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithMethodName(ctx, "CancelReply")
+	caller := c.callCancelReply
+	if c.interceptor != nil {
+		caller = func(ctx context.Context, req *CancelReplyRequest) (*CancelReplyResponse, error) {
+			resp, err := c.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*CancelReplyRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*CancelReplyRequest) when calling interceptor")
+					}
+					return c.callCancelReply(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*CancelReplyResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*CancelReplyResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+	return caller(ctx, in)
+}
+
+func (c *chatServiceJSONClient) callCancelReply(ctx context.Context, in *CancelReplyRequest) (*CancelReplyResponse, error) {
+	out := new(CancelReplyResponse)
+	ctx, err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[18], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+// ==========================
+// ChatService Server Handler
+// ==========================
+
+type chatServiceServer struct {
+	ChatService
+	interceptor      twirp.Interceptor
+	hooks            *twirp.ServerHooks
+	pathPrefix       string // prefix for routing
+	jsonSkipDefaults bool   // do not include unpopulated fields (default values) in the response
+	jsonCamelCase    bool   // JSON fields are serialized as lowerCamelCase rather than keeping the original proto names
+}
+
+// NewChatServiceServer builds a TwirpServer that can be used as an http.Handler to handle
+// HTTP requests that are routed to the right method in the provided svc implementation.
+// The opts are twirp.ServerOption modifiers, for example twirp.WithServerHooks(hooks).
+func NewChatServiceServer(svc ChatService, opts ...interface{}) TwirpServer {
+	serverOpts := newServerOpts(opts)
+
+	// Using ReadOpt allows backwards and forwards compatibility with new options in the future
+	jsonSkipDefaults := false
+	_ = serverOpts.ReadOpt("jsonSkipDefaults", &jsonSkipDefaults)
+	jsonCamelCase := false
+	_ = serverOpts.ReadOpt("jsonCamelCase", &jsonCamelCase)
+	var pathPrefix string
+	if ok := serverOpts.ReadOpt("pathPrefix", &pathPrefix); !ok {
+		pathPrefix = "/twirp" // default prefix
+	}
+
+	return &chatServiceServer{
+		ChatService:      svc,
+		hooks:            serverOpts.Hooks,
+		interceptor:      twirp.ChainInterceptors(serverOpts.Interceptors...),
+		pathPrefix:       pathPrefix,
+		jsonSkipDefaults: jsonSkipDefaults,
+		jsonCamelCase:    jsonCamelCase,
+	}
+}
+
+// writeError writes an HTTP response with a valid Twirp error format, and triggers hooks.
+// If err is not a twirp.Error, it will get wrapped with twirp.InternalErrorWith(err)
+func (s *chatServiceServer) writeError(ctx context.Context, resp http.ResponseWriter, err error) {
+	writeError(ctx, resp, err, s.hooks)
+}
+
+// handleRequestBodyError is used to handle error when the twirp server cannot read request
+func (s *chatServiceServer) handleRequestBodyError(ctx context.Context, resp http.ResponseWriter, msg string, err error) {
+	if context.Canceled == ctx.Err() {
+		s.writeError(ctx, resp, twirp.NewError(twirp.Canceled, "failed to read request: context canceled"))
+		return
+	}
+	if context.DeadlineExceeded == ctx.Err() {
+		s.writeError(ctx, resp, twirp.NewError(twirp.DeadlineExceeded, "failed to read request: deadline exceeded"))
+		return
+	}
+	s.writeError(ctx, resp, twirp.WrapError(malformedRequestError(msg), err))
+}
+
+// ChatServicePathPrefix is a convenience constant that may identify URL paths.
+// Should be used with caution, it only matches routes generated by Twirp Go clients,
+// with the default "/twirp" prefix and default CamelCase service and method names.
+// More info: https://twitchtv.github.io/twirp/docs/routing.html
+const ChatServicePathPrefix = "/twirp/acai.chat.ChatService/"
+
+func (s *chatServiceServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
+	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
+	ctx = ctxsetters.WithResponseWriter(ctx, resp)
+
+	var err error
+	ctx, err = callRequestReceived(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("unsupported method %q (only POST is allowed)", req.Method)
+		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+		return
+	}
+
+	// Verify path format: [<prefix>]/<package>.<Service>/<Method>
+	prefix, pkgService, method := parseTwirpPath(req.URL.Path)
+	if pkgService != "acai.chat.ChatService" {
+		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
+		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+		return
+	}
+	if prefix != s.pathPrefix {
+		msg := fmt.Sprintf("invalid path prefix %q, expected %q, on path %q", prefix, s.pathPrefix, req.URL.Path)
+		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+		return
+	}
+
+	switch method {
+	case "StartConversation":
+		s.serveStartConversation(ctx, resp, req)
+		return
+	case "ContinueConversation":
+		s.serveContinueConversation(ctx, resp, req)
+		return
+	case "ListConversations":
+		s.serveListConversations(ctx, resp, req)
+		return
+	case "DescribeConversation":
+		s.serveDescribeConversation(ctx, resp, req)
+		return
+	case "ListTools":
+		s.serveListTools(ctx, resp, req)
+		return
+	case "ApproveToolCall":
+		s.serveApproveToolCall(ctx, resp, req)
+		return
+	case "RejectToolCall":
+		s.serveRejectToolCall(ctx, resp, req)
+		return
+	case "RegenerateReply":
+		s.serveRegenerateReply(ctx, resp, req)
+		return
+	case "EditMessage":
+		s.serveEditMessage(ctx, resp, req)
+		return
+	case "ForkConversation":
+		s.serveForkConversation(ctx, resp, req)
+		return
+	case "SearchConversations":
+		s.serveSearchConversations(ctx, resp, req)
+		return
+	case "SemanticSearch":
+		s.serveSemanticSearch(ctx, resp, req)
+		return
+	case "ListMemories":
+		s.serveListMemories(ctx, resp, req)
+		return
+	case "DeleteMemory":
+		s.serveDeleteMemory(ctx, resp, req)
+		return
+	case "UploadAttachment":
+		s.serveUploadAttachment(ctx, resp, req)
+		return
+	case "ListAttachments":
+		s.serveListAttachments(ctx, resp, req)
+		return
+	case "GetImage":
+		s.serveGetImage(ctx, resp, req)
+		return
+	case "GetMessage":
+		s.serveGetMessage(ctx, resp, req)
+		return
+	case "CancelReply":
+		s.serveCancelReply(ctx, resp, req)
+		return
+	default:
+		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
+		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+		return
+	}
+}
+
+func (s *chatServiceServer) serveStartConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveStartConversationJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveStartConversationProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(StartConversationRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.StartConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*StartConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.StartConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*StartConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *StartConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *StartConversationResponse and nil error while calling StartConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(StartConversationRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.StartConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*StartConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.StartConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*StartConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *StartConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *StartConversationResponse and nil error while calling StartConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveContinueConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveContinueConversationJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveContinueConversationProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ContinueConversationRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ContinueConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ContinueConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.ContinueConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ContinueConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ContinueConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ContinueConversationResponse and nil error while calling ContinueConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ContinueConversationRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ContinueConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ContinueConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.ContinueConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ContinueConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ContinueConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ContinueConversationResponse and nil error while calling ContinueConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListConversations(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveListConversationsJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveListConversationsProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ListConversationsRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ListConversations
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+					}
+					return s.ChatService.ListConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListConversationsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListConversationsResponse and nil error while calling ListConversations. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ListConversationsRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ListConversations
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+					}
+					return s.ChatService.ListConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListConversationsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListConversationsResponse and nil error while calling ListConversations. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveDescribeConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDescribeConversationJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDescribeConversationProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(DescribeConversationRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.DescribeConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DescribeConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.DescribeConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DescribeConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *DescribeConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DescribeConversationResponse and nil error while calling DescribeConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveDescribeConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(DescribeConversationRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.DescribeConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DescribeConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.DescribeConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DescribeConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *DescribeConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DescribeConversationResponse and nil error while calling DescribeConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListTools(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveListToolsJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveListToolsProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveListToolsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListTools")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ListToolsRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ListTools
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListToolsRequest) (*ListToolsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListToolsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListToolsRequest) when calling interceptor")
+					}
+					return s.ChatService.ListTools(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListToolsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListToolsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListToolsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListToolsResponse and nil error while calling ListTools. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListToolsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListTools")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ListToolsRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ListTools
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListToolsRequest) (*ListToolsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListToolsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListToolsRequest) when calling interceptor")
+					}
+					return s.ChatService.ListTools(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListToolsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListToolsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListToolsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListToolsResponse and nil error while calling ListTools. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveApproveToolCall(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveApproveToolCallJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveApproveToolCallProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveApproveToolCallJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ApproveToolCall")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ApproveToolCallRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ApproveToolCall
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ApproveToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ApproveToolCallRequest) when calling interceptor")
+					}
+					return s.ChatService.ApproveToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ApproveToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ApproveToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ApproveToolCallResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ApproveToolCallResponse and nil error while calling ApproveToolCall. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveApproveToolCallProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ApproveToolCall")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ApproveToolCallRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ApproveToolCall
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ApproveToolCallRequest) (*ApproveToolCallResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ApproveToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ApproveToolCallRequest) when calling interceptor")
+					}
+					return s.ChatService.ApproveToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ApproveToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ApproveToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ApproveToolCallResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ApproveToolCallResponse and nil error while calling ApproveToolCall. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveRejectToolCall(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveRejectToolCallJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveRejectToolCallProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveRejectToolCallJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RejectToolCall")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(RejectToolCallRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.RejectToolCall
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RejectToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RejectToolCallRequest) when calling interceptor")
+					}
+					return s.ChatService.RejectToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RejectToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RejectToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *RejectToolCallResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RejectToolCallResponse and nil error while calling RejectToolCall. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveRejectToolCallProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RejectToolCall")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(RejectToolCallRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.RejectToolCall
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *RejectToolCallRequest) (*RejectToolCallResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RejectToolCallRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RejectToolCallRequest) when calling interceptor")
+					}
+					return s.ChatService.RejectToolCall(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RejectToolCallResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RejectToolCallResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *RejectToolCallResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RejectToolCallResponse and nil error while calling RejectToolCall. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveRegenerateReply(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveRegenerateReplyJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveRegenerateReplyProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveRegenerateReplyJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RegenerateReply")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(RegenerateReplyRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.RegenerateReply
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RegenerateReplyRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RegenerateReplyRequest) when calling interceptor")
+					}
+					return s.ChatService.RegenerateReply(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RegenerateReplyResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RegenerateReplyResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *RegenerateReplyResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RegenerateReplyResponse and nil error while calling RegenerateReply. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveRegenerateReplyProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RegenerateReply")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(RegenerateReplyRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.RegenerateReply
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *RegenerateReplyRequest) (*RegenerateReplyResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*RegenerateReplyRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*RegenerateReplyRequest) when calling interceptor")
+					}
+					return s.ChatService.RegenerateReply(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*RegenerateReplyResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*RegenerateReplyResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *RegenerateReplyResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RegenerateReplyResponse and nil error while calling RegenerateReply. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveEditMessage(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveEditMessageJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveEditMessageProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveEditMessageJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "EditMessage")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(EditMessageRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.EditMessage
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *EditMessageRequest) (*EditMessageResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*EditMessageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*EditMessageRequest) when calling interceptor")
+					}
+					return s.ChatService.EditMessage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*EditMessageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*EditMessageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *EditMessageResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *EditMessageResponse and nil error while calling EditMessage. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveEditMessageProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "EditMessage")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(EditMessageRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.EditMessage
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *EditMessageRequest) (*EditMessageResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*EditMessageRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*EditMessageRequest) when calling interceptor")
+					}
+					return s.ChatService.EditMessage(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*EditMessageResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*EditMessageResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *EditMessageResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *EditMessageResponse and nil error while calling EditMessage. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveForkConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveForkConversationJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveForkConversationProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveForkConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ForkConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ForkConversationRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ForkConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ForkConversationRequest) (*ForkConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ForkConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ForkConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.ForkConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ForkConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ForkConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ForkConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ForkConversationResponse and nil error while calling ForkConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveForkConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ForkConversation")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ForkConversationRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ForkConversation
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ForkConversationRequest) (*ForkConversationResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ForkConversationRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ForkConversationRequest) when calling interceptor")
+					}
+					return s.ChatService.ForkConversation(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ForkConversationResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ForkConversationResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ForkConversationResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ForkConversationResponse and nil error while calling ForkConversation. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveSearchConversations(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveSearchConversationsJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveSearchConversationsProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveSearchConversationsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SearchConversations")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(SearchConversationsRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.SearchConversations
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SearchConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SearchConversationsRequest) when calling interceptor")
+					}
+					return s.ChatService.SearchConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SearchConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SearchConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *SearchConversationsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SearchConversationsResponse and nil error while calling SearchConversations. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveSearchConversationsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SearchConversations")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(SearchConversationsRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.SearchConversations
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *SearchConversationsRequest) (*SearchConversationsResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SearchConversationsRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SearchConversationsRequest) when calling interceptor")
+					}
+					return s.ChatService.SearchConversations(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SearchConversationsResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SearchConversationsResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *SearchConversationsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SearchConversationsResponse and nil error while calling SearchConversations. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveSemanticSearch(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveSemanticSearchJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveSemanticSearchProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveSemanticSearchJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SemanticSearch")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(SemanticSearchRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.SemanticSearch
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SemanticSearchRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SemanticSearchRequest) when calling interceptor")
+					}
+					return s.ChatService.SemanticSearch(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SemanticSearchResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SemanticSearchResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *SemanticSearchResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SemanticSearchResponse and nil error while calling SemanticSearch. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveSemanticSearchProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SemanticSearch")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(SemanticSearchRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.SemanticSearch
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *SemanticSearchRequest) (*SemanticSearchResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*SemanticSearchRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*SemanticSearchRequest) when calling interceptor")
+					}
+					return s.ChatService.SemanticSearch(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*SemanticSearchResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*SemanticSearchResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *SemanticSearchResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SemanticSearchResponse and nil error while calling SemanticSearch. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListMemories(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveListMemoriesJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveListMemoriesProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveListMemoriesJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListMemories")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(ListMemoriesRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.ListMemories
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListMemoriesRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListMemoriesRequest) when calling interceptor")
+					}
+					return s.ChatService.ListMemories(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListMemoriesResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListMemoriesResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListMemoriesResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListMemoriesResponse and nil error while calling ListMemories. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveListMemoriesProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListMemories")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(ListMemoriesRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.ListMemories
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *ListMemoriesRequest) (*ListMemoriesResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*ListMemoriesRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*ListMemoriesRequest) when calling interceptor")
+					}
+					return s.ChatService.ListMemories(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*ListMemoriesResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*ListMemoriesResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *ListMemoriesResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListMemoriesResponse and nil error while calling ListMemories. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveDeleteMemory(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDeleteMemoryJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDeleteMemoryProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveDeleteMemoryJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteMemory")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+	reqContent := new(DeleteMemoryRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
+		return
+	}
+
+	handler := s.ChatService.DeleteMemory
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DeleteMemoryRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DeleteMemoryRequest) when calling interceptor")
+					}
+					return s.ChatService.DeleteMemory(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DeleteMemoryResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DeleteMemoryResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *DeleteMemoryResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeleteMemoryResponse and nil error while calling DeleteMemory. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveDeleteMemoryProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteMemory")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
+		return
+	}
+	reqContent := new(DeleteMemoryRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	handler := s.ChatService.DeleteMemory
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *DeleteMemoryRequest) (*DeleteMemoryResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*DeleteMemoryRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*DeleteMemoryRequest) when calling interceptor")
+					}
+					return s.ChatService.DeleteMemory(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*DeleteMemoryResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*DeleteMemoryResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *DeleteMemoryResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeleteMemoryResponse and nil error while calling DeleteMemory. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveUploadAttachment(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveUploadAttachmentJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveUploadAttachmentProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *chatServiceServer) serveUploadAttachmentJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "UploadAttachment")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	d := json.NewDecoder(req.Body)
+	rawReqBody := json.RawMessage{}
+	if err := d.Decode(&rawReqBody); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	if context.DeadlineExceeded == ctx.Err() {
-		s.writeError(ctx, resp, twirp.NewError(twirp.DeadlineExceeded, "failed to read request: deadline exceeded"))
+	reqContent := new(UploadAttachmentRequest)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
+		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	s.writeError(ctx, resp, twirp.WrapError(malformedRequestError(msg), err))
-}
 
-// ChatServicePathPrefix is a convenience constant that may identify URL paths.
-// Should be used with caution, it only matches routes generated by Twirp Go clients,
-// with the default "/twirp" prefix and default CamelCase service and method names.
-// More info: https://twitchtv.github.io/twirp/docs/routing.html
-const ChatServicePathPrefix = "/twirp/acai.chat.ChatService/"
+	handler := s.ChatService.UploadAttachment
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*UploadAttachmentRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*UploadAttachmentRequest) when calling interceptor")
+					}
+					return s.ChatService.UploadAttachment(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*UploadAttachmentResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*UploadAttachmentResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
 
-func (s *chatServiceServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	ctx := req.Context()
-	ctx = ctxsetters.WithPackageName(ctx, "acai.chat")
-	ctx = ctxsetters.WithServiceName(ctx, "ChatService")
-	ctx = ctxsetters.WithResponseWriter(ctx, resp)
+	// Call service method
+	var respContent *UploadAttachmentResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
 
-	var err error
-	ctx, err = callRequestReceived(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
-
-	if req.Method != "POST" {
-		msg := fmt.Sprintf("unsupported method %q (only POST is allowed)", req.Method)
-		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *UploadAttachmentResponse and nil error while calling UploadAttachment. nil responses are not supported"))
 		return
 	}
 
-	// Verify path format: [<prefix>]/<package>.<Service>/<Method>
-	prefix, pkgService, method := parseTwirpPath(req.URL.Path)
-	if pkgService != "acai.chat.ChatService" {
-		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
-		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	marshaler := &protojson.MarshalOptions{UseProtoNames: !s.jsonCamelCase, EmitUnpopulated: !s.jsonSkipDefaults}
+	respBytes, err := marshaler.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
 		return
 	}
-	if prefix != s.pathPrefix {
-		msg := fmt.Sprintf("invalid path prefix %q, expected %q, on path %q", prefix, s.pathPrefix, req.URL.Path)
-		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *chatServiceServer) serveUploadAttachmentProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "UploadAttachment")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
 		return
 	}
 
-	switch method {
-	case "StartConversation":
-		s.serveStartConversation(ctx, resp, req)
+	buf, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
 		return
-	case "ContinueConversation":
-		s.serveContinueConversation(ctx, resp, req)
+	}
+	reqContent := new(UploadAttachmentRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
-	case "ListConversations":
-		s.serveListConversations(ctx, resp, req)
+	}
+
+	handler := s.ChatService.UploadAttachment
+	if s.interceptor != nil {
+		handler = func(ctx context.Context, req *UploadAttachmentRequest) (*UploadAttachmentResponse, error) {
+			resp, err := s.interceptor(
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					typedReq, ok := req.(*UploadAttachmentRequest)
+					if !ok {
+						return nil, twirp.InternalError("failed type assertion req.(*UploadAttachmentRequest) when calling interceptor")
+					}
+					return s.ChatService.UploadAttachment(ctx, typedReq)
+				},
+			)(ctx, req)
+			if resp != nil {
+				typedResp, ok := resp.(*UploadAttachmentResponse)
+				if !ok {
+					return nil, twirp.InternalError("failed type assertion resp.(*UploadAttachmentResponse) when calling interceptor")
+				}
+				return typedResp, err
+			}
+			return nil, err
+		}
+	}
+
+	// Call service method
+	var respContent *UploadAttachmentResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = handler(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
 		return
-	case "DescribeConversation":
-		s.serveDescribeConversation(ctx, resp, req)
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *UploadAttachmentResponse and nil error while calling UploadAttachment. nil responses are not supported"))
 		return
-	default:
-		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
-		s.writeError(ctx, resp, badRouteError(msg, req.Method, req.URL.Path))
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
 		return
 	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		ctx = callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveStartConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveListAttachments(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -636,9 +4836,9 @@ func (s *chatServiceServer) serveStartConversation(ctx context.Context, resp htt
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveStartConversationJSON(ctx, resp, req)
+		s.serveListAttachmentsJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveStartConversationProtobuf(ctx, resp, req)
+		s.serveListAttachmentsProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -646,9 +4846,9 @@ func (s *chatServiceServer) serveStartConversation(ctx context.Context, resp htt
 	}
 }
 
-func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveListAttachmentsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "ListAttachments")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -661,29 +4861,29 @@ func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	reqContent := new(StartConversationRequest)
+	reqContent := new(ListAttachmentsRequest)
 	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
 	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
 
-	handler := s.ChatService.StartConversation
+	handler := s.ChatService.ListAttachments
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+		handler = func(ctx context.Context, req *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*StartConversationRequest)
+					typedReq, ok := req.(*ListAttachmentsRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*ListAttachmentsRequest) when calling interceptor")
 					}
-					return s.ChatService.StartConversation(ctx, typedReq)
+					return s.ChatService.ListAttachments(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*StartConversationResponse)
+				typedResp, ok := resp.(*ListAttachmentsResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*ListAttachmentsResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -692,7 +4892,7 @@ func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp
 	}
 
 	// Call service method
-	var respContent *StartConversationResponse
+	var respContent *ListAttachmentsResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -703,7 +4903,7 @@ func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *StartConversationResponse and nil error while calling StartConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListAttachmentsResponse and nil error while calling ListAttachments. nil responses are not supported"))
 		return
 	}
 
@@ -729,9 +4929,9 @@ func (s *chatServiceServer) serveStartConversationJSON(ctx context.Context, resp
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveListAttachmentsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "StartConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "ListAttachments")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -743,28 +4943,28 @@ func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context,
 		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
 		return
 	}
-	reqContent := new(StartConversationRequest)
+	reqContent := new(ListAttachmentsRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
-	handler := s.ChatService.StartConversation
+	handler := s.ChatService.ListAttachments
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *StartConversationRequest) (*StartConversationResponse, error) {
+		handler = func(ctx context.Context, req *ListAttachmentsRequest) (*ListAttachmentsResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*StartConversationRequest)
+					typedReq, ok := req.(*ListAttachmentsRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*StartConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*ListAttachmentsRequest) when calling interceptor")
 					}
-					return s.ChatService.StartConversation(ctx, typedReq)
+					return s.ChatService.ListAttachments(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*StartConversationResponse)
+				typedResp, ok := resp.(*ListAttachmentsResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*StartConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*ListAttachmentsResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -773,7 +4973,7 @@ func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context,
 	}
 
 	// Call service method
-	var respContent *StartConversationResponse
+	var respContent *ListAttachmentsResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -784,7 +4984,7 @@ func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context,
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *StartConversationResponse and nil error while calling StartConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListAttachmentsResponse and nil error while calling ListAttachments. nil responses are not supported"))
 		return
 	}
 
@@ -808,7 +5008,7 @@ func (s *chatServiceServer) serveStartConversationProtobuf(ctx context.Context,
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveContinueConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetImage(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -816,9 +5016,9 @@ func (s *chatServiceServer) serveContinueConversation(ctx context.Context, resp
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveContinueConversationJSON(ctx, resp, req)
+		s.serveGetImageJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveContinueConversationProtobuf(ctx, resp, req)
+		s.serveGetImageProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -826,9 +5026,9 @@ func (s *chatServiceServer) serveContinueConversation(ctx context.Context, resp
 	}
 }
 
-func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetImageJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "GetImage")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -841,29 +5041,29 @@ func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, r
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	reqContent := new(ContinueConversationRequest)
+	reqContent := new(GetImageRequest)
 	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
 	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
 
-	handler := s.ChatService.ContinueConversation
+	handler := s.ChatService.GetImage
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+		handler = func(ctx context.Context, req *GetImageRequest) (*GetImageResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ContinueConversationRequest)
+					typedReq, ok := req.(*GetImageRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*GetImageRequest) when calling interceptor")
 					}
-					return s.ChatService.ContinueConversation(ctx, typedReq)
+					return s.ChatService.GetImage(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ContinueConversationResponse)
+				typedResp, ok := resp.(*GetImageResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*GetImageResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -872,7 +5072,7 @@ func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, r
 	}
 
 	// Call service method
-	var respContent *ContinueConversationResponse
+	var respContent *GetImageResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -883,7 +5083,7 @@ func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, r
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ContinueConversationResponse and nil error while calling ContinueConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetImageResponse and nil error while calling GetImage. nil responses are not supported"))
 		return
 	}
 
@@ -909,9 +5109,9 @@ func (s *chatServiceServer) serveContinueConversationJSON(ctx context.Context, r
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetImageProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ContinueConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "GetImage")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -923,28 +5123,28 @@ func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Contex
 		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
 		return
 	}
-	reqContent := new(ContinueConversationRequest)
+	reqContent := new(GetImageRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
-	handler := s.ChatService.ContinueConversation
+	handler := s.ChatService.GetImage
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *ContinueConversationRequest) (*ContinueConversationResponse, error) {
+		handler = func(ctx context.Context, req *GetImageRequest) (*GetImageResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ContinueConversationRequest)
+					typedReq, ok := req.(*GetImageRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ContinueConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*GetImageRequest) when calling interceptor")
 					}
-					return s.ChatService.ContinueConversation(ctx, typedReq)
+					return s.ChatService.GetImage(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ContinueConversationResponse)
+				typedResp, ok := resp.(*GetImageResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ContinueConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*GetImageResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -953,7 +5153,7 @@ func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Contex
 	}
 
 	// Call service method
-	var respContent *ContinueConversationResponse
+	var respContent *GetImageResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -964,7 +5164,7 @@ func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Contex
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ContinueConversationResponse and nil error while calling ContinueConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetImageResponse and nil error while calling GetImage. nil responses are not supported"))
 		return
 	}
 
@@ -988,7 +5188,7 @@ func (s *chatServiceServer) serveContinueConversationProtobuf(ctx context.Contex
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveListConversations(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetMessage(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -996,9 +5196,9 @@ func (s *chatServiceServer) serveListConversations(ctx context.Context, resp htt
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveListConversationsJSON(ctx, resp, req)
+		s.serveGetMessageJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveListConversationsProtobuf(ctx, resp, req)
+		s.serveGetMessageProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1006,9 +5206,9 @@ func (s *chatServiceServer) serveListConversations(ctx context.Context, resp htt
 	}
 }
 
-func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetMessageJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
+	ctx = ctxsetters.WithMethodName(ctx, "GetMessage")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1021,29 +5221,29 @@ func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	reqContent := new(ListConversationsRequest)
+	reqContent := new(GetMessageRequest)
 	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
 	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
 
-	handler := s.ChatService.ListConversations
+	handler := s.ChatService.GetMessage
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+		handler = func(ctx context.Context, req *GetMessageRequest) (*GetMessageResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ListConversationsRequest)
+					typedReq, ok := req.(*GetMessageRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*GetMessageRequest) when calling interceptor")
 					}
-					return s.ChatService.ListConversations(ctx, typedReq)
+					return s.ChatService.GetMessage(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ListConversationsResponse)
+				typedResp, ok := resp.(*GetMessageResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*GetMessageResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -1052,7 +5252,7 @@ func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp
 	}
 
 	// Call service method
-	var respContent *ListConversationsResponse
+	var respContent *GetMessageResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -1063,7 +5263,7 @@ func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListConversationsResponse and nil error while calling ListConversations. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetMessageResponse and nil error while calling GetMessage. nil responses are not supported"))
 		return
 	}
 
@@ -1089,9 +5289,9 @@ func (s *chatServiceServer) serveListConversationsJSON(ctx context.Context, resp
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveGetMessageProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ListConversations")
+	ctx = ctxsetters.WithMethodName(ctx, "GetMessage")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1103,28 +5303,28 @@ func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context,
 		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
 		return
 	}
-	reqContent := new(ListConversationsRequest)
+	reqContent := new(GetMessageRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
-	handler := s.ChatService.ListConversations
+	handler := s.ChatService.GetMessage
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *ListConversationsRequest) (*ListConversationsResponse, error) {
+		handler = func(ctx context.Context, req *GetMessageRequest) (*GetMessageResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*ListConversationsRequest)
+					typedReq, ok := req.(*GetMessageRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*ListConversationsRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*GetMessageRequest) when calling interceptor")
 					}
-					return s.ChatService.ListConversations(ctx, typedReq)
+					return s.ChatService.GetMessage(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*ListConversationsResponse)
+				typedResp, ok := resp.(*GetMessageResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*ListConversationsResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*GetMessageResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -1133,7 +5333,7 @@ func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context,
 	}
 
 	// Call service method
-	var respContent *ListConversationsResponse
+	var respContent *GetMessageResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -1144,7 +5344,7 @@ func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context,
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListConversationsResponse and nil error while calling ListConversations. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetMessageResponse and nil error while calling GetMessage. nil responses are not supported"))
 		return
 	}
 
@@ -1168,7 +5368,7 @@ func (s *chatServiceServer) serveListConversationsProtobuf(ctx context.Context,
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveDescribeConversation(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveCancelReply(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -1176,9 +5376,9 @@ func (s *chatServiceServer) serveDescribeConversation(ctx context.Context, resp
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveDescribeConversationJSON(ctx, resp, req)
+		s.serveCancelReplyJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveDescribeConversationProtobuf(ctx, resp, req)
+		s.serveCancelReplyProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1186,9 +5386,9 @@ func (s *chatServiceServer) serveDescribeConversation(ctx context.Context, resp
 	}
 }
 
-func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveCancelReplyJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "CancelReply")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1201,29 +5401,29 @@ func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, r
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
-	reqContent := new(DescribeConversationRequest)
+	reqContent := new(CancelReplyRequest)
 	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
 	if err = unmarshaler.Unmarshal(rawReqBody, reqContent); err != nil {
 		s.handleRequestBodyError(ctx, resp, "the json request could not be decoded", err)
 		return
 	}
 
-	handler := s.ChatService.DescribeConversation
+	handler := s.ChatService.CancelReply
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+		handler = func(ctx context.Context, req *CancelReplyRequest) (*CancelReplyResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*DescribeConversationRequest)
+					typedReq, ok := req.(*CancelReplyRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*CancelReplyRequest) when calling interceptor")
 					}
-					return s.ChatService.DescribeConversation(ctx, typedReq)
+					return s.ChatService.CancelReply(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*DescribeConversationResponse)
+				typedResp, ok := resp.(*CancelReplyResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*CancelReplyResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -1232,7 +5432,7 @@ func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, r
 	}
 
 	// Call service method
-	var respContent *DescribeConversationResponse
+	var respContent *CancelReplyResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -1243,7 +5443,7 @@ func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, r
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *DescribeConversationResponse and nil error while calling DescribeConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *CancelReplyResponse and nil error while calling CancelReply. nil responses are not supported"))
 		return
 	}
 
@@ -1269,9 +5469,9 @@ func (s *chatServiceServer) serveDescribeConversationJSON(ctx context.Context, r
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *chatServiceServer) serveDescribeConversationProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *chatServiceServer) serveCancelReplyProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "DescribeConversation")
+	ctx = ctxsetters.WithMethodName(ctx, "CancelReply")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1283,28 +5483,28 @@ func (s *chatServiceServer) serveDescribeConversationProtobuf(ctx context.Contex
 		s.handleRequestBodyError(ctx, resp, "failed to read request body", err)
 		return
 	}
-	reqContent := new(DescribeConversationRequest)
+	reqContent := new(CancelReplyRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
-	handler := s.ChatService.DescribeConversation
+	handler := s.ChatService.CancelReply
 	if s.interceptor != nil {
-		handler = func(ctx context.Context, req *DescribeConversationRequest) (*DescribeConversationResponse, error) {
+		handler = func(ctx context.Context, req *CancelReplyRequest) (*CancelReplyResponse, error) {
 			resp, err := s.interceptor(
 				func(ctx context.Context, req interface{}) (interface{}, error) {
-					typedReq, ok := req.(*DescribeConversationRequest)
+					typedReq, ok := req.(*CancelReplyRequest)
 					if !ok {
-						return nil, twirp.InternalError("failed type assertion req.(*DescribeConversationRequest) when calling interceptor")
+						return nil, twirp.InternalError("failed type assertion req.(*CancelReplyRequest) when calling interceptor")
 					}
-					return s.ChatService.DescribeConversation(ctx, typedReq)
+					return s.ChatService.CancelReply(ctx, typedReq)
 				},
 			)(ctx, req)
 			if resp != nil {
-				typedResp, ok := resp.(*DescribeConversationResponse)
+				typedResp, ok := resp.(*CancelReplyResponse)
 				if !ok {
-					return nil, twirp.InternalError("failed type assertion resp.(*DescribeConversationResponse) when calling interceptor")
+					return nil, twirp.InternalError("failed type assertion resp.(*CancelReplyResponse) when calling interceptor")
 				}
 				return typedResp, err
 			}
@@ -1313,7 +5513,7 @@ func (s *chatServiceServer) serveDescribeConversationProtobuf(ctx context.Contex
 	}
 
 	// Call service method
-	var respContent *DescribeConversationResponse
+	var respContent *CancelReplyResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
 		respContent, err = handler(ctx, reqContent)
@@ -1324,7 +5524,7 @@ func (s *chatServiceServer) serveDescribeConversationProtobuf(ctx context.Contex
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *DescribeConversationResponse and nil error while calling DescribeConversation. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *CancelReplyResponse and nil error while calling CancelReply. nil responses are not supported"))
 		return
 	}
 
@@ -1929,39 +6129,155 @@ func callClientError(ctx context.Context, h *twirp.ClientHooks, err twirp.Error)
 }
 
 var twirpFileDescriptor0 = []byte{
-	// 529 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x94, 0x51, 0x6b, 0xd3, 0x50,
-	0x14, 0xc7, 0x4d, 0xda, 0xd9, 0xe5, 0x64, 0xad, 0xdd, 0xa5, 0x60, 0x96, 0x15, 0x56, 0xa2, 0xb8,
-	0x3e, 0x48, 0x2a, 0x75, 0x0f, 0xc2, 0xf0, 0x61, 0x56, 0x85, 0xa1, 0x56, 0x48, 0x3a, 0x84, 0x09,
-	0xd3, 0x34, 0xbb, 0x66, 0x17, 0xd2, 0xdc, 0x98, 0x7b, 0x3b, 0xf0, 0xd3, 0xec, 0x83, 0xfa, 0x22,
-	0x4d, 0x6e, 0xe3, 0xbd, 0x34, 0xa9, 0x8a, 0x8f, 0xe7, 0xe4, 0x7f, 0xcf, 0xf9, 0xfd, 0xcf, 0x39,
-	0x04, 0x3a, 0x59, 0x1a, 0x8e, 0xc2, 0x9b, 0x80, 0xbb, 0x69, 0x46, 0x39, 0x45, 0x46, 0x10, 0x06,
-	0xc4, 0x5d, 0x25, 0xec, 0xa3, 0x88, 0xd2, 0x28, 0xc6, 0xa3, 0xfc, 0xc3, 0x7c, 0xf9, 0x6d, 0xc4,
-	0xc9, 0x02, 0x33, 0x1e, 0x2c, 0xd2, 0x42, 0xeb, 0xfc, 0xd4, 0x61, 0x6f, 0x42, 0x93, 0x5b, 0x9c,
-	0xb1, 0x80, 0x13, 0x9a, 0xa0, 0x0e, 0xe8, 0xe4, 0xda, 0xd2, 0x06, 0xda, 0xd0, 0xf0, 0x74, 0x72,
-	0x8d, 0x7a, 0xb0, 0xc3, 0x09, 0x8f, 0xb1, 0xa5, 0xe7, 0xa9, 0x22, 0x40, 0x2f, 0xc0, 0x28, 0x2b,
-	0x59, 0x8d, 0x81, 0x36, 0x34, 0xc7, 0xb6, 0x5b, 0xf4, 0x72, 0xd7, 0xbd, 0xdc, 0xd9, 0x5a, 0xe1,
-	0xfd, 0x16, 0xa3, 0x53, 0xd8, 0x5d, 0x60, 0xc6, 0x82, 0x08, 0x33, 0xab, 0x39, 0x68, 0x0c, 0xcd,
-	0xf1, 0x91, 0x5b, 0xf2, 0xba, 0x32, 0x8a, 0xfb, 0xa1, 0xd0, 0x79, 0xe5, 0x03, 0xfb, 0x4e, 0x83,
-	0x96, 0xc8, 0x6e, 0x80, 0x3e, 0x83, 0x66, 0x46, 0x05, 0x67, 0x67, 0xdc, 0xaf, 0x2b, 0xea, 0xd1,
-	0x18, 0x7b, 0xb9, 0x12, 0x59, 0xd0, 0x0a, 0x69, 0xc2, 0x71, 0xc2, 0x73, 0x0b, 0x86, 0xb7, 0x0e,
-	0x55, 0x7b, 0xcd, 0x7f, 0xb0, 0xe7, 0x3c, 0x85, 0xe6, 0xaa, 0x03, 0x32, 0xa1, 0x75, 0x31, 0x7d,
-	0x37, 0xfd, 0xf8, 0x69, 0xda, 0xbd, 0x87, 0x76, 0xa1, 0x79, 0xe1, 0xbf, 0xf1, 0xba, 0x1a, 0x6a,
-	0x83, 0x71, 0xe6, 0xfb, 0xe7, 0xfe, 0xec, 0x6c, 0x3a, 0xeb, 0xea, 0xce, 0x09, 0x58, 0x3e, 0x0f,
-	0x32, 0x2e, 0x13, 0x7a, 0xf8, 0xfb, 0x12, 0x33, 0xbe, 0xa2, 0x13, 0xbe, 0x85, 0xc9, 0x75, 0xe8,
-	0xa4, 0x70, 0x50, 0xf1, 0x8a, 0xa5, 0x34, 0x61, 0x18, 0x1d, 0xc3, 0x83, 0x50, 0xca, 0x7f, 0x29,
-	0x67, 0xd4, 0x91, 0xd3, 0xe7, 0x75, 0x8b, 0xed, 0xc1, 0x4e, 0x86, 0xd3, 0xf8, 0x87, 0x98, 0x48,
-	0x11, 0x38, 0x5f, 0xe1, 0x70, 0x42, 0x13, 0x4e, 0x92, 0x25, 0xae, 0x42, 0xfd, 0xeb, 0x9e, 0x92,
-	0x27, 0x5d, 0xf5, 0x74, 0x02, 0xfd, 0xea, 0x0e, 0xc2, 0x56, 0xc9, 0xa5, 0xc9, 0x5c, 0x36, 0x58,
-	0xef, 0x09, 0x53, 0x06, 0xc1, 0x04, 0x94, 0x73, 0x09, 0x07, 0x15, 0xdf, 0x44, 0xb9, 0x97, 0xd0,
-	0x96, 0xd1, 0x98, 0xa5, 0xe5, 0xa7, 0xf8, 0xb0, 0xe6, 0x6a, 0x3c, 0x55, 0xed, 0xbc, 0x85, 0xc3,
-	0xd7, 0x98, 0x85, 0x19, 0x99, 0xff, 0xd7, 0x3c, 0x9c, 0xcf, 0xd0, 0xaf, 0xae, 0x23, 0x30, 0x4f,
-	0x61, 0x4f, 0x7e, 0x91, 0x57, 0xd9, 0x42, 0xa9, 0x88, 0xc7, 0x77, 0x0d, 0x30, 0x27, 0x37, 0x01,
-	0xf7, 0x71, 0x76, 0x4b, 0x42, 0x8c, 0xae, 0x60, 0x7f, 0xe3, 0x6c, 0xd0, 0x23, 0xa9, 0x56, 0xdd,
-	0x29, 0xda, 0x8f, 0xb7, 0x8b, 0x04, 0x6c, 0x04, 0xbd, 0xaa, 0x15, 0xa2, 0x27, 0x2a, 0x6e, 0xdd,
-	0x15, 0xd9, 0xc7, 0x7f, 0xd4, 0x89, 0x46, 0x57, 0xb0, 0xbf, 0xb1, 0x59, 0xc5, 0x48, 0xdd, 0x4d,
-	0x28, 0x46, 0xea, 0x8f, 0x23, 0x82, 0x5e, 0xd5, 0x56, 0x14, 0x23, 0x5b, 0xd6, 0xaf, 0x18, 0xd9,
-	0xb6, 0xde, 0x57, 0xed, 0x4b, 0x93, 0x24, 0x1c, 0x67, 0x49, 0x10, 0x8f, 0xd2, 0xf9, 0xfc, 0x7e,
-	0xfe, 0x6b, 0x79, 0xfe, 0x2b, 0x00, 0x00, 0xff, 0xff, 0x59, 0x83, 0x17, 0xf4, 0xd0, 0x05, 0x00,
-	0x00,
+	// 2397 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x59, 0x5b, 0x73, 0xdb, 0xc6,
+	0x15, 0x36, 0x78, 0x13, 0x79, 0xa8, 0x0b, 0xb5, 0x92, 0x25, 0x1a, 0x52, 0x62, 0x79, 0x1d, 0xdb,
+	0x9a, 0xd6, 0x91, 0x5b, 0x79, 0x9a, 0xb4, 0x71, 0x3d, 0xa9, 0x2c, 0xc9, 0x36, 0x5b, 0x5d, 0x5c,
+	0x90, 0x4a, 0xda, 0x78, 0x26, 0x0c, 0x04, 0xac, 0x25, 0x54, 0x20, 0x80, 0x00, 0x4b, 0xdb, 0x4a,
+	0x66, 0x9a, 0x4e, 0x3b, 0xd3, 0xf7, 0xbe, 0xb6, 0xd3, 0xb7, 0xf6, 0xb9, 0x3f, 0xa1, 0xd3, 0xb7,
+	0x4e, 0x7f, 0x45, 0xf3, 0x23, 0xfa, 0xde, 0xd9, 0x0b, 0x80, 0x05, 0x09, 0x52, 0x92, 0xed, 0xf8,
+	0x0d, 0x7b, 0xf6, 0xdb, 0x73, 0xdf, 0xdd, 0xb3, 0x07, 0x30, 0x1d, 0x06, 0xd6, 0x1d, 0xeb, 0xd8,
+	0xa4, 0x6b, 0x41, 0xe8, 0x53, 0x1f, 0xd5, 0x4c, 0xcb, 0x74, 0xd6, 0x18, 0x41, 0x5f, 0x3e, 0xf2,
+	0xfd, 0x23, 0x97, 0xdc, 0xe1, 0x13, 0x87, 0xfd, 0x67, 0x77, 0x22, 0x1a, 0xf6, 0x2d, 0x09, 0xd4,
+	0xaf, 0x0e, 0xce, 0x52, 0xa7, 0x47, 0x22, 0x6a, 0xf6, 0x02, 0x01, 0xc0, 0xdf, 0x56, 0x61, 0x72,
+	0xd3, 0xf7, 0x9e, 0x93, 0x30, 0x32, 0xa9, 0xe3, 0x7b, 0x68, 0x1a, 0x0a, 0x8e, 0xdd, 0xd4, 0x56,
+	0xb4, 0xd5, 0x9a, 0x51, 0x70, 0x6c, 0x34, 0x0f, 0x65, 0xea, 0x50, 0x97, 0x34, 0x0b, 0x9c, 0x24,
+	0x06, 0xe8, 0xc7, 0x50, 0x4b, 0x38, 0x35, 0x8b, 0x2b, 0xda, 0x6a, 0x7d, 0x5d, 0x5f, 0x13, 0xb2,
+	0xd6, 0x62, 0x59, 0x6b, 0x9d, 0x18, 0x61, 0xa4, 0x60, 0x74, 0x0f, 0xaa, 0x3d, 0x12, 0x45, 0xe6,
+	0x11, 0x89, 0x9a, 0xa5, 0x95, 0xe2, 0x6a, 0x7d, 0xfd, 0xea, 0x5a, 0x62, 0xcd, 0x9a, 0xaa, 0xca,
+	0xda, 0xae, 0xc0, 0x19, 0xc9, 0x02, 0x74, 0x03, 0xa6, 0xcd, 0x20, 0x08, 0xfd, 0xe7, 0xc4, 0xee,
+	0x52, 0xdf, 0x77, 0xa3, 0x66, 0x79, 0xa5, 0xb8, 0x5a, 0x33, 0xa6, 0x62, 0x6a, 0x87, 0x11, 0xd1,
+	0x4d, 0x28, 0xf7, 0xd9, 0x82, 0x66, 0x85, 0x6b, 0xd6, 0x50, 0x04, 0x1c, 0x70, 0x8e, 0x62, 0x1a,
+	0x7d, 0x0f, 0x66, 0x4d, 0x8b, 0x3a, 0xcf, 0x49, 0x57, 0x4a, 0xe8, 0x3a, 0x76, 0x73, 0x82, 0xdb,
+	0x39, 0x23, 0x26, 0xa4, 0x0a, 0x2d, 0x1b, 0xdd, 0x87, 0xa5, 0x67, 0x7e, 0x78, 0x42, 0xec, 0xee,
+	0xb3, 0xd0, 0xef, 0x75, 0x2d, 0x45, 0x51, 0xb6, 0xaa, 0xca, 0x57, 0x35, 0x05, 0xe4, 0x61, 0xe8,
+	0xf7, 0x54, 0x4b, 0x5a, 0x36, 0xba, 0x0b, 0x0b, 0xea, 0x72, 0x45, 0x5e, 0x8d, 0xaf, 0x9c, 0x4b,
+	0x57, 0x26, 0x32, 0xf5, 0xff, 0x68, 0x50, 0x65, 0x16, 0x6d, 0x9a, 0xae, 0x3b, 0x14, 0x18, 0x04,
+	0x25, 0xcf, 0xec, 0xc5, 0x71, 0xe1, 0xdf, 0x68, 0x19, 0x6a, 0x66, 0x78, 0xd4, 0xef, 0x11, 0x8f,
+	0x46, 0x3c, 0x2c, 0x35, 0x23, 0x25, 0xa0, 0x8f, 0xa1, 0x12, 0x51, 0x93, 0xf6, 0x99, 0xe3, 0xb5,
+	0xd5, 0xe9, 0xf5, 0x5b, 0xa3, 0x1c, 0x1f, 0xcb, 0x5c, 0x6b, 0x73, 0xb8, 0x21, 0x97, 0xe1, 0x7b,
+	0x50, 0x11, 0x14, 0x54, 0x85, 0xd2, 0xc6, 0x41, 0x67, 0xbf, 0x71, 0x09, 0xd5, 0x61, 0xe2, 0xc9,
+	0xf6, 0xde, 0x56, 0x6b, 0xef, 0x51, 0x43, 0x43, 0x93, 0x50, 0xdd, 0x78, 0xf2, 0xc4, 0xd8, 0xff,
+	0x64, 0x7b, 0xab, 0x51, 0x60, 0x23, 0x63, 0xfb, 0xe7, 0xdb, 0x9b, 0x9d, 0xed, 0xad, 0x46, 0x51,
+	0xff, 0x67, 0x11, 0x26, 0xa4, 0x69, 0x43, 0xb6, 0xfc, 0x00, 0x4a, 0xa1, 0x2f, 0x73, 0x6c, 0x7a,
+	0x7d, 0x79, 0x94, 0x5e, 0x86, 0xef, 0x12, 0x83, 0x23, 0x51, 0x13, 0x26, 0x2c, 0xdf, 0xa3, 0xc4,
+	0xa3, 0xd2, 0xce, 0x78, 0x98, 0x4d, 0xcd, 0xd2, 0x45, 0x52, 0xf3, 0x63, 0x00, 0x96, 0x54, 0x5d,
+	0xcb, 0x74, 0x65, 0x66, 0xd5, 0xd7, 0x57, 0xce, 0xf2, 0x91, 0x51, 0xa3, 0xf2, 0x2b, 0x42, 0x2b,
+	0x30, 0x99, 0x30, 0x60, 0xa1, 0xad, 0x70, 0xcd, 0x20, 0x06, 0xb4, 0xec, 0x34, 0x33, 0x27, 0xc6,
+	0x67, 0xe6, 0x12, 0xd4, 0x02, 0x33, 0x24, 0x1e, 0x4d, 0x73, 0xab, 0x2a, 0x08, 0x2d, 0x1b, 0xfd,
+	0x10, 0x6a, 0x96, 0x43, 0xb9, 0x1a, 0x51, 0xb3, 0xc6, 0xd5, 0x9c, 0x53, 0xd5, 0x94, 0x73, 0x46,
+	0x8a, 0x42, 0x37, 0xa0, 0x1c, 0x98, 0x21, 0x8d, 0x9a, 0xc0, 0xe1, 0x33, 0x0a, 0xfc, 0x89, 0x19,
+	0x52, 0x43, 0xcc, 0xb2, 0xfc, 0xb1, 0x4c, 0xcf, 0x22, 0xae, 0x4b, 0xec, 0x66, 0x7d, 0x45, 0x5b,
+	0xad, 0x1a, 0x29, 0x01, 0x7f, 0x00, 0x25, 0x16, 0x01, 0x16, 0xf2, 0x83, 0xbd, 0x5f, 0xec, 0xed,
+	0x7f, 0xba, 0xd7, 0xb8, 0xc4, 0x32, 0xe1, 0xa0, 0xbd, 0x6d, 0x34, 0x34, 0x34, 0x05, 0xb5, 0x8d,
+	0x76, 0xbb, 0xd5, 0xee, 0x6c, 0xec, 0x75, 0x1a, 0x05, 0x36, 0xd1, 0xd9, 0xdf, 0xdf, 0x69, 0x14,
+	0xf1, 0x3f, 0x34, 0x28, 0x73, 0xeb, 0xd8, 0x61, 0xd2, 0xf3, 0x6d, 0xe2, 0xca, 0xd0, 0x8b, 0x01,
+	0xba, 0x0e, 0x53, 0x41, 0xe8, 0xf7, 0x02, 0xda, 0xa5, 0xfe, 0x09, 0xf1, 0x22, 0x9e, 0x06, 0x45,
+	0x63, 0x52, 0x10, 0x3b, 0x9c, 0x86, 0xbe, 0x0f, 0xb3, 0x96, 0xdf, 0x0b, 0x5c, 0xc2, 0x77, 0x9c,
+	0x04, 0x16, 0x39, 0xb0, 0x91, 0x4e, 0x48, 0xf0, 0x75, 0x98, 0xb2, 0x4c, 0xeb, 0x98, 0x9f, 0x12,
+	0x1c, 0x58, 0x12, 0x1c, 0x05, 0x51, 0x82, 0xae, 0x40, 0xd5, 0xf2, 0x23, 0xda, 0xed, 0x47, 0x76,
+	0xb3, 0xbc, 0xa2, 0xad, 0x6a, 0x2c, 0x87, 0x22, 0x7a, 0x10, 0xd9, 0x78, 0x0f, 0x4a, 0xcc, 0x2d,
+	0x68, 0x1e, 0x4a, 0x94, 0xbc, 0xa4, 0x42, 0xdd, 0xc7, 0x97, 0x0c, 0x3e, 0x42, 0xab, 0x50, 0x76,
+	0x7a, 0x2c, 0x88, 0x85, 0xa1, 0x20, 0xb6, 0x18, 0xfd, 0xf1, 0x25, 0x43, 0x00, 0x1e, 0x54, 0xa0,
+	0xc4, 0x1c, 0x8b, 0xbf, 0x80, 0x72, 0xab, 0x97, 0x97, 0xf8, 0x0d, 0x28, 0xf6, 0x43, 0x57, 0xee,
+	0x61, 0xf6, 0x89, 0xae, 0xc1, 0xa4, 0xcc, 0xe4, 0x2e, 0x3d, 0x0d, 0x88, 0xcc, 0xee, 0xba, 0xa4,
+	0x75, 0x4e, 0x03, 0xc2, 0x76, 0xbe, 0x6d, 0x52, 0x93, 0x1b, 0x35, 0x69, 0xf0, 0x6f, 0xfc, 0x57,
+	0x0d, 0x9a, 0x6d, 0x6a, 0x86, 0x54, 0x4d, 0x52, 0x83, 0x7c, 0xd9, 0x27, 0x11, 0x65, 0x9b, 0x45,
+	0x1e, 0x38, 0x52, 0x74, 0x3c, 0x4c, 0xf3, 0xa2, 0x30, 0x36, 0x2f, 0x7e, 0x06, 0x33, 0x21, 0x89,
+	0x02, 0xdf, 0x8b, 0x48, 0x37, 0xb2, 0x8e, 0x49, 0xcf, 0x94, 0x87, 0xfe, 0xe2, 0xd0, 0xce, 0x6a,
+	0xf3, 0xeb, 0xc7, 0x98, 0x8e, 0xf1, 0x6d, 0x0e, 0xc7, 0x7f, 0x28, 0xc0, 0x95, 0x1c, 0xfd, 0x04,
+	0x06, 0xdd, 0x82, 0x99, 0xc1, 0x03, 0x55, 0x28, 0x3a, 0x6d, 0x65, 0x8f, 0xd1, 0xfc, 0xdb, 0x68,
+	0x1e, 0xca, 0x21, 0x09, 0xdc, 0x53, 0xe9, 0x2c, 0x31, 0x40, 0x7b, 0x80, 0x02, 0xe2, 0xd9, 0x8e,
+	0x77, 0xd4, 0x55, 0xb6, 0x75, 0xe9, 0x9c, 0xdb, 0xba, 0x21, 0xd7, 0x76, 0x92, 0xdd, 0xbd, 0x01,
+	0x0d, 0x71, 0xb7, 0xf6, 0x43, 0x62, 0x77, 0x85, 0xc0, 0x32, 0xf7, 0xc2, 0xc2, 0x90, 0x17, 0x3e,
+	0x31, 0xdd, 0x3e, 0x31, 0x66, 0x52, 0xbc, 0xc1, 0xe0, 0xf8, 0x77, 0x05, 0x58, 0xda, 0xf4, 0x3d,
+	0xea, 0x78, 0x7d, 0x92, 0x17, 0xa8, 0x73, 0xfb, 0x41, 0x89, 0x68, 0x61, 0x44, 0x44, 0x8b, 0x17,
+	0x8d, 0x68, 0xe9, 0x42, 0x11, 0x65, 0x4e, 0x37, 0xa3, 0x53, 0xcf, 0xe2, 0x3e, 0xa8, 0x1a, 0x62,
+	0x80, 0xae, 0x42, 0xfd, 0x05, 0x39, 0x3c, 0xf6, 0xfd, 0x93, 0x2e, 0x4b, 0x6c, 0x79, 0x02, 0x4a,
+	0xd2, 0x41, 0xe8, 0xe2, 0xff, 0x69, 0xb0, 0x9c, 0xef, 0x02, 0x99, 0x0b, 0x49, 0x30, 0xb5, 0xb3,
+	0x83, 0x59, 0x78, 0xa3, 0xc1, 0x2c, 0x5e, 0x28, 0x98, 0xe8, 0x76, 0xaa, 0x92, 0x72, 0x9d, 0x97,
+	0xb8, 0xd6, 0xb1, 0xc0, 0xe4, 0x2e, 0xc7, 0x3a, 0x34, 0x77, 0x9c, 0x28, 0x93, 0xfe, 0x91, 0x0c,
+	0x3b, 0xfe, 0x0c, 0xae, 0xe4, 0xcc, 0x49, 0x7f, 0xdc, 0x87, 0x29, 0x35, 0xf8, 0x51, 0x53, 0xe3,
+	0x46, 0x2f, 0x8e, 0x30, 0xda, 0xc8, 0xa2, 0xb1, 0x05, 0x4b, 0x5b, 0x24, 0xb2, 0x42, 0xe7, 0xf0,
+	0xf5, 0x32, 0x6e, 0x09, 0x6a, 0xcf, 0xfa, 0xae, 0xdb, 0xa5, 0x21, 0x11, 0x39, 0x57, 0x35, 0xaa,
+	0x8c, 0xd0, 0x09, 0x09, 0xc1, 0x4f, 0x61, 0x39, 0x5f, 0x88, 0xb4, 0xe1, 0x1e, 0x3f, 0xd4, 0x12,
+	0x3a, 0x17, 0x31, 0xc6, 0x84, 0x0c, 0x18, 0xff, 0x57, 0x83, 0x12, 0x0b, 0x5c, 0x52, 0xf1, 0x68,
+	0x4a, 0xc5, 0xb3, 0x02, 0x75, 0x9b, 0x4b, 0x0e, 0x38, 0x63, 0xb1, 0x19, 0x54, 0x12, 0xfa, 0x10,
+	0x20, 0x30, 0x43, 0xb3, 0x47, 0x28, 0x09, 0xa3, 0xb3, 0x8e, 0x2d, 0x05, 0xca, 0xf6, 0x18, 0xf1,
+	0xcc, 0x43, 0x97, 0x88, 0xa0, 0x56, 0x8d, 0x78, 0x88, 0x56, 0xa1, 0x14, 0x3a, 0xd1, 0x09, 0xcf,
+	0xfc, 0xe9, 0xf5, 0x79, 0xc5, 0x0c, 0xa6, 0xe7, 0x9a, 0xe1, 0x44, 0x27, 0x06, 0x47, 0xe0, 0x1b,
+	0x50, 0x62, 0x23, 0x34, 0x01, 0xc5, 0x9d, 0xfd, 0x4f, 0x1b, 0x97, 0x10, 0x40, 0x65, 0x77, 0x7b,
+	0xab, 0x75, 0xb0, 0xdb, 0xd0, 0xd8, 0x0d, 0xf9, 0xb8, 0xf5, 0xe8, 0x71, 0xa3, 0x80, 0x11, 0x34,
+	0x58, 0x02, 0xb0, 0xd5, 0x49, 0x52, 0x7c, 0x04, 0xb3, 0x0a, 0x4d, 0x3a, 0xf2, 0x06, 0x94, 0x45,
+	0xdd, 0xab, 0x0d, 0xed, 0x6e, 0x06, 0x34, 0xc4, 0x2c, 0xfe, 0xa3, 0x06, 0x0b, 0x1b, 0xa2, 0x24,
+	0x4e, 0xf6, 0xc0, 0x45, 0x03, 0x3e, 0x58, 0xcc, 0x14, 0x86, 0x8a, 0x99, 0x6b, 0x30, 0x69, 0xba,
+	0x2f, 0xcc, 0xd3, 0xa8, 0x6b, 0xba, 0xae, 0xff, 0x82, 0xfb, 0xb6, 0x6a, 0xd4, 0x05, 0x6d, 0x83,
+	0x91, 0xf0, 0x37, 0xb0, 0x38, 0xa4, 0xc7, 0xdb, 0xdc, 0xe7, 0xf8, 0x2b, 0xb8, 0x6c, 0x90, 0xdf,
+	0x10, 0x8b, 0x7e, 0x87, 0x7e, 0x58, 0x80, 0x4a, 0x48, 0xcc, 0xc8, 0xf7, 0xe4, 0xfd, 0x23, 0x47,
+	0xf8, 0xb7, 0xb0, 0x30, 0x28, 0xfb, 0xad, 0xda, 0xfe, 0x05, 0x93, 0x7f, 0x44, 0x3c, 0x12, 0x9a,
+	0x94, 0xf0, 0x33, 0xeb, 0xc2, 0xc6, 0xbf, 0x03, 0xa0, 0x9c, 0x6d, 0xc2, 0xf4, 0x5a, 0x2f, 0x39,
+	0xd4, 0xbe, 0x81, 0xc5, 0x21, 0x09, 0x6f, 0xd5, 0xc4, 0xe7, 0x80, 0xb6, 0x6d, 0x87, 0xc6, 0x2f,
+	0xc5, 0x37, 0x6b, 0x9e, 0x7a, 0xcb, 0x16, 0x33, 0xb7, 0x2c, 0xfe, 0xb3, 0x06, 0x73, 0x19, 0xc1,
+	0xd2, 0xea, 0x2c, 0x43, 0x6d, 0x90, 0x61, 0xe2, 0x94, 0xc2, 0xd9, 0x4e, 0x29, 0xbe, 0xb2, 0x53,
+	0x4c, 0x58, 0x7c, 0xe8, 0x87, 0x27, 0xaf, 0x75, 0xdc, 0x9f, 0x11, 0xf8, 0x5f, 0x43, 0x73, 0x58,
+	0xc4, 0x1b, 0x29, 0xe6, 0xf0, 0x63, 0xd0, 0xdb, 0xc4, 0x0c, 0xad, 0xe3, 0xbc, 0xab, 0x92, 0xad,
+	0xf9, 0xb2, 0x4f, 0xc2, 0x24, 0xad, 0xf8, 0x80, 0x51, 0x5d, 0xa7, 0xe7, 0x50, 0xce, 0xa9, 0x6c,
+	0x88, 0x01, 0xfe, 0x7b, 0x11, 0x96, 0x72, 0x59, 0x49, 0x45, 0x1f, 0xc1, 0x44, 0x48, 0xa2, 0xbe,
+	0x4b, 0xe3, 0xe3, 0xf4, 0x7d, 0xc5, 0xd9, 0x63, 0x16, 0xae, 0x19, 0x7c, 0x95, 0x11, 0xaf, 0xd6,
+	0x43, 0x28, 0xef, 0x9a, 0xd4, 0x3a, 0x3e, 0x2b, 0xfc, 0xaf, 0xf4, 0xcc, 0x8d, 0x3c, 0x27, 0x08,
+	0x48, 0xf2, 0xcc, 0x95, 0x43, 0xfd, 0xdf, 0x1a, 0x54, 0x84, 0x1e, 0xaf, 0x75, 0xbb, 0x32, 0xd7,
+	0x45, 0x96, 0x1f, 0x0a, 0xa5, 0x34, 0x43, 0x0c, 0xd8, 0x03, 0x8a, 0x47, 0xa3, 0x9b, 0x95, 0x3e,
+	0xc9, 0x89, 0x6d, 0x41, 0x43, 0x0f, 0x61, 0xa2, 0xc7, 0xcc, 0x4e, 0x3a, 0x39, 0xb7, 0xcf, 0xe9,
+	0x3f, 0xee, 0x2c, 0x23, 0x5e, 0x8c, 0xbf, 0x86, 0xcb, 0x6d, 0xd2, 0x33, 0x3d, 0xea, 0x58, 0x62,
+	0xd5, 0x2b, 0x04, 0x1b, 0x7d, 0x00, 0x8b, 0xe4, 0xa5, 0xe5, 0xf6, 0x6d, 0x32, 0xd4, 0x9b, 0x11,
+	0xba, 0x5f, 0x96, 0xd3, 0xd9, 0xc6, 0x0c, 0xfe, 0xb6, 0x00, 0x0b, 0x83, 0xd2, 0x65, 0x7e, 0x3c,
+	0x18, 0xcc, 0x8f, 0xd5, 0x8c, 0x7d, 0x79, 0x6b, 0x86, 0x52, 0xe3, 0xf7, 0x85, 0x24, 0x4c, 0xaf,
+	0xf9, 0xc8, 0xc9, 0xe6, 0x56, 0x71, 0x54, 0x6e, 0x95, 0x5e, 0xa5, 0x85, 0x52, 0xce, 0xb6, 0x50,
+	0x92, 0x9c, 0xa8, 0xa8, 0x39, 0x91, 0x69, 0xac, 0x4c, 0x5c, 0xa0, 0xb1, 0x82, 0x5d, 0xa8, 0xec,
+	0x92, 0x9e, 0x1f, 0x9e, 0x0e, 0xbd, 0x7f, 0x15, 0x1d, 0x0a, 0x63, 0xda, 0x38, 0x17, 0xe9, 0x30,
+	0xe2, 0xcb, 0x30, 0xc7, 0x0a, 0x27, 0x2e, 0xd1, 0x21, 0x49, 0x3d, 0xb5, 0x0d, 0xf3, 0x59, 0xb2,
+	0x8c, 0xf2, 0xfb, 0xac, 0x21, 0x29, 0x68, 0x32, 0xcc, 0xb3, 0x8a, 0xf3, 0x84, 0xde, 0x46, 0x02,
+	0xc1, 0xeb, 0x30, 0xb7, 0x45, 0x5c, 0x42, 0x89, 0x9c, 0x91, 0xa9, 0xba, 0x04, 0x35, 0x0e, 0x39,
+	0x4d, 0xc3, 0x2a, 0xd6, 0x9c, 0xb6, 0x6c, 0xbc, 0x00, 0xf3, 0xd9, 0x35, 0x42, 0x34, 0xfe, 0x97,
+	0x06, 0xb0, 0x41, 0xa9, 0x69, 0x1d, 0xb3, 0x06, 0xdd, 0x90, 0x73, 0x74, 0xa8, 0x3e, 0x73, 0x5c,
+	0xa2, 0x74, 0xf9, 0x92, 0xf1, 0x39, 0xdb, 0x04, 0x91, 0xf3, 0x15, 0x91, 0xbd, 0x0f, 0xfe, 0xcd,
+	0x4a, 0x15, 0xeb, 0xb8, 0xef, 0x9d, 0x44, 0x3c, 0xe4, 0x65, 0x43, 0x8e, 0xb2, 0xde, 0xae, 0x5c,
+	0xc4, 0xdb, 0x5f, 0x43, 0x35, 0x6e, 0x38, 0xb1, 0x53, 0xc3, 0x4c, 0xcc, 0x49, 0x1d, 0x31, 0x99,
+	0x12, 0x5b, 0xe3, 0xad, 0x9a, 0x87, 0x32, 0x57, 0x88, 0x9b, 0x53, 0x36, 0xc4, 0x80, 0x17, 0xe2,
+	0x2f, 0x2d, 0x12, 0x06, 0x54, 0xbe, 0xae, 0xe2, 0x21, 0xfe, 0x8b, 0x06, 0x8b, 0x07, 0x81, 0xeb,
+	0x9b, 0x76, 0xea, 0xc6, 0x0b, 0x5f, 0x75, 0xaf, 0xe9, 0x66, 0x25, 0x85, 0x45, 0x43, 0x26, 0x1e,
+	0xe2, 0x5f, 0x42, 0x73, 0x58, 0x39, 0x99, 0x75, 0x3f, 0x02, 0x48, 0xbd, 0x22, 0x4f, 0xec, 0xcb,
+	0x4a, 0xde, 0x29, 0x4b, 0x14, 0x20, 0xde, 0x80, 0x05, 0x96, 0xc4, 0xe9, 0x6c, 0x74, 0x51, 0x73,
+	0xb1, 0x01, 0x8b, 0x43, 0x2c, 0xa4, 0x52, 0x1f, 0x42, 0x3d, 0x95, 0x15, 0xef, 0x86, 0x11, 0x5a,
+	0xa9, 0x48, 0x7c, 0x1b, 0x66, 0x1e, 0x11, 0xca, 0x5b, 0x5c, 0xb1, 0x3e, 0x57, 0xa0, 0xca, 0x7b,
+	0x60, 0xa9, 0x22, 0x13, 0x7c, 0xdc, 0xb2, 0xf1, 0x47, 0xd0, 0x48, 0xd1, 0x52, 0xf4, 0xcd, 0xb8,
+	0xa7, 0xa6, 0xe5, 0xf7, 0xd4, 0x64, 0x47, 0x0d, 0x3f, 0x85, 0xd9, 0x47, 0xe4, 0x3b, 0xaa, 0xf7,
+	0xf0, 0xdf, 0x0a, 0x80, 0x54, 0xee, 0x52, 0xb7, 0x9f, 0x26, 0x7d, 0x73, 0x8d, 0x1f, 0xae, 0xef,
+	0x29, 0xca, 0x0d, 0xc3, 0x07, 0x9a, 0xe6, 0xe8, 0x27, 0xd9, 0x56, 0xcd, 0x39, 0xfe, 0x77, 0xc4,
+	0x78, 0xb6, 0x1d, 0x48, 0x18, 0xfa, 0x61, 0xdc, 0xd7, 0xe2, 0x83, 0x37, 0xdd, 0xd7, 0xc2, 0x77,
+	0x92, 0xae, 0xfe, 0x24, 0x54, 0x37, 0xf7, 0x77, 0x9f, 0xec, 0x6c, 0x77, 0xb6, 0x07, 0x3b, 0xfb,
+	0x00, 0x95, 0x87, 0x1b, 0xad, 0x1d, 0xd6, 0xd7, 0xc7, 0xf7, 0x01, 0x6d, 0xf2, 0xa6, 0xf0, 0x2b,
+	0xbd, 0x29, 0xf0, 0x5d, 0x98, 0xcb, 0x2c, 0x97, 0x5e, 0xce, 0xf4, 0x9e, 0x35, 0xbe, 0xff, 0x53,
+	0xc2, 0xfa, 0x9f, 0xa6, 0xa0, 0xbe, 0x79, 0x6c, 0xd2, 0x36, 0x09, 0x9f, 0x3b, 0x16, 0x41, 0x9f,
+	0xc3, 0xec, 0x50, 0x3b, 0x11, 0x5d, 0x57, 0xef, 0xe7, 0x11, 0xcd, 0x50, 0xfd, 0xbd, 0xf1, 0x20,
+	0xa9, 0xcd, 0x11, 0xcc, 0xe7, 0x75, 0xa9, 0xd0, 0xcd, 0xac, 0x83, 0x47, 0x75, 0xf2, 0xf4, 0x5b,
+	0x67, 0xe2, 0xa4, 0xa0, 0xcf, 0xc5, 0x33, 0x5f, 0x9d, 0x8b, 0x32, 0x86, 0x8c, 0xea, 0x1a, 0xe9,
+	0xef, 0x8d, 0x07, 0xa5, 0x86, 0xe4, 0xb5, 0x66, 0x32, 0x86, 0x8c, 0x69, 0x10, 0xe9, 0xb7, 0xce,
+	0xc4, 0x49, 0x41, 0x0f, 0xa1, 0x96, 0xf4, 0x2b, 0xd0, 0xd2, 0x80, 0x6e, 0x6a, 0x67, 0x43, 0x5f,
+	0xce, 0x9f, 0x94, 0x7c, 0x7e, 0x05, 0x33, 0x03, 0x2d, 0x03, 0x74, 0x4d, 0x3d, 0x82, 0x72, 0xdb,
+	0x1a, 0x3a, 0x1e, 0x07, 0x91, 0x9c, 0x0f, 0x60, 0x3a, 0xfb, 0x1e, 0x47, 0xea, 0x76, 0xc9, 0x6d,
+	0x13, 0xe8, 0xd7, 0xc6, 0x20, 0x52, 0x85, 0x07, 0x1e, 0xc1, 0x28, 0xbb, 0x2a, 0xef, 0x09, 0xae,
+	0xe3, 0x71, 0x10, 0xc9, 0x79, 0x07, 0xea, 0xca, 0x23, 0x13, 0xbd, 0xa3, 0x2c, 0x19, 0x7e, 0xf5,
+	0xea, 0xef, 0x8e, 0x9a, 0x96, 0xdc, 0x9e, 0x42, 0x63, 0xf0, 0xcd, 0x86, 0x54, 0x2d, 0x46, 0xbc,
+	0x19, 0xf5, 0xeb, 0x63, 0x31, 0x92, 0xb9, 0x0d, 0x73, 0x39, 0x15, 0x3f, 0xba, 0x71, 0xd6, 0x8b,
+	0x40, 0x88, 0xb8, 0x79, 0xbe, 0x87, 0x03, 0x8b, 0x60, 0xb6, 0xee, 0xce, 0x44, 0x30, 0xf7, 0x11,
+	0xa1, 0x5f, 0x1b, 0x83, 0x90, 0x6c, 0xf7, 0x61, 0x52, 0x2d, 0x0d, 0xd1, 0xbb, 0x03, 0x09, 0x3a,
+	0x50, 0x4a, 0xea, 0x57, 0x47, 0xce, 0xa7, 0x0c, 0xd5, 0x82, 0x2f, 0xc3, 0x30, 0xa7, 0x7a, 0xd4,
+	0xaf, 0x8e, 0x9c, 0x4f, 0x63, 0x37, 0x58, 0x4a, 0x64, 0x62, 0x37, 0xa2, 0x08, 0xd2, 0xaf, 0x8f,
+	0xc5, 0xa4, 0x09, 0x3c, 0x50, 0x11, 0x64, 0x12, 0x38, 0xbf, 0xe0, 0xd0, 0xf1, 0x38, 0x88, 0xe4,
+	0xbc, 0x09, 0xd5, 0xf8, 0xa6, 0x47, 0x7a, 0xf6, 0xd6, 0x54, 0x8b, 0x05, 0x7d, 0x29, 0x77, 0x4e,
+	0x32, 0x69, 0x01, 0xa4, 0xb7, 0x2c, 0x5a, 0x1e, 0x71, 0xf9, 0x0a, 0x46, 0xef, 0x8c, 0xbd, 0x9a,
+	0xd9, 0x86, 0x52, 0xae, 0x9e, 0xcc, 0x86, 0x1a, 0xbe, 0xd1, 0xf4, 0x77, 0x47, 0x4d, 0x0b, 0x6e,
+	0x0f, 0xa6, 0x3e, 0xab, 0x3b, 0x1e, 0x25, 0xa1, 0x67, 0xba, 0x77, 0x82, 0xc3, 0xc3, 0x0a, 0x2f,
+	0x94, 0xef, 0xfe, 0x7f, 0x00, 0x9f, 0xba, 0x9a, 0x2d, 0xad, 0x21, 0x00, 0x00,
 }
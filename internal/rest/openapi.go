@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// service describes the chat service the routes are documented from
+var service = pb.File_rpc_chat_proto.Services().ByName("ChatService")
+
+var pathParams = regexp.MustCompile(`\{(\w+)\}`)
+
+// OpenAPI returns the OpenAPI 3 document of the routes, generated from the protobuf descriptors of the
+// service so it follows the proto as it changes
+func OpenAPI() map[string]any {
+	g := &generator{schemas: map[string]any{
+		"Error": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"code": map[string]any{"type": "string"},
+				"msg":  map[string]any{"type": "string"},
+				"meta": map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+			},
+		},
+	}}
+
+	paths := map[string]any{}
+	for _, rt := range routes {
+		item, _ := paths[rt.path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = g.operation(rt)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   string(service.FullName()),
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.schemas},
+	}
+}
+
+type generator struct {
+	schemas map[string]any
+}
+
+// operation documents a route: its path parameters, the other scalar fields of the request as query
+// parameters when it has no body, and its request and response messages
+func (g *generator) operation(rt route) map[string]any {
+	method := methodOf(rt.call.input)
+	fields := rt.call.input.Fields()
+
+	var params []any
+	inPath := map[string]bool{}
+	for _, m := range pathParams.FindAllStringSubmatch(rt.path, -1) {
+		inPath[m[1]] = true
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   g.field(fields.ByName(protoreflect.Name(m[1]))),
+		})
+	}
+
+	op := map[string]any{
+		"operationId": string(method.Name()),
+		"tags":        []string{string(service.Name())},
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content":     jsonContent(g.message(method.Output())),
+			},
+			"default": map[string]any{
+				"description": "A Twirp error",
+				"content":     jsonContent(ref("Error")),
+			},
+		},
+	}
+
+	if hasBody(rt.method) {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  jsonContent(g.message(method.Input())),
+		}
+	} else {
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if inPath[string(fd.Name())] || fd.Message() != nil {
+				continue
+			}
+
+			params = append(params, map[string]any{
+				"name":   string(fd.Name()),
+				"in":     "query",
+				"schema": g.field(fd),
+			})
+		}
+	}
+
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	return op
+}
+
+// message returns a reference to the schema of the message, adding it and the messages it uses to the
+// components
+func (g *generator) message(md protoreflect.MessageDescriptor) map[string]any {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}
+	case "google.protobuf.Struct":
+		return map[string]any{"type": "object", "additionalProperties": true}
+	case "google.protobuf.Value":
+		return map[string]any{}
+	case "google.protobuf.ListValue":
+		return map[string]any{"type": "array", "items": map[string]any{}}
+	}
+
+	name := schemaName(md)
+	if _, ok := g.schemas[name]; ok {
+		return ref(name)
+	}
+
+	properties := map[string]any{}
+	schema := map[string]any{"type": "object", "properties": properties}
+	g.schemas[name] = schema
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[string(fd.Name())] = g.field(fd)
+	}
+
+	return ref(name)
+}
+
+// field returns the schema of the JSON form of a field
+func (g *generator) field(fd protoreflect.FieldDescriptor) map[string]any {
+	switch {
+	case fd.IsMap():
+		return map[string]any{"type": "object", "additionalProperties": g.value(fd.MapValue())}
+	case fd.IsList():
+		return map[string]any{"type": "array", "items": g.value(fd)}
+	default:
+		return g.value(fd)
+	}
+}
+
+func (g *generator) value(fd protoreflect.FieldDescriptor) map[string]any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// protojson writes 64-bit integers as strings
+		return map[string]any{"type": "string", "format": "int64"}
+	case protoreflect.FloatKind:
+		return map[string]any{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]any{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return map[string]any{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return g.message(fd.Message())
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+// methodOf returns the method of the service taking the request, each one has its own request message
+func methodOf(input protoreflect.MessageDescriptor) protoreflect.MethodDescriptor {
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		if methods.Get(i).Input().FullName() == input.FullName() {
+			return methods.Get(i)
+		}
+	}
+	panic("rest: no method of " + string(service.FullName()) + " takes " + string(input.FullName()))
+}
+
+// schemaName names the schema of a message after its name in the package, like Conversation.Message
+func schemaName(md protoreflect.MessageDescriptor) string {
+	return strings.TrimPrefix(string(md.FullName()), string(md.ParentFile().Package())+".")
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func hasBody(method string) bool {
+	return slices.Contains([]string{http.MethodPost, http.MethodPut, http.MethodPatch}, method)
+}
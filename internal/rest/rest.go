@@ -0,0 +1,192 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/gorilla/mux"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MaxBodyBytes bounds request bodies, attachments are sent inline encoded in base64
+const MaxBodyBytes = 32 << 20
+
+var (
+	read  = protojson.UnmarshalOptions{DiscardUnknown: true}
+	write = protojson.MarshalOptions{UseProtoNames: true}
+)
+
+// route maps an HTTP method and path to a method of the chat service. Path parameters are named after
+// the request fields they fill.
+type route struct {
+	method string
+	path   string
+	call   binding
+}
+
+// binding calls a method of the service with a request decoded by decode
+type binding struct {
+	input protoreflect.MessageDescriptor
+	call  func(ctx context.Context, svc pb.ChatService, decode func(proto.Message) error) (proto.Message, error)
+}
+
+// bind adapts a method of the service, as a method expression, to a binding
+func bind[Req any, PReq interface {
+	*Req
+	proto.Message
+}, Resp proto.Message](method func(pb.ChatService, context.Context, PReq) (Resp, error)) binding {
+	return binding{
+		input: PReq(new(Req)).ProtoReflect().Descriptor(),
+		call: func(ctx context.Context, svc pb.ChatService, decode func(proto.Message) error) (proto.Message, error) {
+			req := PReq(new(Req))
+			if err := decode(req); err != nil {
+				return nil, err
+			}
+			return method(svc, ctx, req)
+		},
+	}
+}
+
+var routes = []route{
+	{http.MethodGet, "/v1/conversations", bind(pb.ChatService.ListConversations)},
+	{http.MethodPost, "/v1/conversations", bind(pb.ChatService.StartConversation)},
+	{http.MethodGet, "/v1/conversations/{conversation_id}", bind(pb.ChatService.DescribeConversation)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/messages", bind(pb.ChatService.ContinueConversation)},
+	{http.MethodGet, "/v1/conversations/{conversation_id}/messages/{message_id}", bind(pb.ChatService.GetMessage)},
+	{http.MethodPatch, "/v1/conversations/{conversation_id}/messages/{message_id}", bind(pb.ChatService.EditMessage)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/regenerate", bind(pb.ChatService.RegenerateReply)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/fork", bind(pb.ChatService.ForkConversation)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/cancel", bind(pb.ChatService.CancelReply)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/tool-calls/{tool_call_id}/approve", bind(pb.ChatService.ApproveToolCall)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/tool-calls/{tool_call_id}/reject", bind(pb.ChatService.RejectToolCall)},
+	{http.MethodGet, "/v1/conversations/{conversation_id}/attachments", bind(pb.ChatService.ListAttachments)},
+	{http.MethodPost, "/v1/conversations/{conversation_id}/attachments", bind(pb.ChatService.UploadAttachment)},
+	{http.MethodGet, "/v1/search", bind(pb.ChatService.SearchConversations)},
+	{http.MethodGet, "/v1/semantic-search", bind(pb.ChatService.SemanticSearch)},
+	{http.MethodGet, "/v1/memories", bind(pb.ChatService.ListMemories)},
+	{http.MethodDelete, "/v1/memories/{memory_id}", bind(pb.ChatService.DeleteMemory)},
+	{http.MethodGet, "/v1/images/{image_id}", bind(pb.ChatService.GetImage)},
+	{http.MethodGet, "/v1/tools", bind(pb.ChatService.ListTools)},
+}
+
+// NewHandler serves the chat service as JSON REST routes under /v1, and their OpenAPI document at
+// /v1/openapi.json. Requests are built from the path, the query string and the JSON body, in the same
+// form as Twirp requests; responses and errors are written like Twirp's.
+func NewHandler(svc pb.ChatService) http.Handler {
+	r := mux.NewRouter()
+
+	for _, rt := range routes {
+		r.Handle(rt.path, serve(svc, rt.call)).Methods(rt.method)
+	}
+
+	doc := OpenAPI()
+	r.HandleFunc("/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(doc)
+	}).Methods(http.MethodGet)
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = twirp.WriteError(w, twirp.NewError(twirp.BadRoute, "no route for "+r.Method+" "+r.URL.Path))
+	})
+	r.MethodNotAllowedHandler = r.NotFoundHandler
+
+	return r
+}
+
+func serve(svc pb.ChatService, b binding) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := b.call(r.Context(), svc, func(req proto.Message) error { return decode(r, req) })
+		if err != nil {
+			_ = twirp.WriteError(w, err)
+			return
+		}
+
+		body, err := write.Marshal(resp)
+		if err != nil {
+			_ = twirp.WriteError(w, twirp.InternalErrorWith(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}
+}
+
+// decode fills the request from the JSON body, then the query string, then the path parameters
+func decode(r *http.Request, req proto.Message) error {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	if err != nil {
+		return twirp.NewError(twirp.Malformed, "failed to read the request body: "+err.Error())
+	}
+
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := read.Unmarshal(body, req); err != nil {
+			return twirp.NewError(twirp.Malformed, "the request body is not a valid request: "+err.Error())
+		}
+	}
+
+	for name, values := range r.URL.Query() {
+		if err := setField(req, name, values); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range mux.Vars(r) {
+		if err := setField(req, name, []string{value}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setField sets a scalar field of the request from its text values, parsed like its JSON form.
+// Unknown fields are ignored, like unknown fields in the body.
+func setField(req proto.Message, name string, values []string) error {
+	fields := req.ProtoReflect().Descriptor().Fields()
+
+	fd := fields.ByName(protoreflect.Name(name))
+	if fd == nil {
+		fd = fields.ByJSONName(name)
+	}
+	if fd == nil {
+		return nil
+	}
+
+	if fd.Message() != nil {
+		return twirp.InvalidArgumentError(name, "must be sent in the request body")
+	}
+
+	raw := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		if fd.Kind() == protoreflect.BoolKind {
+			raw[i] = json.RawMessage(v)
+		} else {
+			raw[i], _ = json.Marshal(v)
+		}
+	}
+
+	var value any = raw[len(raw)-1]
+	if fd.IsList() {
+		value = raw
+	}
+
+	doc, _ := json.Marshal(map[string]any{string(fd.Name()): value})
+
+	field := req.ProtoReflect().New().Interface()
+	if err := read.Unmarshal(doc, field); err != nil {
+		return twirp.InvalidArgumentError(name, "is not a valid "+fd.Kind().String())
+	}
+
+	req.ProtoReflect().Clear(fd)
+	proto.Merge(req, field)
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/acai-travel/tech-challenge/internal/pb"
+	"github.com/twitchtv/twirp"
+)
+
+// fakeService records the requests it gets, its other methods panic
+type fakeService struct {
+	pb.ChatService
+	continued *pb.ContinueConversationRequest
+	searched  *pb.SearchConversationsRequest
+}
+
+func (f *fakeService) ContinueConversation(ctx context.Context, req *pb.ContinueConversationRequest) (*pb.ContinueConversationResponse, error) {
+	f.continued = req
+	return &pb.ContinueConversationResponse{Reply: "It is sunny."}, nil
+}
+
+func (f *fakeService) SearchConversations(ctx context.Context, req *pb.SearchConversationsRequest) (*pb.SearchConversationsResponse, error) {
+	f.searched = req
+	return &pb.SearchConversationsResponse{}, nil
+}
+
+func (f *fakeService) DescribeConversation(ctx context.Context, req *pb.DescribeConversationRequest) (*pb.DescribeConversationResponse, error) {
+	return nil, twirp.NotFoundError("conversation not found")
+}
+
+func do(t *testing.T, handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("builds requests from the path, query and body", func(t *testing.T) {
+		svc := &fakeService{}
+		handler := NewHandler(svc)
+
+		w := do(t, handler, http.MethodPost, "/v1/conversations/68a5aa7b14ba62ef8448c917/messages?async=true",
+			`{"conversation_id": "ignored", "message": "Weather?"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+		}
+
+		if svc.continued.GetConversationId() != "68a5aa7b14ba62ef8448c917" || svc.continued.GetMessage() != "Weather?" || !svc.continued.GetAsync() {
+			t.Errorf("unexpected request %v", svc.continued)
+		}
+
+		var out map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || out["reply"] != "It is sunny." {
+			t.Errorf("unexpected response %s", w.Body)
+		}
+
+		w = do(t, handler, http.MethodGet, "/v1/search?query=lisbon&limit=5", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+		}
+
+		if svc.searched.GetQuery() != "lisbon" || svc.searched.GetLimit() != 5 {
+			t.Errorf("unexpected request %v", svc.searched)
+		}
+	})
+
+	t.Run("responds with Twirp errors", func(t *testing.T) {
+		handler := NewHandler(&fakeService{})
+
+		for _, tc := range []struct {
+			method, target, body string
+			status               int
+			code                 twirp.ErrorCode
+		}{
+			{http.MethodGet, "/v1/conversations/68a5aa7b14ba62ef8448c917", "", http.StatusNotFound, twirp.NotFound},
+			{http.MethodPost, "/v1/conversations/68a5aa7b14ba62ef8448c917/messages", `{"message": 42}`, http.StatusBadRequest, twirp.Malformed},
+			{http.MethodGet, "/v1/search?limit=many", "", http.StatusBadRequest, twirp.InvalidArgument},
+			{http.MethodPut, "/v1/tools", "", http.StatusNotFound, twirp.BadRoute},
+		} {
+			w := do(t, handler, tc.method, tc.target, tc.body)
+
+			var out struct{ Code twirp.ErrorCode }
+			_ = json.Unmarshal(w.Body.Bytes(), &out)
+
+			if w.Code != tc.status || out.Code != tc.code {
+				t.Errorf("%s %s: expected a %d %s error, got %d %s", tc.method, tc.target, tc.status, tc.code, w.Code, w.Body)
+			}
+		}
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := OpenAPI()
+
+	t.Run("documents every method of the service", func(t *testing.T) {
+		operations := map[string]bool{}
+		for _, item := range doc["paths"].(map[string]any) {
+			for _, op := range item.(map[string]any) {
+				operations[op.(map[string]any)["operationId"].(string)] = true
+			}
+		}
+
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			if name := string(methods.Get(i).Name()); !operations[name] {
+				t.Errorf("%s has no route", name)
+			}
+		}
+	})
+
+	t.Run("resolves every reference", func(t *testing.T) {
+		body, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("failed to encode the document: %v", err)
+		}
+
+		schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+		for _, part := range strings.Split(string(body), `"$ref":"#/components/schemas/`)[1:] {
+			name := part[:strings.IndexByte(part, '"')]
+			if _, ok := schemas[name]; !ok {
+				t.Errorf("unresolved reference to %s", name)
+			}
+		}
+	})
+
+	t.Run("is served by the handler", func(t *testing.T) {
+		w := do(t, NewHandler(&fakeService{}), http.MethodGet, "/v1/openapi.json", "")
+
+		var out map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || out["openapi"] != "3.0.3" {
+			t.Errorf("unexpected document %s", w.Body)
+		}
+	})
+}
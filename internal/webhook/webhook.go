@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+)
+
+// Kind identifies webhook deliveries in the job queue
+const Kind = "webhook"
+
+const (
+	// SignatureHeader holds "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body
+	SignatureHeader = "X-Acai-Signature"
+
+	// TimestampHeader holds the Unix time the delivery was signed at, receivers reject old deliveries
+	TimestampHeader = "X-Acai-Timestamp"
+
+	// maxAttempts bounds the deliveries of an event, retried with backoff for about 8 minutes
+	maxAttempts = 8
+)
+
+// Sender delivers events to webhooks as signed JSON POST requests, through the job queue so
+// failed deliveries are retried
+type Sender struct {
+	secret []byte
+	queue  jobs.Enqueuer
+	client *http.Client
+}
+
+// NewSender returns a sender signing deliveries with the secret. Deliveries are only made to public
+// addresses, so clients cannot use webhooks to reach the services next to this one.
+func NewSender(secret string, queue jobs.Enqueuer) *Sender {
+	// The addresses are checked as they are dialed, after DNS resolution, so a host cannot resolve to a
+	// public address when the URL is checked and to a private one when it is called. Redirects are
+	// dialed the same way.
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+
+	return &Sender{
+		secret: []byte(secret),
+		queue:  queue,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		},
+	}
+}
+
+// ErrPrivateAddress is returned for webhooks on loopback, private, link-local or unspecified addresses
+var ErrPrivateAddress = errors.New("webhooks cannot be sent to private addresses")
+
+// CheckURL checks the URL is an absolute http or https URL whose host is not a private address. Host
+// names are only checked once they are resolved, when the webhook is called.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+
+	if u.Hostname() == "localhost" {
+		return ErrPrivateAddress
+	}
+
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !public(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// publicOnly is a net.Dialer Control refusing to connect to addresses that are not public
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !public(addr.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addr.Addr())
+	}
+
+	return nil
+}
+
+func public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+type delivery struct {
+	URL  string `bson:"url"`
+	Body []byte `bson:"body"`
+}
+
+// Send queues the delivery of the event, marshalled as JSON, to the URL
+func (s *Sender) Send(ctx context.Context, url string, event any) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.queue.Enqueue(ctx, Kind, delivery{URL: url, Body: body}, jobs.WithMaxAttempts(maxAttempts))
+	return err
+}
+
+// Handle delivers an event, register it with the job queue for Kind. Responses other than 2xx are
+// retried, except client errors that retrying cannot fix.
+func (s *Sender) Handle(ctx context.Context, job *jobs.Job) error {
+	var d delivery
+	if err := job.Decode(&d); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "acai-webhooks/1.0")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(s.secret, timestamp, d.Body))
+
+	resp, err := s.client.Do(req)
+	if errors.Is(err, ErrPrivateAddress) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		slog.InfoContext(ctx, "Webhook delivered", "url", d.URL, "status", resp.StatusCode)
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return jobs.Permanent(fmt.Errorf("webhook responded with %s", resp.Status))
+	default:
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp, a dot and the body
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a delivery received at now, deliveries signed more than
+// tolerance ago are rejected so they cannot be replayed
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("timestamp outside the tolerance")
+	}
+
+	want := "sha256=" + Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(want)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/acai-travel/tech-challenge/internal/jobs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeQueue keeps the enqueued deliveries
+type fakeQueue struct {
+	jobs []*jobs.Job
+}
+
+func (q *fakeQueue) Enqueue(ctx context.Context, kind string, payload any, opts ...jobs.EnqueueOption) (primitive.ObjectID, error) {
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	job := &jobs.Job{ID: primitive.NewObjectID(), Kind: kind, Payload: raw}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	q.jobs = append(q.jobs, job)
+	return job.ID, nil
+}
+
+func TestSender(t *testing.T) {
+	ctx := context.Background()
+	secret := "s3cret"
+
+	t.Run("delivers signed events", func(t *testing.T) {
+		var verified error
+		var body string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, _ := io.ReadAll(r.Body)
+			body = string(raw)
+			verified = Verify([]byte(secret), r.Header, raw, 5*time.Minute, time.Now())
+		}))
+		defer srv.Close()
+
+		queue := &fakeQueue{}
+		sender := NewSender(secret, queue)
+		sender.client = srv.Client() // the test server listens on loopback
+
+		if err := sender.Send(ctx, srv.URL, map[string]string{"type": "reply.completed"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(queue.jobs) != 1 || queue.jobs[0].Kind != Kind {
+			t.Fatalf("expected a webhook job, got %v", queue.jobs)
+		}
+
+		if err := sender.Handle(ctx, queue.jobs[0]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if verified != nil {
+			t.Errorf("expected a valid signature, got %v", verified)
+		}
+
+		if body != `{"type":"reply.completed"}` {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("retries server errors only", func(t *testing.T) {
+		for status, permanent := range map[int]bool{
+			http.StatusInternalServerError: false,
+			http.StatusTooManyRequests:     false,
+			http.StatusNotFound:            true,
+		} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+
+			queue := &fakeQueue{}
+			sender := NewSender(secret, queue)
+			sender.client = srv.Client()
+			if err := sender.Send(ctx, srv.URL, "event"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err := sender.Handle(ctx, queue.jobs[0])
+			if err == nil || jobs.IsPermanent(err) != permanent {
+				t.Errorf("status %d: expected permanent %v, got %v", status, permanent, err)
+			}
+
+			srv.Close()
+		}
+	})
+}
+
+func TestSender_PrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	queue := &fakeQueue{}
+	sender := NewSender("s3cret", queue)
+	if err := sender.Send(context.Background(), srv.URL, "event"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := sender.Handle(context.Background(), queue.jobs[0])
+	if !errors.Is(err, ErrPrivateAddress) || !jobs.IsPermanent(err) || called {
+		t.Errorf("expected loopback to be refused for good, got %v", err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, want := range map[string]error{
+		"https://example.com/hook":                 nil,
+		"http://93.184.215.14:8080/hook":           nil,
+		"/hook":                                    errors.New("must be an absolute http or https URL"),
+		"ftp://example.com/hook":                   errors.New("must be an absolute http or https URL"),
+		"http://localhost:8080/hook":               ErrPrivateAddress,
+		"http://127.0.0.1/hook":                    ErrPrivateAddress,
+		"http://10.1.2.3/hook":                     ErrPrivateAddress,
+		"http://192.168.1.1/hook":                  ErrPrivateAddress,
+		"http://169.254.169.254/latest/meta-data/": ErrPrivateAddress,
+		"http://0.0.0.0/hook":                      ErrPrivateAddress,
+		"http://[::1]/hook":                        ErrPrivateAddress,
+		"http://[::ffff:127.0.0.1]/hook":           ErrPrivateAddress,
+		"http://[fe80::1]/hook":                    ErrPrivateAddress,
+	} {
+		err := CheckURL(raw)
+		if (err == nil) != (want == nil) || (err != nil && err.Error() != want.Error()) {
+			t.Errorf("%s: expected %v, got %v", raw, want, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"type":"reply.completed"}`)
+	now := time.Unix(1755600000, 0)
+
+	header := http.Header{}
+	header.Set(TimestampHeader, "1755600000")
+	header.Set(SignatureHeader, "sha256="+Sign(secret, now.Unix(), body))
+
+	if err := Verify(secret, header, body, time.Minute, now.Add(30*time.Second)); err != nil {
+		t.Errorf("expected a valid delivery, got %v", err)
+	}
+
+	if err := Verify(secret, header, []byte(`{"type":"reply.failed"}`), time.Minute, now); err == nil {
+		t.Error("expected a tampered body to be rejected")
+	}
+
+	if err := Verify([]byte("other"), header, body, time.Minute, now); err == nil {
+		t.Error("expected another secret to be rejected")
+	}
+
+	if err := Verify(secret, header, body, time.Minute, now.Add(time.Hour)); err == nil {
+		t.Error("expected an old delivery to be rejected")
+	}
+}